@@ -4,9 +4,12 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -83,7 +86,20 @@ type TokenClaims struct {
 
 // IsExpired checks if the token claims have expired
 func (c *TokenClaims) IsExpired() bool {
-	return time.Now().After(c.ExpiresAt)
+	return c.ExpiredAt(time.Now(), 0)
+}
+
+// ExpiredAt reports whether the claims have expired at now, accepting them
+// for up to leeway past ExpiresAt to allow for clock skew between the
+// issuer and the validator
+func (c *TokenClaims) ExpiredAt(now time.Time, leeway time.Duration) bool {
+	return now.After(c.ExpiresAt.Add(leeway))
+}
+
+// NotYetValidAt reports whether the claims were issued more than leeway
+// after now, i.e. by an issuer whose clock runs too far ahead
+func (c *TokenClaims) NotYetValidAt(now time.Time, leeway time.Duration) bool {
+	return !c.IssuedAt.IsZero() && c.IssuedAt.After(now.Add(leeway))
 }
 
 // AuthError represents an authentication error
@@ -101,6 +117,7 @@ const (
 	ErrCodeInvalidDID         = "invalid_did"
 	ErrCodeInvalidProof       = "invalid_proof"
 	ErrCodeExpiredToken       = "expired_token"
+	ErrCodeTokenNotYetValid   = "token_not_yet_valid"
 	ErrCodeInvalidToken       = "invalid_token"
 	ErrCodeTokenRevoked       = "token_revoked"
 	ErrCodeAuthFailed         = "authentication_failed"
@@ -118,6 +135,27 @@ type PlaceholderAuthenticator struct {
 	tokens map[string]*TokenClaims
 	// Token validity duration
 	tokenDuration time.Duration
+	// Leeway for clock skew when checking expiry and issue times
+	clockSkew time.Duration
+	// Token ID prefix and number of random bytes after it
+	tokenPrefix string
+	tokenBytes  int
+	// How old a proof's timestamp may be before Verify rejects it (0 = unchecked)
+	proofMaxAge time.Duration
+	// Recent Verify results keyed by proof hash, kept for verifyCacheTTL (0 = no cache)
+	verifyCache    map[string]cachedVerification
+	verifyCacheTTL time.Duration
+	// Token lifecycle counters
+	issued, validated, refreshed, revoked, expired atomic.Uint64
+}
+
+// TokenMetrics counts token lifecycle events since the authenticator was created
+type TokenMetrics struct {
+	Issued    uint64 // tokens issued by Verify
+	Validated uint64 // successful ValidateToken calls
+	Refreshed uint64 // tokens replaced by RefreshToken
+	Revoked   uint64 // tokens removed by RevokeToken
+	Expired   uint64 // expired tokens found and removed
 }
 
 // NewPlaceholderAuthenticator creates a new placeholder authenticator
@@ -125,6 +163,9 @@ func NewPlaceholderAuthenticator() *PlaceholderAuthenticator {
 	return &PlaceholderAuthenticator{
 		tokens:        make(map[string]*TokenClaims),
 		tokenDuration: 24 * time.Hour,
+		tokenPrefix:   defaultTokenPrefix,
+		tokenBytes:    minTokenBytes,
+		verifyCache:   make(map[string]cachedVerification),
 	}
 }
 
@@ -137,6 +178,15 @@ func (p *PlaceholderAuthenticator) Verify(ctx context.Context, did string, proof
 		return nil, &AuthError{Code: ErrCodeInvalidDID, Message: "DID cannot be empty"}
 	}
 
+	if err := p.checkProofFreshness(proof); err != nil {
+		return nil, err
+	}
+
+	key := verificationKey(did, proof)
+	if result, ok := p.cachedResult(key); ok {
+		return result, nil
+	}
+
 	// TODO: Real Agentries integration would:
 	// 1. Resolve the DID to a DID document
 	// 2. Verify the proof against the public keys in the document
@@ -144,7 +194,6 @@ func (p *PlaceholderAuthenticator) Verify(ctx context.Context, did string, proof
 	// 4. Validate any additional credentials
 
 	// For now, generate a mock token
-	tokenID := generateTokenID()
 	now := time.Now()
 	expiresAt := now.Add(p.tokenDuration)
 
@@ -153,28 +202,137 @@ func (p *PlaceholderAuthenticator) Verify(ctx context.Context, did string, proof
 		DID:       did,
 		IssuedAt:  now,
 		ExpiresAt: expiresAt,
-		TokenID:   tokenID,
 		Extra:     make(map[string]interface{}),
 	}
 
-	p.mu.Lock()
-	p.tokens[tokenID] = claims
-	p.mu.Unlock()
-
-	return &VerificationResult{
+	result := &VerificationResult{
 		DID:        did,
-		Token:      tokenID,
 		ExpiresAt:  expiresAt,
 		VerifiedAt: now,
 		Claims: map[string]interface{}{
 			"placeholder": true,
 			"note":        "This is a placeholder implementation. Integrate with Agentries for production.",
 		},
-	}, nil
+	}
+
+	p.mu.Lock()
+	result.Token = p.addTokenLocked(claims)
+	if p.verifyCacheTTL > 0 {
+		p.verifyCache[key] = cachedVerification{result: result, expires: now.Add(p.verifyCacheTTL)}
+	}
+	p.mu.Unlock()
+	p.issued.Add(1)
+
+	copied := *result
+	return &copied, nil
+}
+
+// SetProofMaxAge makes Verify reject proofs whose timestamp is older than
+// maxAge, or later than now plus the clock-skew leeway, so a captured proof
+// cannot be replayed indefinitely (0 = timestamps unchecked, the default)
+func (p *PlaceholderAuthenticator) SetProofMaxAge(maxAge time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.proofMaxAge = maxAge
+}
+
+// checkProofFreshness rejects a proof outside the freshness window
+func (p *PlaceholderAuthenticator) checkProofFreshness(proof *AuthenticationProof) error {
+	p.mu.RLock()
+	maxAge, skew := p.proofMaxAge, p.clockSkew
+	p.mu.RUnlock()
+	if maxAge <= 0 || proof == nil {
+		return nil
+	}
+
+	now := time.Now()
+	switch {
+	case proof.Timestamp.IsZero():
+		return &AuthError{Code: ErrCodeInvalidProof, Message: "proof has no timestamp"}
+	case proof.Timestamp.Before(now.Add(-maxAge)):
+		return &AuthError{Code: ErrCodeInvalidProof, Message: "proof is too old"}
+	case proof.Timestamp.After(now.Add(skew)):
+		return &AuthError{Code: ErrCodeInvalidProof, Message: "proof timestamp is in the future"}
+	}
+	return nil
+}
+
+// cachedVerification is a Verify result reused for repeats of the same proof
+type cachedVerification struct {
+	result  *VerificationResult
+	expires time.Time
+}
+
+// SetVerifyCacheTTL makes Verify return the earlier result for a repeat of
+// the same DID and proof within ttl, as long as its token is still live,
+// instead of verifying again (0 = no cache, the default)
+func (p *PlaceholderAuthenticator) SetVerifyCacheTTL(ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.verifyCacheTTL = ttl
+	if ttl <= 0 {
+		p.verifyCache = make(map[string]cachedVerification)
+	}
+}
+
+// cachedResult returns a copy of the cached result for key if it is fresh
+// and its token has not been revoked, refreshed or expired since
+func (p *PlaceholderAuthenticator) cachedResult(key string) (*VerificationResult, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.verifyCacheTTL <= 0 {
+		return nil, false
+	}
+
+	entry, ok := p.verifyCache[key]
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	claims, live := p.tokens[entry.result.Token]
+	if !now.Before(entry.expires) || !live || claims.ExpiredAt(now, 0) {
+		delete(p.verifyCache, key)
+		return nil, false
+	}
+	copied := *entry.result
+	return &copied, true
+}
+
+// invalidateCachedLocked drops cached results carrying one of the revoked
+// tokens. The caller must hold the write lock.
+func (p *PlaceholderAuthenticator) invalidateCachedLocked(revoked map[string]bool) {
+	for key, entry := range p.verifyCache {
+		if revoked[entry.result.Token] {
+			delete(p.verifyCache, key)
+		}
+	}
+}
+
+// verificationKey hashes a DID and proof into a verification cache key
+func verificationKey(did string, proof *AuthenticationProof) string {
+	h := sha256.New()
+	h.Write([]byte(did))
+	h.Write([]byte{0})
+	if proof != nil {
+		// Marshaling a struct of plain fields cannot fail
+		data, _ := json.Marshal(proof)
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 // ValidateToken validates a token in the placeholder implementation
 func (p *PlaceholderAuthenticator) ValidateToken(ctx context.Context, token string) (*TokenClaims, error) {
+	claims, err := p.lookup(token)
+	if err != nil {
+		return nil, err
+	}
+	p.validated.Add(1)
+	return claims, nil
+}
+
+// lookup returns a live token's claims, removing the token if it has expired
+func (p *PlaceholderAuthenticator) lookup(token string) (*TokenClaims, error) {
 	p.mu.RLock()
 	claims, exists := p.tokens[token]
 	p.mu.RUnlock()
@@ -183,9 +341,16 @@ func (p *PlaceholderAuthenticator) ValidateToken(ctx context.Context, token stri
 		return nil, &AuthError{Code: ErrCodeInvalidToken, Message: "token not found"}
 	}
 
-	if claims.IsExpired() {
+	now := time.Now()
+	if claims.NotYetValidAt(now, p.clockSkew) {
+		return nil, &AuthError{Code: ErrCodeTokenNotYetValid, Message: "token is not yet valid"}
+	}
+	if claims.ExpiredAt(now, p.clockSkew) {
 		p.mu.Lock()
-		delete(p.tokens, token)
+		if _, still := p.tokens[token]; still {
+			delete(p.tokens, token)
+			p.expired.Add(1)
+		}
 		p.mu.Unlock()
 		return nil, &AuthError{Code: ErrCodeExpiredToken, Message: "token has expired"}
 	}
@@ -195,13 +360,12 @@ func (p *PlaceholderAuthenticator) ValidateToken(ctx context.Context, token stri
 
 // RefreshToken refreshes a token in the placeholder implementation
 func (p *PlaceholderAuthenticator) RefreshToken(ctx context.Context, token string) (string, error) {
-	claims, err := p.ValidateToken(ctx, token)
+	claims, err := p.lookup(token)
 	if err != nil {
 		return "", err
 	}
 
 	// Create new token
-	newTokenID := generateTokenID()
 	now := time.Now()
 	expiresAt := now.Add(p.tokenDuration)
 
@@ -209,15 +373,15 @@ func (p *PlaceholderAuthenticator) RefreshToken(ctx context.Context, token strin
 		DID:       claims.DID,
 		IssuedAt:  now,
 		ExpiresAt: expiresAt,
-		TokenID:   newTokenID,
 		Extra:     claims.Extra,
 	}
 
 	// Revoke old token and store new one atomically
 	p.mu.Lock()
 	delete(p.tokens, token)
-	p.tokens[newTokenID] = newClaims
+	newTokenID := p.addTokenLocked(newClaims)
 	p.mu.Unlock()
+	p.refreshed.Add(1)
 
 	return newTokenID, nil
 }
@@ -232,21 +396,101 @@ func (p *PlaceholderAuthenticator) RevokeToken(ctx context.Context, token string
 	}
 
 	delete(p.tokens, token)
+	p.invalidateCachedLocked(map[string]bool{token: true})
+	p.revoked.Add(1)
 	return nil
 }
 
+// RevokeAllForDID revokes every token issued to did, for example when the
+// agent is compromised, and returns how many were revoked
+func (p *PlaceholderAuthenticator) RevokeAllForDID(ctx context.Context, did string) (int, error) {
+	if did == "" {
+		return 0, &AuthError{Code: ErrCodeInvalidDID, Message: "DID cannot be empty"}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	revoked := make(map[string]bool)
+	for token, claims := range p.tokens {
+		if claims.DID == did {
+			delete(p.tokens, token)
+			revoked[token] = true
+		}
+	}
+	p.invalidateCachedLocked(revoked)
+	p.revoked.Add(uint64(len(revoked)))
+	return len(revoked), nil
+}
+
+// Metrics returns the token lifecycle counters
+func (p *PlaceholderAuthenticator) Metrics() TokenMetrics {
+	return TokenMetrics{
+		Issued:    p.issued.Load(),
+		Validated: p.validated.Load(),
+		Refreshed: p.refreshed.Load(),
+		Revoked:   p.revoked.Load(),
+		Expired:   p.expired.Load(),
+	}
+}
+
 // SetTokenDuration sets the token validity duration (for testing)
 func (p *PlaceholderAuthenticator) SetTokenDuration(duration time.Duration) {
 	p.tokenDuration = duration
 }
 
+// SetClockSkew sets the leeway allowed for clock differences between the
+// token issuer and validator: a token is accepted up to d past its expiry
+// and up to d before its issue time
+func (p *PlaceholderAuthenticator) SetClockSkew(d time.Duration) {
+	p.clockSkew = d
+}
+
+// SetTokenIDFormat sets the prefix of issued token IDs and how many random
+// bytes follow it, hex-encoded. Fewer than 16 bytes are raised to 16 so IDs
+// stay unguessable and collision-free.
+func (p *PlaceholderAuthenticator) SetTokenIDFormat(prefix string, randomBytes int) {
+	if randomBytes < minTokenBytes {
+		randomBytes = minTokenBytes
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tokenPrefix = prefix
+	p.tokenBytes = randomBytes
+}
+
+// addTokenLocked stores claims under a fresh token ID, drawing again in the
+// unlikely case the ID is taken, and returns the ID. The caller must hold
+// the write lock.
+func (p *PlaceholderAuthenticator) addTokenLocked(claims *TokenClaims) string {
+	for {
+		id := newTokenID(p.tokenPrefix, p.tokenBytes)
+		if _, taken := p.tokens[id]; !taken {
+			claims.TokenID = id
+			p.tokens[id] = claims
+			return id
+		}
+	}
+}
+
+// Token ID defaults: "token_" followed by 16 random bytes
+const (
+	defaultTokenPrefix = "token_"
+	minTokenBytes      = 16
+)
+
 // generateTokenID generates a cryptographically secure unique token ID
 func generateTokenID() string {
-	b := make([]byte, 16)
+	return newTokenID(defaultTokenPrefix, minTokenBytes)
+}
+
+// newTokenID returns prefix followed by n hex-encoded random bytes
+func newTokenID(prefix string, n int) string {
+	b := make([]byte, n)
 	if _, err := rand.Read(b); err != nil {
 		panic("crypto/rand failed: " + err.Error())
 	}
-	return "token_" + hex.EncodeToString(b)
+	return prefix + hex.EncodeToString(b)
 }
 
 // NoOpAuthenticator is an authenticator that does no verification
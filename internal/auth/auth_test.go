@@ -191,6 +191,88 @@ func TestPlaceholderAuthenticator_ValidateToken(t *testing.T) {
 	})
 }
 
+// ---------------------------------------------------------------------------
+// TestTokenClaims_ClockSkew
+// ---------------------------------------------------------------------------
+
+func TestTokenClaims_ClockSkew(t *testing.T) {
+	now := time.Now()
+	claims := &TokenClaims{IssuedAt: now, ExpiresAt: now}
+	leeway := 5 * time.Second
+
+	tests := []struct {
+		name        string
+		at          time.Time
+		leeway      time.Duration
+		expired     bool
+		notYetValid bool
+	}{
+		{"at expiry without leeway", now, 0, false, false},
+		{"just past expiry without leeway", now.Add(time.Millisecond), 0, true, false},
+		{"just past expiry within leeway", now.Add(time.Millisecond), leeway, false, false},
+		{"at the end of the leeway", now.Add(leeway), leeway, false, false},
+		{"past the leeway", now.Add(leeway + time.Millisecond), leeway, true, false},
+		{"just before issue without leeway", now.Add(-time.Millisecond), 0, false, true},
+		{"just before issue within leeway", now.Add(-time.Millisecond), leeway, false, false},
+		{"before issue past the leeway", now.Add(-leeway - time.Millisecond), leeway, false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := claims.ExpiredAt(tc.at, tc.leeway); got != tc.expired {
+				t.Errorf("ExpiredAt = %v, want %v", got, tc.expired)
+			}
+			if got := claims.NotYetValidAt(tc.at, tc.leeway); got != tc.notYetValid {
+				t.Errorf("NotYetValidAt = %v, want %v", got, tc.notYetValid)
+			}
+		})
+	}
+}
+
+func TestPlaceholderAuthenticator_ClockSkew(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("recently expired token", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		a.SetTokenDuration(-1 * time.Second)
+		result, err := a.Verify(ctx, "did:example:skew", nil)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+
+		a.SetClockSkew(5 * time.Second)
+		if _, err := a.ValidateToken(ctx, result.Token); err != nil {
+			t.Fatalf("token within the leeway rejected: %v", err)
+		}
+
+		a.SetClockSkew(0)
+		_, err = a.ValidateToken(ctx, result.Token)
+		if authErr, ok := err.(*AuthError); !ok || authErr.Code != ErrCodeExpiredToken {
+			t.Fatalf("without leeway got %v, want %s", err, ErrCodeExpiredToken)
+		}
+	})
+
+	t.Run("token issued in the future", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		now := time.Now()
+		a.tokens["token_future"] = &TokenClaims{
+			DID:       "did:example:skew",
+			IssuedAt:  now.Add(2 * time.Second),
+			ExpiresAt: now.Add(time.Hour),
+			TokenID:   "token_future",
+		}
+
+		_, err := a.ValidateToken(ctx, "token_future")
+		if authErr, ok := err.(*AuthError); !ok || authErr.Code != ErrCodeTokenNotYetValid {
+			t.Fatalf("without leeway got %v, want %s", err, ErrCodeTokenNotYetValid)
+		}
+
+		a.SetClockSkew(5 * time.Second)
+		if _, err := a.ValidateToken(ctx, "token_future"); err != nil {
+			t.Fatalf("token within the leeway rejected: %v", err)
+		}
+	})
+}
+
 // ---------------------------------------------------------------------------
 // TestPlaceholderAuthenticator_RefreshToken
 // ---------------------------------------------------------------------------
@@ -288,6 +370,207 @@ func TestPlaceholderAuthenticator_RevokeToken(t *testing.T) {
 	})
 }
 
+// ---------------------------------------------------------------------------
+// TestPlaceholderAuthenticator_RevokeAllForDID
+// ---------------------------------------------------------------------------
+
+func TestPlaceholderAuthenticator_RevokeAllForDID(t *testing.T) {
+	a := NewPlaceholderAuthenticator()
+	ctx := context.Background()
+
+	var compromised []string
+	for i := 0; i < 3; i++ {
+		result, err := a.Verify(ctx, "did:example:compromised", nil)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		compromised = append(compromised, result.Token)
+	}
+	other, err := a.Verify(ctx, "did:example:other", nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+
+	n, err := a.RevokeAllForDID(ctx, "did:example:compromised")
+	if err != nil {
+		t.Fatalf("RevokeAllForDID failed: %v", err)
+	}
+	if n != len(compromised) {
+		t.Fatalf("expected %d tokens revoked, got %d", len(compromised), n)
+	}
+	for _, token := range compromised {
+		if _, err := a.ValidateToken(ctx, token); err == nil {
+			t.Fatalf("token %q still valid after RevokeAllForDID", token)
+		}
+	}
+	if _, err := a.ValidateToken(ctx, other.Token); err != nil {
+		t.Fatalf("another DID's token was revoked: %v", err)
+	}
+	if m := a.Metrics(); m.Revoked != uint64(len(compromised)) {
+		t.Fatalf("expected Revoked metric %d, got %d", len(compromised), m.Revoked)
+	}
+
+	// Nothing left to revoke
+	if n, err := a.RevokeAllForDID(ctx, "did:example:compromised"); err != nil || n != 0 {
+		t.Fatalf("second RevokeAllForDID = %d, %v; want 0, nil", n, err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestPlaceholderAuthenticator_VerifyCache
+// ---------------------------------------------------------------------------
+
+func TestPlaceholderAuthenticator_VerifyCache(t *testing.T) {
+	ctx := context.Background()
+	proof := func(data string) *AuthenticationProof {
+		return &AuthenticationProof{Type: "signature", Data: []byte(data), Challenge: "nonce"}
+	}
+
+	t.Run("repeat within the window reuses the result", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		a.SetVerifyCacheTTL(time.Minute)
+
+		first, err := a.Verify(ctx, "did:example:cache", proof("sig-1"))
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		again, err := a.Verify(ctx, "did:example:cache", proof("sig-1"))
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if again.Token != first.Token {
+			t.Fatalf("expected cached token %q, got %q", first.Token, again.Token)
+		}
+		if m := a.Metrics(); m.Issued != 1 {
+			t.Fatalf("expected 1 token issued, got %d", m.Issued)
+		}
+
+		// A different proof, or the same proof from another DID, is verified afresh
+		other, err := a.Verify(ctx, "did:example:cache", proof("sig-2"))
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		if other.Token == first.Token {
+			t.Fatal("a different proof reused the cached result")
+		}
+		if other, _ := a.Verify(ctx, "did:example:else", proof("sig-1")); other.Token == first.Token {
+			t.Fatal("another DID reused the cached result")
+		}
+	})
+
+	t.Run("revocation invalidates the cached result", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		a.SetVerifyCacheTTL(time.Minute)
+
+		first, _ := a.Verify(ctx, "did:example:cache", proof("sig-1"))
+		if err := a.RevokeToken(ctx, first.Token); err != nil {
+			t.Fatalf("RevokeToken failed: %v", err)
+		}
+		again, _ := a.Verify(ctx, "did:example:cache", proof("sig-1"))
+		if again.Token == first.Token {
+			t.Fatal("Verify returned a revoked token from the cache")
+		}
+
+		a.RevokeAllForDID(ctx, "did:example:cache")
+		if third, _ := a.Verify(ctx, "did:example:cache", proof("sig-1")); third.Token == again.Token {
+			t.Fatal("Verify returned a token revoked by RevokeAllForDID from the cache")
+		}
+	})
+
+	t.Run("window elapses", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		a.SetVerifyCacheTTL(10 * time.Millisecond)
+
+		first, _ := a.Verify(ctx, "did:example:cache", proof("sig-1"))
+		time.Sleep(20 * time.Millisecond)
+		if again, _ := a.Verify(ctx, "did:example:cache", proof("sig-1")); again.Token == first.Token {
+			t.Fatal("Verify reused a result past the cache window")
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		first, _ := a.Verify(ctx, "did:example:cache", proof("sig-1"))
+		if again, _ := a.Verify(ctx, "did:example:cache", proof("sig-1")); again.Token == first.Token {
+			t.Fatal("Verify cached a result without a cache TTL")
+		}
+	})
+}
+
+// ---------------------------------------------------------------------------
+// TestPlaceholderAuthenticator_ProofMaxAge
+// ---------------------------------------------------------------------------
+
+func TestPlaceholderAuthenticator_ProofMaxAge(t *testing.T) {
+	ctx := context.Background()
+	a := NewPlaceholderAuthenticator()
+	a.SetProofMaxAge(time.Minute)
+	proofAt := func(ts time.Time) *AuthenticationProof {
+		return &AuthenticationProof{Type: "challenge-response", Data: []byte("sig"), Timestamp: ts}
+	}
+
+	if _, err := a.Verify(ctx, "did:example:fresh", proofAt(time.Now().Add(-30*time.Second))); err != nil {
+		t.Fatalf("fresh proof rejected: %v", err)
+	}
+
+	rejected := map[string]*AuthenticationProof{
+		"stale proof":        proofAt(time.Now().Add(-2 * time.Minute)),
+		"future-dated proof": proofAt(time.Now().Add(10 * time.Second)),
+		"missing timestamp":  proofAt(time.Time{}),
+	}
+	for name, proof := range rejected {
+		_, err := a.Verify(ctx, "did:example:replay", proof)
+		authErr, ok := err.(*AuthError)
+		if !ok || authErr.Code != ErrCodeInvalidProof {
+			t.Errorf("%s: got %v, want %s", name, err, ErrCodeInvalidProof)
+		}
+	}
+
+	// The clock-skew leeway also admits a proof from a clock running ahead
+	a.SetClockSkew(30 * time.Second)
+	if _, err := a.Verify(ctx, "did:example:skewed", proofAt(time.Now().Add(10*time.Second))); err != nil {
+		t.Fatalf("future-dated proof within the leeway rejected: %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestPlaceholderAuthenticator_Metrics
+// ---------------------------------------------------------------------------
+
+func TestPlaceholderAuthenticator_Metrics(t *testing.T) {
+	a := NewPlaceholderAuthenticator()
+	ctx := context.Background()
+
+	result, err := a.Verify(ctx, "did:example:metrics", nil)
+	if err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if _, err := a.ValidateToken(ctx, result.Token); err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	refreshed, err := a.RefreshToken(ctx, result.Token)
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %v", err)
+	}
+	if err := a.RevokeToken(ctx, refreshed); err != nil {
+		t.Fatalf("RevokeToken failed: %v", err)
+	}
+
+	a.SetTokenDuration(-time.Second)
+	stale, _ := a.Verify(ctx, "did:example:metrics", nil)
+	if _, err := a.ValidateToken(ctx, stale.Token); err == nil {
+		t.Fatal("expected expired token to fail validation")
+	}
+	// Failed operations move no counters
+	a.ValidateToken(ctx, "token_nonexistent")
+	a.RevokeToken(ctx, "token_nonexistent")
+
+	want := TokenMetrics{Issued: 2, Validated: 1, Refreshed: 1, Revoked: 1, Expired: 1}
+	if got := a.Metrics(); got != want {
+		t.Fatalf("Metrics() = %+v, want %+v", got, want)
+	}
+}
+
 // ---------------------------------------------------------------------------
 // TestPlaceholderAuthenticator_ConcurrentAccess
 // ---------------------------------------------------------------------------
@@ -521,3 +804,55 @@ func TestGenerateTokenID(t *testing.T) {
 		}
 	})
 }
+
+func TestPlaceholderAuthenticator_SetTokenIDFormat(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("configured prefix and length", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		a.SetTokenIDFormat("amp_", 32)
+
+		seen := make(map[string]struct{})
+		var last string
+		for i := 0; i < 100; i++ {
+			result, err := a.Verify(ctx, "did:example:format", nil)
+			if err != nil {
+				t.Fatalf("Verify failed: %v", err)
+			}
+			if !strings.HasPrefix(result.Token, "amp_") {
+				t.Fatalf("expected 'amp_' prefix, got %q", result.Token)
+			}
+			// "amp_" (4 chars) + hex-encoded 32 bytes (64 chars) = 68
+			if len(result.Token) != 68 {
+				t.Fatalf("expected token ID length 68, got %d (%q)", len(result.Token), result.Token)
+			}
+			if _, dup := seen[result.Token]; dup {
+				t.Fatalf("duplicate token ID issued: %q", result.Token)
+			}
+			seen[result.Token] = struct{}{}
+			last = result.Token
+		}
+
+		refreshed, err := a.RefreshToken(ctx, last)
+		if err != nil {
+			t.Fatalf("RefreshToken failed: %v", err)
+		}
+		if !strings.HasPrefix(refreshed, "amp_") || len(refreshed) != 68 {
+			t.Fatalf("refreshed token %q does not follow the configured format", refreshed)
+		}
+	})
+
+	t.Run("entropy below the minimum is raised", func(t *testing.T) {
+		a := NewPlaceholderAuthenticator()
+		a.SetTokenIDFormat("", 4)
+
+		result, err := a.Verify(ctx, "did:example:format", nil)
+		if err != nil {
+			t.Fatalf("Verify failed: %v", err)
+		}
+		// hex-encoded 16 bytes (32 chars), no prefix
+		if len(result.Token) != 32 {
+			t.Fatalf("expected token ID length 32, got %d (%q)", len(result.Token), result.Token)
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// didKeyPrefix starts a did:key DID whose key is multibase base58btc encoded
+const didKeyPrefix = "did:key:z"
+
+// ed25519Multicodec is the multicodec prefix of an Ed25519 public key
+var ed25519Multicodec = []byte{0xed, 0x01}
+
+// base58Alphabet is the Bitcoin base58 alphabet used by base58btc
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// DIDKeyVerifier verifies handshake signatures for did:key DIDs carrying an
+// Ed25519 key, which need no resolver: the public key is the DID itself.
+// Signatures over the nonce from any other DID method are refused.
+type DIDKeyVerifier struct{}
+
+// Verify reports whether signature is did's signature over nonce
+func (DIDKeyVerifier) Verify(did string, signature []byte, nonce string) (bool, error) {
+	key, err := DIDKeyPublicKey(did)
+	if err != nil {
+		return false, err
+	}
+	return ed25519.Verify(key, []byte(nonce), signature), nil
+}
+
+// DIDKeyPublicKey extracts the Ed25519 public key from a did:key DID
+func DIDKeyPublicKey(did string) (ed25519.PublicKey, error) {
+	if !strings.HasPrefix(did, didKeyPrefix) {
+		return nil, fmt.Errorf("not a base58btc did:key: %s", did)
+	}
+	raw, err := base58Decode(strings.TrimPrefix(did, didKeyPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid did:key %s: %w", did, err)
+	}
+	if len(raw) != len(ed25519Multicodec)+ed25519.PublicKeySize ||
+		raw[0] != ed25519Multicodec[0] || raw[1] != ed25519Multicodec[1] {
+		return nil, fmt.Errorf("did:key %s does not hold an Ed25519 key", did)
+	}
+	return ed25519.PublicKey(raw[len(ed25519Multicodec):]), nil
+}
+
+// DIDKeyFromPublicKey returns the did:key DID of an Ed25519 public key
+func DIDKeyFromPublicKey(key ed25519.PublicKey) string {
+	return didKeyPrefix + base58Encode(append(append([]byte{}, ed25519Multicodec...), key...))
+}
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
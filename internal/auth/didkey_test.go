@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+)
+
+func TestDIDKeyVerifier(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	did := DIDKeyFromPublicKey(pub)
+	if !strings.HasPrefix(did, "did:key:z6Mk") {
+		t.Fatalf("DIDKeyFromPublicKey = %q, want a did:key:z6Mk... DID", did)
+	}
+	if key, err := DIDKeyPublicKey(did); err != nil || !key.Equal(pub) {
+		t.Fatalf("DIDKeyPublicKey = %x, %v; want the original key", key, err)
+	}
+
+	var v DIDKeyVerifier
+	sig := ed25519.Sign(priv, []byte("nonce"))
+	if ok, err := v.Verify(did, sig, "nonce"); !ok || err != nil {
+		t.Errorf("Verify(valid) = %v, %v; want true", ok, err)
+	}
+	if ok, _ := v.Verify(did, sig, "other"); ok {
+		t.Error("signature over another nonce verified")
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if ok, _ := v.Verify(DIDKeyFromPublicKey(otherPub), sig, "nonce"); ok {
+		t.Error("signature verified for another DID's key")
+	}
+	for _, bad := range []string{"did:web:example.com", "did:key:z0OIl", "did:key:z" + strings.Repeat("1", 10)} {
+		if ok, err := v.Verify(bad, sig, "nonce"); ok || err == nil {
+			t.Errorf("Verify(%q) = %v, %v; want an error", bad, ok, err)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Endpoints of the external auth service, relative to its base URL
+const (
+	httpAuthVerifyPath   = "/verify"
+	httpAuthValidatePath = "/validate"
+	httpAuthRefreshPath  = "/refresh"
+	httpAuthRevokePath   = "/revoke"
+)
+
+// Defaults for HTTPAuthenticator
+const (
+	defaultHTTPAuthTimeout      = 5 * time.Second
+	defaultHTTPAuthMaxRetries   = 2
+	defaultHTTPAuthRetryBackoff = 100 * time.Millisecond
+)
+
+// maxHTTPAuthResponse bounds the response bodies read from the auth service
+const maxHTTPAuthResponse = 1 << 20
+
+// HTTPAuthenticator delegates authentication to an external identity
+// service over REST. Each operation POSTs a JSON body to its endpoint under
+// BaseURL:
+//
+//	/verify    {"did", "proof"} -> VerificationResult
+//	/validate  {"token"}        -> TokenClaims
+//	/refresh   {"token"}        -> {"token"}
+//	/revoke    {"token"}        -> any 2xx
+//
+// A non-2xx answer carrying a JSON {"code", "message"} body becomes an
+// *AuthError with that code; without one, the code is derived from the
+// status. 5xx answers and transport errors are retried.
+type HTTPAuthenticator struct {
+	// BaseURL is the service's root, e.g. https://id.example.com/v1
+	BaseURL string
+
+	// Client sends the requests (nil = http.DefaultClient)
+	Client *http.Client
+
+	// Timeout bounds each attempt (0 = none beyond the caller's context)
+	Timeout time.Duration
+
+	// MaxRetries is how many times a 5xx answer or transport error is
+	// retried, waiting RetryBackoff, doubled each time, in between
+	MaxRetries   int
+	RetryBackoff time.Duration
+}
+
+// NewHTTPAuthenticator creates an authenticator for the service at baseURL
+// with a 5s per-attempt timeout and up to 2 retries
+func NewHTTPAuthenticator(baseURL string) *HTTPAuthenticator {
+	return &HTTPAuthenticator{
+		BaseURL:      strings.TrimSuffix(baseURL, "/"),
+		Timeout:      defaultHTTPAuthTimeout,
+		MaxRetries:   defaultHTTPAuthMaxRetries,
+		RetryBackoff: defaultHTTPAuthRetryBackoff,
+	}
+}
+
+// tokenRequest is the body of the token endpoints
+type tokenRequest struct {
+	Token string `json:"token"`
+}
+
+// Verify asks the service to verify a DID authentication request
+func (h *HTTPAuthenticator) Verify(ctx context.Context, did string, proof *AuthenticationProof) (*VerificationResult, error) {
+	body := struct {
+		DID   string               `json:"did"`
+		Proof *AuthenticationProof `json:"proof,omitempty"`
+	}{did, proof}
+
+	result := &VerificationResult{}
+	if err := h.call(ctx, httpAuthVerifyPath, body, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// ValidateToken asks the service for the claims of a token
+func (h *HTTPAuthenticator) ValidateToken(ctx context.Context, token string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	if err := h.call(ctx, httpAuthValidatePath, tokenRequest{token}, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// RefreshToken asks the service to replace a token with a new one
+func (h *HTTPAuthenticator) RefreshToken(ctx context.Context, token string) (string, error) {
+	var refreshed tokenRequest
+	if err := h.call(ctx, httpAuthRefreshPath, tokenRequest{token}, &refreshed); err != nil {
+		return "", err
+	}
+	if refreshed.Token == "" {
+		return "", &AuthError{Code: ErrCodeServiceUnavailable, Message: "auth service returned no token"}
+	}
+	return refreshed.Token, nil
+}
+
+// RevokeToken asks the service to revoke a token
+func (h *HTTPAuthenticator) RevokeToken(ctx context.Context, token string) error {
+	return h.call(ctx, httpAuthRevokePath, tokenRequest{token}, nil)
+}
+
+// call POSTs body to path, retrying 5xx answers and transport errors, and
+// decodes a 2xx answer into out (nil = ignore the answer)
+func (h *HTTPAuthenticator) call(ctx context.Context, path string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode auth request: %w", err)
+	}
+
+	backoff := h.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := h.attempt(ctx, path, payload, out)
+		if err == nil || !retry || attempt >= h.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return &AuthError{Code: ErrCodeServiceUnavailable, Message: ctx.Err().Error()}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// attempt makes one request, reporting whether a failure is worth retrying
+func (h *HTTPAuthenticator) attempt(ctx context.Context, path string, payload []byte, out interface{}) (retry bool, err error) {
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.BaseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return false, fmt.Errorf("failed to build auth request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return true, &AuthError{Code: ErrCodeServiceUnavailable, Message: "auth service timed out"}
+		}
+		return true, &AuthError{Code: ErrCodeServiceUnavailable, Message: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHTTPAuthResponse))
+	if err != nil {
+		return true, &AuthError{Code: ErrCodeServiceUnavailable, Message: "failed to read auth response: " + err.Error()}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode >= 500, httpAuthError(resp.StatusCode, data)
+	}
+	if out == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return false, &AuthError{Code: ErrCodeServiceUnavailable, Message: "invalid auth response: " + err.Error()}
+	}
+	return false, nil
+}
+
+// httpAuthError maps a failed answer to an *AuthError, preferring the code
+// the service put in its body over one derived from the status
+func httpAuthError(status int, body []byte) *AuthError {
+	authErr := &AuthError{}
+	if json.Unmarshal(body, authErr) == nil && authErr.Code != "" {
+		return authErr
+	}
+
+	authErr.Message = strings.TrimSpace(string(body))
+	if authErr.Message == "" {
+		authErr.Message = http.StatusText(status)
+	}
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		authErr.Code = ErrCodeAuthFailed
+	case status == http.StatusNotFound:
+		authErr.Code = ErrCodeInvalidToken
+	case status == http.StatusBadRequest:
+		authErr.Code = ErrCodeInvalidProof
+	case status >= 500:
+		authErr.Code = ErrCodeServiceUnavailable
+	default:
+		authErr.Code = ErrCodeAuthFailed
+	}
+	return authErr
+}
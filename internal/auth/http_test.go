@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var _ Authenticator = (*HTTPAuthenticator)(nil)
+
+// authCode returns the code of an *AuthError, or fails the test
+func authCode(t *testing.T, err error) string {
+	t.Helper()
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Fatalf("expected *AuthError, got %T: %v", err, err)
+	}
+	return authErr.Code
+}
+
+// ---------------------------------------------------------------------------
+// TestHTTPAuthenticator_Success
+// ---------------------------------------------------------------------------
+
+func TestHTTPAuthenticator_Success(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("expected POST, got %s", r.Method)
+		}
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/verify":
+			json.NewEncoder(w).Encode(VerificationResult{DID: req["did"].(string), Token: "tok-1"})
+		case "/validate":
+			json.NewEncoder(w).Encode(TokenClaims{DID: "did:example:alice", TokenID: req["token"].(string)})
+		case "/refresh":
+			json.NewEncoder(w).Encode(map[string]string{"token": "tok-2"})
+		case "/revoke":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	a := NewHTTPAuthenticator(srv.URL + "/")
+	ctx := context.Background()
+
+	result, err := a.Verify(ctx, "did:example:alice", &AuthenticationProof{Type: "Ed25519Signature2020"})
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if result.DID != "did:example:alice" || result.Token != "tok-1" {
+		t.Fatalf("unexpected verification result: %+v", result)
+	}
+
+	claims, err := a.ValidateToken(ctx, "tok-1")
+	if err != nil {
+		t.Fatalf("ValidateToken: %v", err)
+	}
+	if claims.DID != "did:example:alice" || claims.TokenID != "tok-1" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	token, err := a.RefreshToken(ctx, "tok-1")
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if token != "tok-2" {
+		t.Fatalf("expected refreshed token tok-2, got %q", token)
+	}
+
+	if err := a.RevokeToken(ctx, "tok-2"); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestHTTPAuthenticator_ErrorMapping
+// ---------------------------------------------------------------------------
+
+func TestHTTPAuthenticator_ErrorMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		body     string
+		wantCode string
+	}{
+		{"401 without body", http.StatusUnauthorized, "", ErrCodeAuthFailed},
+		{"401 with text body", http.StatusUnauthorized, "bad signature", ErrCodeAuthFailed},
+		{"404", http.StatusNotFound, "", ErrCodeInvalidToken},
+		{"body code wins", http.StatusUnauthorized, `{"code":"token_revoked","message":"revoked"}`, ErrCodeTokenRevoked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			_, err := NewHTTPAuthenticator(srv.URL).ValidateToken(context.Background(), "tok")
+			if code := authCode(t, err); code != tt.wantCode {
+				t.Fatalf("expected code %q, got %q", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestHTTPAuthenticator_RetriesServerErrors
+// ---------------------------------------------------------------------------
+
+func TestHTTPAuthenticator_RetriesServerErrors(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.NewEncoder(w).Encode(TokenClaims{DID: "did:example:alice"})
+	}))
+	defer srv.Close()
+
+	a := NewHTTPAuthenticator(srv.URL)
+	a.RetryBackoff = time.Millisecond
+
+	if _, err := a.ValidateToken(context.Background(), "tok"); err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	// Out of retries, the last 5xx is returned
+	calls.Store(0)
+	a.MaxRetries = 1
+	_, err := a.ValidateToken(context.Background(), "tok")
+	if code := authCode(t, err); code != ErrCodeServiceUnavailable {
+		t.Fatalf("expected code %q, got %q", ErrCodeServiceUnavailable, code)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+}
+
+// ---------------------------------------------------------------------------
+// TestHTTPAuthenticator_Timeout
+// ---------------------------------------------------------------------------
+
+func TestHTTPAuthenticator_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	a := NewHTTPAuthenticator(srv.URL)
+	a.Timeout = 50 * time.Millisecond
+	a.MaxRetries = 0
+
+	start := time.Now()
+	_, err := a.Verify(context.Background(), "did:example:alice", nil)
+	if code := authCode(t, err); code != ErrCodeServiceUnavailable {
+		t.Fatalf("expected code %q, got %q", ErrCodeServiceUnavailable, code)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("timeout not enforced: call took %v", elapsed)
+	}
+}
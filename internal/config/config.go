@@ -4,6 +4,9 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -42,6 +45,10 @@ type ServerConfig struct {
 	// MaxPayloadSize is the maximum allowed message payload size in bytes
 	MaxPayloadSize int64 `yaml:"max_payload_size" json:"max_payload_size"`
 
+	// MaxResponseSize is the maximum size in bytes of a handler response
+	// (0 = MaxPayloadSize)
+	MaxResponseSize int64 `yaml:"max_response_size" json:"max_response_size"`
+
 	// EnableWebSocket enables WebSocket transport
 	EnableWebSocket bool `yaml:"enable_websocket" json:"enable_websocket"`
 }
@@ -54,14 +61,28 @@ type StorageConfig struct {
 	// Path to storage directory (for file-based storage)
 	Path string `yaml:"path" json:"path"`
 
+	// RedisAddr is the host:port of the Redis server (for redis storage)
+	RedisAddr string `yaml:"redis_addr" json:"redis_addr"`
+
+	// RedisPassword authenticates to the Redis server (empty = none)
+	RedisPassword string `yaml:"redis_password" json:"redis_password"`
+
+	// RedisDB is the Redis database number
+	RedisDB int `yaml:"redis_db" json:"redis_db"`
+
 	// DefaultTTL is the default message TTL
 	DefaultTTL time.Duration `yaml:"default_ttl" json:"default_ttl"`
 
-	// MaxMessages is the maximum number of messages to store (0 = unlimited)
+	// MaxMessages is the maximum number of relayed messages to store
+	// (0 = unlimited); the relay's control state is kept apart and is never
+	// evicted to make room
 	MaxMessages int `yaml:"max_messages" json:"max_messages"`
 
 	// CleanupInterval is the interval between cleanup runs
 	CleanupInterval time.Duration `yaml:"cleanup_interval" json:"cleanup_interval"`
+
+	// MaxMessageAge caps how long any message is kept, whatever its TTL (0 = no cap)
+	MaxMessageAge time.Duration `yaml:"max_message_age" json:"max_message_age"`
 }
 
 // LoggingConfig holds logging-specific configuration
@@ -74,18 +95,32 @@ type LoggingConfig struct {
 
 	// Output is the log output (stdout, stderr, or file path)
 	Output string `yaml:"output" json:"output"`
+
+	// SampleEvery logs only 1 in N occurrences of each high-frequency event (0 or 1 = all)
+	SampleEvery int `yaml:"sample_every" json:"sample_every"`
+
+	// MaxPerSecond caps each high-frequency event at N lines per second (0 = unlimited)
+	MaxPerSecond int `yaml:"max_per_second" json:"max_per_second"`
 }
 
 // SecurityConfig holds security-specific configuration
 type SecurityConfig struct {
-	// EnableAuth enables DID-based authentication
+	// EnableAuth enables DID-based authentication: each WebSocket client
+	// must sign the handshake nonce with the Ed25519 key of its did:key DID
 	EnableAuth bool `yaml:"enable_auth" json:"enable_auth"`
 
-	// AllowedOrigins is a list of allowed CORS origins
+	// AllowedOrigins is a list of allowed CORS origins ("*" allows any).
+	// An explicitly empty list is rejected rather than read as allow-all.
 	AllowedOrigins []string `yaml:"allowed_origins" json:"allowed_origins"`
 
-	// RateLimitPerMinute is the number of requests allowed per minute per client
+	// RateLimitPerMinute is the number of requests allowed per minute per
+	// client (0 = unlimited). It is enforced, at 60 by default.
 	RateLimitPerMinute int `yaml:"rate_limit_per_minute" json:"rate_limit_per_minute"`
+
+	// RateLimitByOrigin overrides RateLimitPerMinute for clients
+	// authenticated as the listed DIDs. Entries for origins can only lower
+	// the limit, as clients choose their own Origin header.
+	RateLimitByOrigin map[string]int `yaml:"rate_limit_by_origin,omitempty" json:"rate_limit_by_origin,omitempty"`
 }
 
 // DefaultConfig returns a configuration with default values
@@ -101,6 +136,7 @@ func DefaultConfig() *Config {
 		Storage: StorageConfig{
 			Type:            "memory",
 			Path:            "./data",
+			RedisAddr:       "localhost:6379",
 			DefaultTTL:      5 * time.Minute,
 			MaxMessages:     10000,
 			CleanupInterval: 1 * time.Minute,
@@ -118,9 +154,22 @@ func DefaultConfig() *Config {
 	}
 }
 
+// Overrides holds settings given on the command line. Non-empty fields take
+// precedence over environment variables and the config file.
+type Overrides struct {
+	Address  string // server.address
+	LogLevel string // logging.level
+}
+
 // Load loads configuration from file and environment variables
 // Environment variables take precedence over file configuration
 func Load(configPath string) (*Config, error) {
+	return LoadWithOverrides(configPath, Overrides{})
+}
+
+// LoadWithOverrides loads configuration like Load, then applies overrides.
+// Precedence, highest first: overrides, environment, file, defaults.
+func LoadWithOverrides(configPath string, overrides Overrides) (*Config, error) {
 	// Start with defaults
 	config := DefaultConfig()
 
@@ -136,6 +185,14 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to load environment variables: %w", err)
 	}
 
+	// Command-line overrides win over everything else
+	if overrides.Address != "" {
+		config.Server.Address = overrides.Address
+	}
+	if overrides.LogLevel != "" {
+		config.Logging.Level = overrides.LogLevel
+	}
+
 	// Validate configuration
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
@@ -144,14 +201,61 @@ func Load(configPath string) (*Config, error) {
 	return config, nil
 }
 
-// loadFromFile loads configuration from a YAML or JSON file
-func loadFromFile(config *Config, path string) error {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return fmt.Errorf("failed to read config file: %w", err)
+// ValidateFile loads the configuration at path, as Load would, and reports
+// whether it is valid. Nothing is started or written.
+func ValidateFile(path string) error {
+	if path == "" {
+		return fmt.Errorf("no config file given")
 	}
+	_, err := Load(path)
+	return err
+}
+
+// stdinPath is the config path that reads the configuration from stdin
+const stdinPath = "-"
+
+// stdin is the reader used for stdinPath; tests substitute it
+var stdin io.Reader = os.Stdin
+
+// fetchTimeout bounds fetching a configuration from a URL
+const fetchTimeout = 10 * time.Second
 
+// maxConfigSize caps a configuration read from stdin or a URL
+const maxConfigSize = 1 << 20
+
+// loadFromFile loads configuration from a YAML or JSON file, from stdin when
+// path is "-", or from an http(s) URL. The format comes from the file
+// extension, or from AMP_CONFIG_FORMAT when there is none to go by.
+func loadFromFile(config *Config, path string) error {
+	var data []byte
+	var err error
 	ext := strings.ToLower(filepath.Ext(path))
+
+	switch {
+	case path == stdinPath:
+		ext = ""
+		if data, err = readConfig(stdin); err != nil {
+			return fmt.Errorf("failed to read config from stdin: %w", err)
+		}
+	case strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://"):
+		if data, ext, err = fetchConfig(path); err != nil {
+			return err
+		}
+	default:
+		// Passing a directory is a common mistake; say so instead of
+		// surfacing the read error
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return fmt.Errorf("config path %s is a directory, expected a file", path)
+		}
+		if data, err = os.ReadFile(path); err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+	}
+
+	if format := os.Getenv("AMP_CONFIG_FORMAT"); format != "" {
+		ext = "." + strings.ToLower(strings.TrimPrefix(format, "."))
+	}
+
 	switch ext {
 	case ".yaml", ".yml":
 		if err := yaml.Unmarshal(data, config); err != nil {
@@ -161,6 +265,8 @@ func loadFromFile(config *Config, path string) error {
 		if err := json.Unmarshal(data, config); err != nil {
 			return fmt.Errorf("failed to parse JSON: %w", err)
 		}
+	case "":
+		return fmt.Errorf("config format unknown for %s: set AMP_CONFIG_FORMAT to yaml or json", path)
 	default:
 		return fmt.Errorf("unsupported config file format: %s (use .yaml, .yml, or .json)", ext)
 	}
@@ -168,6 +274,50 @@ func loadFromFile(config *Config, path string) error {
 	return nil
 }
 
+// fetchConfig downloads a configuration and returns it with the format
+// extension implied by the URL path or, failing that, the content type
+func fetchConfig(url string) ([]byte, string, error) {
+	client := &http.Client{Timeout: fetchTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch config: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch config: %s returned %s", url, resp.Status)
+	}
+	data, err := readConfig(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read fetched config: %w", err)
+	}
+
+	ext := strings.ToLower(filepath.Ext(resp.Request.URL.Path))
+	if ext == "" {
+		contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		switch contentType {
+		case "application/json":
+			ext = ".json"
+		case "application/yaml", "application/x-yaml", "text/yaml":
+			ext = ".yaml"
+		}
+	}
+	return data, ext, nil
+}
+
+// readConfig reads a whole configuration from r, failing once it exceeds
+// maxConfigSize
+func readConfig(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxConfigSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxConfigSize {
+		return nil, fmt.Errorf("config exceeds %d bytes", maxConfigSize)
+	}
+	return data, nil
+}
+
 // loadFromEnv overrides configuration with environment variables
 // Environment variables use the prefix "AMP_" and follow the pattern:
 // AMP_SERVER_ADDRESS, AMP_STORAGE_PATH, etc.
@@ -191,6 +341,11 @@ func loadFromEnv(config *Config) error {
 			config.Server.MaxPayloadSize = n
 		}
 	}
+	if v := os.Getenv("AMP_SERVER_MAX_RESPONSE_SIZE"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			config.Server.MaxResponseSize = n
+		}
+	}
 	if v := os.Getenv("AMP_SERVER_ENABLE_WEBSOCKET"); v != "" {
 		config.Server.EnableWebSocket = parseBool(v)
 	}
@@ -202,6 +357,17 @@ func loadFromEnv(config *Config) error {
 	if v := os.Getenv("AMP_STORAGE_PATH"); v != "" {
 		config.Storage.Path = v
 	}
+	if v := os.Getenv("AMP_STORAGE_REDIS_ADDR"); v != "" {
+		config.Storage.RedisAddr = v
+	}
+	if v := os.Getenv("AMP_STORAGE_REDIS_PASSWORD"); v != "" {
+		config.Storage.RedisPassword = v
+	}
+	if v := os.Getenv("AMP_STORAGE_REDIS_DB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.Storage.RedisDB = n
+		}
+	}
 	if v := os.Getenv("AMP_STORAGE_DEFAULT_TTL"); v != "" {
 		if d, err := time.ParseDuration(v); err == nil {
 			config.Storage.DefaultTTL = d
@@ -217,6 +383,11 @@ func loadFromEnv(config *Config) error {
 			config.Storage.CleanupInterval = d
 		}
 	}
+	if v := os.Getenv("AMP_STORAGE_MAX_MESSAGE_AGE"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			config.Storage.MaxMessageAge = d
+		}
+	}
 
 	// Logging configuration
 	if v := os.Getenv("AMP_LOG_LEVEL"); v != "" {
@@ -228,13 +399,27 @@ func loadFromEnv(config *Config) error {
 	if v := os.Getenv("AMP_LOG_OUTPUT"); v != "" {
 		config.Logging.Output = v
 	}
+	if v := os.Getenv("AMP_LOG_SAMPLE_EVERY"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.Logging.SampleEvery = n
+		}
+	}
+	if v := os.Getenv("AMP_LOG_MAX_PER_SECOND"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			config.Logging.MaxPerSecond = n
+		}
+	}
 
 	// Security configuration
 	if v := os.Getenv("AMP_SECURITY_ENABLE_AUTH"); v != "" {
 		config.Security.EnableAuth = parseBool(v)
 	}
 	if v := os.Getenv("AMP_SECURITY_ALLOWED_ORIGINS"); v != "" {
-		config.Security.AllowedOrigins = strings.Split(v, ",")
+		origins, err := parseOriginList(v)
+		if err != nil {
+			return fmt.Errorf("invalid AMP_SECURITY_ALLOWED_ORIGINS: %w", err)
+		}
+		config.Security.AllowedOrigins = origins
 	}
 	if v := os.Getenv("AMP_SECURITY_RATE_LIMIT"); v != "" {
 		if n, err := strconv.Atoi(v); err == nil {
@@ -245,6 +430,19 @@ func loadFromEnv(config *Config) error {
 	return nil
 }
 
+// parseOriginList parses an origin list given either as a JSON array
+// (detected by a leading '[') or as a comma-separated string
+func parseOriginList(v string) ([]string, error) {
+	if strings.HasPrefix(strings.TrimSpace(v), "[") {
+		origins := []string{}
+		if err := json.Unmarshal([]byte(v), &origins); err != nil {
+			return nil, err
+		}
+		return origins, nil
+	}
+	return strings.Split(v, ","), nil
+}
+
 // parseBool parses a string as a boolean value
 func parseBool(s string) bool {
 	s = strings.ToLower(strings.TrimSpace(s))
@@ -260,6 +458,9 @@ func (c *Config) Validate() error {
 	if c.Server.MaxPayloadSize <= 0 {
 		return fmt.Errorf("max payload size must be positive")
 	}
+	if c.Server.MaxResponseSize < 0 {
+		return fmt.Errorf("max response size cannot be negative")
+	}
 	if c.Server.ReadTimeout <= 0 {
 		return fmt.Errorf("read timeout must be positive")
 	}
@@ -278,9 +479,18 @@ func (c *Config) Validate() error {
 	if c.Storage.Type == "file" && c.Storage.Path == "" {
 		return fmt.Errorf("storage path cannot be empty when using file storage")
 	}
+	if c.Storage.Type == "redis" && c.Storage.RedisAddr == "" {
+		return fmt.Errorf("redis address cannot be empty when using redis storage")
+	}
+	if c.Storage.RedisDB < 0 {
+		return fmt.Errorf("redis db cannot be negative")
+	}
 	if c.Storage.DefaultTTL <= 0 {
 		return fmt.Errorf("default TTL must be positive")
 	}
+	if c.Storage.MaxMessageAge < 0 {
+		return fmt.Errorf("max message age cannot be negative")
+	}
 
 	// Validate logging configuration
 	validLogLevels := []string{"debug", "info", "warn", "error"}
@@ -291,11 +501,25 @@ func (c *Config) Validate() error {
 	if !contains(validLogFormats, strings.ToLower(c.Logging.Format)) {
 		return fmt.Errorf("invalid log format: %s (must be one of: %v)", c.Logging.Format, validLogFormats)
 	}
+	if c.Logging.SampleEvery < 0 {
+		return fmt.Errorf("log sample rate cannot be negative")
+	}
+	if c.Logging.MaxPerSecond < 0 {
+		return fmt.Errorf("log rate limit cannot be negative")
+	}
 
 	// Validate security configuration
+	if c.Security.AllowedOrigins != nil && len(c.Security.AllowedOrigins) == 0 {
+		return fmt.Errorf("allowed origins cannot be empty (list the origins to allow, or \"*\" for any)")
+	}
 	if c.Security.RateLimitPerMinute < 0 {
 		return fmt.Errorf("rate limit cannot be negative")
 	}
+	for origin, limit := range c.Security.RateLimitByOrigin {
+		if limit < 0 {
+			return fmt.Errorf("rate limit for %s cannot be negative", origin)
+		}
+	}
 
 	return nil
 }
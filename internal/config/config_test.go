@@ -2,8 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -116,6 +119,26 @@ func TestLoad_EnvOverride(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:   "AMP_STORAGE_REDIS_ADDR overrides default",
+			envKey: "AMP_STORAGE_REDIS_ADDR",
+			envVal: "redis:6379",
+			checkFn: func(t *testing.T, cfg *Config) {
+				if cfg.Storage.RedisAddr != "redis:6379" {
+					t.Errorf("Storage.RedisAddr = %q, want %q", cfg.Storage.RedisAddr, "redis:6379")
+				}
+			},
+		},
+		{
+			name:   "AMP_STORAGE_REDIS_DB overrides default",
+			envKey: "AMP_STORAGE_REDIS_DB",
+			envVal: "2",
+			checkFn: func(t *testing.T, cfg *Config) {
+				if cfg.Storage.RedisDB != 2 {
+					t.Errorf("Storage.RedisDB = %d, want 2", cfg.Storage.RedisDB)
+				}
+			},
+		},
 		{
 			name:   "AMP_LOG_LEVEL overrides default",
 			envKey: "AMP_LOG_LEVEL",
@@ -126,6 +149,26 @@ func TestLoad_EnvOverride(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:   "AMP_LOG_SAMPLE_EVERY overrides default",
+			envKey: "AMP_LOG_SAMPLE_EVERY",
+			envVal: "100",
+			checkFn: func(t *testing.T, cfg *Config) {
+				if cfg.Logging.SampleEvery != 100 {
+					t.Errorf("Logging.SampleEvery = %d, want %d", cfg.Logging.SampleEvery, 100)
+				}
+			},
+		},
+		{
+			name:   "AMP_LOG_MAX_PER_SECOND overrides default",
+			envKey: "AMP_LOG_MAX_PER_SECOND",
+			envVal: "5",
+			checkFn: func(t *testing.T, cfg *Config) {
+				if cfg.Logging.MaxPerSecond != 5 {
+					t.Errorf("Logging.MaxPerSecond = %d, want %d", cfg.Logging.MaxPerSecond, 5)
+				}
+			},
+		},
 		{
 			name:   "AMP_SERVER_READ_TIMEOUT overrides default",
 			envKey: "AMP_SERVER_READ_TIMEOUT",
@@ -202,6 +245,32 @@ func TestLoad_EnvOverride(t *testing.T) {
 				}
 			},
 		},
+		{
+			name:   "AMP_SECURITY_ALLOWED_ORIGINS accepts a JSON array",
+			envKey: "AMP_SECURITY_ALLOWED_ORIGINS",
+			envVal: `["https://example.com", "https://a,b.example"]`,
+			checkFn: func(t *testing.T, cfg *Config) {
+				want := []string{"https://example.com", "https://a,b.example"}
+				if len(cfg.Security.AllowedOrigins) != len(want) {
+					t.Fatalf("Security.AllowedOrigins = %q, want %q", cfg.Security.AllowedOrigins, want)
+				}
+				for i, v := range want {
+					if cfg.Security.AllowedOrigins[i] != v {
+						t.Errorf("Security.AllowedOrigins[%d] = %q, want %q", i, cfg.Security.AllowedOrigins[i], v)
+					}
+				}
+			},
+		},
+		{
+			name:   "AMP_SECURITY_ALLOWED_ORIGINS accepts a single origin",
+			envKey: "AMP_SECURITY_ALLOWED_ORIGINS",
+			envVal: "https://example.com",
+			checkFn: func(t *testing.T, cfg *Config) {
+				if len(cfg.Security.AllowedOrigins) != 1 || cfg.Security.AllowedOrigins[0] != "https://example.com" {
+					t.Errorf("Security.AllowedOrigins = %q, want [https://example.com]", cfg.Security.AllowedOrigins)
+				}
+			},
+		},
 		{
 			name:   "AMP_SECURITY_RATE_LIMIT overrides default",
 			envKey: "AMP_SECURITY_RATE_LIMIT",
@@ -294,6 +363,11 @@ func TestValidate_TableDriven(t *testing.T) {
 			mutate:  func(cfg *Config) { cfg.Server.MaxPayloadSize = -1 },
 			wantErr: true,
 		},
+		{
+			name:    "negative max response size",
+			mutate:  func(cfg *Config) { cfg.Server.MaxResponseSize = -1 },
+			wantErr: true,
+		},
 		{
 			name:    "zero read timeout",
 			mutate:  func(cfg *Config) { cfg.Server.ReadTimeout = 0 },
@@ -322,11 +396,34 @@ func TestValidate_TableDriven(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:    "redis storage without an address",
+			mutate:  func(cfg *Config) { cfg.Storage.Type = "redis"; cfg.Storage.RedisAddr = "" },
+			wantErr: true,
+		},
+		{
+			name: "redis storage with an address",
+			mutate: func(cfg *Config) {
+				cfg.Storage.Type = "redis"
+				cfg.Storage.RedisAddr = "localhost:6379"
+			},
+			wantErr: false,
+		},
+		{
+			name:    "negative redis db",
+			mutate:  func(cfg *Config) { cfg.Storage.RedisDB = -1 },
+			wantErr: true,
+		},
 		{
 			name:    "zero default TTL",
 			mutate:  func(cfg *Config) { cfg.Storage.DefaultTTL = 0 },
 			wantErr: true,
 		},
+		{
+			name:    "negative max message age",
+			mutate:  func(cfg *Config) { cfg.Storage.MaxMessageAge = -time.Hour },
+			wantErr: true,
+		},
 		{
 			name:    "invalid log level",
 			mutate:  func(cfg *Config) { cfg.Logging.Level = "trace" },
@@ -347,6 +444,13 @@ func TestValidate_TableDriven(t *testing.T) {
 			mutate:  func(cfg *Config) { cfg.Security.RateLimitPerMinute = 0 },
 			wantErr: false,
 		},
+		{
+			name: "negative per-origin rate limit",
+			mutate: func(cfg *Config) {
+				cfg.Security.RateLimitByOrigin = map[string]int{"https://app.example.com": -1}
+			},
+			wantErr: true,
+		},
 		{
 			name:    "valid storage types - memory",
 			mutate:  func(cfg *Config) { cfg.Storage.Type = "memory" },
@@ -357,6 +461,16 @@ func TestValidate_TableDriven(t *testing.T) {
 			mutate:  func(cfg *Config) { cfg.Storage.Type = "redis" },
 			wantErr: false,
 		},
+		{
+			name:    "negative log sample rate",
+			mutate:  func(cfg *Config) { cfg.Logging.SampleEvery = -1 },
+			wantErr: true,
+		},
+		{
+			name:    "negative log rate limit",
+			mutate:  func(cfg *Config) { cfg.Logging.MaxPerSecond = -1 },
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -606,6 +720,130 @@ func TestLoadFromFile_NonExistent(t *testing.T) {
 	}
 }
 
+func TestLoadFromFile_Directory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("Mkdir returned error: %v", err)
+	}
+
+	_, err := Load(dir)
+	if err == nil {
+		t.Fatal("Load() returned nil, want error for a directory path")
+	}
+	if !strings.Contains(err.Error(), "is a directory, expected a file") {
+		t.Errorf("Load() error = %q, want it to say the path is a directory", err)
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	valid := filepath.Join(tmpDir, "valid.yaml")
+	if err := os.WriteFile(valid, []byte("server:\n  address: \":9102\"\n"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if err := ValidateFile(valid); err != nil {
+		t.Errorf("ValidateFile(valid) = %v, want nil", err)
+	}
+
+	invalid := filepath.Join(tmpDir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("storage:\n  type: \"floppy\"\n"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if err := ValidateFile(invalid); err == nil {
+		t.Error("ValidateFile(invalid) returned nil, want error")
+	}
+
+	if err := ValidateFile(""); err == nil {
+		t.Error("ValidateFile(\"\") returned nil, want error")
+	}
+}
+
+func TestLoad_Stdin(t *testing.T) {
+	orig := stdin
+	t.Cleanup(func() { stdin = orig })
+
+	stdin = strings.NewReader("server:\n  address: \":9101\"\n")
+	t.Setenv("AMP_CONFIG_FORMAT", "")
+	if _, err := Load("-"); err == nil {
+		t.Error("Load(\"-\") without AMP_CONFIG_FORMAT returned nil, want error")
+	}
+
+	stdin = strings.NewReader("server:\n  address: \":9101\"\n")
+	t.Setenv("AMP_CONFIG_FORMAT", "yaml")
+	cfg, err := Load("-")
+	if err != nil {
+		t.Fatalf("Load(\"-\") returned error: %v", err)
+	}
+	if cfg.Server.Address != ":9101" {
+		t.Errorf("Server.Address = %q, want %q", cfg.Server.Address, ":9101")
+	}
+
+	// Loaded values are validated as usual
+	stdin = strings.NewReader(`{"storage": {"type": "tape"}}`)
+	t.Setenv("AMP_CONFIG_FORMAT", "json")
+	if _, err := Load("-"); err == nil {
+		t.Error("Load(\"-\") accepted an invalid storage type")
+	}
+}
+
+func TestLoad_URL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/relay.yaml":
+			w.Write([]byte("server:\n  address: \":9102\"\n"))
+		case "/config":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"server": {"address": ":9103"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("AMP_CONFIG_FORMAT", "")
+
+	tests := []struct {
+		path     string
+		wantAddr string
+	}{
+		{"/relay.yaml", ":9102"}, // format from the URL extension
+		{"/config", ":9103"},     // format from the content type
+	}
+	for _, tt := range tests {
+		cfg, err := Load(srv.URL + tt.path)
+		if err != nil {
+			t.Fatalf("Load(%s) returned error: %v", tt.path, err)
+		}
+		if cfg.Server.Address != tt.wantAddr {
+			t.Errorf("Load(%s): Server.Address = %q, want %q", tt.path, cfg.Server.Address, tt.wantAddr)
+		}
+	}
+
+	if _, err := Load(srv.URL + "/missing.yaml"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Load(missing) error = %v, want a 404 failure", err)
+	}
+}
+
+func TestLoad_OversizedConfig(t *testing.T) {
+	huge := "# " + strings.Repeat("x", maxConfigSize) + "\nserver:\n  address: \":9104\"\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(huge))
+	}))
+	defer srv.Close()
+
+	if _, err := Load(srv.URL + "/relay.yaml"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Load(URL) error = %v, want a size limit failure", err)
+	}
+
+	orig := stdin
+	t.Cleanup(func() { stdin = orig })
+	stdin = strings.NewReader(huge)
+	t.Setenv("AMP_CONFIG_FORMAT", "yaml")
+	if _, err := Load("-"); err == nil || !strings.Contains(err.Error(), "exceeds") {
+		t.Errorf("Load(\"-\") error = %v, want a size limit failure", err)
+	}
+}
+
 func TestLoadFromFile_InvalidYAML(t *testing.T) {
 	tmpDir := t.TempDir()
 	yamlPath := filepath.Join(tmpDir, "bad.yaml")
@@ -778,3 +1016,32 @@ func TestLoad_EnvOverride_ValidationFailure(t *testing.T) {
 		t.Fatal("Load() returned nil, want validation error for invalid storage type from env")
 	}
 }
+
+func TestLoad_EnvOverride_InvalidOriginsJSON(t *testing.T) {
+	// A leading '[' selects the JSON form, so malformed JSON is an error
+	// rather than being split on commas
+	t.Setenv("AMP_SECURITY_ALLOWED_ORIGINS", `["https://example.com",`)
+
+	_, err := Load("")
+	if err == nil {
+		t.Fatal("Load() returned nil, want error for malformed JSON origins")
+	}
+}
+
+func TestLoad_EmptyAllowedOriginsRejected(t *testing.T) {
+	// The transport reads an empty origin list as allow-all, the opposite of
+	// what an operator writing [] means, so it is refused
+	t.Setenv("AMP_SECURITY_ALLOWED_ORIGINS", "[]")
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "allowed origins") {
+		t.Errorf("Load() with [] in env error = %v, want an empty origins failure", err)
+	}
+	t.Setenv("AMP_SECURITY_ALLOWED_ORIGINS", "")
+
+	yamlPath := filepath.Join(t.TempDir(), "origins.yaml")
+	if err := os.WriteFile(yamlPath, []byte("security:\n  allowed_origins: []\n"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+	if _, err := Load(yamlPath); err == nil || !strings.Contains(err.Error(), "allowed origins") {
+		t.Errorf("Load() with [] in file error = %v, want an empty origins failure", err)
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFromDir loads configuration from a directory holding one file per
+// setting, as Kubernetes mounts a ConfigMap or Secret. Each file is named
+// after a dotted section.key path (server.address, storage.type, ...) and
+// holds its value; surrounding whitespace is ignored and list values use
+// YAML syntax. Hidden entries, such as the ..data links Kubernetes adds,
+// and subdirectories are skipped. Environment variables are then applied
+// and the result validated, as with Load.
+func LoadFromDir(dir string) (*Config, error) {
+	config := DefaultConfig()
+
+	if err := loadFromDir(config, dir); err != nil {
+		return nil, fmt.Errorf("failed to load config directory: %w", err)
+	}
+
+	if err := loadFromEnv(config); err != nil {
+		return nil, fmt.Errorf("failed to load environment variables: %w", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadFromDir assembles the settings in dir into a YAML document and
+// decodes it over config
+func loadFromDir(config *Config, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	known := knownKeys()
+	raw := make(map[string]interface{})
+	var unknown []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") {
+			continue
+		}
+		path := filepath.Join(dir, name)
+		// Mounted keys are usually symlinks, so stat through them
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		if !known[name] {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		setPath(raw, name, dirValue(strings.TrimSpace(string(data))))
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown config keys: %s", strings.Join(unknown, ", "))
+	}
+
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to parse settings: %w", err)
+	}
+	return nil
+}
+
+// dirValue interprets a file's content as a YAML scalar or list, so numbers,
+// booleans and lists reach their fields typed; anything else is a string
+func dirValue(s string) interface{} {
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(s), &v); err != nil || v == nil {
+		return s
+	}
+	if _, isMap := v.(map[string]interface{}); isMap {
+		return s
+	}
+	return v
+}
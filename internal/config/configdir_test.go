@@ -0,0 +1,112 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfigDir lays out files as a Kubernetes ConfigMap mount would,
+// including the hidden ..data link directory
+func writeConfigDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	data := filepath.Join(dir, "..data")
+	if err := os.Mkdir(data, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(data, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("..data", name), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadFromDir(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{
+		"server.address":           "0.0.0.0:9000\n",
+		"server.enable_websocket":  "false",
+		"server.max_payload_size":  "2048",
+		"storage.type":             "file",
+		"storage.path":             "/var/lib/amp",
+		"storage.default_ttl":      "10m",
+		"security.allowed_origins": "- https://a.example\n- https://b.example\n",
+		"logging.level":            "debug",
+	})
+
+	cfg, err := LoadFromDir(dir)
+	if err != nil {
+		t.Fatalf("LoadFromDir returned error: %v", err)
+	}
+
+	if cfg.Server.Address != "0.0.0.0:9000" {
+		t.Errorf("Server.Address = %q, want %q", cfg.Server.Address, "0.0.0.0:9000")
+	}
+	if cfg.Server.EnableWebSocket {
+		t.Error("Server.EnableWebSocket = true, want false")
+	}
+	if cfg.Server.MaxPayloadSize != 2048 {
+		t.Errorf("Server.MaxPayloadSize = %d, want 2048", cfg.Server.MaxPayloadSize)
+	}
+	if cfg.Storage.Type != "file" || cfg.Storage.Path != "/var/lib/amp" {
+		t.Errorf("Storage = %q at %q, want file at /var/lib/amp", cfg.Storage.Type, cfg.Storage.Path)
+	}
+	if cfg.Storage.DefaultTTL != 10*time.Minute {
+		t.Errorf("Storage.DefaultTTL = %v, want %v", cfg.Storage.DefaultTTL, 10*time.Minute)
+	}
+	if want := []string{"https://a.example", "https://b.example"}; !reflect.DeepEqual(cfg.Security.AllowedOrigins, want) {
+		t.Errorf("Security.AllowedOrigins = %v, want %v", cfg.Security.AllowedOrigins, want)
+	}
+	if cfg.Logging.Level != "debug" {
+		t.Errorf("Logging.Level = %q, want debug", cfg.Logging.Level)
+	}
+
+	// Settings without a file keep their defaults
+	if def := DefaultConfig(); cfg.Server.ReadTimeout != def.Server.ReadTimeout {
+		t.Errorf("Server.ReadTimeout = %v, want default %v", cfg.Server.ReadTimeout, def.Server.ReadTimeout)
+	}
+}
+
+func TestLoadFromDir_EnvOverridesFiles(t *testing.T) {
+	dir := writeConfigDir(t, map[string]string{"logging.level": "debug"})
+	t.Setenv("AMP_LOG_LEVEL", "warn")
+
+	cfg, err := LoadFromDir(dir)
+	if err != nil {
+		t.Fatalf("LoadFromDir returned error: %v", err)
+	}
+	if cfg.Logging.Level != "warn" {
+		t.Errorf("Logging.Level = %q, want the environment's warn", cfg.Logging.Level)
+	}
+}
+
+func TestLoadFromDir_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{"unknown key", map[string]string{"server.listen": ":9000", "storage.backend": "file"}, "unknown config keys: server.listen, storage.backend"},
+		{"bad value", map[string]string{"server.max_payload_size": "lots"}, "failed to parse settings"},
+		{"invalid config", map[string]string{"storage.type": "tape"}, "invalid storage type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFromDir(writeConfigDir(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("LoadFromDir error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadFromDir(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadFromDir on a missing directory returned no error")
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ApplyMergePatch applies an RFC 7386 JSON Merge Patch to the configuration,
+// e.g. {"security":{"rate_limit_per_minute":120}}. Keys use the JSON field
+// names and durations are nanoseconds, as in a JSON config file. The result
+// is validated; if the patch is malformed or the result invalid, an error is
+// returned and the configuration is left unchanged.
+func (c *Config) ApplyMergePatch(patch []byte) error {
+	var patchDoc interface{}
+	if err := json.Unmarshal(patch, &patchDoc); err != nil {
+		return fmt.Errorf("invalid merge patch: %w", err)
+	}
+
+	current, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return fmt.Errorf("failed to decode config: %w", err)
+	}
+
+	merged, err := json.Marshal(mergePatch(doc, patchDoc))
+	if err != nil {
+		return fmt.Errorf("failed to encode patched config: %w", err)
+	}
+
+	// Reject keys the config doesn't have rather than silently dropping them
+	var patched Config
+	dec := json.NewDecoder(bytes.NewReader(merged))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&patched); err != nil {
+		return fmt.Errorf("invalid merge patch: %w", err)
+	}
+	if err := patched.Validate(); err != nil {
+		return fmt.Errorf("config validation failed: %w", err)
+	}
+
+	*c = patched
+	return nil
+}
+
+// mergePatch implements the RFC 7386 MergePatch algorithm: objects are merged
+// key by key, null removes a key, and any other value replaces the target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyMergePatch_PartialUpdate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Security.AllowedOrigins = []string{"https://app.example.com"}
+
+	patch := `{"security": {"rate_limit_per_minute": 120}, "logging": {"level": "debug"}}`
+	if err := cfg.ApplyMergePatch([]byte(patch)); err != nil {
+		t.Fatalf("ApplyMergePatch returned error: %v", err)
+	}
+
+	if cfg.Security.RateLimitPerMinute != 120 {
+		t.Errorf("Security.RateLimitPerMinute = %d, want %d", cfg.Security.RateLimitPerMinute, 120)
+	}
+	if cfg.Logging.Level != "debug" {
+		t.Errorf("Logging.Level = %q, want %q", cfg.Logging.Level, "debug")
+	}
+
+	// Everything the patch doesn't mention is untouched
+	want := DefaultConfig()
+	want.Security.AllowedOrigins = []string{"https://app.example.com"}
+	want.Security.RateLimitPerMinute = 120
+	want.Logging.Level = "debug"
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("patched config = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestApplyMergePatch_NullRemovesKey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Security.RateLimitByOrigin = map[string]int{"https://a.example.com": 10, "https://b.example.com": 20}
+
+	patch := `{"security": {"rate_limit_by_origin": {"https://a.example.com": null, "https://c.example.com": 30}}}`
+	if err := cfg.ApplyMergePatch([]byte(patch)); err != nil {
+		t.Fatalf("ApplyMergePatch returned error: %v", err)
+	}
+
+	want := map[string]int{"https://b.example.com": 20, "https://c.example.com": 30}
+	if !reflect.DeepEqual(cfg.Security.RateLimitByOrigin, want) {
+		t.Errorf("Security.RateLimitByOrigin = %v, want %v", cfg.Security.RateLimitByOrigin, want)
+	}
+}
+
+func TestApplyMergePatch_InvalidLeavesConfigUnchanged(t *testing.T) {
+	patches := map[string]string{
+		"malformed JSON":      `{"logging":`,
+		"fails validation":    `{"logging": {"level": "loud"}, "security": {"rate_limit_per_minute": 120}}`,
+		"negative rate limit": `{"security": {"rate_limit_per_minute": -1}}`,
+		"unknown key":         `{"server": {"no_such_field": true}}`,
+		"wrong type":          `{"server": {"address": 8080}}`,
+	}
+
+	for name, patch := range patches {
+		t.Run(name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			if err := cfg.ApplyMergePatch([]byte(patch)); err == nil {
+				t.Fatal("ApplyMergePatch returned nil, want error")
+			}
+			if !reflect.DeepEqual(cfg, DefaultConfig()) {
+				t.Errorf("config changed by a rejected patch: %+v", cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// renamedKeys maps keys from older config files to their current name, as
+// dotted section.key paths. No key has been renamed since the first
+// released schema; add an entry here whenever one is.
+var renamedKeys = map[string]string{}
+
+// Migrate builds a Config from a raw, possibly old-style, configuration map.
+// Renamed keys are moved to their current name, unknown keys are dropped and
+// anything missing keeps its default. The returned warnings describe every
+// change so the file can be updated.
+func Migrate(raw map[string]interface{}) (*Config, []string) {
+	config := DefaultConfig()
+	var warnings []string
+
+	known := knownKeys()
+	current := make(map[string]interface{})
+
+	// Sort the keys so the warnings come out in a stable order
+	sections := make([]string, 0, len(raw))
+	for section := range raw {
+		sections = append(sections, section)
+	}
+	sort.Strings(sections)
+
+	for _, section := range sections {
+		values, ok := asMap(raw[section])
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: expected a section, ignored", section))
+			continue
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			path := section + "." + key
+			if newPath, renamed := renamedKeys[path]; renamed {
+				if _, set := lookupPath(raw, newPath); set {
+					warnings = append(warnings, fmt.Sprintf("%s is deprecated and %s is also set; using %s", path, newPath, newPath))
+					continue
+				}
+				warnings = append(warnings, fmt.Sprintf("%s is deprecated; use %s", path, newPath))
+				path = newPath
+			} else if !known[path] {
+				warnings = append(warnings, fmt.Sprintf("%s: unknown key, ignored", path))
+				continue
+			}
+			setPath(current, path, values[key])
+		}
+	}
+
+	for _, section := range []string{"server", "storage", "logging", "security"} {
+		if _, ok := raw[section]; !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: section missing, using defaults", section))
+		}
+	}
+
+	// Round-trip through YAML so durations may be given as "30s" as well as
+	// nanoseconds, the same as in a config file
+	data, err := yaml.Marshal(current)
+	if err != nil {
+		return config, append(warnings, fmt.Sprintf("failed to encode migrated config: %v", err))
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return config, append(warnings, fmt.Sprintf("failed to decode migrated config: %v", err))
+		}
+		// Fields that decoded cleanly are kept; the rest keep their defaults
+		warnings = append(warnings, typeErr.Errors...)
+	}
+
+	return config, warnings
+}
+
+// knownKeys returns the dotted section.key paths of the current schema
+func knownKeys() map[string]bool {
+	keys := make(map[string]bool)
+	root := reflect.TypeOf(Config{})
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Field(i)
+		prefix := yamlName(section)
+		for j := 0; j < section.Type.NumField(); j++ {
+			keys[prefix+"."+yamlName(section.Type.Field(j))] = true
+		}
+	}
+	return keys
+}
+
+// yamlName returns the key a struct field is read from
+func yamlName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	if name == "" {
+		return strings.ToLower(field.Name)
+	}
+	return name
+}
+
+// asMap accepts the map shapes produced by both the JSON and YAML decoders
+func asMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, val := range m {
+			out[fmt.Sprint(k)] = val
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// lookupPath reports whether a dotted section.key path is set in raw
+func lookupPath(raw map[string]interface{}, path string) (interface{}, bool) {
+	section, key, _ := strings.Cut(path, ".")
+	values, ok := asMap(raw[section])
+	if !ok {
+		return nil, false
+	}
+	v, ok := values[key]
+	return v, ok
+}
+
+// setPath stores v under a dotted section.key path
+func setPath(m map[string]interface{}, path string, v interface{}) {
+	section, key, _ := strings.Cut(path, ".")
+	values, ok := m[section].(map[string]interface{})
+	if !ok {
+		values = make(map[string]interface{})
+		m[section] = values
+	}
+	values[key] = v
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMigrate_OldStyleConfig(t *testing.T) {
+	// Written against the first released schema, before the Redis, log
+	// sampling and message age settings existed
+	old := `
+server:
+  address: ":9000"
+  workers: 4
+storage:
+  type: file
+  path: /var/lib/amp
+  default_ttl: 10m
+security:
+  rate_limit_per_minute: 20
+`
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal([]byte(old), &raw); err != nil {
+		t.Fatalf("yaml.Unmarshal returned error: %v", err)
+	}
+
+	cfg, warnings := Migrate(raw)
+
+	wantWarnings := []string{
+		"server.workers: unknown key, ignored",
+		"logging: section missing, using defaults",
+	}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings =\n%q\nwant\n%q", warnings, wantWarnings)
+	}
+
+	// Keys present in the old file carry over
+	if cfg.Server.Address != ":9000" {
+		t.Errorf("Server.Address = %q, want %q", cfg.Server.Address, ":9000")
+	}
+	if cfg.Storage.Type != "file" {
+		t.Errorf("Storage.Type = %q, want %q", cfg.Storage.Type, "file")
+	}
+	if cfg.Storage.DefaultTTL != 10*time.Minute {
+		t.Errorf("Storage.DefaultTTL = %v, want %v", cfg.Storage.DefaultTTL, 10*time.Minute)
+	}
+	if cfg.Security.RateLimitPerMinute != 20 {
+		t.Errorf("Security.RateLimitPerMinute = %d, want %d", cfg.Security.RateLimitPerMinute, 20)
+	}
+	if cfg.Storage.Path != "/var/lib/amp" {
+		t.Errorf("Storage.Path = %q, want %q", cfg.Storage.Path, "/var/lib/amp")
+	}
+
+	// Anything the old file lacks keeps its default
+	defaults := DefaultConfig()
+	if cfg.Server.ReadTimeout != defaults.Server.ReadTimeout {
+		t.Errorf("Server.ReadTimeout = %v, want default %v", cfg.Server.ReadTimeout, defaults.Server.ReadTimeout)
+	}
+	if cfg.Server.MaxPayloadSize != defaults.Server.MaxPayloadSize {
+		t.Errorf("Server.MaxPayloadSize = %d, want default %d", cfg.Server.MaxPayloadSize, defaults.Server.MaxPayloadSize)
+	}
+	if cfg.Storage.RedisAddr != defaults.Storage.RedisAddr {
+		t.Errorf("Storage.RedisAddr = %q, want default %q", cfg.Storage.RedisAddr, defaults.Storage.RedisAddr)
+	}
+	if !reflect.DeepEqual(cfg.Logging, defaults.Logging) {
+		t.Errorf("Logging = %+v, want defaults %+v", cfg.Logging, defaults.Logging)
+	}
+	if !reflect.DeepEqual(cfg.Security.AllowedOrigins, defaults.Security.AllowedOrigins) {
+		t.Errorf("Security.AllowedOrigins = %v, want default %v", cfg.Security.AllowedOrigins, defaults.Security.AllowedOrigins)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("migrated config failed validation: %v", err)
+	}
+}
+
+func TestMigrate_RenamedKeys(t *testing.T) {
+	orig := renamedKeys
+	t.Cleanup(func() { renamedKeys = orig })
+	renamedKeys = map[string]string{
+		"server.listen":       "server.address",
+		"security.rate_limit": "security.rate_limit_per_minute",
+	}
+
+	raw := map[string]interface{}{
+		"server":   map[string]interface{}{"listen": ":9000"},
+		"security": map[string]interface{}{"rate_limit": 10, "rate_limit_per_minute": 20},
+	}
+	cfg, warnings := Migrate(raw)
+
+	wantWarnings := []string{
+		"security.rate_limit is deprecated and security.rate_limit_per_minute is also set; using security.rate_limit_per_minute",
+		"server.listen is deprecated; use server.address",
+		"storage: section missing, using defaults",
+		"logging: section missing, using defaults",
+	}
+	if !reflect.DeepEqual(warnings, wantWarnings) {
+		t.Errorf("warnings =\n%q\nwant\n%q", warnings, wantWarnings)
+	}
+	if cfg.Server.Address != ":9000" {
+		t.Errorf("Server.Address = %q, want %q", cfg.Server.Address, ":9000")
+	}
+	if cfg.Security.RateLimitPerMinute != 20 {
+		t.Errorf("Security.RateLimitPerMinute = %d, want %d", cfg.Security.RateLimitPerMinute, 20)
+	}
+}
+
+func TestMigrate_CurrentConfigHasNoWarnings(t *testing.T) {
+	data, err := yaml.Marshal(DefaultConfig())
+	if err != nil {
+		t.Fatalf("yaml.Marshal returned error: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		t.Fatalf("yaml.Unmarshal returned error: %v", err)
+	}
+
+	cfg, warnings := Migrate(raw)
+	if len(warnings) != 0 {
+		t.Errorf("warnings = %q, want none", warnings)
+	}
+	if !reflect.DeepEqual(cfg, DefaultConfig()) {
+		t.Errorf("Migrate(defaults) = %+v, want %+v", cfg, DefaultConfig())
+	}
+}
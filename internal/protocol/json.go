@@ -0,0 +1,53 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// JSONMarshal encodes the message using JSON. Maps decoded from CBOR in the
+// body and extensions are converted; the message itself is left untouched.
+func (m *Message) JSONMarshal() ([]byte, error) {
+	return json.Marshal(m.JSONCompatible())
+}
+
+// JSONUnmarshal decodes the message from JSON
+func (m *Message) JSONUnmarshal(data []byte) error {
+	return json.Unmarshal(data, m)
+}
+
+// JSONCompatible returns a shallow copy of the message whose body and
+// extensions encoding/json can marshal. CBOR decodes maps with interface
+// keys, which it can't.
+func (m *Message) JSONCompatible() *Message {
+	out := *m
+	out.Body = jsonValue(m.Body)
+	if m.Ext != nil {
+		out.Ext = make(map[string]interface{}, len(m.Ext))
+		for k, v := range m.Ext {
+			out.Ext[k] = jsonValue(v)
+		}
+	}
+	return &out
+}
+
+// jsonValue converts CBOR-decoded maps with interface keys into maps that
+// encoding/json can marshal. It copies rather than modifies v.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[fmt.Sprint(k)] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i := range v {
+			s[i] = jsonValue(v[i])
+		}
+		return s
+	default:
+		return v
+	}
+}
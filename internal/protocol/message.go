@@ -4,6 +4,8 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"reflect"
 	"time"
 
 	cbor "github.com/fxamacker/cbor/v2"
@@ -70,8 +72,11 @@ const (
 	// Extension (0xF0-0xFF)
 	MessageTypeExtension MessageType = 0xF0
 
-	// MessageTypeEvent is kept as an alias for backward compat during transition
-	MessageTypeEvent MessageType = 0x10
+	// MessageTypeEvent is the pre-v5 name for MessageTypeMessage and shares
+	// its wire code; it is not a distinct type.
+	//
+	// Deprecated: use MessageTypeMessage.
+	MessageTypeEvent = MessageTypeMessage
 )
 
 // Message represents the base AMP v5.0 message per RFC 001 §4.1
@@ -88,6 +93,7 @@ type Message struct {
 	Sig      []byte      `cbor:"10,keyasint,omitempty" json:"sig,omitempty"`   // Ed25519 signature
 	Body     interface{} `cbor:"11,keyasint,omitempty" json:"body,omitempty"`  // Message body (type-dependent)
 	Ext      map[string]interface{} `cbor:"12,keyasint,omitempty" json:"ext,omitempty"` // Extension fields (NOT signed)
+	Headers  map[string]string      `cbor:"13,keyasint,omitempty" json:"headers,omitempty"` // Transport headers (signer, key ID, algorithm)
 }
 
 // NewMessage creates a new AMP message with RFC 001 defaults
@@ -110,14 +116,119 @@ func (m *Message) IDHex() string {
 	return hex.EncodeToString(m.ID)
 }
 
-// IsExpired checks if the message has expired based on TTL per RFC 001 §8.3
+// IsExpired checks if the message has expired based on TTL per RFC 001 §8.3.
+// TTL=0 means immediate delivery, not "no expiration": such a message is
+// deliverable when it arrives, so it is never reported expired here, but it
+// must not be held for later (storage gives a zero TTL a zero lifetime).
 func (m *Message) IsExpired() bool {
 	if m.TTL == 0 {
-		return false // TTL=0 means immediate delivery, not "no expiration"
+		return false
 	}
 	return uint64(time.Now().UnixMilli()) > m.Ts+m.TTL
 }
 
+// ValidationError describes why a message failed policy validation
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid message [%s]: %s", e.Code, e.Message)
+}
+
+// Validation error codes
+const (
+	ErrCodeBodyTooDeep = "body_too_deep"
+)
+
+// Validate checks the message against application-level policy.
+// maxBodyDepth limits how deeply maps and arrays may nest in Body (0 = unlimited);
+// a scalar body has depth 0 and each level of map/array adds one.
+func (m *Message) Validate(maxBodyDepth int) error {
+	if maxBodyDepth > 0 {
+		if depth := valueDepth(reflect.ValueOf(m.Body), maxBodyDepth+1); depth > maxBodyDepth {
+			return &ValidationError{
+				Code:    ErrCodeBodyTooDeep,
+				Message: fmt.Sprintf("body nesting exceeds maximum depth %d", maxBodyDepth),
+			}
+		}
+	}
+	return nil
+}
+
+// valueDepth returns the nesting depth of maps, slices and arrays in v,
+// stopping once limit is reached so hostile bodies are not walked in full
+func valueDepth(v reflect.Value, limit int) int {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+	if limit <= 0 {
+		return 0
+	}
+
+	max := 0
+	switch v.Kind() {
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if d := valueDepth(iter.Value(), limit-1); d > max {
+				max = d
+			}
+			if max >= limit-1 {
+				break
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return 0 // raw bytes are a scalar
+		}
+		for i := 0; i < v.Len() && max < limit-1; i++ {
+			if d := valueDepth(v.Index(i), limit-1); d > max {
+				max = d
+			}
+		}
+	default:
+		return 0
+	}
+	return max + 1
+}
+
+// BodyBytes returns the body as raw bytes if it was carried as a CBOR byte string
+func (m *Message) BodyBytes() ([]byte, bool) {
+	b, ok := m.Body.([]byte)
+	return b, ok
+}
+
+// Decoder limits guarding against CBOR bombs: small inputs that decode into
+// deeply nested or huge structures. String lengths need no separate cap since
+// a declared length larger than the remaining input is rejected outright, so
+// the transport read limit bounds them.
+const (
+	MaxCBORNestedLevels  = 16
+	MaxCBORArrayElements = 16384
+	MaxCBORMapPairs      = 16384
+)
+
+// decMode decodes CBOR byte strings in interface{} fields (Body, Ext) as []byte,
+// so raw binary bodies survive a round-trip instead of being coerced to another type,
+// and enforces the structural limits above
+var decMode = func() cbor.DecMode {
+	dm, err := cbor.DecOptions{
+		DefaultByteStringType: reflect.TypeOf([]byte(nil)),
+		MaxNestedLevels:       MaxCBORNestedLevels,
+		MaxArrayElements:      MaxCBORArrayElements,
+		MaxMapPairs:           MaxCBORMapPairs,
+	}.DecMode()
+	if err != nil {
+		panic("cbor: invalid decode options: " + err.Error())
+	}
+	return dm
+}()
+
 // CBORMarshal encodes the message using CBOR
 func (m *Message) CBORMarshal() ([]byte, error) {
 	return cbor.Marshal(m)
@@ -125,7 +236,7 @@ func (m *Message) CBORMarshal() ([]byte, error) {
 
 // CBORUnmarshal decodes the message from CBOR
 func (m *Message) CBORUnmarshal(data []byte) error {
-	return cbor.Unmarshal(data, m)
+	return decMode.Unmarshal(data, m)
 }
 
 // generateID generates a 16-byte message ID per RFC 001 §4.2
@@ -138,3 +249,55 @@ func generateID(now time.Time) []byte {
 	}
 	return id
 }
+
+// messageTypeNames maps type codes to the lowercase names used in RFC 001 §4.3
+var messageTypeNames = map[MessageType]string{
+	MessageTypePing:           "ping",
+	MessageTypePong:           "pong",
+	MessageTypeACK:            "ack",
+	MessageTypeProcOK:         "proc_ok",
+	MessageTypeProcFail:       "proc_fail",
+	MessageTypeContactRequest: "contact_request",
+	MessageTypeContactResp:    "contact_response",
+	MessageTypeContactRevoke:  "contact_revoke",
+	MessageTypeProcessing:     "processing",
+	MessageTypeProgress:       "progress",
+	MessageTypeInputRequired:  "input_required",
+	MessageTypeError:          "error",
+	MessageTypeMessage:        "message",
+	MessageTypeRequest:        "request",
+	MessageTypeResponse:       "response",
+	MessageTypeStreamStart:    "stream_start",
+	MessageTypeStreamData:     "stream_data",
+	MessageTypeStreamEnd:      "stream_end",
+	MessageTypeCapQuery:       "cap_query",
+	MessageTypeCapDeclare:     "cap_declare",
+	MessageTypeCapInvoke:      "cap_invoke",
+	MessageTypeCapResult:      "cap_result",
+	MessageTypeDocSend:        "doc_send",
+	MessageTypeDocRequest:     "doc_request",
+	MessageTypeCredIssue:      "cred_issue",
+	MessageTypeCredRequest:    "cred_request",
+	MessageTypeCredPresent:    "cred_present",
+	MessageTypeCredVerify:     "cred_verify",
+	MessageTypeDelegGrant:     "deleg_grant",
+	MessageTypeDelegRevoke:    "deleg_revoke",
+	MessageTypeDelegQuery:     "deleg_query",
+	MessageTypePresence:       "presence",
+	MessageTypePresenceQuery:  "presence_query",
+	MessageTypePresenceSub:    "presence_sub",
+	MessageTypePresenceUnsub:  "presence_unsub",
+	MessageTypeHello:          "hello",
+	MessageTypeHelloACK:       "hello_ack",
+	MessageTypeHelloReject:    "hello_reject",
+	MessageTypeExtension:      "extension",
+}
+
+// Name returns the lowercase name of the type, or its hex code if unknown.
+// It is deliberately not String so %x formatting keeps printing the code.
+func (t MessageType) Name() string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", uint8(t))
+}
@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bytes"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -253,3 +254,258 @@ func BenchmarkMessage_CBORUnmarshal(b *testing.B) {
 		decoded.CBORUnmarshal(data)
 	}
 }
+
+func BenchmarkMessage_CBORUnmarshalPooled(b *testing.B) {
+	msg := NewMessage(MessageTypeRequest, "did:web:alice", "did:web:bob", []byte("benchmark payload"))
+	data, _ := msg.CBORMarshal()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decoded := AcquireMessage()
+		decoded.CBORUnmarshal(data)
+		ReleaseMessage(decoded)
+	}
+}
+
+func TestMessagePool_ResetBetweenUses(t *testing.T) {
+	full := NewMessage(MessageTypeRequest, "did:web:alice", "did:web:bob", map[string]interface{}{"action": "echo"})
+	full.ReplyTo = []byte("reply")
+	full.ThreadID = []byte("thread")
+	full.Sig = []byte("sig")
+	full.Ext = map[string]interface{}{"trace": "abc"}
+	fullData, _ := full.CBORMarshal()
+
+	// A minimal message omits every optional field
+	sparse := &Message{V: 1, ID: []byte("0123456789abcdef"), Type: MessageTypePing}
+	sparseData, _ := sparse.CBORMarshal()
+
+	for i := 0; i < 100; i++ {
+		msg := AcquireMessage()
+		if err := msg.CBORUnmarshal(fullData); err != nil {
+			t.Fatalf("CBORUnmarshal failed: %v", err)
+		}
+		ReleaseMessage(msg)
+
+		msg = AcquireMessage()
+		if err := msg.CBORUnmarshal(sparseData); err != nil {
+			t.Fatalf("CBORUnmarshal failed: %v", err)
+		}
+		if msg.Body != nil || msg.Ext != nil || msg.ReplyTo != nil || msg.ThreadID != nil || msg.Sig != nil {
+			t.Fatalf("pooled message kept fields from a previous use: %+v", msg)
+		}
+		if msg.From != "" || msg.To != "" || msg.Type != MessageTypePing {
+			t.Fatalf("pooled message decoded incorrectly: %+v", msg)
+		}
+		ReleaseMessage(msg)
+	}
+
+	ReleaseMessage(nil) // must not panic
+}
+
+func TestMessage_CBORUnmarshal_DoesNotAliasInput(t *testing.T) {
+	original := NewMessage(MessageTypeRequest, "did:web:alice", "did:web:bob",
+		map[string]interface{}{"payload": []byte("raw bytes"), "note": "text"})
+	original.ThreadID = []byte("thread-1")
+	original.Ext = map[string]interface{}{"trace": []byte("trace-id")}
+	data, _ := original.CBORMarshal()
+
+	decoded := &Message{}
+	if err := decoded.CBORUnmarshal(data); err != nil {
+		t.Fatalf("CBORUnmarshal failed: %v", err)
+	}
+
+	// Clobber the input as a reused read buffer would be
+	for i := range data {
+		data[i] = 0xff
+	}
+
+	if !bytes.Equal(decoded.ID, original.ID) || string(decoded.ThreadID) != "thread-1" {
+		t.Error("decoded ID/ThreadID changed after the input buffer was reused")
+	}
+	if decoded.From != "did:web:alice" || decoded.To != "did:web:bob" {
+		t.Error("decoded From/To changed after the input buffer was reused")
+	}
+	body := decoded.Body.(map[interface{}]interface{})
+	if string(body["payload"].([]byte)) != "raw bytes" || body["note"] != "text" {
+		t.Errorf("decoded body changed after the input buffer was reused: %v", body)
+	}
+	if string(decoded.Ext["trace"].([]byte)) != "trace-id" {
+		t.Error("decoded Ext changed after the input buffer was reused")
+	}
+}
+
+func TestMessage_CBORRoundtrip_BinaryBody(t *testing.T) {
+	payload := []byte{0x00, 0x01, 0xfe, 0xff, 'r', 'a', 'w'}
+	original := NewMessage(MessageTypeMessage, "did:web:alice", "did:web:bob", payload)
+
+	data, err := original.CBORMarshal()
+	if err != nil {
+		t.Fatalf("CBORMarshal failed: %v", err)
+	}
+
+	decoded := &Message{}
+	if err := decoded.CBORUnmarshal(data); err != nil {
+		t.Fatalf("CBORUnmarshal failed: %v", err)
+	}
+
+	if _, ok := decoded.Body.([]byte); !ok {
+		t.Fatalf("Body type: got %T, want []byte", decoded.Body)
+	}
+	body, ok := decoded.BodyBytes()
+	if !ok {
+		t.Fatal("BodyBytes returned ok=false for a byte-string body")
+	}
+	if !bytes.Equal(body, payload) {
+		t.Errorf("Body mismatch: got %x, want %x", body, payload)
+	}
+}
+
+func TestMessage_CBORRoundtrip_Headers(t *testing.T) {
+	original := NewMessage(MessageTypeRequest, "did:web:alice", "did:web:bob", "signed")
+	original.Headers = map[string]string{
+		"x-amp-signer":    "did:web:alice",
+		"x-amp-key-id":    "did:web:alice#key-1",
+		"x-amp-algorithm": "EdDSA",
+	}
+	original.Ext = map[string]interface{}{"trace": "abc"}
+
+	data, err := original.CBORMarshal()
+	if err != nil {
+		t.Fatalf("CBORMarshal failed: %v", err)
+	}
+	decoded := &Message{}
+	if err := decoded.CBORUnmarshal(data); err != nil {
+		t.Fatalf("CBORUnmarshal failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(decoded.Headers, original.Headers) {
+		t.Errorf("Headers: got %v, want %v", decoded.Headers, original.Headers)
+	}
+	if _, leaked := decoded.Ext["x-amp-signer"]; leaked || decoded.Ext["trace"] != "abc" {
+		t.Errorf("Ext: got %v, want only trace", decoded.Ext)
+	}
+}
+
+func TestMessage_BodyBytes_StructuredBody(t *testing.T) {
+	msg := NewMessage(MessageTypeRequest, "a", "b", map[string]interface{}{"action": "ping"})
+	if _, ok := msg.BodyBytes(); ok {
+		t.Error("BodyBytes should return ok=false for a map body")
+	}
+
+	msg.Body = nil
+	if _, ok := msg.BodyBytes(); ok {
+		t.Error("BodyBytes should return ok=false for a nil body")
+	}
+}
+
+// encodeWithBody marshals a message and swaps in a raw CBOR body, so tests can
+// feed decoders structures the encoder-side types would not produce
+func encodeWithBody(t *testing.T, body []byte) []byte {
+	t.Helper()
+	msg := NewMessage(MessageTypeRequest, "a", "b", "placeholder")
+	data, err := msg.CBORMarshal()
+	if err != nil {
+		t.Fatalf("CBORMarshal failed: %v", err)
+	}
+	// "placeholder" is encoded as text string 0x6b + 11 bytes at the end of the map
+	placeholder := append([]byte{0x6b}, []byte("placeholder")...)
+	idx := bytes.LastIndex(data, placeholder)
+	if idx < 0 {
+		t.Fatal("placeholder body not found in encoding")
+	}
+	return append(append(data[:idx:idx], body...), data[idx+len(placeholder):]...)
+}
+
+func TestMessage_CBORUnmarshal_DeeplyNested(t *testing.T) {
+	// 100 nested single-element arrays: [[[[...0...]]]]
+	depth := 100
+	body := bytes.Repeat([]byte{0x81}, depth)
+	body = append(body, 0x00)
+
+	msg := &Message{}
+	if err := msg.CBORUnmarshal(encodeWithBody(t, body)); err == nil {
+		t.Error("Expected error for deeply nested body")
+	}
+}
+
+func TestMessage_CBORUnmarshal_HugeArray(t *testing.T) {
+	// Array header declaring far more elements than allowed (0x9a = uint32 length)
+	body := []byte{0x9a, 0x00, 0x10, 0x00, 0x00}
+	body = append(body, bytes.Repeat([]byte{0x00}, 64)...)
+
+	msg := &Message{}
+	if err := msg.CBORUnmarshal(encodeWithBody(t, body)); err == nil {
+		t.Error("Expected error for huge array body")
+	}
+}
+
+func TestMessage_CBORUnmarshal_OversizedStringLength(t *testing.T) {
+	// Byte string header declaring 4 GiB with only a few bytes of data
+	body := []byte{0x5a, 0xff, 0xff, 0xff, 0xff, 'x', 'y'}
+
+	msg := &Message{}
+	if err := msg.CBORUnmarshal(encodeWithBody(t, body)); err == nil {
+		t.Error("Expected error for string length exceeding input")
+	}
+}
+
+func TestMessage_CBORUnmarshal_WithinLimits(t *testing.T) {
+	body := map[string]interface{}{
+		"action": "ping",
+		"nested": map[string]interface{}{"list": []interface{}{1, 2, 3}},
+	}
+	data, err := NewMessage(MessageTypeRequest, "a", "b", body).CBORMarshal()
+	if err != nil {
+		t.Fatalf("CBORMarshal failed: %v", err)
+	}
+	if err := (&Message{}).CBORUnmarshal(data); err != nil {
+		t.Errorf("CBORUnmarshal rejected a normal body: %v", err)
+	}
+}
+
+// nestedBody builds a body of the given depth alternating maps and slices
+func nestedBody(depth int) interface{} {
+	var body interface{} = "leaf"
+	for i := 0; i < depth; i++ {
+		if i%2 == 0 {
+			body = []interface{}{body}
+		} else {
+			body = map[string]interface{}{"next": body}
+		}
+	}
+	return body
+}
+
+func TestMessage_Validate_BodyDepth(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    interface{}
+		max     int
+		wantErr bool
+	}{
+		{"scalar body", "hello", 1, false},
+		{"nil body", nil, 1, false},
+		{"byte body", []byte{1, 2, 3}, 1, false},
+		{"depth at limit", nestedBody(4), 4, false},
+		{"depth over limit", nestedBody(5), 4, true},
+		{"decoded CBOR map", map[interface{}]interface{}{"a": map[interface{}]interface{}{"b": 1}}, 1, true},
+		{"deep but unlimited", nestedBody(50), 0, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := NewMessage(MessageTypeRequest, "a", "b", tc.body)
+			err := msg.Validate(tc.max)
+			if tc.wantErr {
+				ve, ok := err.(*ValidationError)
+				if !ok {
+					t.Fatalf("Validate() = %v, want *ValidationError", err)
+				}
+				if ve.Code != ErrCodeBodyTooDeep {
+					t.Errorf("Code = %q, want %q", ve.Code, ErrCodeBodyTooDeep)
+				}
+			} else if err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+		})
+	}
+}
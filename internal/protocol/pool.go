@@ -0,0 +1,27 @@
+package protocol
+
+import "sync"
+
+// messagePool recycles Message structs across decode cycles
+var messagePool = sync.Pool{
+	New: func() interface{} { return new(Message) },
+}
+
+// AcquireMessage returns an empty Message from the pool
+func AcquireMessage() *Message {
+	return messagePool.Get().(*Message)
+}
+
+// ReleaseMessage resets msg and returns it to the pool. The caller must not
+// use msg, or any slice, map or body value taken from it, afterwards, and
+// must not release a message that has been stored or handed to another
+// goroutine.
+func ReleaseMessage(msg *Message) {
+	if msg == nil {
+		return
+	}
+	// CBOR decoding leaves fields absent from the input untouched, so every
+	// field (Body and Ext included) must be cleared before reuse
+	*msg = Message{}
+	messagePool.Put(msg)
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+// deadLetterKeyPrefix starts the state store keys of messages given up on
+// after their redeliveries went unacknowledged
+const deadLetterKeyPrefix = "dead_letter:"
+
+// ackEntry is a forwarded message awaiting its recipient's ACK
+type ackEntry struct {
+	msg         *protocol.Message
+	redelivered int // redeliveries so far
+	deadline    time.Time
+}
+
+// ackTracker remembers messages forwarded to connected clients until the
+// recipient answers with an ACK replying to them. A message whose ACK is
+// overdue is redelivered up to maxRedeliveries times, then dead-lettered.
+type ackTracker struct {
+	timeout         time.Duration
+	maxRedeliveries int
+	now             func() time.Time
+	mu              sync.Mutex
+	entries         map[string]*ackEntry // keyed by message ID hex
+}
+
+// newAckTracker creates a tracker waiting timeout for each ACK (0 = disabled)
+func newAckTracker(timeout time.Duration, maxRedeliveries int) *ackTracker {
+	return &ackTracker{
+		timeout:         timeout,
+		maxRedeliveries: maxRedeliveries,
+		now:             time.Now,
+		entries:         make(map[string]*ackEntry),
+	}
+}
+
+// track starts waiting for the ACK of a message just forwarded. ACKs
+// themselves are never acknowledged.
+func (a *ackTracker) track(msg *protocol.Message) {
+	if a.timeout <= 0 || len(msg.ID) == 0 || msg.Type == protocol.MessageTypeACK {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries[msg.IDHex()] = &ackEntry{msg: msg, deadline: a.now().Add(a.timeout)}
+}
+
+// ack settles the message replyTo refers to if from is its recipient,
+// reporting whether it was awaiting an ACK
+func (a *ackTracker) ack(replyTo []byte, from string) bool {
+	if a.timeout <= 0 || len(replyTo) == 0 {
+		return false
+	}
+
+	id := hex.EncodeToString(replyTo)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, ok := a.entries[id]
+	if !ok || entry.msg.To != from {
+		return false
+	}
+	delete(a.entries, id)
+	return true
+}
+
+// due collects the messages whose ACK is overdue: those with redeliveries
+// left are returned for redelivery with a fresh deadline, the rest are
+// dropped and returned as dead
+func (a *ackTracker) due() (redeliver, dead []*protocol.Message) {
+	now := a.now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for id, entry := range a.entries {
+		if now.Before(entry.deadline) {
+			continue
+		}
+		if entry.redelivered >= a.maxRedeliveries {
+			delete(a.entries, id)
+			dead = append(dead, entry.msg)
+			continue
+		}
+		entry.redelivered++
+		entry.deadline = now.Add(a.timeout)
+		redeliver = append(redeliver, entry.msg)
+	}
+	return redeliver, dead
+}
+
+// ackLoop checks for overdue ACKs every half AckTimeout until the server stops
+func (s *RelayServer) ackLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.AckTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.redeliverUnacked()
+		}
+	}
+}
+
+// redeliverUnacked resends messages whose ACK is overdue to their
+// recipient's current connection, and dead-letters those out of redeliveries
+func (s *RelayServer) redeliverUnacked() {
+	redeliver, dead := s.acks.due()
+	for _, msg := range redeliver {
+		logger := s.msgLogger(msg)
+		clientID, ok := s.clientForDID(msg.To)
+		if !ok {
+			logger.Debug("Redelivery skipped: destination not connected", "id", msg.IDHex(), "to", msg.To)
+			continue
+		}
+		logger.Debug("Redelivering unacknowledged message", "id", msg.IDHex(), "to", msg.To)
+		if err := s.forwardMessageToClient(clientID, msg); err != nil {
+			logger.Warn("Redelivery failed", "id", msg.IDHex(), "client", clientID, "error", err)
+		}
+	}
+	for _, msg := range dead {
+		if err := s.deadLetter(msg); err != nil {
+			s.msgLogger(msg).Error("Failed to dead-letter message", "id", msg.IDHex(), "error", err)
+		}
+	}
+}
+
+// deadLetter moves msg out of its recipient's mailbox into a dead-letter
+// record in the state store, kept until an operator removes it
+func (s *RelayServer) deadLetter(msg *protocol.Message) error {
+	data, err := msg.CBORMarshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+	if err := s.state.Put(deadLetterKeyPrefix+msg.IDHex(), data, storage.NoExpiry); err != nil {
+		return fmt.Errorf("failed to save dead letter: %w", err)
+	}
+	if err := s.store.Delete(msg.IDHex()); err != nil {
+		return fmt.Errorf("failed to remove dead letter from mailbox: %w", err)
+	}
+	s.msgLogger(msg).Warn("Dead-lettered unacknowledged message", "id", msg.IDHex(), "to", msg.To,
+		"redeliveries", s.config.MaxRedeliveries)
+	return nil
+}
+
+// DeadLetters returns the messages given up on after going unacknowledged
+// through all their redeliveries, oldest first
+func (s *RelayServer) DeadLetters() ([]*protocol.Message, error) {
+	values, err := s.state.List(deadLetterKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	messages := make([]*protocol.Message, 0, len(values))
+	for _, data := range values {
+		msg := &protocol.Message{}
+		if err := msg.CBORUnmarshal(data); err != nil {
+			return nil, fmt.Errorf("failed to decode dead letter: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].IDHex() < messages[j].IDHex()
+	})
+	return messages, nil
+}
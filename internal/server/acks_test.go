@@ -0,0 +1,92 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// TestRelayServer_AckInTime checks that a forwarded message acknowledged
+// before AckTimeout is not redelivered
+func TestRelayServer_AckInTime(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.AckTimeout = 50 * time.Millisecond
+	cfg.MaxRedeliveries = 2
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "ping"})
+	sendTestMessage(t, alice, req)
+	if got := readTestMessage(t, bob); !bytes.Equal(got.ID, req.ID) {
+		t.Fatalf("bob got %s %s, want the request", got.Type.Name(), got.IDHex())
+	}
+
+	ack := protocol.NewMessage(protocol.MessageTypeACK, "did:example:bob", "", nil)
+	ack.ReplyTo = req.ID
+	sendTestMessage(t, bob, ack)
+
+	// Several timeouts pass without a redelivery, or a reply to the ACK
+	bob.SetReadDeadline(time.Now().Add(4 * cfg.AckTimeout))
+	if _, data, err := bob.ReadMessage(); err == nil {
+		t.Errorf("acknowledged message redelivered: %x", data)
+	}
+	if dead, err := srv.DeadLetters(); err != nil || len(dead) != 0 {
+		t.Errorf("DeadLetters() = %d, %v; want none", len(dead), err)
+	}
+}
+
+// TestRelayServer_AckNeverArrives checks that an unacknowledged message is
+// redelivered MaxRedeliveries times, then moved to the dead letters
+func TestRelayServer_AckNeverArrives(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.AckTimeout = 30 * time.Millisecond
+	cfg.MaxRedeliveries = 2
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "ping"})
+	sendTestMessage(t, alice, req)
+
+	// The original delivery and each redelivery
+	for i := 0; i <= cfg.MaxRedeliveries; i++ {
+		if got := readTestMessage(t, bob); !bytes.Equal(got.ID, req.ID) {
+			t.Fatalf("delivery %d: bob got %s %s, want the request", i, got.Type.Name(), got.IDHex())
+		}
+	}
+
+	var dead []*protocol.Message
+	for deadline := time.Now().Add(2 * time.Second); len(dead) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		if dead, err = srv.DeadLetters(); err != nil {
+			t.Fatalf("DeadLetters: %v", err)
+		}
+	}
+	if len(dead) != 1 || !bytes.Equal(dead[0].ID, req.ID) || dead[0].To != "did:example:bob" {
+		t.Fatalf("dead letters = %v, want the request", dead)
+	}
+	if stored, _ := cfg.Storage.Get(req.IDHex()); stored != nil {
+		t.Error("dead-lettered message still in the store")
+	}
+	if stored, _ := cfg.Storage.List(); len(stored) != 0 {
+		t.Errorf("message store holds %d entries after dead-lettering, want none", len(stored))
+	}
+
+	// No more deliveries after dead-lettering
+	bob.SetReadDeadline(time.Now().Add(4 * cfg.AckTimeout))
+	if _, data, err := bob.ReadMessage(); err == nil {
+		t.Errorf("dead-lettered message delivered again: %x", data)
+	}
+}
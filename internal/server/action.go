@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+)
+
+// actionExtractors maps registered body types to functions reading their action
+var actionExtractors sync.Map // reflect.Type -> func(interface{}) string
+
+// RegisterActionExtractor makes extractAction recognise bodies of type T,
+// such as a typed request struct handed to the server in-process. fn returns
+// the body's action, or "" if it has none.
+func RegisterActionExtractor[T any](fn func(T) string) {
+	actionExtractors.Store(reflect.TypeOf((*T)(nil)).Elem(), func(body interface{}) string {
+		return fn(body.(T))
+	})
+}
+
+// registeredAction applies the extractor registered for body's type, if any
+func registeredAction(body interface{}) (string, bool) {
+	if body == nil {
+		return "", false
+	}
+	fn, ok := actionExtractors.Load(reflect.TypeOf(body))
+	if !ok {
+		return "", false
+	}
+	return fn.(func(interface{}) string)(body), true
+}
+
+// rawJSONAction reads just the top-level "action" string from a JSON object
+func rawJSONAction(raw json.RawMessage) string {
+	var body struct {
+		Action json.RawMessage `json:"action"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return ""
+	}
+	var action string
+	if err := json.Unmarshal(body.Action, &action); err != nil {
+		return ""
+	}
+	return action
+}
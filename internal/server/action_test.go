@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// lookupBody is a typed body registered for action extraction in tests
+type lookupBody struct {
+	Op  string
+	Key string
+}
+
+func init() {
+	RegisterActionExtractor(func(b lookupBody) string { return b.Op })
+	RegisterActionExtractor(func(b *lookupBody) string {
+		if b == nil {
+			return ""
+		}
+		return b.Op
+	})
+}
+
+// unregisteredBody is a struct type with no extractor
+type unregisteredBody struct {
+	Action string
+}
+
+func TestExtractAction_TypedAndRawBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     interface{}
+		expected string
+	}{
+		{"raw JSON with action", json.RawMessage(`{"action":"relay.lookup","args":[1,2]}`), "relay.lookup"},
+		{"raw JSON without action", json.RawMessage(`{"args":[1,2]}`), ""},
+		{"raw JSON non-string action", json.RawMessage(`{"action":{"nested":true}}`), ""},
+		{"raw JSON array", json.RawMessage(`["action"]`), ""},
+		{"raw JSON malformed", json.RawMessage(`{"action":`), ""},
+		{"registered struct", lookupBody{Op: "relay.get", Key: "k"}, "relay.get"},
+		{"registered struct pointer", &lookupBody{Op: "relay.put"}, "relay.put"},
+		{"nil registered struct pointer", (*lookupBody)(nil), ""},
+		{"unregistered struct", unregisteredBody{Action: "ignored"}, ""},
+		{"plain bytes are not JSON", []byte(`{"action":"relay.lookup"}`), ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := &protocol.Message{Body: tc.body}
+			if got := extractAction(msg); got != tc.expected {
+				t.Errorf("extractAction() = %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRelayServer_RoutesRawJSONBody(t *testing.T) {
+	srv := NewRelayServer(DefaultConfig())
+	called := make(chan string, 1)
+	srv.RegisterRoute("relay.lookup", func(msg RelayMessage) (RelayMessage, error) {
+		called <- msg.ID()
+		return nil, nil
+	})
+
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "relay-server",
+		json.RawMessage(`{"action":"relay.lookup"}`))
+	if err := srv.handleRequest("client-1", req); err != nil {
+		t.Fatalf("handleRequest() error: %v", err)
+	}
+	select {
+	case id := <-called:
+		if id != req.IDHex() {
+			t.Errorf("handler got message %s, want %s", id, req.IDHex())
+		}
+	default:
+		t.Error("route for raw JSON action was not invoked")
+	}
+}
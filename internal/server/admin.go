@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// adminRoutesPath serves the routing table dump
+const adminRoutesPath = "/amp/v1/admin/routes"
+
+// ListRoutes returns the registered action names in sorted order
+func (s *RelayServer) ListRoutes() []string {
+	s.routesMu.RLock()
+	defer s.routesMu.RUnlock()
+
+	actions := make([]string, 0, len(s.routes))
+	for action := range s.routes {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// listTypeHandlers returns the names of the types with a registered handler
+func (s *RelayServer) listTypeHandlers() []string {
+	s.routesMu.RLock()
+	defer s.routesMu.RUnlock()
+
+	types := make([]string, 0, len(s.typeHandlers))
+	for msgType := range s.typeHandlers {
+		types = append(types, msgType.Name())
+	}
+	sort.Strings(types)
+	return types
+}
+
+// routesDump is the admin view of the routing table
+type routesDump struct {
+	Routes       []string `json:"routes"`
+	TypeHandlers []string `json:"type_handlers"`
+}
+
+// handleAdminRoutes serves the routing table to callers holding the admin token
+func (s *RelayServer) handleAdminRoutes(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAuthorized(r) {
+		httpError(w, errCodeAuthRequired, "admin token required")
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		httpError(w, errCodeMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(routesDump{
+		Routes:       s.ListRoutes(),
+		TypeHandlers: s.listTypeHandlers(),
+	})
+}
+
+// adminAuthorized reports whether r carries the configured admin bearer token
+func (s *RelayServer) adminAuthorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(header, "Bearer ")
+	if s.config.AdminToken == "" || token == header {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// getAdminRoutes fetches the routing table dump with the given bearer token
+func getAdminRoutes(t *testing.T, srv *RelayServer, token string) (int, routesDump) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, "http://"+srv.config.ListenAddr+adminRoutesPath, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", adminRoutesPath, err)
+	}
+	defer resp.Body.Close()
+
+	var dump routesDump
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&dump); err != nil {
+			t.Fatalf("decoding routes: %v", err)
+		}
+	}
+	return resp.StatusCode, dump
+}
+
+func TestRelayServer_ListRoutes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AdminToken = "s3cret"
+	srv := startTestServer(t, cfg)
+	for _, action := range []string{"echo", "lookup", "ping"} {
+		srv.RegisterRoute(action, echoRelayHandler)
+	}
+	srv.RegisterTypeHandler(protocol.MessageTypePresence, TypeHandler(echoRelayHandler))
+
+	want := []string{"echo", "lookup", "ping"}
+	if got := srv.ListRoutes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("ListRoutes() = %v, want %v", got, want)
+	}
+
+	status, dump := getAdminRoutes(t, srv, "s3cret")
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200", status)
+	}
+	if !reflect.DeepEqual(dump.Routes, want) {
+		t.Errorf("dumped routes = %v, want %v", dump.Routes, want)
+	}
+	if !reflect.DeepEqual(dump.TypeHandlers, []string{"presence"}) {
+		t.Errorf("dumped type handlers = %v, want [presence]", dump.TypeHandlers)
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if status, _ := getAdminRoutes(t, srv, token); status != http.StatusUnauthorized {
+			t.Errorf("token %q: status = %d, want 401", token, status)
+		}
+	}
+}
+
+func TestRelayServer_AdminDisabledWithoutToken(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	if status, _ := getAdminRoutes(t, srv, ""); status != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 when no admin token is configured", status)
+	}
+}
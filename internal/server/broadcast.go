@@ -0,0 +1,53 @@
+package server
+
+import "sync"
+
+// fanOut calls send for every target using at most workers goroutines
+// (<= 1 runs sequentially). A failing target does not stop delivery to the
+// rest; the errors are returned keyed by target.
+func fanOut(targets []string, workers int, send func(target string) error) map[string]error {
+	var mu sync.Mutex
+	var failed map[string]error
+	record := func(target string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failed == nil {
+			failed = make(map[string]error)
+		}
+		failed[target] = err
+	}
+
+	if workers <= 1 || len(targets) == 1 {
+		for _, target := range targets {
+			if err := send(target); err != nil {
+				record(target, err)
+			}
+		}
+		return failed
+	}
+
+	if workers > len(targets) {
+		workers = len(targets)
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range jobs {
+				if err := send(target); err != nil {
+					record(target, err)
+				}
+			}
+		}()
+	}
+	for _, target := range targets {
+		jobs <- target
+	}
+	close(jobs)
+	wg.Wait()
+
+	return failed
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/gorilla/websocket"
+)
+
+func fanOutTargets(n int) []string {
+	targets := make([]string, n)
+	for i := range targets {
+		targets[i] = fmt.Sprintf("client-%d", i)
+	}
+	return targets
+}
+
+// TestFanOut_ContinuesPastFailures verifies one failing target does not stop
+// delivery to the others, sequentially or with workers.
+func TestFanOut_ContinuesPastFailures(t *testing.T) {
+	for _, workers := range []int{1, 8} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			targets := fanOutTargets(50)
+			var delivered sync.Map
+			failed := fanOut(targets, workers, func(target string) error {
+				if target == "client-7" {
+					return errors.New("send failed")
+				}
+				delivered.Store(target, true)
+				return nil
+			})
+
+			if len(failed) != 1 || failed["client-7"] == nil {
+				t.Errorf("failed = %v, want only client-7", failed)
+			}
+			for _, target := range targets {
+				if _, ok := delivered.Load(target); !ok && target != "client-7" {
+					t.Errorf("%s did not receive the event", target)
+				}
+			}
+		})
+	}
+}
+
+// TestFanOut_BoundsWorkers verifies no more than workers sends run at once.
+func TestFanOut_BoundsWorkers(t *testing.T) {
+	var running, peak atomic.Int64
+	fanOut(fanOutTargets(40), 3, func(string) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+
+	if p := peak.Load(); p > 3 {
+		t.Errorf("peak concurrent sends = %d, want <= 3", p)
+	}
+}
+
+// TestRelayServer_EventSkipsFailingClient broadcasts an event while one known
+// client cannot be reached and checks every other client still receives it.
+func TestRelayServer_EventSkipsFailingClient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+
+	var receivers []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		conn := dialTestClient(t, srv)
+		bindTestClientDID(t, srv, conn, fmt.Sprintf("did:example:r%d", i))
+		receivers = append(receivers, conn)
+	}
+
+	// A client the relay still tracks but the transport no longer knows
+	srv.clientsMu.Lock()
+	srv.clients["gone"] = &ClientInfo{ID: "gone", LastActivity: time.Now()}
+	srv.clientsMu.Unlock()
+
+	sender := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, sender, "did:example:sender")
+	event := protocol.NewMessage(protocol.MessageTypeMessage, "did:example:sender", "", "hello")
+	sendTestMessage(t, sender, event)
+
+	for i, conn := range receivers {
+		if got := readTestMessage(t, conn); !bytes.Equal(got.ID, event.ID) {
+			t.Errorf("receiver %d got message %x, want event %x", i, got.ID, event.ID)
+		}
+	}
+}
+
+// BenchmarkFanOut delivers to many clients whose sends each take a little
+// time, as a slow socket would, comparing sequential and pooled delivery.
+func BenchmarkFanOut(b *testing.B) {
+	targets := fanOutTargets(500)
+	send := func(string) error {
+		time.Sleep(20 * time.Microsecond)
+		return nil
+	}
+	for _, workers := range []int{1, 8, 32} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fanOut(targets, workers, send)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+// contactKeyPrefix starts the state store keys of DID pairs' contact states
+const contactKeyPrefix = "contact:"
+
+// Contact states between two DIDs; a pair with no record has no contact
+const (
+	contactPending  = "pending"  // requested, awaiting a ContactResp
+	contactAccepted = "accepted" // both sides are contacts
+)
+
+// contactStateError is a contact message that doesn't fit the pair's state
+type contactStateError struct {
+	code    string
+	message string
+}
+
+func (e *contactStateError) Error() string {
+	return e.message
+}
+
+// contactTracker records the contact state of each DID pair in the state
+// store, so with a persistent store it survives a restart. A ContactRequest
+// makes the pair pending, an accepting ContactResp from the addressee makes
+// it accepted, and a declining ContactResp or a ContactRevoke from either
+// side removes it.
+type contactTracker struct {
+	state storage.StateStore
+	mu    sync.Mutex
+}
+
+// contactRecord is the stored state of a DID pair
+type contactRecord struct {
+	State     string `json:"state"`
+	Requester string `json:"requester,omitempty"`
+}
+
+// newContactTracker creates a tracker keeping contact state in state
+func newContactTracker(state storage.StateStore) *contactTracker {
+	return &contactTracker{state: state}
+}
+
+// load returns the pair's contact state and, while pending, the requester
+func (c *contactTracker) load(a, b string) (state, requester string, err error) {
+	value, err := c.state.Get(contactKey(a, b))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to load contact state: %w", err)
+	}
+	if value == nil {
+		return "", "", nil
+	}
+	var record contactRecord
+	if err := json.Unmarshal(value, &record); err != nil {
+		return "", "", fmt.Errorf("failed to decode contact state: %w", err)
+	}
+	return record.State, record.Requester, nil
+}
+
+// apply moves the pair's state according to a contact message from the
+// authenticated DID from to msg.To, returning a *contactStateError if the
+// message isn't allowed
+func (c *contactTracker) apply(from string, msg *protocol.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state, requester, err := c.load(from, msg.To)
+	if err != nil {
+		return err
+	}
+
+	switch msg.Type {
+	case protocol.MessageTypeContactRequest:
+		if state == contactAccepted {
+			return &contactStateError{"contact_exists", "Already a contact"}
+		}
+		return c.save(from, msg.To, contactPending, from)
+
+	case protocol.MessageTypeContactResp:
+		if state != contactPending || requester != msg.To {
+			return &contactStateError{"no_contact_request", "No pending contact request from the destination"}
+		}
+		if !contactAcceptedBody(msg.Body) {
+			return c.remove(from, msg.To)
+		}
+		return c.save(from, msg.To, contactAccepted, requester)
+
+	case protocol.MessageTypeContactRevoke:
+		if state == "" {
+			return &contactStateError{"not_a_contact", "No contact with the destination"}
+		}
+		return c.remove(from, msg.To)
+	}
+	return nil
+}
+
+// save persists the pair's state until it is revoked
+func (c *contactTracker) save(a, b, state, requester string) error {
+	value, err := json.Marshal(contactRecord{State: state, Requester: requester})
+	if err != nil {
+		return fmt.Errorf("failed to encode contact state: %w", err)
+	}
+	if err := c.state.Put(contactKey(a, b), value, storage.NoExpiry); err != nil {
+		return fmt.Errorf("failed to save contact state: %w", err)
+	}
+	return nil
+}
+
+// remove forgets the pair's state
+func (c *contactTracker) remove(a, b string) error {
+	if err := c.state.Delete(contactKey(a, b)); err != nil {
+		return fmt.Errorf("failed to delete contact state: %w", err)
+	}
+	return nil
+}
+
+// contactKey orders a DID pair so both directions share one record
+func contactKey(a, b string) string {
+	if b < a {
+		a, b = b, a
+	}
+	return contactKeyPrefix + a + "\x00" + b
+}
+
+// contactAcceptedBody reads the "accepted" flag of a ContactResp body
+func contactAcceptedBody(body interface{}) bool {
+	switch b := body.(type) {
+	case map[interface{}]interface{}:
+		v, _ := b["accepted"].(bool)
+		return v
+	case map[string]interface{}:
+		v, _ := b["accepted"].(bool)
+		return v
+	}
+	return false
+}
+
+// handleContact updates the contact state of the sender and destination and
+// relays the message to the destination. The sender is the client's
+// authenticated DID, never the message's own From, so unauthenticated
+// clients are refused.
+func (s *RelayServer) handleContact(clientID string, msg *protocol.Message) error {
+	logger := s.msgLogger(msg)
+
+	sender := s.authenticatedDID(clientID)
+	if sender == "" {
+		logger.Warn("Refusing contact message from an unauthenticated client", "client", clientID)
+		return s.sendErrorResponse(clientID, msg, errCodeAuthRequired, "Contact messages require an authenticated DID")
+	}
+
+	if s.addressedToServer(msg.To) {
+		return s.sendErrorResponse(clientID, msg, "missing_destination", "Message type requires a destination")
+	}
+	if !s.destinationAllowed(msg.To) {
+		return s.sendErrorResponse(clientID, msg, "destination_blocked", "Destination is not allowed")
+	}
+
+	if err := s.contacts.apply(sender, msg); err != nil {
+		if se, ok := err.(*contactStateError); ok {
+			logger.Debug("Rejecting contact message", "type", msg.Type.Name(), "from", sender, "to", msg.To, "reason", se.code)
+			return s.sendErrorResponse(clientID, msg, se.code, se.message)
+		}
+		logger.Error("Contact state update failed", "error", err)
+		return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to update contact state")
+	}
+
+	return s.handleRelay(clientID, msg)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// contactState reads the relay's recorded state for a DID pair
+func contactState(t *testing.T, srv *RelayServer, a, b string) string {
+	t.Helper()
+	state, _, err := srv.contacts.load(a, b)
+	if err != nil {
+		t.Fatalf("contact state: %v", err)
+	}
+	return state
+}
+
+// TestRelayServer_ContactLifecycle drives request, accept and revoke through
+// the relay and checks each message is relayed and the pair's state follows
+func TestRelayServer_ContactLifecycle(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+
+	// Alice asks Bob
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeContactRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"note": "hi"}))
+	if got := readTestMessage(t, bob); got.Type != protocol.MessageTypeContactRequest || got.From != "did:example:alice" {
+		t.Fatalf("bob got %s from %s, want contact_request from alice", got.Type.Name(), got.From)
+	}
+	if state := contactState(t, srv, "did:example:bob", "did:example:alice"); state != contactPending {
+		t.Errorf("state after request = %q, want %q", state, contactPending)
+	}
+
+	// Alice can't answer her own request
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeContactResp, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"accepted": true}))
+	if got := readTestMessage(t, alice); errorCode(got) != "no_contact_request" {
+		t.Errorf("self-accept got %s %q, want no_contact_request", got.Type.Name(), errorCode(got))
+	}
+
+	// Bob accepts
+	sendTestMessage(t, bob, protocol.NewMessage(protocol.MessageTypeContactResp, "did:example:bob", "did:example:alice",
+		map[string]interface{}{"accepted": true}))
+	if got := readTestMessage(t, alice); got.Type != protocol.MessageTypeContactResp {
+		t.Fatalf("alice got %s, want contact_resp", got.Type.Name())
+	}
+	if state := contactState(t, srv, "did:example:alice", "did:example:bob"); state != contactAccepted {
+		t.Errorf("state after accept = %q, want %q", state, contactAccepted)
+	}
+
+	// A second request between contacts is refused
+	sendTestMessage(t, bob, protocol.NewMessage(protocol.MessageTypeContactRequest, "did:example:bob", "did:example:alice", nil))
+	if got := readTestMessage(t, bob); errorCode(got) != "contact_exists" {
+		t.Errorf("repeat request got %s %q, want contact_exists", got.Type.Name(), errorCode(got))
+	}
+
+	// Bob revokes
+	sendTestMessage(t, bob, protocol.NewMessage(protocol.MessageTypeContactRevoke, "did:example:bob", "did:example:alice", nil))
+	if got := readTestMessage(t, alice); got.Type != protocol.MessageTypeContactRevoke {
+		t.Fatalf("alice got %s, want contact_revoke", got.Type.Name())
+	}
+	if state := contactState(t, srv, "did:example:alice", "did:example:bob"); state != "" {
+		t.Errorf("state after revoke = %q, want none", state)
+	}
+
+	// Nothing left to revoke
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeContactRevoke, "did:example:alice", "did:example:bob", nil))
+	if got := readTestMessage(t, alice); errorCode(got) != "not_a_contact" {
+		t.Errorf("second revoke got %s %q, want not_a_contact", got.Type.Name(), errorCode(got))
+	}
+}
+
+// TestRelayServer_ContactDeclineAndPersistence checks that a declined request
+// clears the pair and that state lives in the state store, not the server
+func TestRelayServer_ContactDeclineAndPersistence(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeContactRequest, "did:example:alice", "did:example:bob", nil))
+	readTestMessage(t, bob)
+
+	// A fresh tracker over the same store sees the pending request
+	if state, requester, _ := newContactTracker(cfg.StateStore).load("did:example:alice", "did:example:bob"); state != contactPending || requester != "did:example:alice" {
+		t.Errorf("persisted state = (%q, %q), want pending from alice", state, requester)
+	}
+
+	sendTestMessage(t, bob, protocol.NewMessage(protocol.MessageTypeContactResp, "did:example:bob", "did:example:alice",
+		map[string]interface{}{"accepted": false}))
+	if got := readTestMessage(t, alice); got.Type != protocol.MessageTypeContactResp {
+		t.Fatalf("alice got %s, want contact_resp", got.Type.Name())
+	}
+	if state := contactState(t, srv, "did:example:alice", "did:example:bob"); state != "" {
+		t.Errorf("state after decline = %q, want none", state)
+	}
+}
+
+// TestRelayServer_ContactRequiresAuthentication checks a client with no
+// authenticated DID can't change any pair's contact state by claiming a From
+func TestRelayServer_ContactRequiresAuthentication(t *testing.T) {
+	cfg := DefaultConfig()
+	srv := startTestServer(t, cfg)
+	if err := srv.contacts.save("did:example:alice", "did:example:bob", contactAccepted, "did:example:alice"); err != nil {
+		t.Fatalf("seed contact: %v", err)
+	}
+	conn := dialTestClient(t, srv)
+
+	for _, typ := range []protocol.MessageType{
+		protocol.MessageTypeContactRequest, protocol.MessageTypeContactResp, protocol.MessageTypeContactRevoke,
+	} {
+		sendTestMessage(t, conn, protocol.NewMessage(typ, "did:example:alice", "did:example:bob",
+			map[string]interface{}{"accepted": true}))
+		if reply := readTestMessage(t, conn); errorCode(reply) != errCodeAuthRequired {
+			t.Errorf("unauthenticated %s got %s %q, want auth_required", typ.Name(), reply.Type.Name(), errorCode(reply))
+		}
+	}
+	if state := contactState(t, srv, "did:example:alice", "did:example:bob"); state != contactAccepted {
+		t.Errorf("state after unauthenticated messages = %q, want %q", state, contactAccepted)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// correlationExtKey is the Ext field carrying a message's correlation ID
+// across relays, so one conversation can be followed through the logs
+const correlationExtKey = "correlation_id"
+
+// maxCorrelationIDLen bounds caller-supplied IDs that end up in every log line
+const maxCorrelationIDLen = 64
+
+// ensureCorrelationID returns the message's correlation ID, recording one in
+// Ext if the sender did not supply a usable one. The message ID is used when
+// present since it is already unique.
+func ensureCorrelationID(msg *protocol.Message) string {
+	if id, ok := msg.Ext[correlationExtKey].(string); ok && id != "" && len(id) <= maxCorrelationIDLen {
+		return id
+	}
+
+	id := msg.IDHex()
+	if id == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			panic("crypto/rand failed: " + err.Error())
+		}
+		id = hex.EncodeToString(b)
+	}
+	if msg.Ext == nil {
+		msg.Ext = make(map[string]interface{})
+	}
+	msg.Ext[correlationExtKey] = id
+	return id
+}
+
+// msgLogger returns the server logger tagged with the message's correlation ID
+func (s *RelayServer) msgLogger(msg *protocol.Message) *slog.Logger {
+	id, _ := msg.Ext[correlationExtKey].(string)
+	return s.logger.With(slog.String(correlationExtKey, id))
+}
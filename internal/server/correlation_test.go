@@ -0,0 +1,108 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent log writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+// records decodes every JSON log line written so far
+func (b *syncBuffer) records(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var out []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(b.buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var rec map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", line, err)
+		}
+		out = append(out, rec)
+	}
+	return out
+}
+
+func TestEnsureCorrelationID(t *testing.T) {
+	msg := protocol.NewMessage(protocol.MessageTypeRequest, "a", "b", nil)
+	if got := ensureCorrelationID(msg); got != msg.IDHex() {
+		t.Errorf("generated ID = %q, want message ID %q", got, msg.IDHex())
+	}
+	if msg.Ext[correlationExtKey] != msg.IDHex() {
+		t.Errorf("Ext[%s] = %v, want %q", correlationExtKey, msg.Ext[correlationExtKey], msg.IDHex())
+	}
+
+	msg = protocol.NewMessage(protocol.MessageTypeRequest, "a", "b", nil)
+	msg.Ext = map[string]interface{}{correlationExtKey: "upstream-123"}
+	if got := ensureCorrelationID(msg); got != "upstream-123" {
+		t.Errorf("supplied ID = %q, want upstream-123", got)
+	}
+
+	msg = &protocol.Message{Ext: map[string]interface{}{correlationExtKey: strings.Repeat("x", maxCorrelationIDLen+1)}}
+	got := ensureCorrelationID(msg)
+	if len(got) != 16 || msg.Ext[correlationExtKey] != got {
+		t.Errorf("oversized ID replaced with %q (Ext %v), want fresh 16-char ID", got, msg.Ext[correlationExtKey])
+	}
+}
+
+func TestRelayServer_LogsShareCorrelationID(t *testing.T) {
+	logs := &syncBuffer{}
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.Logger = slog.New(slog.NewJSONHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	srv := startTestServer(t, cfg)
+
+	recipient := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, recipient, "did:example:bob")
+	// A ping round trip ensures the recipient's setup has finished logging
+	sendTestMessage(t, recipient, protocol.NewMessage(protocol.MessageTypePing, "did:example:bob", "", nil))
+	readTestMessage(t, recipient)
+	logs.Reset()
+
+	sender := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, sender, "did:example:alice")
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "unrouted"})
+	sendTestMessage(t, sender, req)
+
+	forwarded := readTestMessage(t, recipient)
+	if got := forwarded.Ext[correlationExtKey]; got != req.IDHex() {
+		t.Errorf("forwarded Ext[%s] = %v, want %q", correlationExtKey, got, req.IDHex())
+	}
+
+	records := logs.records(t)
+	if len(records) < 3 {
+		t.Fatalf("got %d log lines, want at least dispatch, store and forward", len(records))
+	}
+	for _, rec := range records {
+		if rec[correlationExtKey] != req.IDHex() {
+			t.Errorf("log line %q has %s = %v, want %q", rec["msg"], correlationExtKey, rec[correlationExtKey], req.IDHex())
+		}
+	}
+}
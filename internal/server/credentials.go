@@ -0,0 +1,76 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// Body fields of a CredPresent message
+const (
+	credIssuerField     = "issuer"     // DID of the credential's issuer
+	credCredentialField = "credential" // the serialized credential, as signed
+	credSignatureField  = "signature"  // the issuer's Ed25519 signature over credential
+)
+
+// handleCredential relays credential traffic between agents. When
+// CredentialIssuerKey is set, a CredPresent is delivered only if its
+// credential carries a valid signature from the named issuer.
+func (s *RelayServer) handleCredential(clientID string, msg *protocol.Message) error {
+	if msg.Type == protocol.MessageTypeCredPresent && s.config.CredentialIssuerKey != nil {
+		if err := s.verifyPresentation(msg.Body); err != nil {
+			s.msgLogger(msg).Warn("Rejecting credential presentation", "client", clientID, "from", msg.From, "error", err)
+			return s.sendErrorResponse(clientID, msg, "invalid_credential", err.Error())
+		}
+	}
+	return s.handleRelay(clientID, msg)
+}
+
+// verifyPresentation checks the issuer's signature over a presented credential
+func (s *RelayServer) verifyPresentation(body interface{}) error {
+	issuer := bodyString(body, credIssuerField)
+	if issuer == "" {
+		return errors.New("presentation names no issuer")
+	}
+	credential, ok := bodyBytes(body, credCredentialField)
+	if !ok || len(credential) == 0 {
+		return errors.New("presentation carries no credential")
+	}
+	sig, ok := bodyBytes(body, credSignatureField)
+	if !ok || len(sig) != ed25519.SignatureSize {
+		return errors.New("presentation carries no valid signature")
+	}
+
+	key, err := s.config.CredentialIssuerKey(issuer)
+	if err != nil {
+		return fmt.Errorf("cannot resolve issuer %s: %w", issuer, err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("issuer %s has no usable key", issuer)
+	}
+	if !ed25519.Verify(key, credential, sig) {
+		return fmt.Errorf("signature does not match issuer %s", issuer)
+	}
+	return nil
+}
+
+// bodyBytes reads a byte string field from a message body; a text string is
+// taken as its UTF-8 bytes
+func bodyBytes(body interface{}, key string) ([]byte, bool) {
+	var v interface{}
+	switch b := body.(type) {
+	case map[interface{}]interface{}:
+		v = b[key]
+	case map[string]interface{}:
+		v = b[key]
+	}
+	switch v := v.(type) {
+	case []byte:
+		return v, true
+	case string:
+		return []byte(v), true
+	}
+	return nil, false
+}
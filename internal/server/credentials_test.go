@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// TestRelayServer_CredentialPresentation checks that a presentation signed by
+// its issuer reaches the verifier and a forged or unresolvable one is rejected
+func TestRelayServer_CredentialPresentation(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.CredentialIssuerKey = func(did string) (ed25519.PublicKey, error) {
+		if did == "did:example:issuer" {
+			return pub, nil
+		}
+		return nil, errors.New("unknown DID")
+	}
+	srv := startTestServer(t, cfg)
+	holder := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, holder, "did:example:holder")
+	verifier := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, verifier, "did:example:verifier")
+
+	credential := []byte(`{"sub":"did:example:holder","claim":"over-18"}`)
+	present := func(issuer string, credential, sig []byte) *protocol.Message {
+		return protocol.NewMessage(protocol.MessageTypeCredPresent, "did:example:holder", "did:example:verifier",
+			map[string]interface{}{credIssuerField: issuer, credCredentialField: credential, credSignatureField: sig})
+	}
+
+	rejected := map[string]*protocol.Message{
+		"tampered credential": present("did:example:issuer", []byte(`{"sub":"did:example:holder","claim":"admin"}`), ed25519.Sign(priv, credential)),
+		"unknown issuer":      present("did:example:stranger", credential, ed25519.Sign(priv, credential)),
+		"missing signature":   present("did:example:issuer", credential, nil),
+	}
+	for name, msg := range rejected {
+		sendTestMessage(t, holder, msg)
+		if reply := readTestMessage(t, holder); errorCode(reply) != "invalid_credential" {
+			t.Errorf("%s: got %s %q, want invalid_credential", name, reply.Type.Name(), errorCode(reply))
+		}
+	}
+
+	// A genuine presentation is the first thing the verifier receives
+	valid := present("did:example:issuer", credential, ed25519.Sign(priv, credential))
+	sendTestMessage(t, holder, valid)
+	got := readTestMessage(t, verifier)
+	if got.Type != protocol.MessageTypeCredPresent || got.IDHex() != valid.IDHex() {
+		t.Fatalf("verifier got %s %s, want the valid presentation", got.Type.Name(), got.IDHex())
+	}
+
+	// Other credential traffic is relayed unchecked
+	sendTestMessage(t, verifier, protocol.NewMessage(protocol.MessageTypeCredRequest, "did:example:verifier", "did:example:holder",
+		map[string]interface{}{"type": "age"}))
+	if got := readTestMessage(t, holder); got.Type != protocol.MessageTypeCredRequest {
+		t.Errorf("holder got %s, want cred_request", got.Type.Name())
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Decode failures are logged at most decodeErrorLogBurst times per
+// decodeErrorLogInterval so a client flooding malformed frames can't flood the log
+const (
+	decodeErrorLogBurst    = 10
+	decodeErrorLogInterval = time.Minute
+)
+
+// logThrottle lets a burst of log lines through per interval and counts the rest
+type logThrottle struct {
+	burst    int
+	interval time.Duration
+	now      func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	logged      int
+	suppressed  int
+}
+
+// newLogThrottle creates a throttle passing burst lines per interval
+func newLogThrottle(burst int, interval time.Duration) *logThrottle {
+	return &logThrottle{burst: burst, interval: interval, now: time.Now}
+}
+
+// allow reports whether a line may be logged now. When a new window opens,
+// it also returns how many lines the previous window suppressed.
+func (l *logThrottle) allow() (ok bool, suppressed int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if now.Sub(l.windowStart) >= l.interval {
+		suppressed = l.suppressed
+		l.windowStart = now
+		l.logged = 0
+		l.suppressed = 0
+	}
+
+	if l.logged >= l.burst {
+		l.suppressed++
+		return false, suppressed
+	}
+	l.logged++
+	return true, suppressed
+}
+
+// recordDecodeError counts a frame from clientID that failed to decode and
+// logs it, subject to throttling. Clients that never sent a valid message
+// have no ClientInfo and are only counted in the total.
+func (s *RelayServer) recordDecodeError(clientID string, err error) {
+	s.decodeErrors.Add(1)
+
+	s.clientsMu.Lock()
+	if info, ok := s.clients[clientID]; ok {
+		info.DecodeErrors++
+	}
+	s.clientsMu.Unlock()
+
+	ok, suppressed := s.decodeLogThrottle.allow()
+	if suppressed > 0 {
+		log.Printf("Suppressed %d decode failure logs in the last %s", suppressed, decodeErrorLogInterval)
+	}
+	if ok {
+		log.Printf("Failed to decode message from client %s: %v", clientID, err)
+	}
+}
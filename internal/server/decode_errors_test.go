@@ -0,0 +1,58 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/gorilla/websocket"
+)
+
+func TestRelayServer_CountsDecodeErrors(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	client := dialTestClient(t, srv)
+
+	ping := func() {
+		sendTestMessage(t, client, protocol.NewMessage(protocol.MessageTypePing, "did:example:alice", "", nil))
+		readTestMessage(t, client)
+	}
+	ping() // registers the client
+
+	for _, frame := range [][]byte{{0xff}, {0xa1, 0x01}, []byte("not cbor")} {
+		if err := client.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	ping() // frames on a connection are handled in order
+
+	if got := srv.GetStats().DecodeErrors; got != 3 {
+		t.Errorf("DecodeErrors = %d, want 3", got)
+	}
+	srv.clientsMu.RLock()
+	defer srv.clientsMu.RUnlock()
+	for id, info := range srv.clients {
+		if info.DecodeErrors != 3 {
+			t.Errorf("client %s DecodeErrors = %d, want 3", id, info.DecodeErrors)
+		}
+	}
+}
+
+func TestLogThrottle(t *testing.T) {
+	now := time.Now()
+	l := newLogThrottle(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	for i, want := range []bool{true, true, false, false} {
+		if ok, suppressed := l.allow(); ok != want || suppressed != 0 {
+			t.Errorf("allow #%d = %v, %d; want %v, 0", i, ok, suppressed, want)
+		}
+	}
+
+	now = now.Add(time.Minute)
+	if ok, suppressed := l.allow(); !ok || suppressed != 2 {
+		t.Errorf("allow in new window = %v, %d; want true, 2", ok, suppressed)
+	}
+	if _, suppressed := l.allow(); suppressed != 0 {
+		t.Errorf("suppressed count reported twice: %d", suppressed)
+	}
+}
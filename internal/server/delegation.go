@@ -0,0 +1,189 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+// delegationKeyPrefix starts the state store keys of delegation grants
+const delegationKeyPrefix = "delegation:"
+
+// Body fields of delegation messages
+const (
+	delegCapabilityField = "capability"   // the delegated capability (a request action)
+	onBehalfOfField      = "on_behalf_of" // in a request, the DID whose grant the sender uses
+)
+
+// delegationGrant lets delegate use capability on behalf of delegator
+type delegationGrant struct {
+	Delegator  string `json:"delegator"`
+	Delegate   string `json:"delegate"`
+	Capability string `json:"capability"`
+}
+
+// delegationTracker keeps delegation grants in the state store, so with a
+// persistent store they survive a restart. A grant lasts until revoked.
+type delegationTracker struct {
+	state storage.StateStore
+}
+
+// newDelegationTracker creates a tracker keeping grants in state
+func newDelegationTracker(state storage.StateStore) *delegationTracker {
+	return &delegationTracker{state: state}
+}
+
+// grant records that delegate may use capability on behalf of delegator
+func (d *delegationTracker) grant(g delegationGrant) error {
+	value, err := json.Marshal(g)
+	if err != nil {
+		return fmt.Errorf("failed to encode delegation: %w", err)
+	}
+	if err := d.state.Put(delegationKey(g), value, storage.NoExpiry); err != nil {
+		return fmt.Errorf("failed to save delegation: %w", err)
+	}
+	return nil
+}
+
+// revoke removes a grant, reporting whether it existed
+func (d *delegationTracker) revoke(g delegationGrant) (bool, error) {
+	ok, err := d.allowed(g)
+	if err != nil || !ok {
+		return false, err
+	}
+	if err := d.state.Delete(delegationKey(g)); err != nil {
+		return false, fmt.Errorf("failed to delete delegation: %w", err)
+	}
+	return true, nil
+}
+
+// allowed reports whether the grant exists
+func (d *delegationTracker) allowed(g delegationGrant) (bool, error) {
+	value, err := d.state.Get(delegationKey(g))
+	if err != nil {
+		return false, fmt.Errorf("failed to load delegation: %w", err)
+	}
+	return value != nil, nil
+}
+
+// list returns the grants did has made or received, sorted
+func (d *delegationTracker) list(did string) ([]delegationGrant, error) {
+	values, err := d.state.List(delegationKeyPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list delegations: %w", err)
+	}
+
+	var grants []delegationGrant
+	for _, value := range values {
+		var g delegationGrant
+		if err := json.Unmarshal(value, &g); err != nil {
+			return nil, fmt.Errorf("failed to decode delegation: %w", err)
+		}
+		if g.Delegator == did || g.Delegate == did {
+			grants = append(grants, g)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		a, b := grants[i], grants[j]
+		if a.Delegator != b.Delegator {
+			return a.Delegator < b.Delegator
+		}
+		if a.Delegate != b.Delegate {
+			return a.Delegate < b.Delegate
+		}
+		return a.Capability < b.Capability
+	})
+	return grants, nil
+}
+
+// delegationKey names a grant's record
+func delegationKey(g delegationGrant) string {
+	return delegationKeyPrefix + g.Delegator + "\x00" + g.Delegate + "\x00" + g.Capability
+}
+
+// handleDelegation records or revokes a grant from the sender to the
+// destination and relays the message to it, or answers a DelegQuery with the
+// sender's grants. The sender is the client's authenticated DID, never the
+// message's own From, so unauthenticated clients are refused.
+func (s *RelayServer) handleDelegation(clientID string, msg *protocol.Message) error {
+	logger := s.msgLogger(msg)
+
+	sender := s.authenticatedDID(clientID)
+	if sender == "" {
+		logger.Warn("Refusing delegation from an unauthenticated client", "client", clientID)
+		return s.sendErrorResponse(clientID, msg, errCodeAuthRequired, "Delegation requires an authenticated DID")
+	}
+
+	if msg.Type == protocol.MessageTypeDelegQuery {
+		grants, err := s.delegations.list(sender)
+		if err != nil {
+			logger.Error("Delegation query failed", "error", err)
+			return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to load delegations")
+		}
+		body := make([]interface{}, 0, len(grants))
+		for _, g := range grants {
+			body = append(body, map[string]interface{}{
+				"delegator":  g.Delegator,
+				"delegate":   g.Delegate,
+				"capability": g.Capability,
+			})
+		}
+		reply := protocol.NewMessage(protocol.MessageTypeResponse, s.serverIdentity(), sender,
+			map[string]interface{}{"grants": body})
+		return s.sendResponse(clientID, msg.ID, reply)
+	}
+
+	if s.addressedToServer(msg.To) {
+		return s.sendErrorResponse(clientID, msg, "missing_destination", "Delegation requires a delegate")
+	}
+	if !s.destinationAllowed(msg.To) {
+		return s.sendErrorResponse(clientID, msg, "destination_blocked", "Destination is not allowed")
+	}
+	g := delegationGrant{Delegator: sender, Delegate: msg.To, Capability: bodyString(msg.Body, delegCapabilityField)}
+	if g.Capability == "" {
+		return s.sendErrorResponse(clientID, msg, "invalid_delegation", "Delegation requires a capability")
+	}
+
+	switch msg.Type {
+	case protocol.MessageTypeDelegGrant:
+		if err := s.delegations.grant(g); err != nil {
+			logger.Error("Failed to record delegation", "error", err)
+			return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to record delegation")
+		}
+		logger.Debug("Delegation granted", "delegator", g.Delegator, "delegate", g.Delegate, "capability", g.Capability)
+	case protocol.MessageTypeDelegRevoke:
+		revoked, err := s.delegations.revoke(g)
+		if err != nil {
+			logger.Error("Failed to revoke delegation", "error", err)
+			return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to revoke delegation")
+		}
+		if !revoked {
+			return s.sendErrorResponse(clientID, msg, "no_delegation", "No such delegation")
+		}
+		logger.Debug("Delegation revoked", "delegator", g.Delegator, "delegate", g.Delegate, "capability", g.Capability)
+	}
+
+	return s.handleRelay(clientID, msg)
+}
+
+// authorizeRequest is the authorization hook for requests. A request naming
+// another DID in on_behalf_of is only allowed if that DID has delegated the
+// request's action to the sender, which must have authenticated.
+func (s *RelayServer) authorizeRequest(clientID string, msg *protocol.Message) (bool, error) {
+	principal := bodyString(msg.Body, onBehalfOfField)
+	if principal == "" || principal == msg.From {
+		return true, nil
+	}
+	delegate := s.authenticatedDID(clientID)
+	if delegate == "" {
+		return false, nil
+	}
+	return s.delegations.allowed(delegationGrant{
+		Delegator:  principal,
+		Delegate:   delegate,
+		Capability: extractAction(msg),
+	})
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// delegatedRequest sends an action request on alice's behalf and returns the reply
+func delegatedRequest(t *testing.T, conn *websocket.Conn, from, action string) *protocol.Message {
+	t.Helper()
+	sendTestMessage(t, conn, protocol.NewMessage(protocol.MessageTypeRequest, from, "relay-server",
+		map[string]interface{}{"action": action, onBehalfOfField: "did:example:alice"}))
+	return readTestMessage(t, conn)
+}
+
+// queryGrants returns the grants a DelegQuery from did reports
+func queryGrants(t *testing.T, conn *websocket.Conn, did string) []interface{} {
+	t.Helper()
+	sendTestMessage(t, conn, protocol.NewMessage(protocol.MessageTypeDelegQuery, did, "", nil))
+	reply := readTestMessage(t, conn)
+	if reply.Type != protocol.MessageTypeResponse {
+		t.Fatalf("deleg_query got %s, want response", reply.Type.Name())
+	}
+	body, _ := reply.Body.(map[interface{}]interface{})
+	grants, _ := body["grants"].([]interface{})
+	return grants
+}
+
+// TestRelayServer_DelegationLifecycle grants a capability, queries it, uses
+// it in a request on the delegator's behalf, then revokes it
+func TestRelayServer_DelegationLifecycle(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	srv.RegisterRoute("send_email", echoRelayHandler)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+
+	// Without a grant Bob can't act for Alice
+	if reply := delegatedRequest(t, bob, "did:example:bob", "send_email"); errorCode(reply) != "not_authorized" {
+		t.Fatalf("request before grant got %s %q, want not_authorized", reply.Type.Name(), errorCode(reply))
+	}
+
+	// Alice grants; Bob is told
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeDelegGrant, "did:example:alice", "did:example:bob",
+		map[string]interface{}{delegCapabilityField: "send_email"}))
+	if got := readTestMessage(t, bob); got.Type != protocol.MessageTypeDelegGrant {
+		t.Fatalf("bob got %s, want deleg_grant", got.Type.Name())
+	}
+
+	// Both sides see the grant
+	for _, q := range []struct {
+		conn *websocket.Conn
+		did  string
+	}{{alice, "did:example:alice"}, {bob, "did:example:bob"}} {
+		grants := queryGrants(t, q.conn, q.did)
+		if len(grants) != 1 {
+			t.Fatalf("%s sees %d grants, want 1", q.did, len(grants))
+		}
+		g, _ := grants[0].(map[interface{}]interface{})
+		if g["delegator"] != "did:example:alice" || g["delegate"] != "did:example:bob" || g["capability"] != "send_email" {
+			t.Errorf("%s sees grant %v", q.did, g)
+		}
+	}
+
+	// The grant authorizes only its capability
+	if reply := delegatedRequest(t, bob, "did:example:bob", "send_email"); reply.Type != protocol.MessageTypeResponse {
+		t.Errorf("delegated request got %s %q, want response", reply.Type.Name(), errorCode(reply))
+	}
+	if reply := delegatedRequest(t, bob, "did:example:bob", "delete_account"); errorCode(reply) != "not_authorized" {
+		t.Errorf("request for another action got %s %q, want not_authorized", reply.Type.Name(), errorCode(reply))
+	}
+
+	// Alice revokes; the grant no longer authorizes
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeDelegRevoke, "did:example:alice", "did:example:bob",
+		map[string]interface{}{delegCapabilityField: "send_email"}))
+	if got := readTestMessage(t, bob); got.Type != protocol.MessageTypeDelegRevoke {
+		t.Fatalf("bob got %s, want deleg_revoke", got.Type.Name())
+	}
+	if grants := queryGrants(t, bob, "did:example:bob"); len(grants) != 0 {
+		t.Errorf("bob still sees %d grants after revoke", len(grants))
+	}
+	if reply := delegatedRequest(t, bob, "did:example:bob", "send_email"); errorCode(reply) != "not_authorized" {
+		t.Errorf("request after revoke got %s %q, want not_authorized", reply.Type.Name(), errorCode(reply))
+	}
+
+	// Revoking again reports there is nothing to revoke
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeDelegRevoke, "did:example:alice", "did:example:bob",
+		map[string]interface{}{delegCapabilityField: "send_email"}))
+	if got := readTestMessage(t, alice); errorCode(got) != "no_delegation" {
+		t.Errorf("second revoke got %s %q, want no_delegation", got.Type.Name(), errorCode(got))
+	}
+}
+
+// TestRelayServer_DelegationUsesAuthenticatedDID checks grants are made and
+// queried as the connection's authenticated DID, never a claimed From
+func TestRelayServer_DelegationUsesAuthenticatedDID(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	srv.RegisterRoute("send_email", echoRelayHandler)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	mallory := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, mallory, "did:example:mallory")
+
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeDelegGrant, "did:example:alice", "did:example:bob",
+		map[string]interface{}{delegCapabilityField: "send_email"}))
+	if got := readTestMessage(t, bob); got.Type != protocol.MessageTypeDelegGrant {
+		t.Fatalf("bob got %s, want deleg_grant", got.Type.Name())
+	}
+
+	// Mallory can't grant, revoke or query as Alice
+	for _, typ := range []protocol.MessageType{
+		protocol.MessageTypeDelegGrant, protocol.MessageTypeDelegRevoke, protocol.MessageTypeDelegQuery,
+	} {
+		sendTestMessage(t, mallory, protocol.NewMessage(typ, "did:example:alice", "did:example:mallory",
+			map[string]interface{}{delegCapabilityField: "send_email"}))
+		if reply := readTestMessage(t, mallory); errorCode(reply) != errCodeForbidden {
+			t.Errorf("spoofed %s got %s %q, want forbidden", typ.Name(), reply.Type.Name(), errorCode(reply))
+		}
+	}
+
+	// Her own query shows only grants involving her
+	if grants := queryGrants(t, mallory, "did:example:mallory"); len(grants) != 0 {
+		t.Errorf("mallory sees %d of alice's grants", len(grants))
+	}
+	if reply := delegatedRequest(t, mallory, "did:example:mallory", "send_email"); errorCode(reply) != "not_authorized" {
+		t.Errorf("mallory's request for alice got %s %q, want not_authorized", reply.Type.Name(), errorCode(reply))
+	}
+}
+
+// TestRelayServer_DelegationRequiresAuthentication checks a client with no
+// authenticated DID can't make, revoke, query or use delegations
+func TestRelayServer_DelegationRequiresAuthentication(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	srv.RegisterRoute("send_email", echoRelayHandler)
+	conn := dialTestClient(t, srv)
+
+	for _, typ := range []protocol.MessageType{
+		protocol.MessageTypeDelegGrant, protocol.MessageTypeDelegRevoke, protocol.MessageTypeDelegQuery,
+	} {
+		sendTestMessage(t, conn, protocol.NewMessage(typ, "did:example:alice", "did:example:bob",
+			map[string]interface{}{delegCapabilityField: "send_email"}))
+		if reply := readTestMessage(t, conn); errorCode(reply) != errCodeAuthRequired {
+			t.Errorf("unauthenticated %s got %s %q, want auth_required", typ.Name(), reply.Type.Name(), errorCode(reply))
+		}
+	}
+	if reply := delegatedRequest(t, conn, "did:example:bob", "send_email"); errorCode(reply) != "not_authorized" {
+		t.Errorf("unauthenticated delegated request got %s %q, want not_authorized", reply.Type.Name(), errorCode(reply))
+	}
+}
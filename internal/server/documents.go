@@ -0,0 +1,72 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+// documentIDField names, in a DocRequest body, the hex ID of the DocSend to fetch
+const documentIDField = "document_id"
+
+// handleDocSend stores a document for its addressee, within the store's
+// size limits, and delivers it now if the addressee is connected. Until it
+// expires the addressee can fetch it again with DocRequest.
+func (s *RelayServer) handleDocSend(clientID string, msg *protocol.Message) error {
+	logger := s.msgLogger(msg)
+
+	if s.addressedToServer(msg.To) {
+		return s.sendErrorResponse(clientID, msg, "missing_destination", "Document requires an addressee")
+	}
+	if !s.destinationAllowed(msg.To) {
+		return s.sendErrorResponse(clientID, msg, "destination_blocked", "Destination is not allowed")
+	}
+
+	if err := s.saveMessage(msg); err != nil {
+		if errors.Is(err, storage.ErrMessageTooLarge) {
+			logger.Warn("Rejecting document over the store's byte budget", "client", clientID, "id", msg.IDHex())
+			return s.sendErrorResponse(clientID, msg, "document_too_large", "Document exceeds the relay's storage limit")
+		}
+		logger.Error("Failed to store document", "error", err)
+		return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to store document")
+	}
+	logger.Debug("Stored document", "id", msg.IDHex(), "to", msg.To)
+
+	return s.forwardOrReject(clientID, msg)
+}
+
+// handleDocRequest answers a DocRequest with a stored document. Only the
+// document's addressee may fetch it, as the requester's authenticated DID;
+// the message's own From is never trusted.
+func (s *RelayServer) handleDocRequest(clientID string, msg *protocol.Message) error {
+	id := bodyString(msg.Body, documentIDField)
+	if id == "" {
+		return s.sendErrorResponse(clientID, msg, "invalid_request", "DocRequest requires a document_id")
+	}
+
+	doc, err := s.store.Get(id)
+	if err != nil {
+		s.msgLogger(msg).Error("Failed to load document", "id", id, "error", err)
+		return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to load document")
+	}
+	if doc == nil || doc.Type != protocol.MessageTypeDocSend {
+		return s.sendErrorResponse(clientID, msg, "document_not_found", "No such document")
+	}
+
+	requester := s.authenticatedDID(clientID)
+	if requester == "" {
+		return s.sendErrorResponse(clientID, msg, errCodeAuthRequired, "DocRequest requires an authenticated DID")
+	}
+	if doc.To != requester {
+		s.msgLogger(msg).Warn("Refusing document to a DID it was not sent to", "id", id, "requester", requester)
+		return s.sendErrorResponse(clientID, msg, "not_authorized", "Document was not sent to this DID")
+	}
+
+	reply := protocol.NewMessage(protocol.MessageTypeResponse, s.serverIdentity(), requester, map[string]interface{}{
+		documentIDField: id,
+		"from":          doc.From,
+		"document":      doc.Body,
+	})
+	return s.sendResponse(clientID, msg.ID, reply)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+// TestRelayServer_DocumentSendAndRequest sends a document, fetches it as the
+// addressee, and checks another DID is refused
+func TestRelayServer_DocumentSendAndRequest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	carol := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, carol, "did:example:carol")
+
+	doc := protocol.NewMessage(protocol.MessageTypeDocSend, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"name": "contract.pdf", "content": []byte("%PDF-1.7")})
+	sendTestMessage(t, alice, doc)
+	if got := readTestMessage(t, bob); got.Type != protocol.MessageTypeDocSend {
+		t.Fatalf("bob got %s, want doc_send", got.Type.Name())
+	}
+
+	request := func(from string) *protocol.Message {
+		return protocol.NewMessage(protocol.MessageTypeDocRequest, from, "",
+			map[string]interface{}{documentIDField: doc.IDHex()})
+	}
+
+	// The addressee can fetch it again
+	sendTestMessage(t, bob, request("did:example:bob"))
+	reply := readTestMessage(t, bob)
+	if reply.Type != protocol.MessageTypeResponse {
+		t.Fatalf("addressee got %s %q, want response", reply.Type.Name(), errorCode(reply))
+	}
+	body, _ := reply.Body.(map[interface{}]interface{})
+	document, _ := body["document"].(map[interface{}]interface{})
+	if body["from"] != "did:example:alice" || !bytes.Equal(document["content"].([]byte), []byte("%PDF-1.7")) {
+		t.Errorf("fetched document = %v", body)
+	}
+
+	// Anyone else is refused, and can't claim the addressee's DID
+	sendTestMessage(t, carol, request("did:example:carol"))
+	if reply := readTestMessage(t, carol); errorCode(reply) != "not_authorized" {
+		t.Errorf("other DID got %s %q, want not_authorized", reply.Type.Name(), errorCode(reply))
+	}
+	sendTestMessage(t, carol, request("did:example:bob"))
+	if reply := readTestMessage(t, carol); errorCode(reply) != errCodeForbidden {
+		t.Errorf("spoofed sender got %s %q, want forbidden", reply.Type.Name(), errorCode(reply))
+	}
+
+	// Unknown IDs and non-documents are not found
+	sendTestMessage(t, bob, protocol.NewMessage(protocol.MessageTypeDocRequest, "did:example:bob", "",
+		map[string]interface{}{documentIDField: "00112233445566778899aabbccddeeff"}))
+	if reply := readTestMessage(t, bob); errorCode(reply) != "document_not_found" {
+		t.Errorf("unknown ID got %s %q, want document_not_found", reply.Type.Name(), errorCode(reply))
+	}
+}
+
+// TestRelayServer_DocumentRequestRequiresAuthentication checks a client
+// with no authenticated DID can't fetch a document by claiming its addressee
+func TestRelayServer_DocumentRequestRequiresAuthentication(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	client := dialTestClient(t, srv)
+
+	doc := protocol.NewMessage(protocol.MessageTypeDocSend, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"content": []byte("secret")})
+	sendTestMessage(t, client, doc)
+
+	sendTestMessage(t, client, protocol.NewMessage(protocol.MessageTypeDocRequest, "did:example:bob", "",
+		map[string]interface{}{documentIDField: doc.IDHex()}))
+	if reply := readTestMessage(t, client); errorCode(reply) != errCodeAuthRequired {
+		t.Errorf("unauthenticated request got %s %q, want auth_required", reply.Type.Name(), errorCode(reply))
+	}
+}
+
+// TestRelayServer_DocumentOverByteBudget checks a document larger than the
+// store's byte budget is rejected
+func TestRelayServer_DocumentOverByteBudget(t *testing.T) {
+	store := storage.NewMemoryStore()
+	store.SetMaxBytes(1024)
+	cfg := DefaultConfig()
+	cfg.Storage = store
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeDocSend, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"content": make([]byte, 4096)}))
+	if reply := readTestMessage(t, alice); errorCode(reply) != "document_too_large" {
+		t.Errorf("oversized document got %s %q, want document_too_large", reply.Type.Name(), errorCode(reply))
+	}
+	if store.Count() != 0 {
+		t.Errorf("store holds %d messages, want 0", store.Count())
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// encryptionExtKey is the Ext field naming the scheme an encrypted body uses
+const encryptionExtKey = "encryption"
+
+// encryptionNaClBox is the scheme produced by pkg/auth's Encryptor
+const encryptionNaClBox = "nacl-box"
+
+// A nacl-box body is ephemeral public key + nonce + ciphertext, and the
+// ciphertext carries at least the Poly1305 tag
+const (
+	naclBoxKeySize   = 32
+	naclBoxNonceSize = 24
+	naclBoxOverhead  = 16
+	naclBoxMinSize   = naclBoxKeySize + naclBoxNonceSize + naclBoxOverhead
+)
+
+// validateEncryption checks that a message marked encrypted carries a
+// well-formed envelope. The relay never decrypts; it only rejects bodies that
+// no recipient could open. Unmarked messages pass unchecked.
+func validateEncryption(msg *protocol.Message) error {
+	raw, marked := msg.Ext[encryptionExtKey]
+	if !marked {
+		return nil
+	}
+
+	scheme, _ := raw.(string)
+	if scheme != encryptionNaClBox {
+		return fmt.Errorf("unsupported encryption scheme %q", scheme)
+	}
+	body, ok := msg.BodyBytes()
+	if !ok {
+		return fmt.Errorf("encrypted body must be a byte string")
+	}
+	if len(body) < naclBoxMinSize {
+		return fmt.Errorf("encrypted body is %d bytes, want at least %d", len(body), naclBoxMinSize)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func encryptedMessage(body interface{}, scheme interface{}) *protocol.Message {
+	msg := protocol.NewMessage(protocol.MessageTypeMessage, "did:example:alice", "did:example:bob", body)
+	msg.Ext = map[string]interface{}{encryptionExtKey: scheme}
+	return msg
+}
+
+func TestValidateEncryption(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     *protocol.Message
+		wantErr bool
+	}{
+		{"unmarked", protocol.NewMessage(protocol.MessageTypeMessage, "a", "b", "plain"), false},
+		{"well-formed", encryptedMessage(make([]byte, naclBoxMinSize+10), encryptionNaClBox), false},
+		{"tag only", encryptedMessage(make([]byte, naclBoxMinSize), encryptionNaClBox), false},
+		{"too short", encryptedMessage(make([]byte, naclBoxKeySize+naclBoxNonceSize), encryptionNaClBox), true},
+		{"not bytes", encryptedMessage("ciphertext", encryptionNaClBox), true},
+		{"unknown scheme", encryptedMessage(make([]byte, 128), "rot13"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateEncryption(tt.msg); (err != nil) != tt.wantErr {
+				t.Errorf("validateEncryption() = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRelayServer_RejectsMalformedEncryption(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+
+	short := encryptedMessage(make([]byte, 40), encryptionNaClBox)
+	sendTestMessage(t, alice, short)
+	if resp := readTestMessage(t, alice); errorCode(resp) != "invalid_encryption" {
+		t.Fatalf("short payload: type 0x%02x code %q, want invalid_encryption", resp.Type, errorCode(resp))
+	}
+
+	sealed := bytes.Repeat([]byte{0xAB}, naclBoxMinSize+32)
+	good := encryptedMessage(sealed, encryptionNaClBox)
+	sendTestMessage(t, alice, good)
+	got := readTestMessage(t, bob)
+	if got.IDHex() != good.IDHex() {
+		t.Fatalf("bob got %s, want the encrypted message %s", got.IDHex(), good.IDHex())
+	}
+	if body, _ := got.BodyBytes(); !bytes.Equal(body, sealed) {
+		t.Error("encrypted body was altered in transit")
+	}
+}
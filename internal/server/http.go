@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/auth"
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// httpMessagesPath serves HTTP message submission
+const httpMessagesPath = "/amp/v1/messages"
+
+// Content types accepted and produced by the HTTP message endpoint
+const (
+	contentTypeCBOR = "application/cbor"
+	contentTypeJSON = "application/json"
+)
+
+// httpRequestSeq numbers HTTP submissions to build unique pseudo client IDs
+var httpRequestSeq atomic.Uint64
+
+// httpSubmission is an HTTP message submission being processed
+type httpSubmission struct {
+	did     string      // bearer token DID, or "" with auth disabled
+	replies chan []byte // the relay's first reply
+}
+
+// deliver sends encoded data to a WebSocket client, or to the pending HTTP
+// submission registered under clientID
+func (s *RelayServer) deliver(clientID string, data []byte) bool {
+	if sub, ok := s.httpReplies.Load(clientID); ok {
+		select {
+		case sub.(*httpSubmission).replies <- data:
+			return true
+		default:
+			// Only the first reply is returned over HTTP
+			return false
+		}
+	}
+	return s.wsServer.SendToClient(clientID, data)
+}
+
+// handleHTTPMessages serves the HTTP message endpoint
+func (s *RelayServer) handleHTTPMessages(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.handleHTTPMailbox(w, r)
+	case http.MethodPost:
+		s.handleHTTPSubmit(w, r)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		httpError(w, errCodeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleHTTPSubmit accepts a single JSON or CBOR message, processes it like
+// a message received over WebSocket and returns the relay's reply, or 202
+// Accepted if the message produced none (for example, it was forwarded)
+func (s *RelayServer) handleHTTPSubmit(w http.ResponseWriter, r *http.Request) {
+	did, err := s.authenticateHTTP(r)
+	if err != nil {
+		httpError(w, errCodeAuthRequired, err.Error())
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != contentTypeCBOR && contentType != contentTypeJSON {
+		httpError(w, errCodeUnsupportedMediaType, "content type must be application/cbor or application/json")
+		return
+	}
+
+	reader := r.Body
+	if s.config.MaxPayloadSize > 0 {
+		reader = http.MaxBytesReader(w, r.Body, s.config.MaxPayloadSize)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		httpError(w, errCodePayloadTooLarge, "failed to read request body")
+		return
+	}
+
+	msg := &protocol.Message{}
+	if contentType == contentTypeCBOR {
+		err = msg.CBORUnmarshal(body)
+	} else {
+		err = json.Unmarshal(body, msg)
+	}
+	if err != nil {
+		httpError(w, errCodeBadRequest, fmt.Sprintf("invalid message format: %v", err))
+		return
+	}
+	if len(msg.ID) == 0 {
+		httpError(w, errCodeBadRequest, "message id is required")
+		return
+	}
+	if connectionScoped(msg.Type) {
+		httpError(w, errCodeBadRequest, fmt.Sprintf("%s requires a WebSocket connection", msg.Type.Name()))
+		return
+	}
+
+	if msg.From == "" {
+		msg.From = did
+	} else if msg.From != did && !s.authDisabled() {
+		httpError(w, errCodeForbidden, "message sender does not match the authenticated DID")
+		return
+	}
+
+	clientID := fmt.Sprintf("http-%d", httpRequestSeq.Add(1))
+	replies := make(chan []byte, 1)
+	submission := &httpSubmission{replies: replies}
+	if !s.authDisabled() {
+		submission.did = did
+	}
+	s.httpReplies.Store(clientID, submission)
+	defer s.httpReplies.Delete(clientID)
+
+	// Submissions share a rate limit per DID, or per remote address without auth
+	rateKey := "http:" + submission.did
+	if submission.did == "" {
+		rateKey = "http-addr:" + remoteHost(r)
+	}
+	admitted, err := s.admitMessage(clientID, rateKey, submission.did, r.Header.Get("Origin"), msg)
+	if admitted {
+		_, err = s.dispatchMessage(clientID, msg)
+	}
+	if err != nil {
+		s.msgLogger(msg).Warn("HTTP submission failed", "did", did, "error", err)
+	}
+
+	var reply []byte
+	select {
+	case reply = <-replies:
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	status := http.StatusOK
+	if code := replyErrorCode(reply); code != "" {
+		// An error reply is returned with the status registered for its code
+		w.Header().Set(errorCodeHeader, code)
+		status = httpStatus(code)
+	}
+
+	if contentType == contentTypeJSON {
+		if reply, err = cborToJSON(reply); err != nil {
+			httpError(w, errCodeInternal, "failed to encode response")
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+	w.Write(reply)
+}
+
+// connectionScoped reports whether a message type only makes sense on a
+// WebSocket connection: streams, presence subscriptions, session hellos and
+// keepalive pongs all attach state to a connection, which a single HTTP
+// request does not have
+func connectionScoped(typ protocol.MessageType) bool {
+	switch typ {
+	case protocol.MessageTypeStreamStart, protocol.MessageTypeStreamData, protocol.MessageTypeStreamEnd,
+		protocol.MessageTypePresenceSub, protocol.MessageTypePresenceUnsub,
+		protocol.MessageTypeHello, protocol.MessageTypePong:
+		return true
+	}
+	return false
+}
+
+// remoteHost returns the host part of a request's remote address
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authenticateHTTP validates the request's bearer token and returns the DID
+// it was issued to
+func (s *RelayServer) authenticateHTTP(r *http.Request) (string, error) {
+	if s.authDisabled() {
+		return "anonymous", nil
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == r.Header.Get("Authorization") {
+		return "", fmt.Errorf("missing bearer token")
+	}
+
+	claims, err := s.config.Authenticator.ValidateToken(r.Context(), token)
+	if err != nil {
+		return "", err
+	}
+	if claims.ExpiredAt(time.Now(), s.config.TokenClockSkew) {
+		return "", fmt.Errorf("token expired")
+	}
+	return claims.DID, nil
+}
+
+// authDisabled reports whether the server runs without authentication
+func (s *RelayServer) authDisabled() bool {
+	switch s.config.Authenticator.(type) {
+	case nil, *auth.NoOpAuthenticator:
+		return true
+	}
+	return false
+}
+
+// cborToJSON re-encodes a CBOR message as JSON
+func cborToJSON(data []byte) ([]byte, error) {
+	msg := &protocol.Message{}
+	if err := msg.CBORUnmarshal(data); err != nil {
+		return nil, err
+	}
+	return messageJSON(msg)
+}
+
+// replyErrorCode returns the code of an encoded error reply, or "" if data
+// is not an error
+func replyErrorCode(data []byte) string {
+	msg := &protocol.Message{}
+	if err := msg.CBORUnmarshal(data); err != nil || msg.Type != protocol.MessageTypeError {
+		return ""
+	}
+	if code := bodyString(msg.Body, "code"); code != "" {
+		return code
+	}
+	return errCodeInternal
+}
+
+// messageJSON encodes msg as JSON, converting CBOR-decoded maps in its body
+// and extensions. msg itself is left untouched.
+func messageJSON(msg *protocol.Message) ([]byte, error) {
+	return msg.JSONMarshal()
+}
@@ -0,0 +1,234 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/auth"
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// postTestMessage submits body to the server's HTTP message endpoint,
+// retrying briefly while the listener comes up.
+func postTestMessage(t *testing.T, srv *RelayServer, contentType, token string, body []byte) (*http.Response, []byte) {
+	t.Helper()
+	url := "http://" + srv.config.ListenAddr + httpMessagesPath
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		req, _ := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response: %v", err)
+	}
+	return resp, data
+}
+
+func startHTTPTestServer(t *testing.T, cfg *Config) *RelayServer {
+	t.Helper()
+	cfg.EnableHTTPMessages = true
+	return startTestServer(t, cfg)
+}
+
+func TestHTTPSubmit_PingCBOR(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+
+	ping := protocol.NewMessage(protocol.MessageTypePing, "did:example:a", "relay-server", nil)
+	data, _ := ping.CBORMarshal()
+	resp, body := postTestMessage(t, srv, contentTypeCBOR, "", data)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != contentTypeCBOR {
+		t.Errorf("Content-Type = %q, want %q", ct, contentTypeCBOR)
+	}
+	pong := &protocol.Message{}
+	if err := pong.CBORUnmarshal(body); err != nil {
+		t.Fatalf("CBORUnmarshal: %v", err)
+	}
+	if pong.Type != protocol.MessageTypePong || !bytes.Equal(pong.ReplyTo, ping.ID) {
+		t.Errorf("got type 0x%02x replying to %x, want pong to %x", pong.Type, pong.ReplyTo, ping.ID)
+	}
+}
+
+func TestHTTPSubmit_PingJSON(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+
+	ping := protocol.NewMessage(protocol.MessageTypePing, "did:example:a", "relay-server", nil)
+	data, _ := json.Marshal(ping)
+	resp, body := postTestMessage(t, srv, contentTypeJSON, "", data)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", resp.StatusCode, body)
+	}
+	pong := &protocol.Message{}
+	if err := json.Unmarshal(body, pong); err != nil {
+		t.Fatalf("json.Unmarshal: %v", err)
+	}
+	if pong.Type != protocol.MessageTypePong || !bytes.Equal(pong.ReplyTo, ping.ID) {
+		t.Errorf("got type 0x%02x replying to %x, want pong to %x", pong.Type, pong.ReplyTo, ping.ID)
+	}
+}
+
+func TestHTTPSubmit_RouteResponseJSON(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+	srv.RegisterRoute("ping", func(msg RelayMessage) (RelayMessage, error) {
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(),
+			map[string]interface{}{"status": "ok", "message": "pong"})), nil
+	})
+
+	req := newActionRequest("ping")
+	data, _ := json.Marshal(req)
+	resp, body := postTestMessage(t, srv, contentTypeJSON, "", data)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (%s)", resp.StatusCode, body)
+	}
+	var got struct {
+		Type protocol.MessageType `json:"typ"`
+		Body map[string]string    `json:"body"`
+	}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("json.Unmarshal: %v (%s)", err, body)
+	}
+	if got.Type != protocol.MessageTypeResponse || got.Body["message"] != "pong" {
+		t.Errorf("got %+v, want a pong response", got)
+	}
+}
+
+func TestHTTPSubmit_AcceptedWithoutReply(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+
+	msg := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:a", "did:example:offline", nil)
+	data, _ := msg.CBORMarshal()
+	resp, _ := postTestMessage(t, srv, contentTypeCBOR, "", data)
+
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want 202", resp.StatusCode)
+	}
+	if stored, _ := srv.store.Get(msg.IDHex()); stored == nil {
+		t.Error("submitted message was not stored for later delivery")
+	}
+}
+
+func TestHTTPSubmit_BearerAuth(t *testing.T) {
+	authenticator := auth.NewPlaceholderAuthenticator()
+	result, err := authenticator.Verify(context.Background(), "did:example:a", nil)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Authenticator = authenticator
+	srv := startHTTPTestServer(t, cfg)
+
+	encode := func(from string) []byte {
+		data, _ := protocol.NewMessage(protocol.MessageTypePing, from, "relay-server", nil).CBORMarshal()
+		return data
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		from  string
+		want  int
+	}{
+		{"missing token", "", "did:example:a", http.StatusUnauthorized},
+		{"unknown token", "bogus", "did:example:a", http.StatusUnauthorized},
+		{"sender mismatch", result.Token, "did:example:mallory", http.StatusForbidden},
+		{"valid token", result.Token, "did:example:a", http.StatusOK},
+		{"sender taken from token", result.Token, "", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := postTestMessage(t, srv, contentTypeCBOR, tc.token, encode(tc.from))
+			if resp.StatusCode != tc.want {
+				t.Errorf("status = %d, want %d (%s)", resp.StatusCode, tc.want, body)
+			}
+		})
+	}
+}
+
+func TestHTTPSubmit_Rejections(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+	ping, _ := protocol.NewMessage(protocol.MessageTypePing, "did:example:a", "relay-server", nil).CBORMarshal()
+
+	if resp, _ := postTestMessage(t, srv, "text/plain", "", ping); resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain status = %d, want 415", resp.StatusCode)
+	}
+	if resp, _ := postTestMessage(t, srv, contentTypeCBOR, "", []byte{0xff}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed body status = %d, want 400", resp.StatusCode)
+	}
+	for _, typ := range []protocol.MessageType{
+		protocol.MessageTypeStreamStart, protocol.MessageTypeHello,
+		protocol.MessageTypePresenceSub, protocol.MessageTypePong,
+	} {
+		data, _ := protocol.NewMessage(typ, "did:example:a", "did:example:b", nil).CBORMarshal()
+		if resp, _ := postTestMessage(t, srv, contentTypeCBOR, "", data); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want 400", typ.Name(), resp.StatusCode)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPut, "http://"+srv.config.ListenAddr+httpMessagesPath, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("PUT: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("PUT status = %d, want 405", resp.StatusCode)
+	}
+}
+
+// TestHTTPSubmit_AdmissionChecks verifies HTTP submissions go through the
+// same rate limit and message type allow-list as WebSocket messages
+func TestHTTPSubmit_AdmissionChecks(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimitPerMinute = 2
+	cfg.AllowedMessageTypes = []protocol.MessageType{protocol.MessageTypePing}
+	srv := startHTTPTestServer(t, cfg)
+
+	event, _ := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil).CBORMarshal()
+	if resp, body := postTestMessage(t, srv, contentTypeCBOR, "", event); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("disallowed type status = %d, want 403 (%s)", resp.StatusCode, body)
+	}
+
+	ping, _ := protocol.NewMessage(protocol.MessageTypePing, "did:example:a", "relay-server", nil).CBORMarshal()
+	if resp, body := postTestMessage(t, srv, contentTypeCBOR, "", ping); resp.StatusCode != http.StatusOK {
+		t.Errorf("ping status = %d, want 200 (%s)", resp.StatusCode, body)
+	}
+	if resp, _ := postTestMessage(t, srv, contentTypeCBOR, "", ping); resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("ping past the limit status = %d, want 429", resp.StatusCode)
+	}
+}
+
+func TestHTTPSubmit_DisabledByDefault(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	ping, _ := protocol.NewMessage(protocol.MessageTypePing, "did:example:a", "relay-server", nil).CBORMarshal()
+
+	if resp, _ := postTestMessage(t, srv, contentTypeCBOR, "", ping); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 when HTTP messages are disabled", resp.StatusCode)
+	}
+}
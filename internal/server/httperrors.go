@@ -0,0 +1,77 @@
+package server
+
+import "net/http"
+
+// Error codes answered only by the HTTP endpoints; relay error replies use
+// their own codes, which share the registry below
+const (
+	errCodeBadRequest           = "bad_request"
+	errCodeAuthRequired         = "auth_required"
+	errCodeForbidden            = "forbidden"
+	errCodeMethodNotAllowed     = "method_not_allowed"
+	errCodeUnsupportedMediaType = "unsupported_media_type"
+	errCodePayloadTooLarge      = "payload_too_large"
+	errCodeInternal             = "internal_error"
+)
+
+// errorCodeHeader carries the error code of a failed HTTP request
+const errorCodeHeader = "AMP-Error-Code"
+
+// httpStatusByCode maps each error code to the HTTP status it is answered
+// with. Codes not listed here are answered with 500.
+var httpStatusByCode = map[string]int{
+	errCodeBadRequest:           http.StatusBadRequest,
+	errCodeAuthRequired:         http.StatusUnauthorized,
+	errCodeForbidden:            http.StatusForbidden,
+	errCodeMethodNotAllowed:     http.StatusMethodNotAllowed,
+	errCodeUnsupportedMediaType: http.StatusUnsupportedMediaType,
+	errCodePayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	errCodeInternal:             http.StatusInternalServerError,
+
+	"invalid_request":     http.StatusBadRequest,
+	"missing_destination": http.StatusBadRequest,
+	"unsupported_type":    http.StatusBadRequest,
+	"invalid_credential":  http.StatusBadRequest,
+	"invalid_delegation":  http.StatusBadRequest,
+	"invalid_encryption":  http.StatusBadRequest,
+
+	"not_authorized":           http.StatusForbidden,
+	"destination_blocked":      http.StatusForbidden,
+	"message_type_not_allowed": http.StatusForbidden,
+
+	"document_not_found": http.StatusNotFound,
+	"no_delegation":      http.StatusNotFound,
+
+	"contact_exists":     http.StatusConflict,
+	"no_contact_request": http.StatusConflict,
+	"not_a_contact":      http.StatusConflict,
+
+	"document_too_large": http.StatusRequestEntityTooLarge,
+
+	"rate_limited":     http.StatusTooManyRequests,
+	"quota_exceeded":   http.StatusTooManyRequests,
+	"too_many_streams": http.StatusTooManyRequests,
+
+	"handler_error":      http.StatusInternalServerError,
+	"response_too_large": http.StatusInternalServerError,
+	"storage_error":      http.StatusInternalServerError,
+
+	"server_busy":       http.StatusServiceUnavailable,
+	"server_overloaded": http.StatusServiceUnavailable,
+	"storage_timeout":   http.StatusGatewayTimeout,
+}
+
+// httpStatus returns the HTTP status registered for an error code, or 500
+func httpStatus(code string) int {
+	if status, ok := httpStatusByCode[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// httpError answers a failed HTTP request with message and the status
+// registered for code, which is also sent in the AMP-Error-Code header
+func httpError(w http.ResponseWriter, code, message string) {
+	w.Header().Set(errorCodeHeader, code)
+	http.Error(w, message, httpStatus(code))
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func TestHTTPStatus(t *testing.T) {
+	tests := map[string]int{
+		errCodeBadRequest:           http.StatusBadRequest,
+		errCodeAuthRequired:         http.StatusUnauthorized,
+		errCodeForbidden:            http.StatusForbidden,
+		errCodeMethodNotAllowed:     http.StatusMethodNotAllowed,
+		errCodeUnsupportedMediaType: http.StatusUnsupportedMediaType,
+		errCodePayloadTooLarge:      http.StatusRequestEntityTooLarge,
+		errCodeInternal:             http.StatusInternalServerError,
+		"invalid_request":           http.StatusBadRequest,
+		"missing_destination":       http.StatusBadRequest,
+		"unsupported_type":          http.StatusBadRequest,
+		"invalid_credential":        http.StatusBadRequest,
+		"invalid_delegation":        http.StatusBadRequest,
+		"invalid_encryption":        http.StatusBadRequest,
+		"not_authorized":            http.StatusForbidden,
+		"destination_blocked":       http.StatusForbidden,
+		"message_type_not_allowed":  http.StatusForbidden,
+		"document_not_found":        http.StatusNotFound,
+		"no_delegation":             http.StatusNotFound,
+		"contact_exists":            http.StatusConflict,
+		"no_contact_request":        http.StatusConflict,
+		"not_a_contact":             http.StatusConflict,
+		"document_too_large":        http.StatusRequestEntityTooLarge,
+		"rate_limited":              http.StatusTooManyRequests,
+		"quota_exceeded":            http.StatusTooManyRequests,
+		"too_many_streams":          http.StatusTooManyRequests,
+		"handler_error":             http.StatusInternalServerError,
+		"response_too_large":        http.StatusInternalServerError,
+		"storage_error":             http.StatusInternalServerError,
+		"server_busy":               http.StatusServiceUnavailable,
+		"server_overloaded":         http.StatusServiceUnavailable,
+		"storage_timeout":           http.StatusGatewayTimeout,
+	}
+	if len(tests) != len(httpStatusByCode) {
+		t.Errorf("registry has %d codes, test covers %d", len(httpStatusByCode), len(tests))
+	}
+	for code, want := range tests {
+		if got := httpStatus(code); got != want {
+			t.Errorf("httpStatus(%q) = %d, want %d", code, got, want)
+		}
+	}
+
+	for _, code := range []string{"", "no_such_code"} {
+		if got := httpStatus(code); got != http.StatusInternalServerError {
+			t.Errorf("httpStatus(%q) = %d, want 500", code, got)
+		}
+	}
+}
+
+// TestHTTPSubmit_ErrorReplyStatus checks that an error reply is returned with
+// the status registered for its code
+func TestHTTPSubmit_ErrorReplyStatus(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+
+	msg := protocol.NewMessage(protocol.MessageTypeDocSend, "did:example:a", "relay-server", nil)
+	data, _ := msg.CBORMarshal()
+	resp, body := postTestMessage(t, srv, contentTypeCBOR, "", data)
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 (%s)", resp.StatusCode, body)
+	}
+	if code := resp.Header.Get(errorCodeHeader); code != "missing_destination" {
+		t.Errorf("%s = %q, want missing_destination", errorCodeHeader, code)
+	}
+	reply := &protocol.Message{}
+	if err := reply.CBORUnmarshal(body); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	if errorCode(reply) != "missing_destination" {
+		t.Errorf("reply code = %q, want missing_destination", errorCode(reply))
+	}
+}
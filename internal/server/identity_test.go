@@ -0,0 +1,54 @@
+package server
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func TestRelayServer_ConfiguredServerIdentity(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.ServerName = "relay.example"
+	cfg.ServerDID = "did:web:relay.example"
+	srv := startTestServer(t, cfg)
+
+	var calls atomic.Int32
+	srv.RegisterRoute("lookup", func(msg RelayMessage) (RelayMessage, error) {
+		calls.Add(1)
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "", msg.From(), "found")), nil
+	})
+
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+
+	for _, to := range []string{"relay.example", "did:web:relay.example", ""} {
+		req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", to,
+			map[string]interface{}{"action": "lookup"})
+		sendTestMessage(t, alice, req)
+		resp := readTestMessage(t, alice)
+		if resp.Type != protocol.MessageTypeResponse || resp.Body != "found" {
+			t.Fatalf("request to %q: type 0x%02x body %v, want local response", to, resp.Type, resp.Body)
+		}
+	}
+
+	// The same action addressed to a peer is forwarded, not handled
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "lookup"})
+	sendTestMessage(t, alice, req)
+	if got := readTestMessage(t, bob); got.IDHex() != req.IDHex() {
+		t.Fatalf("bob got %s, want forwarded request %s", got.IDHex(), req.IDHex())
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("route ran %d times, want 3", n)
+	}
+
+	// Relay-originated messages carry the configured identity
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypePing, "did:example:alice", "", nil))
+	if pong := readTestMessage(t, alice); pong.From != "did:web:relay.example" {
+		t.Errorf("pong From = %q, want did:web:relay.example", pong.From)
+	}
+}
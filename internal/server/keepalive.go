@@ -0,0 +1,84 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/transport"
+)
+
+// defaultKeepaliveMissLimit is how many unanswered keepalive pings close a
+// client when Config.KeepaliveMissLimit is unset
+const defaultKeepaliveMissLimit = 3
+
+// keepaliveLoop pings idle clients every KeepaliveInterval until the server stops
+func (s *RelayServer) keepaliveLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.KeepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			s.keepalive()
+		}
+	}
+}
+
+// keepalive sends an application Ping to each DID-bound client that has
+// been quiet for a keepalive interval, and closes those that have left
+// KeepaliveMissLimit pings in a row without a Pong. Unlike WebSocket
+// control frames, these survive intermediaries that strip them.
+func (s *RelayServer) keepalive() {
+	limit := s.config.KeepaliveMissLimit
+	if limit <= 0 {
+		limit = defaultKeepaliveMissLimit
+	}
+	cutoff := time.Now().Add(-s.config.KeepaliveInterval)
+
+	type target struct{ id, did string }
+	var ping []target
+	var unresponsive []string
+	s.clientsMu.Lock()
+	for id, client := range s.clients {
+		if client.DID == "" || client.LastActivity.After(cutoff) {
+			continue
+		}
+		if client.MissedPings >= limit {
+			unresponsive = append(unresponsive, id)
+			continue
+		}
+		client.MissedPings++
+		ping = append(ping, target{id, client.DID})
+	}
+	s.clientsMu.Unlock()
+
+	for _, id := range unresponsive {
+		if !s.removeClient(id, disconnectUnresponsive) {
+			continue
+		}
+		if s.wsServer != nil {
+			s.wsServer.CloseClient(id, transport.CloseIdle, "keepalive timeout")
+		}
+		log.Printf("Removed unresponsive client: %s", id)
+	}
+	for _, t := range ping {
+		msg := protocol.NewMessage(protocol.MessageTypePing, s.serverIdentity(), t.did, nil)
+		if err := s.forwardMessageToClient(t.id, msg); err != nil {
+			s.logger.Debug("Keepalive ping failed", "client", t.id, "error", err)
+		}
+	}
+}
+
+// handlePong records a keepalive reply, clearing the client's missed pings
+func (s *RelayServer) handlePong(clientID string) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if client, exists := s.clients[clientID]; exists {
+		client.MissedPings = 0
+	}
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/transport"
+	"github.com/gorilla/websocket"
+)
+
+// answerPings replies to every keepalive Ping on conn until it is closed,
+// reporting how many it answered
+func answerPings(conn *websocket.Conn, did string, answered chan<- int) {
+	n := 0
+	defer func() { answered <- n }()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg := &protocol.Message{}
+		if msg.CBORUnmarshal(data) != nil || msg.Type != protocol.MessageTypePing {
+			continue
+		}
+		pong := protocol.NewMessage(protocol.MessageTypePong, did, "", nil)
+		pong.ReplyTo = msg.ID
+		data, _ = pong.CBORMarshal()
+		if conn.WriteMessage(websocket.BinaryMessage, data) != nil {
+			return
+		}
+		n++
+	}
+}
+
+// TestRelayServer_Keepalive verifies idle clients are pinged, a client that
+// answers stays connected and one that doesn't is closed
+func TestRelayServer_Keepalive(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.KeepaliveInterval = 20 * time.Millisecond
+	cfg.KeepaliveMissLimit = 2
+	srv := startTestServer(t, cfg)
+	responsive := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, responsive, "did:example:alice")
+	silent := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, silent, "did:example:bob")
+
+	answered := make(chan int, 1)
+	go answerPings(responsive, "did:example:alice", answered)
+
+	// The silent client is pinged up to the limit, then closed
+	pings := 0
+	silent.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := silent.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, transport.CloseIdle) {
+				t.Fatalf("silent client read error = %v, want close code %d", err, transport.CloseIdle)
+			}
+			break
+		}
+		msg := &protocol.Message{}
+		if msg.CBORUnmarshal(data) == nil && msg.Type == protocol.MessageTypePing {
+			if msg.To != "did:example:bob" {
+				t.Errorf("ping addressed to %q, want did:example:bob", msg.To)
+			}
+			pings++
+		}
+	}
+	if pings != cfg.KeepaliveMissLimit {
+		t.Errorf("silent client got %d pings before closing, want %d", pings, cfg.KeepaliveMissLimit)
+	}
+
+	// Well past the point the silent client was dropped, the responsive one
+	// is still registered and answering
+	time.Sleep(5 * cfg.KeepaliveInterval)
+	srv.clientsMu.RLock()
+	dids := make(map[string]bool)
+	for _, info := range srv.clients {
+		dids[info.DID] = true
+	}
+	srv.clientsMu.RUnlock()
+	if !dids["did:example:alice"] || dids["did:example:bob"] {
+		t.Errorf("registered DIDs = %v, want alice only", dids)
+	}
+
+	responsive.Close()
+	if n := <-answered; n < cfg.KeepaliveMissLimit+1 {
+		t.Errorf("responsive client answered %d pings, want more than the miss limit", n)
+	}
+}
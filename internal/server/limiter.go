@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// errServerBusy is returned when no handler slot is free and the wait queue is full
+var errServerBusy = errors.New("server busy")
+
+// handlerLimiter bounds the number of route handlers executing at once.
+// Callers that find every slot taken wait in a bounded queue; beyond that
+// they are rejected immediately.
+type handlerLimiter struct {
+	slots    chan struct{} // nil means unlimited
+	queued   atomic.Int64
+	maxQueue int64
+}
+
+// newHandlerLimiter creates a limiter allowing maxConcurrent handlers (0 = unlimited)
+// with up to maxQueued callers waiting for a slot
+func newHandlerLimiter(maxConcurrent, maxQueued int) *handlerLimiter {
+	l := &handlerLimiter{maxQueue: int64(maxQueued)}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// acquire takes a handler slot, waiting in the queue if one is available.
+// It returns false if the queue is full or done is closed while waiting.
+func (l *handlerLimiter) acquire(done <-chan struct{}) bool {
+	if l.slots == nil {
+		return true
+	}
+
+	// Fast path: a slot is free
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.queued.Add(1) > l.maxQueue {
+		l.queued.Add(-1)
+		return false
+	}
+	defer l.queued.Add(-1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *handlerLimiter) release() {
+	if l.slots == nil {
+		return
+	}
+	<-l.slots
+}
+
+// runHandler executes handler within the configured concurrency limit,
+// returning errServerBusy if no slot could be obtained
+func (s *RelayServer) runHandler(handler RouteHandler, msg RelayMessage) (RelayMessage, error) {
+	if !s.handlers.acquire(s.ctx.Done()) {
+		return nil, errServerBusy
+	}
+	defer s.handlers.release()
+
+	return handler(msg)
+}
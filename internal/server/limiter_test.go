@@ -0,0 +1,121 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// TestHandlerLimiter_Unlimited verifies that a zero limit never blocks.
+func TestHandlerLimiter_Unlimited(t *testing.T) {
+	l := newHandlerLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if !l.acquire(nil) {
+			t.Fatalf("acquire %d failed on unlimited limiter", i)
+		}
+	}
+}
+
+// TestRunHandler_Overflow saturates the handler semaphore and verifies that
+// one extra request waits in the queue while the next is rejected as busy.
+func TestRunHandler_Overflow(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrentHandlers = 2
+	cfg.MaxQueuedHandlers = 1
+	srv := NewRelayServer(cfg)
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	blocking := func(msg RelayMessage) (RelayMessage, error) {
+		started <- struct{}{}
+		<-release
+		return msg, nil
+	}
+	msg := WrapMessage(protocol.NewMessage(protocol.MessageTypeRequest, "from", "to", nil))
+
+	// Fill both slots
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := srv.runHandler(blocking, msg)
+			errs <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+
+	// Third caller queues for a slot
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, err := srv.runHandler(blocking, msg)
+		errs <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for srv.handlers.queued.Load() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("third handler never entered the wait queue")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Fourth caller overflows the queue
+	if _, err := srv.runHandler(blocking, msg); err != errServerBusy {
+		t.Fatalf("overflow runHandler error = %v, want errServerBusy", err)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("admitted handler returned error: %v", err)
+		}
+	}
+
+	// Capacity is restored once handlers finish
+	if _, err := srv.runHandler(func(RelayMessage) (RelayMessage, error) { return nil, nil }, msg); err != nil {
+		t.Errorf("runHandler after release error = %v, want nil", err)
+	}
+}
+
+// TestRunHandler_ShutdownUnblocksQueue verifies that queued callers give up
+// when the server is stopped.
+func TestRunHandler_ShutdownUnblocksQueue(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxConcurrentHandlers = 1
+	cfg.MaxQueuedHandlers = 1
+	srv := NewRelayServer(cfg)
+
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	go srv.runHandler(func(RelayMessage) (RelayMessage, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}, nil)
+	<-started
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := srv.runHandler(func(RelayMessage) (RelayMessage, error) { return nil, nil }, nil)
+		result <- err
+	}()
+
+	srv.cancel()
+	select {
+	case err := <-result:
+		if err != errServerBusy {
+			t.Errorf("queued runHandler error = %v, want errServerBusy", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued handler did not return after shutdown")
+	}
+}
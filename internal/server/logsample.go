@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// samplingHandler thins out repeated log events below error level. Each
+// distinct record message is its own event type: only every Nth occurrence
+// is kept, and at most maxPerSecond per second.
+type samplingHandler struct {
+	next    slog.Handler
+	sampler *logSampler
+}
+
+// newSamplingHandler wraps next, keeping 1 in every events (0 or 1 = all)
+// and at most maxPerSecond per event type each second (0 = unlimited)
+func newSamplingHandler(next slog.Handler, every, maxPerSecond int) *samplingHandler {
+	return &samplingHandler{
+		next: next,
+		sampler: &logSampler{
+			every:        uint64(every),
+			maxPerSecond: maxPerSecond,
+			now:          time.Now,
+			events:       make(map[string]*sampledEvent),
+		},
+	}
+}
+
+func (h *samplingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *samplingHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelError && !h.sampler.allow(r.Message) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *samplingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &samplingHandler{next: h.next.WithAttrs(attrs), sampler: h.sampler}
+}
+
+func (h *samplingHandler) WithGroup(name string) slog.Handler {
+	return &samplingHandler{next: h.next.WithGroup(name), sampler: h.sampler}
+}
+
+// logSampler tracks occurrences per event type. Log messages are constant
+// strings at each call site, so the map stays small.
+type logSampler struct {
+	every        uint64
+	maxPerSecond int
+	now          func() time.Time
+
+	mu     sync.Mutex
+	events map[string]*sampledEvent
+}
+
+// sampledEvent counts one event type's occurrences
+type sampledEvent struct {
+	seen     uint64
+	second   int64
+	inSecond int
+}
+
+// allow reports whether this occurrence of event should be logged
+func (s *logSampler) allow(event string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.events[event]
+	if !ok {
+		e = &sampledEvent{}
+		s.events[event] = e
+	}
+
+	e.seen++
+	if s.every > 1 && (e.seen-1)%s.every != 0 {
+		return false
+	}
+
+	if s.maxPerSecond > 0 {
+		if sec := s.now().Unix(); sec != e.second {
+			e.second = sec
+			e.inSecond = 0
+		}
+		if e.inSecond >= s.maxPerSecond {
+			return false
+		}
+		e.inSecond++
+	}
+	return true
+}
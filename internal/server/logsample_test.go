@@ -0,0 +1,63 @@
+package server
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+// countLines returns how many log records in logs have the given message
+func countLines(t *testing.T, logs *syncBuffer, msg string) int {
+	t.Helper()
+	n := 0
+	for _, rec := range logs.records(t) {
+		if rec["msg"] == msg {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSamplingHandler_OneInN(t *testing.T) {
+	logs := &syncBuffer{}
+	logger := slog.New(newSamplingHandler(slog.NewJSONHandler(logs, nil), 10, 0))
+
+	for i := 0; i < 100; i++ {
+		logger.Warn("Failed to forward event", "client", i)
+		if i < 5 {
+			logger.Info("Destination not connected")
+		}
+		logger.Error("Failed to store message")
+	}
+
+	if got := countLines(t, logs, "Failed to forward event"); got != 10 {
+		t.Errorf("sampled warnings = %d, want 10", got)
+	}
+	// Event types are sampled independently; the first occurrence always logs
+	if got := countLines(t, logs, "Destination not connected"); got != 1 {
+		t.Errorf("sampled infos = %d, want 1", got)
+	}
+	if got := countLines(t, logs, "Failed to store message"); got != 100 {
+		t.Errorf("errors = %d, want all 100", got)
+	}
+}
+
+func TestSamplingHandler_MaxPerSecond(t *testing.T) {
+	logs := &syncBuffer{}
+	h := newSamplingHandler(slog.NewJSONHandler(logs, nil), 0, 3)
+	now := time.Unix(1700000000, 0)
+	h.sampler.now = func() time.Time { return now }
+	logger := slog.New(h).With("correlation_id", "abc")
+
+	for i := 0; i < 50; i++ {
+		logger.Warn("Failed to forward event")
+	}
+	now = now.Add(time.Second)
+	for i := 0; i < 50; i++ {
+		logger.Warn("Failed to forward event")
+	}
+
+	if got := countLines(t, logs, "Failed to forward event"); got != 6 {
+		t.Errorf("rate-limited lines = %d, want 3 per second over 2 seconds", got)
+	}
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+	cbor "github.com/fxamacker/cbor/v2"
+)
+
+// Mailbox page sizes and long-poll bound
+const (
+	defaultMailboxLimit = 100
+	maxMailboxLimit     = 1000
+	maxMailboxWait      = 60 * time.Second
+)
+
+// receiveSeqExtKey is the Ext field the relay stamps on every message it
+// stores with its receive sequence, which orders and pages mailbox listings
+const receiveSeqExtKey = "relay_seq"
+
+// receiveSequence hands out increasing receive sequences: the receive time
+// in nanoseconds, bumped past the last one so no two are equal. Being
+// time-based, sequences keep increasing across a restart.
+type receiveSequence struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+// next returns the next sequence as fixed-width hex, so string order is
+// numeric order
+func (r *receiveSequence) next() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seq := uint64(time.Now().UnixNano())
+	if seq <= r.last {
+		seq = r.last + 1
+	}
+	r.last = seq
+	return fmt.Sprintf("%016x", seq)
+}
+
+// saveMessage stamps msg with the next receive sequence, replacing any the
+// sender supplied, and stores it for its effective TTL
+func (s *RelayServer) saveMessage(msg *protocol.Message) error {
+	if msg.Ext == nil {
+		msg.Ext = make(map[string]interface{})
+	}
+	msg.Ext[receiveSeqExtKey] = s.receiveSeq.next()
+	return s.store.Save(msg, s.effectiveTTL(msg))
+}
+
+// receiveSeqOf returns the receive sequence stamped on a stored message, or
+// "" if it has none
+func receiveSeqOf(msg *protocol.Message) string {
+	seq, _ := msg.Ext[receiveSeqExtKey].(string)
+	return seq
+}
+
+// mailboxPage is one page of a mailbox listing
+type mailboxPage struct {
+	Messages   []*protocol.Message `json:"messages" cbor:"messages"`
+	NextCursor string              `json:"next_cursor,omitempty" cbor:"next_cursor,omitempty"`
+}
+
+// handleHTTPMailbox returns stored messages addressed to the authenticated
+// DID, oldest first. Query parameters:
+//
+//	to      recipient DID; must match the token's DID when auth is enabled
+//	limit   page size (default 100, max 1000)
+//	cursor  next_cursor from the previous page (a relay receive sequence)
+//	ack     "true" deletes the returned messages after they are read
+//	wait    long-poll duration (e.g. 30s, max 60s): if the mailbox is empty,
+//	        block until a message arrives or the duration elapses. Requires
+//	        a store implementing storage.Notifier; otherwise it is ignored.
+func (s *RelayServer) handleHTTPMailbox(w http.ResponseWriter, r *http.Request) {
+	did, err := s.authenticateHTTP(r)
+	if err != nil {
+		httpError(w, errCodeAuthRequired, err.Error())
+		return
+	}
+
+	query := r.URL.Query()
+	to := query.Get("to")
+	switch {
+	case to == "" && s.authDisabled():
+		httpError(w, errCodeBadRequest, "to parameter is required")
+		return
+	case to == "":
+		to = did
+	case to != did && !s.authDisabled():
+		httpError(w, errCodeForbidden, "mailbox does not belong to the authenticated DID")
+		return
+	}
+
+	limit := defaultMailboxLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			httpError(w, errCodeBadRequest, "limit must be a positive integer")
+			return
+		}
+		if n > maxMailboxLimit {
+			n = maxMailboxLimit
+		}
+		limit = n
+	}
+	cursor := query.Get("cursor")
+
+	var wait time.Duration
+	if v := query.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			httpError(w, errCodeBadRequest, "wait must be a non-negative duration")
+			return
+		}
+		if d > maxMailboxWait {
+			d = maxMailboxWait
+		}
+		wait = d
+	}
+
+	// Subscribe before listing so a message saved in between is not missed
+	var updates <-chan *protocol.Message
+	if n, ok := s.store.(storage.Notifier); ok && wait > 0 {
+		ch, cancel := n.Subscribe(func(msg *protocol.Message) bool { return msg.To == to })
+		defer cancel()
+		updates = ch
+	}
+
+	messages, err := s.listMailbox(to, cursor)
+	if err == nil && len(messages) == 0 && updates != nil {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-updates:
+			messages, err = s.listMailbox(to, cursor)
+		case <-timer.C:
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+		}
+	}
+	if err != nil {
+		log.Printf("Mailbox listing for %s failed: %v", to, err)
+		httpError(w, storageErrorCode(err), "failed to list messages")
+		return
+	}
+
+	page := mailboxPage{Messages: messages}
+	if len(messages) > limit {
+		page.Messages = messages[:limit]
+		page.NextCursor = receiveSeqOf(page.Messages[limit-1])
+	}
+
+	if query.Get("ack") == "true" {
+		for _, msg := range page.Messages {
+			if err := s.store.Delete(msg.IDHex()); err != nil {
+				log.Printf("Failed to delete acknowledged message %s: %v", msg.IDHex(), err)
+			}
+		}
+	}
+
+	s.writeMailboxPage(w, r, page)
+}
+
+// listMailbox returns the stored messages addressed to did after cursor, in
+// the order the relay received them. The cursor is a receive sequence rather
+// than a message ID, since senders choose their IDs and a backdated one
+// would land behind a cursor already handed out. Messages stored without a
+// sequence, e.g. by an older relay, come first and only on the first page.
+func (s *RelayServer) listMailbox(did, cursor string) ([]*protocol.Message, error) {
+	messages, err := s.store.ListFiltered(func(msg *protocol.Message) bool {
+		return msg.To == did && (cursor == "" || receiveSeqOf(msg) > cursor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(messages, func(i, j int) bool {
+		a, b := receiveSeqOf(messages[i]), receiveSeqOf(messages[j])
+		if a != b {
+			return a < b
+		}
+		return messages[i].IDHex() < messages[j].IDHex()
+	})
+	return messages, nil
+}
+
+// writeMailboxPage encodes page as CBOR if the client accepts it, JSON otherwise
+func (s *RelayServer) writeMailboxPage(w http.ResponseWriter, r *http.Request, page mailboxPage) {
+	if accept, _, _ := mime.ParseMediaType(r.Header.Get("Accept")); accept == contentTypeCBOR {
+		data, err := cbor.Marshal(page)
+		if err != nil {
+			httpError(w, errCodeInternal, "failed to encode messages")
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeCBOR)
+		w.Write(data)
+		return
+	}
+
+	// Stored messages are shared, so convert copies of them for JSON
+	out := make([]*protocol.Message, len(page.Messages))
+	for i, msg := range page.Messages {
+		out[i] = msg.JSONCompatible()
+	}
+	page.Messages = out
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/auth"
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	cbor "github.com/fxamacker/cbor/v2"
+)
+
+// getMailbox fetches a mailbox page, retrying briefly while the listener
+// comes up, and decodes the JSON or CBOR response.
+func getMailbox(t *testing.T, srv *RelayServer, query, token, accept string) (int, mailboxPage) {
+	t.Helper()
+	url := "http://" + srv.config.ListenAddr + httpMessagesPath + "?" + query
+
+	var resp *http.Response
+	var err error
+	for i := 0; i < 50; i++ {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err = http.DefaultClient.Do(req)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	var page mailboxPage
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, page
+	}
+	if accept == contentTypeCBOR {
+		err = cbor.Unmarshal(data, &page)
+	} else {
+		err = json.Unmarshal(data, &page)
+	}
+	if err != nil {
+		t.Fatalf("decoding mailbox page: %v (%s)", err, data)
+	}
+	return resp.StatusCode, page
+}
+
+// storeForDID saves n messages addressed to did as the relay does and
+// returns their IDs in mailbox (arrival) order
+func storeForDID(t *testing.T, srv *RelayServer, did string, n int) []string {
+	t.Helper()
+	var ids []string
+	for i := 0; i < n; i++ {
+		msg := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:sender", did,
+			map[string]interface{}{"action": "note", "seq": i})
+		if err := srv.saveMessage(msg); err != nil {
+			t.Fatalf("Save: %v", err)
+		}
+		ids = append(ids, msg.IDHex())
+	}
+	return ids
+}
+
+func pageIDs(page mailboxPage) []string {
+	ids := make([]string, len(page.Messages))
+	for i, msg := range page.Messages {
+		ids[i] = msg.IDHex()
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHTTPMailbox_ListsMessagesForDID(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+	want := storeForDID(t, srv, "did:example:a", 3)
+	storeForDID(t, srv, "did:example:b", 2)
+
+	// An expired message is not returned
+	expired := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:sender", "did:example:a", nil)
+	srv.store.Save(expired, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	for _, accept := range []string{"", contentTypeCBOR} {
+		status, page := getMailbox(t, srv, "to=did:example:a", "", accept)
+		if status != http.StatusOK {
+			t.Fatalf("accept %q: status = %d, want 200", accept, status)
+		}
+		if got := pageIDs(page); !equalIDs(got, want) {
+			t.Errorf("accept %q: got %v, want %v", accept, got, want)
+		}
+		if page.NextCursor != "" {
+			t.Errorf("accept %q: next_cursor = %q, want none", accept, page.NextCursor)
+		}
+	}
+}
+
+func TestHTTPMailbox_Pagination(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+	want := storeForDID(t, srv, "did:example:a", 5)
+
+	var got []string
+	query := "to=did:example:a&limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("pagination did not terminate")
+		}
+		_, page := getMailbox(t, srv, query, "", "")
+		if len(page.Messages) > 2 {
+			t.Fatalf("page has %d messages, want at most 2", len(page.Messages))
+		}
+		got = append(got, pageIDs(page)...)
+		if page.NextCursor == "" {
+			break
+		}
+		query = "to=did:example:a&limit=2&cursor=" + page.NextCursor
+	}
+
+	if !equalIDs(got, want) {
+		t.Errorf("paged IDs = %v, want %v", got, want)
+	}
+}
+
+// TestHTTPMailbox_PaginationIgnoresSenderIDs checks paging follows arrival
+// at the relay, so a message with a backdated ID or a forged sequence that
+// arrives after a cursor was handed out is still listed on the next page
+func TestHTTPMailbox_PaginationIgnoresSenderIDs(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+	want := storeForDID(t, srv, "did:example:a", 2)
+
+	_, first := getMailbox(t, srv, "to=did:example:a&limit=1", "", "")
+	if !equalIDs(pageIDs(first), want[:1]) || first.NextCursor == "" {
+		t.Fatalf("first page = %v cursor %q, want %v with a cursor", pageIDs(first), first.NextCursor, want[:1])
+	}
+
+	late := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:sender", "did:example:a", nil)
+	late.ID = make([]byte, len(late.ID)) // sorts before every other ID
+	late.Ext = map[string]interface{}{receiveSeqExtKey: "0000000000000000"}
+	if err := srv.saveMessage(late); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	want = append(want, late.IDHex())
+
+	_, rest := getMailbox(t, srv, "to=did:example:a&cursor="+first.NextCursor, "", "")
+	if !equalIDs(pageIDs(rest), want[1:]) {
+		t.Errorf("next page = %v, want %v", pageIDs(rest), want[1:])
+	}
+}
+
+func TestHTTPMailbox_AckDeletes(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+	want := storeForDID(t, srv, "did:example:a", 3)
+
+	// Without ack the messages stay
+	getMailbox(t, srv, "to=did:example:a", "", "")
+	if _, page := getMailbox(t, srv, "to=did:example:a&ack=true&limit=2", "", ""); !equalIDs(pageIDs(page), want[:2]) {
+		t.Fatalf("ack page = %v, want %v", pageIDs(page), want[:2])
+	}
+
+	// Only the acknowledged page is deleted
+	if _, page := getMailbox(t, srv, "to=did:example:a", "", ""); !equalIDs(pageIDs(page), want[2:]) {
+		t.Errorf("after ack got %v, want %v", pageIDs(page), want[2:])
+	}
+}
+
+func TestHTTPMailbox_Auth(t *testing.T) {
+	authenticator := auth.NewPlaceholderAuthenticator()
+	result, err := authenticator.Verify(context.Background(), "did:example:a", nil)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.Authenticator = authenticator
+	srv := startHTTPTestServer(t, cfg)
+	want := storeForDID(t, srv, "did:example:a", 2)
+	storeForDID(t, srv, "did:example:b", 1)
+
+	if status, _ := getMailbox(t, srv, "to=did:example:a", "", ""); status != http.StatusUnauthorized {
+		t.Errorf("no token: status = %d, want 401", status)
+	}
+	if status, _ := getMailbox(t, srv, "to=did:example:b", result.Token, ""); status != http.StatusForbidden {
+		t.Errorf("other mailbox: status = %d, want 403", status)
+	}
+
+	// The token's DID is used when to is omitted
+	status, page := getMailbox(t, srv, "", result.Token, "")
+	if status != http.StatusOK || !equalIDs(pageIDs(page), want) {
+		t.Errorf("own mailbox: status %d, got %v, want %v", status, pageIDs(page), want)
+	}
+}
+
+func TestHTTPMailbox_BadRequests(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+
+	if status, _ := getMailbox(t, srv, "", "", ""); status != http.StatusBadRequest {
+		t.Errorf("missing to: status = %d, want 400", status)
+	}
+	if status, _ := getMailbox(t, srv, "to=did:example:a&limit=0", "", ""); status != http.StatusBadRequest {
+		t.Errorf("zero limit: status = %d, want 400", status)
+	}
+}
+
+func TestHTTPMailbox_LongPoll(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+
+	getMailbox(t, srv, "to=did:example:a", "", "") // wait for the listener
+
+	type result struct {
+		page    mailboxPage
+		elapsed time.Duration
+		err     error
+	}
+	results := make(chan result, 1)
+	start := time.Now()
+	go func() {
+		var r result
+		resp, err := http.Get("http://" + srv.config.ListenAddr + httpMessagesPath + "?to=did:example:a&wait=5s")
+		if err == nil {
+			err = json.NewDecoder(resp.Body).Decode(&r.page)
+			resp.Body.Close()
+		}
+		r.elapsed, r.err = time.Since(start), err
+		results <- r
+	}()
+
+	// Save once the poll is waiting on the store
+	time.Sleep(100 * time.Millisecond)
+	msg := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:sender", "did:example:a", nil)
+	if err := srv.store.Save(msg, time.Minute); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatalf("long poll failed: %v", r.err)
+		}
+		if got := pageIDs(r.page); !equalIDs(got, []string{msg.IDHex()}) {
+			t.Errorf("long poll returned %v, want [%s]", got, msg.IDHex())
+		}
+		if r.elapsed > 2*time.Second {
+			t.Errorf("long poll took %v, want prompt return after the save", r.elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("long poll did not return")
+	}
+}
+
+func TestHTTPMailbox_LongPollTimeout(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+
+	start := time.Now()
+	status, page := getMailbox(t, srv, "to=did:example:a&wait=100ms", "", "")
+	if status != http.StatusOK || len(page.Messages) != 0 {
+		t.Errorf("status %d with %d messages, want 200 and an empty page", status, len(page.Messages))
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("returned after %v, want to wait for the timeout", elapsed)
+	}
+
+	if status, _ := getMailbox(t, srv, "to=did:example:a&wait=soon", "", ""); status != http.StatusBadRequest {
+		t.Errorf("bad wait: status = %d, want 400", status)
+	}
+}
+
+func TestHTTPMailbox_LongPollClientCancel(t *testing.T) {
+	srv := startHTTPTestServer(t, DefaultConfig())
+	getMailbox(t, srv, "to=did:example:a", "", "") // wait for the listener
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://"+srv.config.ListenAddr+httpMessagesPath+"?to=did:example:a&wait=30s", nil)
+
+	start := time.Now()
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Fatal("expected the cancelled request to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("cancelled long poll took %v to return", elapsed)
+	}
+}
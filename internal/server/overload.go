@@ -0,0 +1,65 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// overloadDetector tracks messages in flight and trips when the count reaches
+// a high-water mark, staying tripped until it falls back to a low-water mark.
+// The hysteresis keeps the server from flapping around a single threshold.
+type overloadDetector struct {
+	high     int64 // 0 disables detection
+	low      int64
+	inflight atomic.Int64
+	tripped  atomic.Bool
+}
+
+// newOverloadDetector creates a detector; a low mark above high is clamped to high
+func newOverloadDetector(high, low int) *overloadDetector {
+	if low > high {
+		low = high
+	}
+	return &overloadDetector{high: int64(high), low: int64(low)}
+}
+
+// enter records a message entering processing and reports whether it should
+// be admitted. Every call must be paired with leave, admitted or not.
+func (d *overloadDetector) enter() bool {
+	load := d.inflight.Add(1) - 1 // messages already in flight
+	if d.high <= 0 {
+		return true
+	}
+
+	if d.tripped.Load() {
+		if load <= d.low {
+			d.tripped.Store(false)
+			log.Printf("Overload cleared: %d messages in flight", load)
+			return true
+		}
+		return false
+	}
+
+	if load >= d.high {
+		d.tripped.Store(true)
+		log.Printf("Overload detected: %d messages in flight, shedding new requests", load)
+		return false
+	}
+	return true
+}
+
+// leave records a message leaving processing
+func (d *overloadDetector) leave() {
+	d.inflight.Add(-1)
+}
+
+// sendOverloadedResponse rejects a request while the server is overloaded,
+// telling the client how long to back off
+func (s *RelayServer) sendOverloadedResponse(clientID string, msg *protocol.Message) error {
+	return s.sendErrorResponseWithDetails(clientID, msg, "server_overloaded", "Server overloaded, retry later",
+		map[string]interface{}{
+			"retry_after_ms": s.config.OverloadRetryAfter.Milliseconds(),
+		})
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// TestOverloadDetector_Hysteresis verifies the detector trips at the high
+// mark and only recovers once load drops to the low mark.
+func TestOverloadDetector_Hysteresis(t *testing.T) {
+	d := newOverloadDetector(2, 1)
+
+	if !d.enter() || !d.enter() {
+		t.Fatal("first two messages should be admitted")
+	}
+	if d.enter() {
+		t.Error("third message should trip the detector")
+	}
+	d.leave() // rejected message
+
+	d.leave() // load now 1 in flight
+	if !d.enter() {
+		t.Error("message at low-water load should be admitted and clear overload")
+	}
+	d.leave()
+	d.leave()
+}
+
+// TestOverloadDetector_Disabled verifies a zero high mark never sheds.
+func TestOverloadDetector_Disabled(t *testing.T) {
+	d := newOverloadDetector(0, 0)
+	for i := 0; i < 100; i++ {
+		if !d.enter() {
+			t.Fatalf("message %d rejected with shedding disabled", i)
+		}
+	}
+}
+
+// TestRelayServer_OverloadShedding forces overload with a slow handler,
+// asserts a concurrent request is shed with server_overloaded and a retry
+// hint, then verifies requests succeed once the load subsides.
+func TestRelayServer_OverloadShedding(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OverloadHighWater = 1
+	cfg.OverloadLowWater = 0
+	cfg.OverloadRetryAfter = 250 * time.Millisecond
+	srv := startTestServer(t, cfg)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	srv.RegisterRoute("slow", func(msg RelayMessage) (RelayMessage, error) {
+		close(started)
+		<-release
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), "done")), nil
+	})
+	srv.RegisterRoute("ping", func(msg RelayMessage) (RelayMessage, error) {
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), "pong")), nil
+	})
+
+	slowClient := dialTestClient(t, srv)
+	client := dialTestClient(t, srv)
+
+	sendTestMessage(t, slowClient, newActionRequest("slow"))
+	<-started
+
+	// While the slow request is in flight, new requests are shed
+	ping := newActionRequest("ping")
+	sendTestMessage(t, client, ping)
+	resp := readTestMessage(t, client)
+	if resp.Type != protocol.MessageTypeError || errorCode(resp) != "server_overloaded" {
+		t.Fatalf("response = type 0x%02x code %q, want server_overloaded error", resp.Type, errorCode(resp))
+	}
+	body := resp.Body.(map[interface{}]interface{})
+	if retry, _ := body["retry_after_ms"].(uint64); retry != 250 {
+		t.Errorf("retry_after_ms = %v, want 250", body["retry_after_ms"])
+	}
+
+	close(release)
+	if done := readTestMessage(t, slowClient); done.Type != protocol.MessageTypeResponse {
+		t.Fatalf("slow response type = 0x%02x, want response", done.Type)
+	}
+
+	// Load has subsided; requests are admitted again
+	deadline := time.Now().Add(time.Second)
+	for srv.overload.inflight.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	sendTestMessage(t, client, newActionRequest("ping"))
+	resp = readTestMessage(t, client)
+	if resp.Type != protocol.MessageTypeResponse {
+		t.Errorf("after recovery response type = 0x%02x code %q, want response", resp.Type, errorCode(resp))
+	}
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+// pendingKeyPrefix starts the state store keys of requests awaiting a response
+const pendingKeyPrefix = "pending:"
+
+// pendingRequest is a forwarded request awaiting its response
+type pendingRequest struct {
+	from     string // requester DID
+	to       string // DID the request was sent to, the only one that may answer
+	deadline time.Time
+}
+
+// pendingRecord is the persisted form of a pendingRequest
+type pendingRecord struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Deadline int64  `json:"deadline"` // Unix milliseconds
+}
+
+// pendingRequests correlates responses with the requests forwarded on their
+// behalf, so a response need not name its destination. Entries lapse after
+// the request timeout. With a state store, each entry is also persisted as
+// a record that expires with it, and load rebuilds the registry after a
+// restart.
+type pendingRequests struct {
+	timeout time.Duration
+	state   storage.StateStore // nil keeps entries in memory only
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]pendingRequest // keyed by request ID hex
+}
+
+// newPendingRequests creates a registry holding requests for timeout (0 = disabled)
+func newPendingRequests(timeout time.Duration, state storage.StateStore) *pendingRequests {
+	return &pendingRequests{
+		timeout: timeout,
+		state:   state,
+		now:     time.Now,
+		entries: make(map[string]pendingRequest),
+	}
+}
+
+// load rebuilds the registry from the records in the state store
+func (p *pendingRequests) load() error {
+	if p.timeout <= 0 || p.state == nil {
+		return nil
+	}
+
+	values, err := p.state.List(pendingKeyPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to load pending requests: %w", err)
+	}
+
+	now := p.now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, value := range values {
+		var record pendingRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			continue
+		}
+		entry := pendingRequest{from: record.From, to: record.To, deadline: time.UnixMilli(record.Deadline)}
+		if entry.from == "" || entry.to == "" || !now.Before(entry.deadline) {
+			continue
+		}
+		p.entries[strings.TrimPrefix(key, pendingKeyPrefix)] = entry
+	}
+	return nil
+}
+
+// add records msg as awaiting a response for its sender from its destination
+func (p *pendingRequests) add(msg *protocol.Message) error {
+	if p.timeout <= 0 || msg.From == "" || msg.To == "" {
+		return nil
+	}
+
+	now := p.now()
+	requestID := msg.IDHex()
+	entry := pendingRequest{from: msg.From, to: msg.To, deadline: now.Add(p.timeout)}
+
+	p.mu.Lock()
+	p.entries[requestID] = entry
+	p.mu.Unlock()
+
+	return p.persist(requestID, entry)
+}
+
+// touch returns the requester of the live request replyTo refers to and
+// restarts its timeout, leaving it pending. Interim updates use it so a
+// long-running request stays open while its handler reports progress. Only
+// the DID the request was sent to may touch it.
+func (p *pendingRequests) touch(replyTo []byte, responder string) (string, bool) {
+	if p.timeout <= 0 || len(replyTo) == 0 {
+		return "", false
+	}
+
+	now := p.now()
+	requestID := hex.EncodeToString(replyTo)
+	p.mu.Lock()
+	entry, ok := p.entries[requestID]
+	ok = ok && entry.to == responder && now.Before(entry.deadline)
+	if ok {
+		entry.deadline = now.Add(p.timeout)
+		p.entries[requestID] = entry
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return "", false
+	}
+	// A failed write only shortens the entry's life across a restart
+	p.persist(requestID, entry)
+	return entry.from, true
+}
+
+// persist saves the record of a request, kept until its deadline
+func (p *pendingRequests) persist(requestID string, entry pendingRequest) error {
+	if p.state == nil {
+		return nil
+	}
+	value, err := json.Marshal(pendingRecord{From: entry.from, To: entry.to, Deadline: entry.deadline.UnixMilli()})
+	if err != nil {
+		return fmt.Errorf("failed to encode pending request: %w", err)
+	}
+	if err := p.state.Put(pendingKeyPrefix+requestID, value, p.timeout); err != nil {
+		return fmt.Errorf("failed to save pending request: %w", err)
+	}
+	return nil
+}
+
+// resolve removes the request replyTo answers and returns its requester's DID.
+// It reports false if no live request matches, or if responder is not the
+// DID the request was sent to, in which case the request stays pending.
+func (p *pendingRequests) resolve(replyTo []byte, responder string) (string, bool) {
+	if p.timeout <= 0 || len(replyTo) == 0 {
+		return "", false
+	}
+
+	requestID := hex.EncodeToString(replyTo)
+	p.mu.Lock()
+	entry, ok := p.entries[requestID]
+	if !ok || entry.to != responder {
+		p.mu.Unlock()
+		return "", false
+	}
+	delete(p.entries, requestID)
+	p.mu.Unlock()
+
+	p.forget(requestID)
+	if !p.now().Before(entry.deadline) {
+		return "", false
+	}
+	return entry.from, true
+}
+
+// cancel drops a request that will not be answered, such as one whose
+// forward was refused
+func (p *pendingRequests) cancel(requestID string) {
+	p.mu.Lock()
+	_, ok := p.entries[requestID]
+	delete(p.entries, requestID)
+	p.mu.Unlock()
+	if ok {
+		p.forget(requestID)
+	}
+}
+
+// forget deletes a request's record, if it is persisted
+func (p *pendingRequests) forget(requestID string) {
+	if p.state != nil {
+		p.state.Delete(pendingKeyPrefix + requestID)
+	}
+}
+
+// sweep drops lapsed entries; their records expire on their own
+func (p *pendingRequests) sweep() {
+	now := p.now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, entry := range p.entries {
+		if !now.Before(entry.deadline) {
+			delete(p.entries, id)
+		}
+	}
+}
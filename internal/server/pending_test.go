@@ -0,0 +1,251 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+func TestPendingRequests_ReloadedFromStore(t *testing.T) {
+	store := storage.NewMemoryStateStore()
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob", nil)
+
+	before := newPendingRequests(time.Minute, store)
+	if err := before.add(req); err != nil {
+		t.Fatalf("add() error: %v", err)
+	}
+
+	// A fresh registry over the same store stands in for a restarted relay
+	after := newPendingRequests(time.Minute, store)
+	if err := after.load(); err != nil {
+		t.Fatalf("load() error: %v", err)
+	}
+	from, ok := after.resolve(req.ID, "did:example:bob")
+	if !ok || from != "did:example:alice" {
+		t.Fatalf("resolve() = %q, %v; want did:example:alice, true", from, ok)
+	}
+
+	if _, ok := after.resolve(req.ID, "did:example:bob"); ok {
+		t.Error("request resolved twice")
+	}
+	if record, _ := store.Get(pendingKeyPrefix + req.IDHex()); record != nil {
+		t.Error("record still stored after resolve")
+	}
+}
+
+func TestPendingRequests_MemoryOnlyNotReloaded(t *testing.T) {
+	store := storage.NewMemoryStateStore()
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob", nil)
+
+	if err := newPendingRequests(time.Minute, nil).add(req); err != nil {
+		t.Fatalf("add() error: %v", err)
+	}
+	after := newPendingRequests(time.Minute, store)
+	if err := after.load(); err != nil {
+		t.Fatalf("load() error: %v", err)
+	}
+	if _, ok := after.resolve(req.ID, "did:example:bob"); ok {
+		t.Error("in-memory request survived a restart")
+	}
+}
+
+func TestPendingRequests_Expiry(t *testing.T) {
+	now := time.Now()
+	p := newPendingRequests(time.Second, nil)
+	p.now = func() time.Time { return now }
+
+	stale := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob", nil)
+	live := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob", nil)
+	p.add(stale)
+	now = now.Add(2 * time.Second)
+	p.add(live)
+
+	if _, ok := p.resolve(stale.ID, "did:example:bob"); ok {
+		t.Error("expired request was resolved")
+	}
+	p.sweep()
+	if len(p.entries) != 1 {
+		t.Errorf("entries after sweep = %d, want 1", len(p.entries))
+	}
+	if _, ok := p.resolve(live.ID, "did:example:bob"); !ok {
+		t.Error("live request was not resolved")
+	}
+}
+
+func TestPendingRequests_OnlyDestinationAnswers(t *testing.T) {
+	p := newPendingRequests(time.Minute, storage.NewMemoryStateStore())
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob", nil)
+	p.add(req)
+
+	if _, ok := p.touch(req.ID, "did:example:mallory"); ok {
+		t.Error("another DID touched the request")
+	}
+	if _, ok := p.resolve(req.ID, "did:example:mallory"); ok {
+		t.Error("another DID resolved the request")
+	}
+	if from, ok := p.resolve(req.ID, "did:example:bob"); !ok || from != "did:example:alice" {
+		t.Errorf("resolve() by the destination = %q, %v; want did:example:alice, true", from, ok)
+	}
+}
+
+// TestRelayServer_ReplyCannotBeHijacked checks a third party replying to a
+// request it was not sent to neither reaches the requester nor closes the
+// request
+func TestRelayServer_ReplyCannotBeHijacked(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.RequestTimeout = time.Minute
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	mallory := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, mallory, "did:example:mallory")
+
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "transfer"})
+	sendTestMessage(t, alice, req)
+	readTestMessage(t, bob)
+
+	// Mallory, who saw the request ID, answers it without a destination
+	forged := protocol.NewMessage(protocol.MessageTypeResponse, "did:example:mallory", "", "approved")
+	forged.ReplyTo = req.ID
+	sendTestMessage(t, mallory, forged)
+	if got := readTestMessage(t, mallory); errorCode(got) != "missing_destination" {
+		t.Errorf("forged reply got %s %q, want missing_destination", got.Type.Name(), errorCode(got))
+	}
+
+	// Bob's real answer still reaches Alice
+	resp := protocol.NewMessage(protocol.MessageTypeResponse, "did:example:bob", "", "declined")
+	resp.ReplyTo = req.ID
+	sendTestMessage(t, bob, resp)
+	if got := readTestMessage(t, alice); got.IDHex() != resp.IDHex() {
+		t.Fatalf("alice got %s %s, want bob's response %s", got.Type.Name(), got.IDHex(), resp.IDHex())
+	}
+}
+
+func TestRelayServer_LateResponseAfterRestart(t *testing.T) {
+	store := storage.NewMemoryStore()
+	state := storage.NewMemoryStateStore()
+	newConfig := func() *Config {
+		cfg := DefaultConfig()
+		cfg.RequireAuthHandshake = true
+		cfg.Storage = store
+		cfg.StateStore = state
+		cfg.RequestTimeout = time.Minute
+		cfg.PersistPendingRequests = true
+		return cfg
+	}
+
+	// Alice's request is stored for Bob, who is offline
+	first := startTestServer(t, newConfig())
+	alice := dialTestClient(t, first)
+	bindTestClientDID(t, first, alice, "did:example:alice")
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "lookup"})
+	sendTestMessage(t, alice, req)
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypePing, "did:example:alice", "", nil))
+	readTestMessage(t, alice)
+	alice.Close()
+	first.Stop()
+
+	// After the restart Bob answers without naming a destination
+	second := startTestServer(t, newConfig())
+	alice = dialTestClient(t, second)
+	bindTestClientDID(t, second, alice, "did:example:alice")
+	bob := dialTestClient(t, second)
+	bindTestClientDID(t, second, bob, "did:example:bob")
+
+	resp := protocol.NewMessage(protocol.MessageTypeResponse, "did:example:bob", "", "found")
+	resp.ReplyTo = req.ID
+	sendTestMessage(t, bob, resp)
+
+	got := readTestMessage(t, alice)
+	if got.Type != protocol.MessageTypeResponse || got.IDHex() != resp.IDHex() {
+		t.Fatalf("alice got type 0x%02x id %s, want response %s", got.Type, got.IDHex(), resp.IDHex())
+	}
+	if got.To != "did:example:alice" {
+		t.Errorf("response To = %q, want did:example:alice", got.To)
+	}
+}
+
+// TestRelayServer_InteractiveRequest drives a long-running request through
+// progress, a request for input, the requester's answer and the final reply,
+// none of which name their destination
+func TestRelayServer_InteractiveRequest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.RequestTimeout = time.Minute
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "book_flight"})
+	sendTestMessage(t, alice, req)
+	readTestMessage(t, bob)
+
+	// Bob's interim updates reach Alice and leave the request open
+	for _, typ := range []protocol.MessageType{protocol.MessageTypeProcessing, protocol.MessageTypeProgress} {
+		update := protocol.NewMessage(typ, "did:example:bob", "", map[string]interface{}{"percent": 50})
+		update.ReplyTo = req.ID
+		sendTestMessage(t, bob, update)
+		if got := readTestMessage(t, alice); got.Type != typ || got.To != "did:example:alice" {
+			t.Fatalf("alice got %s to %q, want %s to alice", got.Type.Name(), got.To, typ.Name())
+		}
+	}
+
+	// Bob asks for input; Alice's answer goes back to Bob
+	ask := protocol.NewMessage(protocol.MessageTypeInputRequired, "did:example:bob", "", "window or aisle?")
+	ask.ReplyTo = req.ID
+	sendTestMessage(t, bob, ask)
+	if got := readTestMessage(t, alice); got.Type != protocol.MessageTypeInputRequired || got.IDHex() != ask.IDHex() {
+		t.Fatalf("alice got %s %s, want input_required %s", got.Type.Name(), got.IDHex(), ask.IDHex())
+	}
+	answer := protocol.NewMessage(protocol.MessageTypeResponse, "did:example:alice", "", "aisle")
+	answer.ReplyTo = ask.ID
+	sendTestMessage(t, alice, answer)
+	if got := readTestMessage(t, bob); got.IDHex() != answer.IDHex() || got.To != "did:example:bob" {
+		t.Fatalf("bob got %s %s to %q, want alice's answer", got.Type.Name(), got.IDHex(), got.To)
+	}
+
+	// The final reply completes the original request
+	done := protocol.NewMessage(protocol.MessageTypeResponse, "did:example:bob", "", "booked")
+	done.ReplyTo = req.ID
+	sendTestMessage(t, bob, done)
+	if got := readTestMessage(t, alice); got.IDHex() != done.IDHex() || got.To != "did:example:alice" {
+		t.Fatalf("alice got %s %s to %q, want the final response", got.Type.Name(), got.IDHex(), got.To)
+	}
+
+	// Once complete, a further update has nowhere to go
+	late := protocol.NewMessage(protocol.MessageTypeProgress, "did:example:bob", "", nil)
+	late.ReplyTo = req.ID
+	sendTestMessage(t, bob, late)
+	if got := readTestMessage(t, bob); errorCode(got) != "missing_destination" {
+		t.Errorf("late update got %s %q, want missing_destination", got.Type.Name(), errorCode(got))
+	}
+}
+
+func TestPendingRequests_TouchExtendsDeadline(t *testing.T) {
+	now := time.Now()
+	p := newPendingRequests(time.Minute, nil)
+	p.now = func() time.Time { return now }
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob", nil)
+	p.add(req)
+
+	now = now.Add(50 * time.Second)
+	if from, ok := p.touch(req.ID, "did:example:bob"); !ok || from != "did:example:alice" {
+		t.Fatalf("touch() = %q, %v; want did:example:alice, true", from, ok)
+	}
+
+	// Past the original deadline but within the refreshed one
+	now = now.Add(50 * time.Second)
+	if _, ok := p.resolve(req.ID, "did:example:bob"); !ok {
+		t.Error("request lapsed despite interim update")
+	}
+}
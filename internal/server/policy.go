@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// errDestinationBlocked is returned when destination policy forbids forwarding
+var errDestinationBlocked = errors.New("destination blocked")
+
+// destinationAllowed applies the configured destination policy to a DID
+func (s *RelayServer) destinationAllowed(did string) bool {
+	for _, blocked := range s.config.BlockedDestinations {
+		if blocked == did {
+			return false
+		}
+	}
+
+	if len(s.config.AllowedDestinations) > 0 {
+		allowed := false
+		for _, a := range s.config.AllowedDestinations {
+			if a == did {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	if s.config.DestinationPolicy != nil {
+		return s.config.DestinationPolicy(did)
+	}
+	return true
+}
+
+// messageTypeAllowed reports whether clients may send messages of type t
+func (s *RelayServer) messageTypeAllowed(t protocol.MessageType) bool {
+	if len(s.config.AllowedMessageTypes) == 0 {
+		return true
+	}
+	for _, allowed := range s.config.AllowedMessageTypes {
+		if allowed == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// TestDestinationAllowed covers the default, allow-list, block-list and
+// custom policy behaviors, including block-list precedence.
+func TestDestinationAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		blocked []string
+		policy  func(string) bool
+		did     string
+		want    bool
+	}{
+		{"default allows all", nil, nil, nil, "did:example:any", true},
+		{"blocked destination", nil, []string{"did:example:bad"}, nil, "did:example:bad", false},
+		{"unlisted with block list", nil, []string{"did:example:bad"}, nil, "did:example:good", true},
+		{"allow-listed destination", []string{"did:example:good"}, nil, nil, "did:example:good", true},
+		{"not on allow list", []string{"did:example:good"}, nil, nil, "did:example:other", false},
+		{"block list wins over allow list", []string{"did:example:bad"}, []string{"did:example:bad"}, nil, "did:example:bad", false},
+		{"policy func rejects", nil, nil, func(string) bool { return false }, "did:example:any", false},
+		{"policy func cannot override block", nil, []string{"did:example:bad"}, func(string) bool { return true }, "did:example:bad", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.AllowedDestinations = tc.allowed
+			cfg.BlockedDestinations = tc.blocked
+			cfg.DestinationPolicy = tc.policy
+			srv := NewRelayServer(cfg)
+
+			if got := srv.destinationAllowed(tc.did); got != tc.want {
+				t.Errorf("destinationAllowed(%q) = %v, want %v", tc.did, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRelayServer_DestinationBlocked verifies a request or message to a
+// blocked DID is answered with destination_blocked and not kept in the store.
+func TestRelayServer_DestinationBlocked(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.BlockedDestinations = []string{"did:example:bad"}
+	srv := startTestServer(t, cfg)
+	client := dialTestClient(t, srv)
+
+	for _, typ := range []protocol.MessageType{protocol.MessageTypeRequest, protocol.MessageTypeMessage} {
+		msg := protocol.NewMessage(typ, "did:example:client", "did:example:bad", nil)
+		sendTestMessage(t, client, msg)
+
+		resp := readTestMessage(t, client)
+		if errorCode(resp) != "destination_blocked" {
+			t.Fatalf("%s: code = %q, want destination_blocked", typ.Name(), errorCode(resp))
+		}
+		if stored, _ := srv.store.Get(msg.IDHex()); stored != nil {
+			t.Errorf("%s to blocked destination should not remain in the store", typ.Name())
+		}
+	}
+}
+
+// TestRelayServer_AllowedMessageTypes checks that only listed types are
+// accepted once an allow-list is configured
+func TestRelayServer_AllowedMessageTypes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowedMessageTypes = []protocol.MessageType{protocol.MessageTypePing, protocol.MessageTypeRequest}
+	srv := startTestServer(t, cfg)
+	conn := dialTestClient(t, srv)
+
+	sendTestMessage(t, conn, protocol.NewMessage(protocol.MessageTypePing, "did:example:client", "", nil))
+	if reply := readTestMessage(t, conn); reply.Type != protocol.MessageTypePong {
+		t.Errorf("allowed ping got %s, want pong", reply.Type.Name())
+	}
+
+	sendTestMessage(t, conn, protocol.NewMessage(protocol.MessageTypeExtension, "did:example:client", "", nil))
+	reply := readTestMessage(t, conn)
+	if reply.Type != protocol.MessageTypeError || errorCode(reply) != "message_type_not_allowed" {
+		t.Errorf("extension message got %s %q, want message_type_not_allowed", reply.Type.Name(), errorCode(reply))
+	}
+
+	// An empty list allows every defined type
+	srv.config.AllowedMessageTypes = nil
+	if !srv.messageTypeAllowed(protocol.MessageTypeExtension) {
+		t.Error("empty allow-list rejected an extension message")
+	}
+}
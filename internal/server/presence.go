@@ -0,0 +1,168 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// Disconnect reasons carried in presence events
+const (
+	disconnectClosed       = "closed"       // the client's connection ended
+	disconnectIdle         = "idle"         // evicted for inactivity
+	disconnectUnresponsive = "unresponsive" // missed keepalive pings
+)
+
+// presenceTracker records which clients watch which DIDs' presence
+type presenceTracker struct {
+	mu       sync.Mutex
+	watchers map[string]map[string]struct{} // watched DID -> subscriber client IDs
+}
+
+// newPresenceTracker creates an empty tracker
+func newPresenceTracker() *presenceTracker {
+	return &presenceTracker{watchers: make(map[string]map[string]struct{})}
+}
+
+// subscribe adds clientID as a watcher of did
+func (p *presenceTracker) subscribe(did, clientID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	subs, ok := p.watchers[did]
+	if !ok {
+		subs = make(map[string]struct{})
+		p.watchers[did] = subs
+	}
+	subs[clientID] = struct{}{}
+}
+
+// unsubscribe removes clientID as a watcher of did
+func (p *presenceTracker) unsubscribe(did, clientID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if subs, ok := p.watchers[did]; ok {
+		delete(subs, clientID)
+		if len(subs) == 0 {
+			delete(p.watchers, did)
+		}
+	}
+}
+
+// drop removes every subscription held by a departed client
+func (p *presenceTracker) drop(clientID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for did, subs := range p.watchers {
+		delete(subs, clientID)
+		if len(subs) == 0 {
+			delete(p.watchers, did)
+		}
+	}
+}
+
+// subscriptionsOf returns the DIDs clientID watches
+func (p *presenceTracker) subscriptionsOf(clientID string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var dids []string
+	for did, subs := range p.watchers {
+		if _, ok := subs[clientID]; ok {
+			dids = append(dids, did)
+		}
+	}
+	return dids
+}
+
+// subscribers returns the clients watching did
+func (p *presenceTracker) subscribers(did string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.watchers[did]))
+	for id := range p.watchers[did] {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// handlePresenceSub subscribes or unsubscribes the sender to presence events
+// for the DID in msg.To, acknowledging with an ACK
+func (s *RelayServer) handlePresenceSub(clientID string, msg *protocol.Message) error {
+	if s.addressedToServer(msg.To) {
+		return s.sendErrorResponse(clientID, msg, "missing_destination", "Presence subscription requires a target DID")
+	}
+
+	if msg.Type == protocol.MessageTypePresenceSub {
+		s.presence.subscribe(msg.To, clientID)
+	} else {
+		s.presence.unsubscribe(msg.To, clientID)
+	}
+
+	ack := protocol.NewMessage(protocol.MessageTypeACK, s.serverIdentity(), msg.From, nil)
+	ack.ReplyTo = msg.ID
+	return s.forwardMessageToClient(clientID, ack)
+}
+
+// notifyOffline tells did's presence subscribers that it went offline and why
+func (s *RelayServer) notifyOffline(did, reason string) {
+	for _, subID := range s.presence.subscribers(did) {
+		event := protocol.NewMessage(protocol.MessageTypePresence, s.serverIdentity(), "", map[string]interface{}{
+			"did":    did,
+			"status": "offline",
+			"reason": reason,
+		})
+		if err := s.forwardMessageToClient(subID, event); err != nil {
+			s.logger.Warn("Failed to send presence event", "client", subID, "did", did, "error", err)
+		}
+	}
+}
+
+// removeClient forgets a client and announces its departure to presence
+// subscribers. It reports false if the client was already gone. The client
+// leaves the client table and every per-client registry under one hold of
+// clientsMu, so nobody sees it half removed.
+func (s *RelayServer) removeClient(clientID, reason string) bool {
+	s.clientsMu.Lock()
+	info, exists := s.clients[clientID]
+	if exists {
+		delete(s.clients, clientID)
+		s.streams.drop(clientID)
+		s.rateLimits.drop(clientID)
+		if s.sessions != nil {
+			s.sessions.suspend(clientID, info.DID, s.presence.subscriptionsOf(clientID))
+		}
+		s.presence.drop(clientID)
+	}
+	s.clientsMu.Unlock()
+
+	if !exists {
+		return false
+	}
+	if info.DID != "" {
+		s.notifyOffline(info.DID, reason)
+	}
+	return true
+}
+
+// handleConnect records a new client along with the DID its handshake
+// authenticated, before the client's first message is read
+func (s *RelayServer) handleConnect(clientID, did string) {
+	now := time.Now()
+	s.clientsMu.Lock()
+	s.clients[clientID] = &ClientInfo{
+		ID:           clientID,
+		DID:          did,
+		ConnectedAt:  now,
+		LastActivity: now,
+		Metadata:     make(map[string]string),
+	}
+	s.clientsMu.Unlock()
+}
+
+// handleDisconnect cleans up after a client whose connection ended. Pending
+// request correlations are keyed by DID, not connection, and are kept so a
+// reply still reaches the requester when it reconnects.
+func (s *RelayServer) handleDisconnect(clientID string) {
+	s.authHandler.DropClient(clientID)
+	s.removeClient(clientID, disconnectClosed)
+}
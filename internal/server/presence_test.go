@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/transport"
+	"github.com/gorilla/websocket"
+)
+
+// subscribePresence subscribes conn to did's presence and waits for the ACK
+func subscribePresence(t *testing.T, conn *websocket.Conn, from, did string) {
+	t.Helper()
+	sub := protocol.NewMessage(protocol.MessageTypePresenceSub, from, did, nil)
+	sendTestMessage(t, conn, sub)
+	if ack := readTestMessage(t, conn); ack.Type != protocol.MessageTypeACK {
+		t.Fatalf("subscription reply type = 0x%02x, want ACK", ack.Type)
+	}
+}
+
+// expectOffline reads a presence event and checks it reports did offline for reason
+func expectOffline(t *testing.T, conn *websocket.Conn, did, reason string) {
+	t.Helper()
+	event := readTestMessage(t, conn)
+	body, _ := event.Body.(map[interface{}]interface{})
+	if event.Type != protocol.MessageTypePresence || body["did"] != did ||
+		body["status"] != "offline" || body["reason"] != reason {
+		t.Errorf("presence event = type 0x%02x body %v, want %s offline (%s)", event.Type, event.Body, did, reason)
+	}
+}
+
+// clientIDForDID returns the server-side client ID bound to did
+func clientIDForDID(t *testing.T, srv *RelayServer, did string) string {
+	t.Helper()
+	srv.clientsMu.RLock()
+	defer srv.clientsMu.RUnlock()
+	for id, info := range srv.clients {
+		if info.DID == did {
+			return id
+		}
+	}
+	t.Fatalf("no client bound to %s", did)
+	return ""
+}
+
+func TestRelayServer_IdleEvictionClosesAndNotifies(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	watcher := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, watcher, "did:example:watcher")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	subscribePresence(t, watcher, "did:example:watcher", "did:example:bob")
+
+	bobID := clientIDForDID(t, srv, "did:example:bob")
+	srv.clientsMu.Lock()
+	srv.clients[bobID].LastActivity = time.Now().Add(-10 * time.Minute)
+	srv.clientsMu.Unlock()
+
+	srv.cleanupInactiveClients()
+
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := bob.ReadMessage()
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) || closeErr.Code != transport.CloseIdle || closeErr.Text != "idle timeout" {
+		t.Errorf("evicted client read error = %v, want close %d idle timeout", err, transport.CloseIdle)
+	}
+
+	expectOffline(t, watcher, "did:example:bob", disconnectIdle)
+	if srv.GetStats().ConnectedClients != 1 {
+		t.Errorf("ConnectedClients = %d, want 1", srv.GetStats().ConnectedClients)
+	}
+}
+
+func TestRelayServer_DisconnectNotifiesPresence(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	watcher := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, watcher, "did:example:watcher")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	subscribePresence(t, watcher, "did:example:watcher", "did:example:bob")
+
+	bob.Close()
+	expectOffline(t, watcher, "did:example:bob", disconnectClosed)
+
+	// Unsubscribed watchers hear nothing further
+	carol := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, carol, "did:example:carol")
+	subscribePresence(t, watcher, "did:example:watcher", "did:example:carol")
+	unsub := protocol.NewMessage(protocol.MessageTypePresenceUnsub, "did:example:watcher", "did:example:carol", nil)
+	sendTestMessage(t, watcher, unsub)
+	readTestMessage(t, watcher) // ACK
+	carol.Close()
+
+	watcher.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, data, err := watcher.ReadMessage(); err == nil {
+		t.Errorf("unsubscribed watcher got %x", data)
+	}
+}
+
+// TestRelayServer_DisconnectClearsClientState verifies a departed client is
+// gone from the client table and every per-client registry
+func TestRelayServer_DisconnectClearsClientState(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.SessionResumeWindow = time.Minute
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	aliceID := clientIDForDID(t, srv, "did:example:alice")
+
+	// Alice watches Bob, holds a session and has a stream open to him
+	subscribePresence(t, alice, "did:example:alice", "did:example:bob")
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeHello, "did:example:alice", "", nil))
+	readTestMessage(t, alice)
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeStreamStart, "did:example:alice", "did:example:bob", nil))
+	if got := readTestMessage(t, bob); got.Type != protocol.MessageTypeStreamStart {
+		t.Fatalf("bob got %s, want stream_start", got.Type.Name())
+	}
+	if srv.streams.count(aliceID) != 1 || len(srv.presence.subscriptionsOf(aliceID)) != 1 {
+		t.Fatal("alice's stream or subscription was not recorded")
+	}
+
+	alice.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		srv.clientsMu.RLock()
+		_, connected := srv.clients[aliceID]
+		srv.clientsMu.RUnlock()
+		if !connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("alice was never removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if subs := srv.presence.subscriptionsOf(aliceID); len(subs) != 0 {
+		t.Errorf("alice still watches %v", subs)
+	}
+	if subs := srv.presence.subscribers("did:example:bob"); len(subs) != 0 {
+		t.Errorf("bob still has watchers %v", subs)
+	}
+	if n := srv.streams.count(aliceID); n != 0 {
+		t.Errorf("alice still has %d open streams", n)
+	}
+	srv.rateLimits.mu.Lock()
+	_, limited := srv.rateLimits.windows[aliceID]
+	srv.rateLimits.mu.Unlock()
+	if limited {
+		t.Error("alice still has a rate limit window")
+	}
+	srv.sessions.mu.Lock()
+	_, bound := srv.sessions.byClient[aliceID]
+	srv.sessions.mu.Unlock()
+	if bound {
+		t.Error("alice's session is still bound to her connection")
+	}
+}
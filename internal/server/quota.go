@@ -0,0 +1,114 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/storage"
+)
+
+// quotaKeyPrefix starts the state store keys of quota counters
+const quotaKeyPrefix = "quota:"
+
+// quotaTracker enforces a per-DID message quota over fixed windows aligned to
+// multiples of the window length. Counters are kept in memory; flush saves
+// the current window's counters to the state store as a single record that
+// expires with the window, and they are read back the first time a window is
+// used, so with a persistent store a restart loses at most the counts since
+// the last flush.
+type quotaTracker struct {
+	state  storage.StateStore
+	limit  uint64
+	window time.Duration
+	now    func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time         // window the counters belong to (zero = none loaded)
+	counts      map[string]uint64 // by DID
+	dirty       bool              // counts changed since the last flush
+}
+
+// newQuotaTracker creates a tracker allowing limit messages per window (0 = unlimited)
+func newQuotaTracker(state storage.StateStore, limit int, window time.Duration) *quotaTracker {
+	return &quotaTracker{
+		state:  state,
+		limit:  uint64(limit),
+		window: window,
+		now:    time.Now,
+	}
+}
+
+// allow counts one message against did's quota and reports whether it is within the limit
+func (q *quotaTracker) allow(did string) (bool, error) {
+	if q.limit == 0 || q.window <= 0 {
+		return true, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	windowStart := q.now().Truncate(q.window)
+	if !q.windowStart.Equal(windowStart) {
+		counts, err := q.load(windowStart)
+		if err != nil {
+			return false, err
+		}
+		q.windowStart, q.counts, q.dirty = windowStart, counts, false
+	}
+
+	if q.counts[did] >= q.limit {
+		return false, nil
+	}
+	q.counts[did]++
+	q.dirty = true
+	return true, nil
+}
+
+// load reads the counters saved for the window starting at windowStart
+func (q *quotaTracker) load(windowStart time.Time) (map[string]uint64, error) {
+	counts := make(map[string]uint64)
+	value, err := q.state.Get(quotaKey(windowStart))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load quota counters: %w", err)
+	}
+	if value != nil {
+		if err := json.Unmarshal(value, &counts); err != nil {
+			return nil, fmt.Errorf("failed to decode quota counters: %w", err)
+		}
+	}
+	return counts, nil
+}
+
+// flush saves the current window's counters if they changed, keeping the
+// record until the window ends
+func (q *quotaTracker) flush() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.dirty {
+		return nil
+	}
+	ttl := q.windowStart.Add(q.window).Sub(q.now())
+	if ttl <= 0 {
+		// The window is over; its counters no longer matter
+		q.dirty = false
+		return nil
+	}
+	value, err := json.Marshal(q.counts)
+	if err != nil {
+		return fmt.Errorf("failed to encode quota counters: %w", err)
+	}
+	if err := q.state.Put(quotaKey(q.windowStart), value, ttl); err != nil {
+		return fmt.Errorf("failed to save quota counters: %w", err)
+	}
+	q.dirty = false
+	return nil
+}
+
+// quotaKey names the counters of the window starting at windowStart
+func quotaKey(windowStart time.Time) string {
+	return quotaKeyPrefix + strconv.FormatInt(windowStart.UnixMilli(), 10)
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+	"github.com/gorilla/websocket"
+)
+
+// TestQuotaTracker_ExhaustAndReset exhausts a small quota and verifies it
+// resets at the next window boundary.
+func TestQuotaTracker_ExhaustAndReset(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	q := newQuotaTracker(storage.NewMemoryStateStore(), 3, time.Hour)
+	q.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, err := q.allow("did:example:alice"); err != nil || !ok {
+			t.Fatalf("message %d: allow = (%v, %v), want (true, nil)", i, ok, err)
+		}
+	}
+	if ok, _ := q.allow("did:example:alice"); ok {
+		t.Error("message past quota should be rejected")
+	}
+
+	// Other DIDs have their own quota
+	if ok, _ := q.allow("did:example:bob"); !ok {
+		t.Error("bob should not be affected by alice's quota")
+	}
+
+	// Next window starts fresh
+	now = now.Add(time.Hour)
+	if ok, _ := q.allow("did:example:alice"); !ok {
+		t.Error("quota should reset at the window boundary")
+	}
+}
+
+// TestQuotaTracker_SurvivesRestart verifies counting doesn't write to the
+// state store, and counters flushed to it are picked up by a new tracker.
+func TestQuotaTracker_SurvivesRestart(t *testing.T) {
+	store := storage.NewMemoryStateStore()
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	before := newQuotaTracker(store, 2, time.Hour)
+	before.now = func() time.Time { return now }
+	before.allow("did:example:alice")
+	before.allow("did:example:alice")
+	if saved, _ := store.List(quotaKeyPrefix); len(saved) != 0 {
+		t.Fatalf("counters saved before a flush: %v", saved)
+	}
+	if err := before.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	after := newQuotaTracker(store, 2, time.Hour)
+	after.now = func() time.Time { return now.Add(time.Minute) }
+	if ok, _ := after.allow("did:example:alice"); ok {
+		t.Error("restarted tracker should see the exhausted quota")
+	}
+}
+
+// TestQuotaTracker_Unlimited verifies a zero limit disables quotas.
+func TestQuotaTracker_Unlimited(t *testing.T) {
+	q := newQuotaTracker(storage.NewMemoryStateStore(), 0, time.Hour)
+	for i := 0; i < 100; i++ {
+		if ok, _ := q.allow("did:example:alice"); !ok {
+			t.Fatalf("message %d rejected with quotas disabled", i)
+		}
+	}
+}
+
+// TestRelayServer_QuotaExceeded verifies the server answers requests past
+// the sender's quota with quota_exceeded.
+func TestRelayServer_QuotaExceeded(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.QuotaLimit = 1
+	cfg.QuotaWindow = time.Hour
+	srv := startTestServer(t, cfg)
+	srv.RegisterRoute("ping", func(msg RelayMessage) (RelayMessage, error) {
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), "pong")), nil
+	})
+	client := dialTestClient(t, srv)
+
+	sendTestMessage(t, client, newActionRequest("ping"))
+	if resp := readTestMessage(t, client); resp.Type != protocol.MessageTypeResponse {
+		t.Fatalf("first request: type 0x%02x code %q, want response", resp.Type, errorCode(resp))
+	}
+
+	sendTestMessage(t, client, newActionRequest("ping"))
+	if resp := readTestMessage(t, client); errorCode(resp) != "quota_exceeded" {
+		t.Errorf("second request: code %q, want quota_exceeded", errorCode(resp))
+	}
+}
+
+// TestRelayServer_QuotaKeyedOnAuthenticatedDID verifies a quota is charged
+// to the DID a client authenticated as, shared by all its connections, and
+// that an unauthenticated client naming another DID in From spends only its
+// own quota.
+func TestRelayServer_QuotaKeyedOnAuthenticatedDID(t *testing.T) {
+	ping := func(msg RelayMessage) (RelayMessage, error) {
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), "pong")), nil
+	}
+	request := func(t *testing.T, conn *websocket.Conn, from string) string {
+		t.Helper()
+		req := newActionRequest("ping")
+		req.From = from
+		sendTestMessage(t, conn, req)
+		return errorCode(readTestMessage(t, conn))
+	}
+
+	t.Run("authenticated", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.RequireAuthHandshake = true
+		cfg.QuotaLimit = 1
+		cfg.QuotaWindow = time.Hour
+		srv := startTestServer(t, cfg)
+		srv.RegisterRoute("ping", ping)
+		first := dialTestClient(t, srv)
+		bindTestClientDID(t, srv, first, "did:example:alice")
+		second := dialTestClient(t, srv)
+		bindTestClientDID(t, srv, second, "did:example:alice")
+		bob := dialTestClient(t, srv)
+		bindTestClientDID(t, srv, bob, "did:example:bob")
+
+		if code := request(t, first, ""); code != "" {
+			t.Fatalf("alice's first request: code %q, want a response", code)
+		}
+		if code := request(t, second, ""); code != "quota_exceeded" {
+			t.Errorf("alice's second connection: code %q, want quota_exceeded", code)
+		}
+		if code := request(t, bob, ""); code != "" {
+			t.Errorf("bob: code %q, want a response", code)
+		}
+	})
+
+	t.Run("spoofed sender", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.QuotaLimit = 1
+		cfg.QuotaWindow = time.Hour
+		srv := startTestServer(t, cfg)
+		srv.RegisterRoute("ping", ping)
+		mallory := dialTestClient(t, srv)
+		victim := dialTestClient(t, srv)
+
+		request(t, mallory, "did:example:victim")
+		if code := request(t, mallory, "did:example:victim"); code != "quota_exceeded" {
+			t.Errorf("mallory's second request: code %q, want quota_exceeded", code)
+		}
+		if code := request(t, victim, "did:example:victim"); code != "" {
+			t.Errorf("victim: code %q, want a response", code)
+		}
+	})
+}
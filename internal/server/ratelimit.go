@@ -0,0 +1,111 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// rateLimitWindow is the window RateLimitPerMinute and RateLimitByOrigin count over
+const rateLimitWindow = time.Minute
+
+// rateLimiter caps how many messages each client may send per minute. The
+// limit for a client comes from byOrigin: an entry for its authenticated DID
+// sets it outright, while an entry for its connection's Origin, which the
+// client chooses, can only lower the default.
+type rateLimiter struct {
+	defaultLimit int
+	byOrigin     map[string]int
+	now          func() time.Time
+
+	mu      sync.Mutex
+	windows map[string]*rateWindow
+}
+
+// rateWindow counts one client's messages in the current window
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// newRateLimiter creates a limiter allowing defaultLimit messages per minute
+// (0 = unlimited) unless byOrigin has an entry for the client
+func newRateLimiter(defaultLimit int, byOrigin map[string]int) *rateLimiter {
+	return &rateLimiter{
+		defaultLimit: defaultLimit,
+		byOrigin:     byOrigin,
+		now:          time.Now,
+		windows:      make(map[string]*rateWindow),
+	}
+}
+
+// limitFor returns the per-minute limit for a client with the given DID and Origin
+func (l *rateLimiter) limitFor(did, origin string) int {
+	if did != "" {
+		if limit, ok := l.byOrigin[did]; ok {
+			return limit
+		}
+	}
+	if origin != "" {
+		if limit, ok := l.byOrigin[origin]; ok && limit > 0 && (l.defaultLimit <= 0 || limit < l.defaultLimit) {
+			return limit
+		}
+	}
+	return l.defaultLimit
+}
+
+// rateLimitExempt reports whether a message type is exempt from rate
+// limiting: keepalive replies and the frames of a stream already open, so a
+// busy client is neither dropped as unresponsive nor cut off mid-stream
+func rateLimitExempt(typ protocol.MessageType) bool {
+	switch typ {
+	case protocol.MessageTypePong, protocol.MessageTypeStreamData, protocol.MessageTypeStreamEnd:
+		return true
+	}
+	return false
+}
+
+// allow counts one message under key, usually the client ID, and reports
+// whether it is within the limit
+func (l *rateLimiter) allow(key, did, origin string) bool {
+	limit := l.limitFor(did, origin)
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= rateLimitWindow {
+		w = &rateWindow{start: now}
+		l.windows[key] = w
+	}
+	if w.count >= limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// sweep drops counters whose window has passed, such as those of HTTP
+// submitters, which never disconnect
+func (l *rateLimiter) sweep() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= rateLimitWindow {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// drop forgets a disconnected client's counter
+func (l *rateLimiter) drop(clientID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.windows, clientID)
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// dialWithOrigin connects a client that presents origin in its upgrade
+// request; it registers once bindTestClientDID has authenticated it
+func dialWithOrigin(t *testing.T, srv *RelayServer, origin string) *websocket.Conn {
+	t.Helper()
+	header := http.Header{}
+	if origin != "" {
+		header.Set("Origin", origin)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+srv.config.ListenAddr+"/amp/v1/ws", header)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// pingsUntilLimited sends pings, as the connection's own DID, until one is
+// rejected and returns how many got a pong
+func pingsUntilLimited(t *testing.T, conn *websocket.Conn, max int) int {
+	t.Helper()
+	for i := 0; i < max; i++ {
+		sendTestMessage(t, conn, protocol.NewMessage(protocol.MessageTypePing, "", "", nil))
+		reply := readTestMessage(t, conn)
+		if reply.Type == protocol.MessageTypeError {
+			if code := errorCode(reply); code != "rate_limited" {
+				t.Fatalf("error code = %q, want rate_limited", code)
+			}
+			return i
+		}
+		if reply.Type != protocol.MessageTypePong {
+			t.Fatalf("reply type = %s, want pong", reply.Type.Name())
+		}
+	}
+	return max
+}
+
+func TestRelayServer_RateLimitByOrigin(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.RateLimitPerMinute = 3
+	cfg.RateLimitByOrigin = map[string]int{
+		"did:example:vip":             5,
+		"https://trusted.example.com": 10,
+		"https://strict.example.com":  1,
+	}
+	srv := startTestServer(t, cfg)
+
+	vip := dialWithOrigin(t, srv, "")
+	bindTestClientDID(t, srv, vip, "did:example:vip")
+	if got := pingsUntilLimited(t, vip, 10); got != 5 {
+		t.Errorf("privileged DID sent %d messages before being limited, want 5", got)
+	}
+
+	// A client can send any Origin, so one can't raise its limit
+	for origin, want := range map[string]int{
+		"https://trusted.example.com": 3,
+		"https://strict.example.com":  1,
+		"https://other.example.com":   3,
+	} {
+		conn := dialWithOrigin(t, srv, origin)
+		bindTestClientDID(t, srv, conn, "did:example:"+strings.TrimPrefix(origin, "https://"))
+		if got := pingsUntilLimited(t, conn, 10); got != want {
+			t.Errorf("origin %s sent %d messages before being limited, want %d", origin, got, want)
+		}
+	}
+}
+
+func TestRelayServer_RateLimitExemptsKeepaliveAndStreams(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.RateLimitPerMinute = 2
+	srv := startTestServer(t, cfg)
+	receiver := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, receiver, "did:example:b")
+	sender := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, sender, "did:example:a")
+
+	start := protocol.NewMessage(protocol.MessageTypeStreamStart, "did:example:a", "did:example:b", nil)
+	sendTestMessage(t, sender, start)
+	readTestMessage(t, receiver)
+
+	// Pongs and the stream's frames don't use up the allowance
+	for i := 0; i < 5; i++ {
+		sendTestMessage(t, sender, protocol.NewMessage(protocol.MessageTypePong, "did:example:a", "", nil))
+		data := protocol.NewMessage(protocol.MessageTypeStreamData, "did:example:a", "did:example:b", nil)
+		data.Body = map[string]interface{}{"stream_id": start.IDHex()}
+		sendTestMessage(t, sender, data)
+		if got := readTestMessage(t, receiver); got.IDHex() != data.IDHex() {
+			t.Fatalf("frame %d: receiver got %s %q, want the stream data", i, got.Type.Name(), errorCode(got))
+		}
+	}
+	if got := pingsUntilLimited(t, sender, 10); got != 1 {
+		t.Errorf("sent %d pings after the stream start, want 1 left of the limit", got)
+	}
+}
+
+func TestRateLimiter_DIDOverridesOriginAndWindowResets(t *testing.T) {
+	l := newRateLimiter(2, map[string]int{
+		"did:example:vip":            3,
+		"https://app.example.com":    5,
+		"https://strict.example.com": 1,
+	})
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+
+	if got := l.limitFor("did:example:vip", "https://strict.example.com"); got != 3 {
+		t.Errorf("limitFor(vip DID) = %d, want 3", got)
+	}
+	if got := l.limitFor("did:example:nobody", "https://strict.example.com"); got != 1 {
+		t.Errorf("limitFor(lowering origin) = %d, want 1", got)
+	}
+	if got := l.limitFor("did:example:nobody", "https://app.example.com"); got != 2 {
+		t.Errorf("limitFor(raising origin) = %d, want the default 2", got)
+	}
+	if got := l.limitFor("", ""); got != 2 {
+		t.Errorf("limitFor(unlisted) = %d, want 2", got)
+	}
+
+	if !l.allow("c1", "", "") || !l.allow("c1", "", "") || l.allow("c1", "", "") {
+		t.Fatal("default limit of 2 not enforced")
+	}
+	now = now.Add(rateLimitWindow)
+	if !l.allow("c1", "", "") {
+		t.Error("counter did not reset after the window")
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	pkgprotocol "github.com/agentries/amp-relay-go/pkg/protocol"
+)
+
+// RelayMessage is the view of a message that route handlers work with,
+// so handlers are not tied to one concrete message type. IDs are hex
+// strings; fields a backing type lacks read as empty.
+type RelayMessage interface {
+	ID() string
+	Type() string
+	From() string
+	To() string
+	Body() interface{}
+	ReplyTo() string
+	ThreadID() string
+	Ext() map[string]interface{}
+	Headers() map[string]string
+}
+
+// WrapMessage adapts an internal CBOR message to RelayMessage
+func WrapMessage(msg *protocol.Message) RelayMessage {
+	return &internalMessage{msg: msg}
+}
+
+// WrapPkgMessage adapts a pkg/protocol JSON message to RelayMessage
+func WrapPkgMessage(msg *pkgprotocol.Message) RelayMessage {
+	return &pkgMessage{msg: msg}
+}
+
+// internalMessage adapts *protocol.Message
+type internalMessage struct {
+	msg *protocol.Message
+}
+
+func (m *internalMessage) ID() string        { return m.msg.IDHex() }
+func (m *internalMessage) Type() string      { return m.msg.Type.Name() }
+func (m *internalMessage) From() string      { return m.msg.From }
+func (m *internalMessage) To() string        { return m.msg.To }
+func (m *internalMessage) Body() interface{} { return m.msg.Body }
+func (m *internalMessage) ReplyTo() string   { return hex.EncodeToString(m.msg.ReplyTo) }
+func (m *internalMessage) ThreadID() string  { return hex.EncodeToString(m.msg.ThreadID) }
+
+func (m *internalMessage) Ext() map[string]interface{} { return m.msg.Ext }
+func (m *internalMessage) Headers() map[string]string  { return m.msg.Headers }
+
+// pkgMessage adapts *pkgprotocol.Message
+type pkgMessage struct {
+	msg *pkgprotocol.Message
+}
+
+func (m *pkgMessage) ID() string   { return m.msg.ID }
+func (m *pkgMessage) Type() string { return string(m.msg.Type) }
+func (m *pkgMessage) From() string { return m.msg.From }
+func (m *pkgMessage) To() string   { return m.msg.To }
+
+// pkg/protocol messages carry no reply, thread or extension fields
+func (m *pkgMessage) ReplyTo() string             { return "" }
+func (m *pkgMessage) ThreadID() string            { return "" }
+func (m *pkgMessage) Ext() map[string]interface{} { return nil }
+func (m *pkgMessage) Headers() map[string]string  { return m.msg.Headers }
+
+// Body decodes the JSON payload, falling back to the raw bytes if it is not valid JSON
+func (m *pkgMessage) Body() interface{} {
+	if len(m.msg.Payload) == 0 {
+		return nil
+	}
+	var body interface{}
+	if err := json.Unmarshal(m.msg.Payload, &body); err != nil {
+		return []byte(m.msg.Payload)
+	}
+	return body
+}
+
+// toProtocolMessage converts a handler result into an internal message for
+// the wire, unwrapping internal messages and rebuilding any other kind
+func toProtocolMessage(rm RelayMessage) *protocol.Message {
+	if im, ok := rm.(*internalMessage); ok {
+		return im.msg
+	}
+	msg := protocol.NewMessage(protocol.MessageTypeResponse, rm.From(), rm.To(), rm.Body())
+	if pm, ok := rm.(*pkgMessage); ok && len(pm.msg.Headers) > 0 {
+		msg.Headers = make(map[string]string, len(pm.msg.Headers))
+		for k, v := range pm.msg.Headers {
+			msg.Headers[k] = v
+		}
+	}
+	return msg
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	pkgprotocol "github.com/agentries/amp-relay-go/pkg/protocol"
+)
+
+// echoRelayHandler replies to the sender with the request body, touching the
+// message only through the RelayMessage interface
+func echoRelayHandler(msg RelayMessage) (RelayMessage, error) {
+	return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), msg.Body())), nil
+}
+
+func TestRelayMessage_HandlerAcceptsBothMessageTypes(t *testing.T) {
+	internal := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "relay-server",
+		map[string]interface{}{"action": "echo", "n": float64(1)})
+
+	payload, _ := json.Marshal(map[string]interface{}{"action": "echo", "n": 1})
+	external := &pkgprotocol.Message{
+		ID:      "msg-1",
+		Type:    pkgprotocol.MessageTypeData,
+		From:    "did:example:alice",
+		To:      "relay-server",
+		Payload: payload,
+	}
+
+	tests := []struct {
+		name     string
+		msg      RelayMessage
+		wantID   string
+		wantType string
+	}{
+		{"internal", WrapMessage(internal), internal.IDHex(), "request"},
+		{"pkg", WrapPkgMessage(external), "msg-1", "data"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.msg.ID() != tt.wantID {
+				t.Errorf("ID() = %q, want %q", tt.msg.ID(), tt.wantID)
+			}
+			if tt.msg.Type() != tt.wantType {
+				t.Errorf("Type() = %q, want %q", tt.msg.Type(), tt.wantType)
+			}
+			if tt.msg.To() != "relay-server" {
+				t.Errorf("To() = %q, want relay-server", tt.msg.To())
+			}
+
+			resp, err := echoRelayHandler(tt.msg)
+			if err != nil {
+				t.Fatalf("handler error: %v", err)
+			}
+			out := toProtocolMessage(resp)
+			if out.To != "did:example:alice" {
+				t.Errorf("response To = %q, want did:example:alice", out.To)
+			}
+			want := map[string]interface{}{"action": "echo", "n": float64(1)}
+			if !reflect.DeepEqual(out.Body, want) {
+				t.Errorf("response Body = %#v, want %#v", out.Body, want)
+			}
+		})
+	}
+}
+
+func TestRelayMessage_ReplyThreadAndExt(t *testing.T) {
+	msg := protocol.NewMessage(protocol.MessageTypeResponse, "did:example:alice", "did:example:bob", nil)
+	msg.ReplyTo = []byte{0x01, 0x02}
+	msg.ThreadID = []byte{0x0a}
+	msg.Ext = map[string]interface{}{"trace": "t-1"}
+	msg.Headers = map[string]string{"x-amp-signer": "did:example:alice"}
+
+	rm := WrapMessage(msg)
+	if rm.ReplyTo() != "0102" || rm.ThreadID() != "0a" {
+		t.Errorf("ReplyTo() = %q, ThreadID() = %q, want 0102 and 0a", rm.ReplyTo(), rm.ThreadID())
+	}
+	if rm.Ext()["trace"] != "t-1" || rm.Headers()["x-amp-signer"] != "did:example:alice" {
+		t.Errorf("Ext() = %v, Headers() = %v", rm.Ext(), rm.Headers())
+	}
+
+	// A pkg/protocol message has no such fields but keeps its headers
+	pm := WrapPkgMessage(&pkgprotocol.Message{ID: "1", Headers: map[string]string{"k": "v"}})
+	if pm.ReplyTo() != "" || pm.ThreadID() != "" || pm.Ext() != nil || pm.Headers()["k"] != "v" {
+		t.Errorf("pkg message: ReplyTo %q, ThreadID %q, Ext %v, Headers %v", pm.ReplyTo(), pm.ThreadID(), pm.Ext(), pm.Headers())
+	}
+}
+
+func TestToProtocolMessage_RebuildsForeignMessages(t *testing.T) {
+	internal := protocol.NewMessage(protocol.MessageTypeResponse, "a", "b", "x")
+	if got := toProtocolMessage(WrapMessage(internal)); got != internal {
+		t.Error("internal message was not unwrapped in place")
+	}
+
+	payload, _ := json.Marshal("pong")
+	got := toProtocolMessage(WrapPkgMessage(&pkgprotocol.Message{ID: "1", From: "a", To: "b", Payload: payload,
+		Headers: map[string]string{"x-amp-signer": "a"}}))
+	if got.Type != protocol.MessageTypeResponse || got.From != "a" || got.To != "b" || got.Body != "pong" {
+		t.Errorf("rebuilt message = %+v", got)
+	}
+	if got.Headers["x-amp-signer"] != "a" {
+		t.Errorf("rebuilt message Headers = %v, want the signer header carried over", got.Headers)
+	}
+	if len(got.ID) != 16 {
+		t.Errorf("rebuilt message ID length = %d, want 16", len(got.ID))
+	}
+}
+
+func TestPkgMessage_BodyFallsBackToRawPayload(t *testing.T) {
+	msg := WrapPkgMessage(&pkgprotocol.Message{Payload: json.RawMessage("not json")})
+	if b, ok := msg.Body().([]byte); !ok || string(b) != "not json" {
+		t.Errorf("Body() = %#v, want raw payload bytes", msg.Body())
+	}
+	if body := WrapPkgMessage(&pkgprotocol.Message{}).Body(); body != nil {
+		t.Errorf("empty payload Body() = %#v, want nil", body)
+	}
+}
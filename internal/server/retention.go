@@ -0,0 +1,35 @@
+package server
+
+import (
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// purgeAgedMessages deletes stored messages whose timestamp is more than
+// MaxMessageAge old. Saves already cap the TTL, so this is a safety net for
+// stores that don't expire messages themselves or that hold messages saved
+// under an earlier configuration.
+func (s *RelayServer) purgeAgedMessages() {
+	maxAge := s.config.MaxMessageAge
+	if maxAge <= 0 {
+		return
+	}
+
+	cutoff := uint64(time.Now().Add(-maxAge).UnixMilli())
+	aged, err := s.store.ListFiltered(func(msg *protocol.Message) bool {
+		return msg.Ts < cutoff
+	})
+	if err != nil {
+		s.logger.Error("Failed to list messages for age purge", "error", err)
+		return
+	}
+	for _, msg := range aged {
+		if err := s.store.Delete(msg.IDHex()); err != nil {
+			s.logger.Error("Failed to purge aged message", "id", msg.IDHex(), "error", err)
+		}
+	}
+	if len(aged) > 0 {
+		s.logger.Debug("Purged aged messages", "count", len(aged), "max_age", maxAge)
+	}
+}
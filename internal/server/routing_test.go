@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/transport"
+	"github.com/gorilla/websocket"
+)
+
+// bindTestClientDID completes the RFC-002 handshake on conn as did and waits
+// for the server to bind the connection to it, so forwarded messages
+// addressed to did reach conn. The server must have RequireAuthHandshake set
+// and no SignatureVerifier, so the auth frame needs no real signature.
+func bindTestClientDID(t *testing.T, srv *RelayServer, conn *websocket.Conn, did string) {
+	t.Helper()
+	if !srv.config.RequireAuthHandshake {
+		t.Fatal("bindTestClientDID needs a server with RequireAuthHandshake")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var challenge transport.ChallengeFrame
+	if err := conn.ReadJSON(&challenge); err != nil || challenge.Type != "challenge" {
+		t.Fatalf("%s: challenge = %+v (err %v)", did, challenge, err)
+	}
+	if err := conn.WriteJSON(transport.AuthFrame{
+		Type:      "auth",
+		DID:       did,
+		Signature: "unchecked",
+		Timestamp: time.Now().Unix(),
+		Nonce:     challenge.Nonce,
+	}); err != nil {
+		t.Fatalf("%s: write auth frame: %v", did, err)
+	}
+	var resp transport.AuthResponse
+	if err := conn.ReadJSON(&resp); err != nil || resp.Type != "auth_ok" {
+		t.Fatalf("%s: auth response = %+v (err %v)", did, resp, err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := srv.clientForDID(did); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("client for %s was never registered", did)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestMessageTypeEventAlias documents that the deprecated event name is the
+// same wire code as MessageTypeMessage rather than a separate type.
+func TestMessageTypeEventAlias(t *testing.T) {
+	if protocol.MessageTypeEvent != protocol.MessageTypeMessage {
+		t.Errorf("MessageTypeEvent = 0x%02x, want MessageTypeMessage (0x%02x)",
+			protocol.MessageTypeEvent, protocol.MessageTypeMessage)
+	}
+}
+
+// TestRelayServer_RoutesByType sends each incoming message type through a
+// running server and checks it is relayed, answered or rejected explicitly.
+func TestRelayServer_RoutesByType(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+
+	receiver := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, receiver, "did:example:b")
+
+	sender := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, sender, "did:example:a")
+
+	relayed := []protocol.MessageType{
+		protocol.MessageTypeResponse,
+		protocol.MessageTypeStreamStart,
+		protocol.MessageTypeStreamData,
+		protocol.MessageTypeStreamEnd,
+		protocol.MessageTypeACK,
+		protocol.MessageTypeProcOK,
+		protocol.MessageTypeProcFail,
+		protocol.MessageTypeProcessing,
+		protocol.MessageTypeProgress,
+		protocol.MessageTypeInputRequired,
+		protocol.MessageTypeError,
+	}
+	for _, typ := range relayed {
+		msg := protocol.NewMessage(typ, "did:example:a", "did:example:b", nil)
+		sendTestMessage(t, sender, msg)
+
+		got := readTestMessage(t, receiver)
+		if got.Type != typ || !bytes.Equal(got.ID, msg.ID) {
+			t.Errorf("type 0x%02x: receiver got type 0x%02x, want the relayed message", typ, got.Type)
+		}
+	}
+
+	t.Run("message is broadcast", func(t *testing.T) {
+		msg := protocol.NewMessage(protocol.MessageTypeMessage, "did:example:a", "", nil)
+		sendTestMessage(t, sender, msg)
+		if got := readTestMessage(t, receiver); got.Type != protocol.MessageTypeMessage {
+			t.Errorf("receiver got type 0x%02x, want 0x%02x", got.Type, protocol.MessageTypeMessage)
+		}
+	})
+
+	t.Run("addressed message reaches only its destination", func(t *testing.T) {
+		bystander := dialTestClient(t, srv)
+		bindTestClientDID(t, srv, bystander, "did:example:c")
+
+		msg := protocol.NewMessage(protocol.MessageTypeMessage, "did:example:a", "did:example:b", "private")
+		sendTestMessage(t, sender, msg)
+		if got := readTestMessage(t, receiver); !bytes.Equal(got.ID, msg.ID) {
+			t.Errorf("receiver got message %x, want %x", got.ID, msg.ID)
+		}
+		bystander.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, data, err := bystander.ReadMessage(); err == nil {
+			t.Errorf("bystander received %d bytes addressed to someone else", len(data))
+		}
+		bystander.Close()
+	})
+
+	t.Run("ping is answered with pong", func(t *testing.T) {
+		ping := protocol.NewMessage(protocol.MessageTypePing, "did:example:a", "relay-server", nil)
+		sendTestMessage(t, sender, ping)
+		got := readTestMessage(t, sender)
+		if got.Type != protocol.MessageTypePong || !bytes.Equal(got.ReplyTo, ping.ID) {
+			t.Errorf("got type 0x%02x replying to %x, want pong to %x", got.Type, got.ReplyTo, ping.ID)
+		}
+	})
+
+	t.Run("relayed type without destination", func(t *testing.T) {
+		sendTestMessage(t, sender, protocol.NewMessage(protocol.MessageTypeStreamData, "did:example:a", "", nil))
+		if code := errorCode(readTestMessage(t, sender)); code != "missing_destination" {
+			t.Errorf("code = %q, want missing_destination", code)
+		}
+	})
+
+	t.Run("unsupported type is rejected", func(t *testing.T) {
+		for _, typ := range []protocol.MessageType{protocol.MessageTypeHello, protocol.MessageTypeCapQuery, 0xEE} {
+			sendTestMessage(t, sender, protocol.NewMessage(typ, "did:example:a", "did:example:b", nil))
+			if code := errorCode(readTestMessage(t, sender)); code != "unsupported_type" {
+				t.Errorf("type 0x%02x: code = %q, want unsupported_type", typ, code)
+			}
+		}
+	})
+}
@@ -3,11 +3,18 @@ package server
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/agentries/amp-relay-go/internal/auth"
 	"github.com/agentries/amp-relay-go/internal/protocol"
 	"github.com/agentries/amp-relay-go/internal/storage"
 	"github.com/agentries/amp-relay-go/internal/transport"
@@ -16,53 +23,260 @@ import (
 // Config holds server configuration
 type Config struct {
 	// Network configuration
-	ListenAddr string
+	ListenAddr     string
+	AllowedOrigins []string // nil allows all origins (development mode)
+
+	// DisableWebSocket serves HTTP endpoints only, without /amp/v1/ws
+	DisableWebSocket bool
+
+	// Logger receives per-message processing logs, each tagged with the
+	// message's correlation ID (nil = slog.Default())
+	Logger *slog.Logger
+
+	// Log sampling for high-frequency events below error level: keep 1 in
+	// every LogSampleEvery occurrences of each event (0 or 1 = all) and at
+	// most LogMaxPerSecond of each per second (0 = unlimited)
+	LogSampleEvery  int
+	LogMaxPerSecond int
+
+	// EnableHTTPMessages serves /amp/v1/messages for clients that submit
+	// messages over plain HTTP instead of holding a WebSocket
+	EnableHTTPMessages bool
+
+	// AdminToken enables the admin endpoints under /amp/v1/admin, which
+	// require it as a bearer token (empty = admin endpoints disabled)
+	AdminToken string
+
+	// Authentication
+	Authenticator auth.Authenticator
+
+	// TokenClockSkew is the leeway allowed for clock differences with the
+	// token issuer when checking bearer token expiry (0 = none)
+	TokenClockSkew time.Duration
+
+	// Server identity for RFC-002 mutual authentication: when ServerKey is
+	// set, auth_ok responses carry its signature over the client's nonce,
+	// verifiable against the key published in ServerDID's document
+	ServerDID string
+	ServerKey ed25519.PrivateKey
+
+	// RequireAuthHandshake runs the RFC-002 handshake on each WebSocket
+	// connection before it may send messages. The DID it authenticates is
+	// bound to the connection, and messages claiming another From are
+	// rejected with forbidden.
+	RequireAuthHandshake bool
+
+	// SignatureVerifier checks the signature in an auth frame over the
+	// connection's challenge nonce (nil = any DID is accepted). Setting it
+	// also requires auth frames to answer a challenge issued on their own
+	// connection, so a signed nonce cannot be replayed.
+	SignatureVerifier transport.SignatureVerifier
+
+	// CredentialIssuerKey, if set, resolves an issuer DID to its Ed25519
+	// public key so CredPresent messages are verified before delivery;
+	// presentations that fail get invalid_credential (nil = relay unchecked)
+	CredentialIssuerKey func(did string) (ed25519.PublicKey, error)
+
+	// ServerName is the reserved destination that addresses the relay itself
+	// (default "relay-server"). Requests sent to it, to ServerDID or with no
+	// destination are handled by registered routes instead of being forwarded.
+	ServerName string
+
+	// AuthFrameType is the WebSocket opcode for JSON auth frames
+	// (websocket.TextMessage or websocket.BinaryMessage; 0 = text)
+	AuthFrameType int
+
+	// UnauthenticatedPolicy is applied to messages sent before auth: close
+	// the connection (default) or allow a retry within the auth timeout
+	UnauthenticatedPolicy transport.UnauthenticatedPolicy
 
 	// Storage configuration
 	Storage storage.MessageStore
 
+	// StateStore keeps the relay's control state: quota counters, contact
+	// states, delegation grants, persisted pending requests and dead
+	// letters. It is separate from Storage, so that state is never listed,
+	// evicted or cleared with messages (nil = in memory, lost on restart).
+	StateStore storage.StateStore
+
 	// Message handling
 	DefaultTTL     time.Duration
-	MaxPayloadSize int64
-
-	// Rate limiting
+	TTLByType      map[protocol.MessageType]time.Duration // overrides DefaultTTL per type
+	MinTTL         time.Duration                          // lower bound on the storage TTL (0 = none)
+	MaxTTL         time.Duration                          // upper bound on the storage TTL (0 = none)
+	MaxMessageAge  time.Duration                          // hard cap on how long any message is kept (0 = none)
+	MaxPayloadSize int64                                  // whole-message limit, for WebSocket and HTTP alike
+	MaxFrameSize   int64                                  // per-WebSocket-frame limit (0 = MaxPayloadSize only)
+	MaxBodyDepth   int                                    // maximum map/array nesting in a message body (0 = unlimited)
+
+	// MaxResponseSize caps the encoded size of a route handler's response;
+	// a larger one is replaced by a response_too_large error (0 = MaxPayloadSize)
+	MaxResponseSize int64
+
+	// CleanupInterval is how often a store that supports it (such as
+	// storage.MemoryStore) sweeps out expired messages nobody has read
+	// (0 = expired messages are only pruned when accessed)
+	CleanupInterval time.Duration
+
+	// ReuseReadBuffers decodes inbound frames straight from a reused
+	// per-connection read buffer instead of a fresh copy of each frame
+	ReuseReadBuffers bool
+
+	// Overload shedding: once OverloadHighWater messages are in flight, new
+	// requests are answered with server_overloaded until the in-flight count
+	// drops to OverloadLowWater (0 disables shedding)
+	OverloadHighWater  int
+	OverloadLowWater   int
+	OverloadRetryAfter time.Duration
+
+	// Handler concurrency: at most MaxConcurrentHandlers route handlers run at
+	// once (0 = unlimited); up to MaxQueuedHandlers more wait for a slot before
+	// requests are rejected with server_busy
+	MaxConcurrentHandlers int
+	MaxQueuedHandlers     int
+
+	// Event fan-out: events are delivered by up to BroadcastWorkers
+	// goroutines (<= 1 delivers sequentially), each send waiting at most
+	// BroadcastSendTimeout for the recipient's queue (0 = transport default)
+	BroadcastWorkers     int
+	BroadcastSendTimeout time.Duration
+
+	// HubShards spreads WebSocket clients across this many hub goroutines
+	// by client ID hash (0 or 1 = a single hub)
+	HubShards int
+
+	// Stuck consumers: a WebSocket client whose message writes take longer
+	// than SlowWriteThreshold SlowWriteLimit times in a row is disconnected
+	// (0 threshold = disabled; 0 limit = transport default)
+	SlowWriteThreshold time.Duration
+	SlowWriteLimit     int
+
+	// Application keepalive: a DID-bound client quiet for KeepaliveInterval
+	// is sent a Ping message, and is closed once KeepaliveMissLimit pings in
+	// a row go without a Pong (0 interval = disabled; 0 limit = 3)
+	KeepaliveInterval  time.Duration
+	KeepaliveMissLimit int
+
+	// Rate limiting: each WebSocket client may send RateLimitPerMinute
+	// messages a minute (0 = unlimited); Pongs and StreamData/StreamEnd
+	// frames are not counted. DefaultConfig enforces 60, so deployments
+	// that relied on the setting being ignored must raise or clear it.
+	// RateLimitByOrigin sets the limit for clients authenticated as a DID
+	// it lists; an entry for a connection's Origin, which the client
+	// controls, can only lower the limit.
 	RateLimitPerMinute int
+	RateLimitByOrigin  map[string]int
+
+	// Destination policy, checked before forwarding. A DID in
+	// BlockedDestinations is always rejected; a non-empty AllowedDestinations
+	// admits only the listed DIDs; DestinationPolicy, if set, has the final say.
+	AllowedDestinations []string
+	BlockedDestinations []string
+	DestinationPolicy   func(did string) bool
+
+	// AllowedMessageTypes, if non-empty, lists the only message types
+	// WebSocket clients may send; others get message_type_not_allowed
+	AllowedMessageTypes []protocol.MessageType
+
+	// Streams: at most MaxStreamsPerClient streams open per client at once
+	// (0 = unlimited); further StreamStart messages get too_many_streams
+	MaxStreamsPerClient int
+
+	// Per-DID quota: at most QuotaLimit messages per authenticated DID in
+	// each QuotaWindow (0 = unlimited); a client that hasn't authenticated is
+	// counted on its own. Counters are kept in memory and saved to
+	// StateStore every minute and on Stop.
+	QuotaLimit  int
+	QuotaWindow time.Duration
+
+	// SessionResumeWindow enables session resumption: a client that sends
+	// Hello gets a resume token, and for this long after it disconnects a new
+	// connection presenting the token in its Hello takes over its DID and
+	// presence subscriptions (0 = disabled, Hello is unsupported)
+	SessionResumeWindow time.Duration
+
+	// Request correlation: forwarded requests are remembered for
+	// RequestTimeout (0 = not tracked), so a response carrying only ReplyTo
+	// still reaches the requester. PersistPendingRequests keeps them in
+	// StateStore so late responses are delivered across a restart.
+	RequestTimeout         time.Duration
+	PersistPendingRequests bool
+
+	// At-least-once delivery: a message forwarded to a connected client is
+	// redelivered if the recipient hasn't answered with an ACK replying to it
+	// within AckTimeout, up to MaxRedeliveries times, after which it is moved
+	// from the store to the dead letters (0 timeout = no ACKs expected)
+	AckTimeout      time.Duration
+	MaxRedeliveries int
 }
 
+// defaultServerName is the reserved destination used when Config.ServerName is empty
+const defaultServerName = "relay-server"
+
 // DefaultConfig returns a default server configuration
 func DefaultConfig() *Config {
 	return &Config{
-		ListenAddr:         ":8080",
-		Storage:            storage.NewMemoryStore(),
-		DefaultTTL:         5 * time.Minute,
-		MaxPayloadSize:     512 * 1024, // 512KB
-		RateLimitPerMinute: 60,
+		ListenAddr:           ":8080",
+		ServerName:           defaultServerName,
+		Authenticator:        auth.NewNoOpAuthenticator(),
+		Storage:              storage.NewMemoryStore(),
+		StateStore:           storage.NewMemoryStateStore(),
+		DefaultTTL:           5 * time.Minute,
+		MaxPayloadSize:       512 * 1024, // 512KB
+		OverloadRetryAfter:   1 * time.Second,
+		BroadcastWorkers:     8,
+		BroadcastSendTimeout: 100 * time.Millisecond,
+		RateLimitPerMinute:   60,
+		QuotaWindow:          24 * time.Hour,
 	}
 }
 
 // RelayServer is the main AMP Relay Server
 type RelayServer struct {
 	config *Config
+	logger *slog.Logger
 
 	// Transport layer
-	wsServer *transport.WebSocketServer
+	wsServer    *transport.WebSocketServer
+	authHandler *transport.WebSocketAuthHandler
 
 	// Storage
-	store storage.MessageStore
+	store      storage.MessageStore
+	state      storage.StateStore
+	receiveSeq receiveSequence // stamped on stored messages for mailbox paging
 
 	// Client management
 	clients   map[string]*ClientInfo
 	clientsMu sync.RWMutex
 
 	// Message routing
-	routes   map[string]RouteHandler
-	routesMu sync.RWMutex
+	routes       map[string]RouteHandler
+	typeHandlers map[protocol.MessageType]TypeHandler
+	routesMu     sync.RWMutex
+	handlers     *handlerLimiter
+	overload     *overloadDetector
+	quotas       *quotaTracker
+	rateLimits   *rateLimiter
+	pending      *pendingRequests
+	acks         *ackTracker
+	streams      *streamTracker
+	presence     *presenceTracker
+	contacts     *contactTracker
+	delegations  *delegationTracker
+	sessions     *sessionManager // nil unless SessionResumeWindow is set
+
+	// decode_errors_total: frames that failed to decode as CBOR
+	decodeErrors      atomic.Uint64
+	decodeLogThrottle *logThrottle
+
+	// In-flight HTTP submissions (*httpSubmission), keyed by pseudo client ID
+	httpReplies sync.Map
 
 	// Lifecycle
 	ctx     context.Context
 	cancel  context.CancelFunc
 	wg      sync.WaitGroup
-	running bool
+	running atomic.Bool
 }
 
 // ClientInfo holds information about a connected client
@@ -72,53 +286,139 @@ type ClientInfo struct {
 	ConnectedAt  time.Time
 	LastActivity time.Time
 	Metadata     map[string]string
+	DecodeErrors uint64 // frames from this client that failed to decode
+	MissedPings  int    // keepalive pings sent since the client's last Pong
 }
 
 // RouteHandler is a function that handles messages for a specific action
-type RouteHandler func(msg *protocol.Message) (*protocol.Message, error)
+type RouteHandler func(msg RelayMessage) (RelayMessage, error)
+
+// TypeHandler handles every message of one type in place of the server's
+// built-in handling. A non-nil reply is sent back to the sender.
+type TypeHandler func(msg RelayMessage) (RelayMessage, error)
 
 // NewRelayServer creates a new AMP Relay Server instance
 func NewRelayServer(config *Config) *RelayServer {
 	ctx, cancel := context.WithCancel(context.Background())
 
+	authHandler := transport.NewWebSocketAuthHandler()
+	authHandler.ServerDID = config.ServerDID
+	authHandler.ServerKey = config.ServerKey
+	authHandler.OnUnauthenticated = config.UnauthenticatedPolicy
+	if config.SignatureVerifier != nil {
+		authHandler.Authenticator = config.SignatureVerifier
+		authHandler.RequireChallenge = true
+	}
+
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if config.LogSampleEvery > 1 || config.LogMaxPerSecond > 0 {
+		logger = slog.New(newSamplingHandler(logger.Handler(), config.LogSampleEvery, config.LogMaxPerSecond))
+	}
+
+	state := config.StateStore
+	if state == nil {
+		state = storage.NewMemoryStateStore()
+	}
+	var pendingState storage.StateStore
+	if config.PersistPendingRequests {
+		pendingState = state
+	}
+
+	var sessions *sessionManager
+	if config.SessionResumeWindow > 0 {
+		sessions = newSessionManager(config.SessionResumeWindow)
+	}
+
 	return &RelayServer{
-		config:  config,
-		store:   config.Storage,
-		clients: make(map[string]*ClientInfo),
-		routes:  make(map[string]RouteHandler),
-		ctx:     ctx,
-		cancel:  cancel,
+		config:       config,
+		logger:       logger,
+		store:        config.Storage,
+		state:        state,
+		authHandler:  authHandler,
+		clients:      make(map[string]*ClientInfo),
+		routes:       make(map[string]RouteHandler),
+		typeHandlers: make(map[protocol.MessageType]TypeHandler),
+		handlers:     newHandlerLimiter(config.MaxConcurrentHandlers, config.MaxQueuedHandlers),
+		overload:     newOverloadDetector(config.OverloadHighWater, config.OverloadLowWater),
+		quotas:       newQuotaTracker(state, config.QuotaLimit, config.QuotaWindow),
+		rateLimits:   newRateLimiter(config.RateLimitPerMinute, config.RateLimitByOrigin),
+		pending:      newPendingRequests(config.RequestTimeout, pendingState),
+		acks:         newAckTracker(config.AckTimeout, config.MaxRedeliveries),
+		streams:      newStreamTracker(config.MaxStreamsPerClient),
+		presence:     newPresenceTracker(),
+		contacts:     newContactTracker(state),
+		delegations:  newDelegationTracker(state),
+		sessions:     sessions,
+		ctx:          ctx,
+		cancel:       cancel,
+
+		decodeLogThrottle: newLogThrottle(decodeErrorLogBurst, decodeErrorLogInterval),
 	}
 }
 
 // Start starts the relay server
 func (s *RelayServer) Start() error {
-	if s.running {
+	if s.running.Load() {
 		return fmt.Errorf("server already running")
 	}
 
+	// Pick up requests still awaiting responses from before a restart
+	if err := s.pending.load(); err != nil {
+		return err
+	}
+
 	// Create WebSocket server
-	s.wsServer = transport.NewWebSocketServer(s.config.ListenAddr)
+	s.wsServer = transport.NewWebSocketServer(s.config.ListenAddr, s.config.AllowedOrigins)
+	s.wsServer.DisableWebSocket = s.config.DisableWebSocket
+	s.wsServer.AuthFrameType = s.config.AuthFrameType
+	s.wsServer.ReuseReadBuffers = s.config.ReuseReadBuffers
+	s.wsServer.MaxMessageSize = s.config.MaxPayloadSize
+	s.wsServer.MaxFrameSize = s.config.MaxFrameSize
+	s.wsServer.HubShards = s.config.HubShards
+	s.wsServer.SlowWriteThreshold = s.config.SlowWriteThreshold
+	s.wsServer.SlowWriteLimit = s.config.SlowWriteLimit
+	if s.config.RequireAuthHandshake {
+		s.wsServer.Auth = s.authHandler
+	}
+	s.wsServer.SetConnectHandler(s.handleConnect)
 	s.wsServer.SetMessageHandler(s.handleWebSocketMessage)
+	s.wsServer.SetDisconnectHandler(s.handleDisconnect)
+	if s.config.EnableHTTPMessages {
+		s.wsServer.HandleFunc(httpMessagesPath, s.handleHTTPMessages)
+	}
+	if s.config.AdminToken != "" {
+		s.wsServer.HandleFunc(adminRoutesPath, s.handleAdminRoutes)
+	}
 
 	// Start WebSocket server
 	if err := s.wsServer.Start(); err != nil {
 		return fmt.Errorf("failed to start WebSocket server: %w", err)
 	}
 
-	s.running = true
+	s.running.Store(true)
 
 	// Start background tasks
 	s.wg.Add(1)
 	go s.cleanupLoop()
-
+	if s.config.KeepaliveInterval > 0 {
+		s.wg.Add(1)
+		go s.keepaliveLoop()
+	}
+	if s.config.AckTimeout > 0 {
+		s.wg.Add(1)
+		go s.ackLoop()
+	}
 	log.Printf("AMP Relay Server started on %s", s.config.ListenAddr)
 	return nil
 }
 
-// Stop gracefully stops the relay server
+// Stop gracefully stops the relay server. It is a no-op returning nil if
+// the server is not running, including when Start was never called or failed.
 func (s *RelayServer) Stop() error {
-	if !s.running {
+	if !s.running.CompareAndSwap(true, false) {
 		return nil
 	}
 
@@ -137,15 +437,28 @@ func (s *RelayServer) Stop() error {
 	// Wait for background tasks
 	s.wg.Wait()
 
-	s.running = false
+	// Save the quota counts made since the last periodic flush
+	if err := s.quotas.flush(); err != nil {
+		s.logger.Error("Failed to save quota counters", "error", err)
+	}
+
 	log.Println("AMP Relay Server stopped")
 	return nil
 }
 
-// RegisterRoute registers a handler for a specific action
+// RegisterRoute registers a handler for a specific action.
+//
+// Handlers are chosen in this order: a type handler for the message's type,
+// then the built-in handling for that type, then, for requests addressed to
+// the relay, the route for the body's action. A route therefore never
+// intercepts a control message: a "ping" route answers ping requests, not
+// protocol Ping frames. Registering an action again replaces its handler.
 func (s *RelayServer) RegisterRoute(action string, handler RouteHandler) {
 	s.routesMu.Lock()
 	defer s.routesMu.Unlock()
+	if _, exists := s.routes[action]; exists {
+		s.logger.Warn("Replacing route handler", "action", action)
+	}
 	s.routes[action] = handler
 }
 
@@ -156,17 +469,50 @@ func (s *RelayServer) UnregisterRoute(action string) {
 	delete(s.routes, action)
 }
 
+// RegisterTypeHandler makes handler process all messages of msgType. It takes
+// precedence over both the built-in handling for that type and, for
+// requests, the action routes. Registering a type again replaces its handler.
+func (s *RelayServer) RegisterTypeHandler(msgType protocol.MessageType, handler TypeHandler) {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	if _, exists := s.typeHandlers[msgType]; exists {
+		s.logger.Warn("Replacing type handler", "type", msgType.Name())
+	} else if builtinTypes[msgType] {
+		s.logger.Info("Type handler overrides built-in handling", "type", msgType.Name())
+	}
+	s.typeHandlers[msgType] = handler
+}
+
+// UnregisterTypeHandler restores the built-in handling for msgType
+func (s *RelayServer) UnregisterTypeHandler(msgType protocol.MessageType) {
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	delete(s.typeHandlers, msgType)
+}
+
+// AuthHandler returns the RFC-002 handshake handler, configured with the
+// server's DID and signing key
+func (s *RelayServer) AuthHandler() *transport.WebSocketAuthHandler {
+	return s.authHandler
+}
+
 // GetStats returns server statistics
 func (s *RelayServer) GetStats() ServerStats {
 	s.clientsMu.RLock()
 	clientCount := len(s.clients)
 	s.clientsMu.RUnlock()
 
-	return ServerStats{
+	stats := ServerStats{
 		ConnectedClients: clientCount,
 		Address:          s.config.ListenAddr,
-		Running:          s.running,
+		Running:          s.running.Load(),
+		DecodeErrors:     s.decodeErrors.Load(),
+	}
+	if s.wsServer != nil {
+		stats.SlowWrites = s.wsServer.SlowWrites()
+		stats.SlowClientsClosed = s.wsServer.SlowClientsClosed()
 	}
+	return stats
 }
 
 // ServerStats holds server statistics
@@ -174,83 +520,429 @@ type ServerStats struct {
 	ConnectedClients int
 	Address          string
 	Running          bool
+	DecodeErrors     uint64 // decode_errors_total: frames that failed to decode
+
+	SlowWrites        uint64 // slow_writes_total: message writes over SlowWriteThreshold
+	SlowClientsClosed uint64 // slow_clients_closed_total: clients closed as stuck consumers
 }
 
-// handleWebSocketMessage processes incoming WebSocket messages
+// handleWebSocketMessage processes incoming WebSocket messages.
+// data may be a reused read buffer: it must not be retained past this call.
+// The CBOR and JSON decoders copy byte and text strings, so msg never aliases it.
 func (s *RelayServer) handleWebSocketMessage(clientID string, data []byte) error {
-	// Decode CBOR message
-	msg := &protocol.Message{}
-	if err := msg.CBORUnmarshal(data); err != nil {
-		log.Printf("Failed to decode message from client %s: %v", clientID, err)
+	// Decode the message, CBOR unless the client negotiated JSON, into a
+	// pooled struct. Messages that get stored or forwarded are retained and
+	// must not go back to the pool.
+	msg := protocol.AcquireMessage()
+	retained := false
+	defer func() {
+		if !retained {
+			protocol.ReleaseMessage(msg)
+		}
+	}()
+	var err error
+	if s.wsServer.ClientUsesJSON(clientID) {
+		err = json.Unmarshal(data, msg)
+	} else {
+		err = msg.CBORUnmarshal(data)
+	}
+	if err != nil {
+		s.recordDecodeError(clientID, err)
 		return fmt.Errorf("invalid message format: %w", err)
 	}
 
 	// Update client info
 	s.updateClientActivity(clientID)
 
+	admitted, err := s.admitMessage(clientID, clientID, s.clientDID(clientID), s.wsServer.ClientOrigin(clientID), msg)
+	if !admitted {
+		return err
+	}
+
+	retained, err = s.dispatchMessage(clientID, msg)
+	return err
+}
+
+// admitMessage applies the checks every transport runs before dispatch: the
+// rate limit counted under rateKey, the binding of the sender to did (if
+// any) and the message type allow-list. A refused message is answered here;
+// it reports whether msg may be dispatched.
+func (s *RelayServer) admitMessage(clientID, rateKey, did, origin string, msg *protocol.Message) (bool, error) {
+	if !rateLimitExempt(msg.Type) && !s.rateLimits.allow(rateKey, did, origin) {
+		s.logger.Warn("Rate limit exceeded, rejecting message", "client", clientID)
+		return false, s.sendErrorResponse(clientID, msg, "rate_limited", "Message rate limit exceeded")
+	}
+
+	// A client bound to a DID may only send as that DID
+	if did != "" {
+		if msg.From == "" {
+			msg.From = did
+		} else if msg.From != did {
+			return false, s.sendErrorResponse(clientID, msg, errCodeForbidden,
+				"message sender does not match the authenticated DID")
+		}
+	}
+
+	if !s.messageTypeAllowed(msg.Type) {
+		s.logger.Warn("Rejecting disallowed message type", "client", clientID, "type", msg.Type.Name())
+		return false, s.sendErrorResponse(clientID, msg, "message_type_not_allowed",
+			fmt.Sprintf("Message type %s is not allowed", msg.Type.Name()))
+	}
+	return true, nil
+}
+
+// builtinTypes are the message types dispatchMessage handles itself
+var builtinTypes = map[protocol.MessageType]bool{
+	protocol.MessageTypeRequest:        true,
+	protocol.MessageTypeMessage:        true,
+	protocol.MessageTypeResponse:       true,
+	protocol.MessageTypeStreamStart:    true,
+	protocol.MessageTypeStreamData:     true,
+	protocol.MessageTypeStreamEnd:      true,
+	protocol.MessageTypeACK:            true,
+	protocol.MessageTypeProcOK:         true,
+	protocol.MessageTypeProcFail:       true,
+	protocol.MessageTypeProcessing:     true,
+	protocol.MessageTypeProgress:       true,
+	protocol.MessageTypeInputRequired:  true,
+	protocol.MessageTypeError:          true,
+	protocol.MessageTypePing:           true,
+	protocol.MessageTypePong:           true,
+	protocol.MessageTypePresenceSub:    true,
+	protocol.MessageTypeContactRequest: true,
+	protocol.MessageTypeContactResp:    true,
+	protocol.MessageTypeContactRevoke:  true,
+	protocol.MessageTypeDelegGrant:     true,
+	protocol.MessageTypeDelegRevoke:    true,
+	protocol.MessageTypeDelegQuery:     true,
+	protocol.MessageTypeCredIssue:      true,
+	protocol.MessageTypeCredRequest:    true,
+	protocol.MessageTypeCredPresent:    true,
+	protocol.MessageTypeCredVerify:     true,
+	protocol.MessageTypeDocSend:        true,
+	protocol.MessageTypeDocRequest:     true,
+	protocol.MessageTypePresenceUnsub:  true,
+	protocol.MessageTypeHello:          true,
+}
+
+// dispatchMessage validates and admits a decoded message, then routes it by
+// type. Replies go to clientID via deliver. It reports whether msg was kept
+// (stored or queued for forwarding) beyond the call.
+func (s *RelayServer) dispatchMessage(clientID string, msg *protocol.Message) (retained bool, err error) {
+	ensureCorrelationID(msg)
+	logger := s.msgLogger(msg)
+	logger.Debug("Dispatching message", "client", clientID, "id", msg.IDHex(),
+		"type", msg.Type.Name(), "from", msg.From, "to", msg.To)
+
+	if err := msg.Validate(s.config.MaxBodyDepth); err != nil {
+		logger.Warn("Rejecting invalid message", "client", clientID, "error", err)
+		if ve, ok := err.(*protocol.ValidationError); ok {
+			return false, s.sendErrorResponse(clientID, msg, ve.Code, ve.Message)
+		}
+		return false, err
+	}
+	if err := validateEncryption(msg); err != nil {
+		logger.Warn("Rejecting malformed encrypted message", "client", clientID, "error", err)
+		return false, s.sendErrorResponse(clientID, msg, "invalid_encryption", err.Error())
+	}
+
+	admitted := s.overload.enter()
+	defer s.overload.leave()
+
+	if ok, err := s.quotas.allow(s.quotaSubject(clientID)); err != nil {
+		logger.Error("Quota check failed", "from", msg.From, "error", err)
+	} else if !ok {
+		logger.Warn("Quota exceeded, rejecting message", "from", msg.From, "client", clientID)
+		return false, s.sendErrorResponse(clientID, msg, "quota_exceeded", "Message quota exceeded for this window")
+	}
+
+	// A registered type handler replaces the built-in handling
+	s.routesMu.RLock()
+	typeHandler, overridden := s.typeHandlers[msg.Type]
+	s.routesMu.RUnlock()
+	if overridden {
+		if msg.Type == protocol.MessageTypeRequest && !admitted {
+			return false, s.sendOverloadedResponse(clientID, msg)
+		}
+		// The handler may hold on to msg, so it never goes back to the pool
+		return true, s.handleWithTypeHandler(clientID, msg, typeHandler)
+	}
+
 	// Process message based on type
 	switch msg.Type {
 	case protocol.MessageTypeRequest:
-		return s.handleRequest(clientID, msg)
-	case protocol.MessageTypeEvent:
-		return s.handleEvent(clientID, msg)
+		if !admitted {
+			return false, s.sendOverloadedResponse(clientID, msg)
+		}
+		return true, s.handleRequest(clientID, msg)
+	case protocol.MessageTypeMessage:
+		// Only undirected messages are broadcast; addressed ones are relayed
+		// under the destination policy like any other peer traffic
+		if !s.addressedToServer(msg.To) {
+			return true, s.handleRelay(clientID, msg)
+		}
+		return true, s.handleEvent(clientID, msg)
+	case protocol.MessageTypeResponse,
+		protocol.MessageTypeStreamStart,
+		protocol.MessageTypeStreamData,
+		protocol.MessageTypeStreamEnd,
+		protocol.MessageTypeACK,
+		protocol.MessageTypeProcOK,
+		protocol.MessageTypeProcFail,
+		protocol.MessageTypeProcessing,
+		protocol.MessageTypeProgress,
+		protocol.MessageTypeInputRequired,
+		protocol.MessageTypeError:
+		return true, s.handleRelay(clientID, msg)
+	case protocol.MessageTypePing:
+		return false, s.handlePing(clientID, msg)
+	case protocol.MessageTypePresenceSub, protocol.MessageTypePresenceUnsub:
+		return false, s.handlePresenceSub(clientID, msg)
+	case protocol.MessageTypeContactRequest,
+		protocol.MessageTypeContactResp,
+		protocol.MessageTypeContactRevoke:
+		return true, s.handleContact(clientID, msg)
+	case protocol.MessageTypeDelegGrant, protocol.MessageTypeDelegRevoke:
+		return true, s.handleDelegation(clientID, msg)
+	case protocol.MessageTypeDelegQuery:
+		return false, s.handleDelegation(clientID, msg)
+	case protocol.MessageTypeCredIssue,
+		protocol.MessageTypeCredRequest,
+		protocol.MessageTypeCredPresent,
+		protocol.MessageTypeCredVerify:
+		return true, s.handleCredential(clientID, msg)
+	case protocol.MessageTypeDocSend:
+		return true, s.handleDocSend(clientID, msg)
+	case protocol.MessageTypeDocRequest:
+		return false, s.handleDocRequest(clientID, msg)
+	case protocol.MessageTypeHello:
+		return false, s.handleHello(clientID, msg)
+	case protocol.MessageTypePong:
+		// Keepalive reply; activity was already recorded by the caller
+		s.handlePong(clientID)
+		return false, nil
 	default:
-		log.Printf("Unsupported message type from client %s: %s", clientID, msg.Type)
-		return fmt.Errorf("unsupported message type: %s", msg.Type)
+		logger.Warn("Unsupported message type", "client", clientID, "type", fmt.Sprintf("0x%02x", uint8(msg.Type)))
+		return false, s.sendErrorResponse(clientID, msg, "unsupported_type",
+			fmt.Sprintf("unsupported message type: 0x%02x", msg.Type))
 	}
 }
 
 // handleRequest processes request messages
 func (s *RelayServer) handleRequest(clientID string, msg *protocol.Message) error {
+	logger := s.msgLogger(msg)
+
+	// Requests made on another DID's behalf need its delegation
+	if ok, err := s.authorizeRequest(clientID, msg); err != nil {
+		logger.Error("Authorization check failed", "error", err)
+		return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to check authorization")
+	} else if !ok {
+		logger.Warn("Rejecting request without delegation", "from", msg.From,
+			"on_behalf_of", bodyString(msg.Body, onBehalfOfField), "action", extractAction(msg))
+		return s.sendErrorResponse(clientID, msg, "not_authorized", "No delegation for this action")
+	}
+
 	// Store the message
-	ttl := s.config.DefaultTTL
-	if msg.TTL > 0 {
-		ttl = time.Duration(msg.TTL) * time.Second
+	if err := s.saveMessage(msg); err != nil {
+		logger.Error("Failed to store message", "error", err)
+		return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to store message")
 	}
+	logger.Debug("Stored message", "id", msg.IDHex())
 
-	if err := s.store.Save(msg, ttl); err != nil {
-		log.Printf("Failed to store message: %v", err)
-		return s.sendErrorResponse(clientID, msg, "storage_error", "Failed to store message")
+	// Forward anything not addressed to the relay itself
+	if !s.addressedToServer(msg.To) {
+		if err := s.pending.add(msg); err != nil {
+			logger.Error("Failed to record pending request", "error", err)
+		}
+		return s.forwardOrReject(clientID, msg)
 	}
 
 	// Route the message if a handler exists
+	action := extractAction(msg)
 	s.routesMu.RLock()
-	handler, exists := s.routes[msg.Action]
+	handler, exists := s.routes[action]
 	s.routesMu.RUnlock()
 
 	if exists {
-		response, err := handler(msg)
+		response, err := s.runHandler(handler, WrapMessage(msg))
+		if err == errServerBusy {
+			logger.Warn("Rejecting action: handler capacity exhausted", "action", action, "client", clientID)
+			return s.sendErrorResponse(clientID, msg, "server_busy", "Server busy, retry later")
+		}
 		if err != nil {
-			log.Printf("Route handler error for action %s: %v", msg.Action, err)
+			logger.Warn("Route handler failed", "action", action, "error", err)
 			return s.sendErrorResponse(clientID, msg, "handler_error", err.Error())
 		}
 
+		logger.Debug("Routed message", "action", action)
 		if response != nil {
 			// Send response back to client
-			return s.sendResponse(clientID, msg.ID, response)
+			return s.sendResponse(clientID, msg.ID, toProtocolMessage(response))
+		}
+	}
+
+	return nil
+}
+
+// handleRelay passes addressed peer-to-peer traffic (messages, responses,
+// progress updates, stream frames, acknowledgements and errors) through to
+// its destination
+func (s *RelayServer) handleRelay(clientID string, msg *protocol.Message) error {
+	logger := s.msgLogger(msg)
+
+	// A reply to a tracked request may leave its destination implicit. A final
+	// reply closes the request; an interim update keeps it open. Only the DID
+	// the request was sent to can do either, and a reply it addresses
+	// elsewhere leaves the request alone.
+	switch msg.Type {
+	case protocol.MessageTypeResponse, protocol.MessageTypeError:
+		if !s.addressedToServer(msg.To) {
+			break
+		}
+		if from, ok := s.pending.resolve(msg.ReplyTo, msg.From); ok {
+			logger.Debug("Addressing reply to pending requester", "to", from)
+			msg.To = from
+		}
+	case protocol.MessageTypeProcessing, protocol.MessageTypeProgress, protocol.MessageTypeInputRequired:
+		if !s.addressedToServer(msg.To) {
+			break
+		}
+		if from, ok := s.pending.touch(msg.ReplyTo, msg.From); ok {
+			logger.Debug("Addressing update to pending requester", "to", from)
+			msg.To = from
+		}
+	case protocol.MessageTypeACK:
+		// An ACK settling a forwarded message is consumed unless it names a peer
+		if s.acks.ack(msg.ReplyTo, msg.From) && s.addressedToServer(msg.To) {
+			logger.Debug("Forwarded message acknowledged", "id", hex.EncodeToString(msg.ReplyTo))
+			return nil
 		}
 	}
 
-	// Forward to destination if specified
-	if msg.Destination != "" && msg.Destination != "relay-server" {
-		return s.forwardMessage(msg)
+	if s.addressedToServer(msg.To) {
+		logger.Warn("Dropping message with no destination", "type", msg.Type.Name(), "client", clientID)
+		if msg.Type == protocol.MessageTypeError {
+			// Never answer an error with an error
+			return nil
+		}
+		return s.sendErrorResponse(clientID, msg, "missing_destination", "Message type requires a destination")
 	}
 
-	return nil
+	// Refuse blocked destinations before a stream is counted against the sender
+	if !s.destinationAllowed(msg.To) {
+		logger.Warn("Refusing to relay message to blocked destination", "id", msg.IDHex(), "to", msg.To)
+		return s.sendErrorResponse(clientID, msg, "destination_blocked", "Destination is not allowed")
+	}
+
+	switch msg.Type {
+	case protocol.MessageTypeStreamStart:
+		if !s.streams.start(clientID, streamID(msg)) {
+			logger.Warn("Rejecting stream: too many open", "client", clientID, "max", s.config.MaxStreamsPerClient)
+			return s.sendErrorResponse(clientID, msg, "too_many_streams", "Too many concurrent streams")
+		}
+	case protocol.MessageTypeStreamEnd:
+		defer s.streams.end(clientID, streamID(msg))
+	}
+
+	if err := s.saveMessage(msg); err != nil {
+		logger.Error("Failed to store message", "error", err)
+		if msg.Type == protocol.MessageTypeStreamStart {
+			s.streams.end(clientID, streamID(msg))
+		}
+		return s.sendErrorResponse(clientID, msg, storageErrorCode(err), "Failed to store message")
+	}
+	logger.Debug("Stored message", "id", msg.IDHex())
+
+	// The requester answers an InputRequired with a Response to it, which
+	// finds its way back to the handler like any other reply
+	if msg.Type == protocol.MessageTypeInputRequired {
+		if err := s.pending.add(msg); err != nil {
+			logger.Error("Failed to record pending input request", "error", err)
+		}
+	}
+
+	return s.forwardOrReject(clientID, msg)
 }
 
-// handleEvent processes event messages
-func (s *RelayServer) handleEvent(clientID string, msg *protocol.Message) error {
-	// Store event
-	ttl := s.config.DefaultTTL
-	if msg.TTL > 0 {
-		ttl = time.Duration(msg.TTL) * time.Second
+// handleWithTypeHandler runs a registered type handler and sends its reply,
+// if any, back to the sender
+func (s *RelayServer) handleWithTypeHandler(clientID string, msg *protocol.Message, handler TypeHandler) error {
+	logger := s.msgLogger(msg)
+
+	reply, err := s.runHandler(RouteHandler(handler), WrapMessage(msg))
+	if err == errServerBusy {
+		logger.Warn("Rejecting message: handler capacity exhausted", "type", msg.Type.Name(), "client", clientID)
+		return s.sendErrorResponse(clientID, msg, "server_busy", "Server busy, retry later")
+	}
+	if err != nil {
+		logger.Warn("Type handler failed", "type", msg.Type.Name(), "error", err)
+		return s.sendErrorResponse(clientID, msg, "handler_error", err.Error())
+	}
+	if reply == nil {
+		return nil
+	}
+
+	out := toProtocolMessage(reply)
+	if len(out.ReplyTo) == 0 {
+		out.ReplyTo = msg.ID
 	}
+	return s.forwardMessageToClient(clientID, out)
+}
+
+// addressedToServer reports whether to names the relay itself rather than a peer
+func (s *RelayServer) addressedToServer(to string) bool {
+	switch to {
+	case "", s.serverName():
+		return true
+	}
+	return s.config.ServerDID != "" && to == s.config.ServerDID
+}
+
+// serverIdentity is the From address of messages the relay originates
+func (s *RelayServer) serverIdentity() string {
+	if s.config.ServerDID != "" {
+		return s.config.ServerDID
+	}
+	return s.serverName()
+}
 
-	if err := s.store.Save(msg, ttl); err != nil {
-		log.Printf("Failed to store event: %v", err)
+// serverName returns the configured reserved destination, or the default
+func (s *RelayServer) serverName() string {
+	if s.config.ServerName != "" {
+		return s.config.ServerName
+	}
+	return defaultServerName
+}
+
+// handlePing answers a protocol-level ping with a pong
+func (s *RelayServer) handlePing(clientID string, msg *protocol.Message) error {
+	pong := protocol.NewMessage(protocol.MessageTypePong, s.serverIdentity(), msg.From, nil)
+	pong.ReplyTo = msg.ID
+	return s.forwardMessageToClient(clientID, pong)
+}
+
+// forwardOrReject forwards a stored message, answering the sender with
+// destination_blocked if the destination policy refuses it
+func (s *RelayServer) forwardOrReject(clientID string, msg *protocol.Message) error {
+	err := s.forwardMessage(msg)
+	if err == errDestinationBlocked {
+		s.store.Delete(msg.IDHex())
+		s.pending.cancel(msg.IDHex())
+		return s.sendErrorResponse(clientID, msg, "destination_blocked", "Destination is not allowed")
+	}
+	return err
+}
+
+// handleEvent broadcasts a message without a destination to every other client
+func (s *RelayServer) handleEvent(clientID string, msg *protocol.Message) error {
+	logger := s.msgLogger(msg)
+
+	// Store event
+	if err := s.saveMessage(msg); err != nil {
+		logger.Error("Failed to store event", "error", err)
 		return err
 	}
+	logger.Debug("Stored message", "id", msg.IDHex())
 
 	// Broadcast to all clients except sender
 	s.clientsMu.RLock()
@@ -262,41 +954,134 @@ func (s *RelayServer) handleEvent(clientID string, msg *protocol.Message) error
 	}
 	s.clientsMu.RUnlock()
 
-	// Forward to each client
-	for _, targetID := range clients {
-		if err := s.forwardMessageToClient(targetID, msg); err != nil {
-			log.Printf("Failed to forward event to client %s: %v", targetID, err)
+	if len(clients) == 0 {
+		return nil
+	}
+
+	data, err := msg.CBORMarshal()
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	// JSON clients get the event re-encoded, once, on first need
+	var jsonOnce sync.Once
+	var jsonData []byte
+	var jsonErr error
+	failed := fanOut(clients, s.config.BroadcastWorkers, func(targetID string) error {
+		payload := data
+		if s.wsServer.ClientUsesJSON(targetID) {
+			jsonOnce.Do(func() { jsonData, jsonErr = messageJSON(msg) })
+			if jsonErr != nil {
+				return fmt.Errorf("failed to encode event as JSON: %w", jsonErr)
+			}
+			payload = jsonData
+		}
+		if !s.sendEventData(targetID, payload) {
+			return fmt.Errorf("failed to send to client %s", targetID)
 		}
+		return nil
+	})
+	for targetID, err := range failed {
+		logger.Warn("Failed to forward event", "client", targetID, "error", err)
 	}
+	logger.Debug("Broadcast event", "clients", len(clients), "failed", len(failed))
 
 	return nil
 }
 
+// effectiveTTL returns the storage TTL for a message: its own TTL if set,
+// otherwise the per-type default, otherwise DefaultTTL, clamped to
+// [MinTTL, MaxTTL] and capped at MaxMessageAge. A zero configured TTL keeps
+// messages without expiry, subject to MaxMessageAge; it is never passed to
+// the store as 0, which would expire them at once.
+func (s *RelayServer) effectiveTTL(msg *protocol.Message) time.Duration {
+	ttl := s.config.DefaultTTL
+	if msg.TTL > 0 {
+		ttl = time.Duration(msg.TTL) * time.Millisecond
+	} else if typeTTL, ok := s.config.TTLByType[msg.Type]; ok {
+		ttl = typeTTL
+	}
+	if ttl <= 0 {
+		ttl = storage.NoExpiry
+	}
+
+	clamped := ttl
+	if s.config.MinTTL > 0 && clamped < s.config.MinTTL {
+		clamped = s.config.MinTTL
+	}
+	if s.config.MaxTTL > 0 && clamped > s.config.MaxTTL {
+		clamped = s.config.MaxTTL
+	}
+	if age := s.config.MaxMessageAge; age > 0 && (clamped < 0 || clamped > age) {
+		clamped = age
+	}
+	if clamped != ttl {
+		s.msgLogger(msg).Debug("Clamped message TTL", "requested", ttl, "ttl", clamped)
+	}
+	return clamped
+}
+
 // forwardMessage forwards a message to its destination
 func (s *RelayServer) forwardMessage(msg *protocol.Message) error {
+	logger := s.msgLogger(msg)
+
+	if !s.destinationAllowed(msg.To) {
+		logger.Warn("Refusing to forward message to blocked destination", "id", msg.IDHex(), "to", msg.To)
+		return errDestinationBlocked
+	}
+
 	// Try to find the destination client
-	s.clientsMu.RLock()
-	for clientID, info := range s.clients {
-		if info.DID == msg.Destination {
-			s.clientsMu.RUnlock()
-			return s.forwardMessageToClient(clientID, msg)
+	if clientID, ok := s.clientForDID(msg.To); ok {
+		logger.Debug("Forwarding message", "to", msg.To, "client", clientID)
+		if err := s.forwardMessageToClient(clientID, msg); err != nil {
+			return err
 		}
+		s.acks.track(msg)
+		return nil
 	}
-	s.clientsMu.RUnlock()
 
 	// Destination not found, message stays in store for later retrieval
-	log.Printf("Destination %s not connected, message stored for later delivery", msg.Destination)
+	logger.Info("Destination not connected, message stored for later delivery", "to", msg.To)
 	return nil
 }
 
+// clientForDID returns the ID of a client bound to did
+func (s *RelayServer) clientForDID(did string) (string, bool) {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	for clientID, info := range s.clients {
+		if info.DID == did {
+			return clientID, true
+		}
+	}
+	return "", false
+}
+
+// sendEventData queues an encoded event for one client within BroadcastSendTimeout
+func (s *RelayServer) sendEventData(clientID string, data []byte) bool {
+	if s.config.BroadcastSendTimeout <= 0 {
+		return s.wsServer.SendToClient(clientID, data)
+	}
+	return s.wsServer.SendToClientTimeout(clientID, data, s.config.BroadcastSendTimeout)
+}
+
+// encodeFor marshals msg in the encoding clientID negotiated: JSON for
+// clients on transport.SubprotocolAMPJSON, CBOR for everyone else
+func (s *RelayServer) encodeFor(clientID string, msg *protocol.Message) ([]byte, error) {
+	if s.wsServer != nil && s.wsServer.ClientUsesJSON(clientID) {
+		return messageJSON(msg)
+	}
+	return msg.CBORMarshal()
+}
+
 // forwardMessageToClient sends a message to a specific client
 func (s *RelayServer) forwardMessageToClient(clientID string, msg *protocol.Message) error {
-	data, err := msg.CBORMarshal()
+	data, err := s.encodeFor(clientID, msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	if !s.wsServer.SendToClient(clientID, data) {
+	if !s.deliver(clientID, data) {
 		return fmt.Errorf("failed to send to client %s", clientID)
 	}
 
@@ -304,46 +1089,95 @@ func (s *RelayServer) forwardMessageToClient(clientID string, msg *protocol.Mess
 }
 
 // sendResponse sends a response message
-func (s *RelayServer) sendResponse(clientID string, requestID string, response *protocol.Message) error {
-	response.CorrelationID = requestID
+func (s *RelayServer) sendResponse(clientID string, requestID []byte, response *protocol.Message) error {
+	response.ReplyTo = requestID
 	response.Type = protocol.MessageTypeResponse
 
-	data, err := response.CBORMarshal()
+	data, err := s.encodeFor(clientID, response)
 	if err != nil {
 		return fmt.Errorf("failed to marshal response: %w", err)
 	}
 
-	if !s.wsServer.SendToClient(clientID, data) {
+	if limit := s.maxResponseSize(); limit > 0 && int64(len(data)) > limit {
+		s.msgLogger(response).Warn("Replacing oversized response", "client", clientID, "size", len(data), "max", limit)
+		request := &protocol.Message{ID: requestID, From: response.To}
+		return s.sendErrorResponse(clientID, request, "response_too_large", "Response exceeds the relay's size limit")
+	}
+
+	if !s.deliver(clientID, data) {
 		return fmt.Errorf("failed to send response to client %s", clientID)
 	}
 
 	return nil
 }
 
+// maxResponseSize is the largest encoded response sendResponse delivers
+func (s *RelayServer) maxResponseSize() int64 {
+	if s.config.MaxResponseSize > 0 {
+		return s.config.MaxResponseSize
+	}
+	return s.config.MaxPayloadSize
+}
+
 // sendErrorResponse sends an error response
 func (s *RelayServer) sendErrorResponse(clientID string, originalMsg *protocol.Message, code string, message string) error {
+	return s.sendErrorResponseWithDetails(clientID, originalMsg, code, message, nil)
+}
+
+// sendErrorResponseWithDetails sends an error response whose body carries
+// extra fields alongside the code and message
+func (s *RelayServer) sendErrorResponseWithDetails(clientID string, originalMsg *protocol.Message, code string, message string, details map[string]interface{}) error {
+	body := map[string]interface{}{
+		"code":    code,
+		"message": message,
+	}
+	for k, v := range details {
+		body[k] = v
+	}
+
 	errorMsg := protocol.NewMessage(
 		protocol.MessageTypeError,
-		"relay-server",
-		originalMsg.Source,
-		"error",
-		[]byte(message),
+		s.serverIdentity(),
+		originalMsg.From,
+		body,
 	)
-	errorMsg.CorrelationID = originalMsg.ID
-	errorMsg.AddMetadata("error_code", code)
+	errorMsg.ReplyTo = originalMsg.ID
 
-	data, err := errorMsg.CBORMarshal()
+	data, err := s.encodeFor(clientID, errorMsg)
 	if err != nil {
 		return err
 	}
 
-	if !s.wsServer.SendToClient(clientID, data) {
+	if !s.deliver(clientID, data) {
 		return fmt.Errorf("failed to send error response")
 	}
 
 	return nil
 }
 
+// extractAction returns the action a message body names, or "" if it has none.
+// A body whose type was registered with RegisterActionExtractor is read by
+// its extractor; otherwise maps and raw JSON objects yield their string
+// "action" field.
+func extractAction(msg *protocol.Message) string {
+	if action, ok := registeredAction(msg.Body); ok {
+		return action
+	}
+
+	switch body := msg.Body.(type) {
+	case map[string]interface{}:
+		action, _ := body["action"].(string)
+		return action
+	case map[interface{}]interface{}:
+		action, _ := body["action"].(string)
+		return action
+	case json.RawMessage:
+		return rawJSONAction(body)
+	default:
+		return ""
+	}
+}
+
 // updateClientActivity updates client activity timestamp
 func (s *RelayServer) updateClientActivity(clientID string) {
 	s.clientsMu.Lock()
@@ -362,10 +1196,59 @@ func (s *RelayServer) updateClientActivity(clientID string) {
 	}
 }
 
-// cleanupLoop runs periodic cleanup tasks
+// clientDID returns the DID bound to a client, or "" if it has none
+func (s *RelayServer) clientDID(clientID string) string {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+	if client, exists := s.clients[clientID]; exists {
+		return client.DID
+	}
+	return ""
+}
+
+// authenticatedDID returns the DID a client has proven it controls: the DID
+// its WebSocket handshake authenticated, or the bearer token's DID for an
+// HTTP submission. It is "" for a client that has not authenticated.
+func (s *RelayServer) authenticatedDID(clientID string) string {
+	if sub, ok := s.httpReplies.Load(clientID); ok {
+		return sub.(*httpSubmission).did
+	}
+	return s.clientDID(clientID)
+}
+
+// quotaSubject is who a client's messages count against: its authenticated
+// DID, or the client itself if it hasn't authenticated, so naming another
+// DID in From never spends that DID's quota
+func (s *RelayServer) quotaSubject(clientID string) string {
+	if did := s.authenticatedDID(clientID); did != "" {
+		return did
+	}
+	return "client:" + clientID
+}
+
+// storageErrorCode is the error code for a failed store operation
+func storageErrorCode(err error) string {
+	if errors.Is(err, storage.ErrTimeout) {
+		return "storage_timeout"
+	}
+	return "storage_error"
+}
+
+// storeCleaner is a store that can sweep expired messages in the background
+type storeCleaner interface {
+	StartCleanup(ctx context.Context, interval time.Duration) (stop func())
+}
+
+// cleanupLoop runs periodic cleanup tasks, alongside the store's own sweep
+// of expired messages every CleanupInterval, until the server stops
 func (s *RelayServer) cleanupLoop() {
 	defer s.wg.Done()
 
+	if cleaner, ok := s.store.(storeCleaner); ok {
+		stop := cleaner.StartCleanup(s.ctx, s.config.CleanupInterval)
+		defer stop()
+	}
+
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
@@ -375,21 +1258,40 @@ func (s *RelayServer) cleanupLoop() {
 			return
 		case <-ticker.C:
 			s.cleanupInactiveClients()
+			s.purgeAgedMessages()
+			s.pending.sweep()
+			s.rateLimits.sweep()
+			if err := s.quotas.flush(); err != nil {
+				s.logger.Error("Failed to save quota counters", "error", err)
+			}
+			if s.sessions != nil {
+				s.sessions.sweep()
+			}
 		}
 	}
 }
 
-// cleanupInactiveClients removes clients that haven't been active for a while
+// cleanupInactiveClients evicts clients that haven't been active for a
+// while, closing their connections with CloseIdle
 func (s *RelayServer) cleanupInactiveClients() {
 	cutoff := time.Now().Add(-5 * time.Minute)
 
-	s.clientsMu.Lock()
-	defer s.clientsMu.Unlock()
-
+	s.clientsMu.RLock()
+	var idle []string
 	for id, client := range s.clients {
 		if client.LastActivity.Before(cutoff) {
-			delete(s.clients, id)
-			log.Printf("Removed inactive client: %s", id)
+			idle = append(idle, id)
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	for _, id := range idle {
+		if !s.removeClient(id, disconnectIdle) {
+			continue
+		}
+		if s.wsServer != nil {
+			s.wsServer.CloseClient(id, transport.CloseIdle, "idle timeout")
 		}
+		log.Printf("Removed inactive client: %s", id)
 	}
 }
@@ -1,14 +1,20 @@
 package server
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net"
 	"testing"
 	"time"
 
-	"github.com/openclaw/amp-relay-go/internal/auth"
-	"github.com/openclaw/amp-relay-go/internal/protocol"
-	"github.com/openclaw/amp-relay-go/internal/storage"
+	"github.com/agentries/amp-relay-go/internal/auth"
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/storage"
+	"github.com/agentries/amp-relay-go/internal/transport"
+	"github.com/gorilla/websocket"
 )
 
 // getFreePort asks the OS for a free TCP port on localhost.
@@ -23,6 +29,91 @@ func getFreePort(t *testing.T) string {
 	return addr
 }
 
+// startTestServer starts srv on a free port and stops it when the test ends.
+func startTestServer(t *testing.T, cfg *Config) *RelayServer {
+	t.Helper()
+	cfg.ListenAddr = getFreePort(t)
+	srv := NewRelayServer(cfg)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	t.Cleanup(func() { srv.Stop() })
+	return srv
+}
+
+// dialTestClient connects a WebSocket client to a running server, retrying
+// briefly while the listener comes up.
+func dialTestClient(t *testing.T, srv *RelayServer) *websocket.Conn {
+	t.Helper()
+	url := "ws://" + srv.config.ListenAddr + "/amp/v1/ws"
+	var conn *websocket.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		conn, _, err = websocket.DefaultDialer.Dial(url, nil)
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// With the handshake, the connection registers once bindTestClientDID
+	// has authenticated it
+	if srv.config.RequireAuthHandshake {
+		return conn
+	}
+
+	// Wait for the hub to register the connection
+	deadline := time.Now().Add(time.Second)
+	for srv.wsServer.GetClientCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	return conn
+}
+
+// sendTestMessage CBOR-encodes msg and writes it to conn.
+func sendTestMessage(t *testing.T, conn *websocket.Conn, msg *protocol.Message) {
+	t.Helper()
+	data, err := msg.CBORMarshal()
+	if err != nil {
+		t.Fatalf("CBORMarshal: %v", err)
+	}
+	if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+}
+
+// readTestMessage reads and decodes the next message from conn.
+func readTestMessage(t *testing.T, conn *websocket.Conn) *protocol.Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	msg := &protocol.Message{}
+	if err := msg.CBORUnmarshal(data); err != nil {
+		t.Fatalf("CBORUnmarshal: %v", err)
+	}
+	return msg
+}
+
+// errorCode returns the "code" field of an error message body.
+func errorCode(msg *protocol.Message) string {
+	body, _ := msg.Body.(map[interface{}]interface{})
+	code, _ := body["code"].(string)
+	return code
+}
+
+// newActionRequest builds a request whose body routes to action.
+func newActionRequest(action string) *protocol.Message {
+	return protocol.NewMessage(protocol.MessageTypeRequest, "did:example:client", "relay-server",
+		map[string]interface{}{"action": action})
+}
+
 // TestNewRelayServer verifies that NewRelayServer returns a non-nil server
 // with all fields properly initialized.
 func TestNewRelayServer(t *testing.T) {
@@ -163,6 +254,31 @@ func TestRelayServer_Stop_NotRunning(t *testing.T) {
 	}
 }
 
+// TestRelayServer_Stop_AfterFailedStart verifies that Stop() is a no-op when
+// Start() could not bind its listen address.
+func TestRelayServer_Stop_AfterFailedStart(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	defer ln.Close()
+
+	cfg := DefaultConfig()
+	cfg.ListenAddr = ln.Addr().String()
+	srv := NewRelayServer(cfg)
+
+	if err := srv.Start(); err == nil {
+		srv.Stop()
+		t.Fatal("Start() on a busy address should return an error")
+	}
+	if srv.running.Load() {
+		t.Error("server should not be running after a failed Start()")
+	}
+	if err := srv.Stop(); err != nil {
+		t.Errorf("Stop() after failed Start() returned error: %v", err)
+	}
+}
+
 // TestRelayServer_RegisterRoute verifies that RegisterRoute stores the handler
 // and that it can be retrieved from the routes map.
 func TestRelayServer_RegisterRoute(t *testing.T) {
@@ -170,7 +286,7 @@ func TestRelayServer_RegisterRoute(t *testing.T) {
 	srv := NewRelayServer(cfg)
 
 	called := false
-	handler := func(msg *protocol.Message) (*protocol.Message, error) {
+	handler := func(msg RelayMessage) (RelayMessage, error) {
 		called = true
 		return nil, nil
 	}
@@ -191,7 +307,7 @@ func TestRelayServer_RegisterRoute(t *testing.T) {
 	}
 
 	// Invoke the handler to confirm it is the one we registered
-	_, _ = h(protocol.NewMessage(protocol.MessageTypeRequest, "from", "to", nil))
+	_, _ = h(WrapMessage(protocol.NewMessage(protocol.MessageTypeRequest, "from", "to", nil)))
 	if !called {
 		t.Error("registered handler was not invoked")
 	}
@@ -216,7 +332,7 @@ func TestRelayServer_RegisterRoute_Multiple(t *testing.T) {
 	actions := []string{"action.a", "action.b", "action.c"}
 	for _, a := range actions {
 		a := a
-		srv.RegisterRoute(a, func(msg *protocol.Message) (*protocol.Message, error) {
+		srv.RegisterRoute(a, func(msg RelayMessage) (RelayMessage, error) {
 			return nil, fmt.Errorf("handler-%s", a)
 		})
 	}
@@ -384,3 +500,460 @@ func TestExtractAction(t *testing.T) {
 		})
 	}
 }
+
+// ttlRecordingStore wraps a MemoryStore and records the TTL passed to Save.
+type ttlRecordingStore struct {
+	*storage.MemoryStore
+	ttls map[string]time.Duration
+}
+
+func newTTLRecordingStore() *ttlRecordingStore {
+	return &ttlRecordingStore{
+		MemoryStore: storage.NewMemoryStore(),
+		ttls:        make(map[string]time.Duration),
+	}
+}
+
+func (r *ttlRecordingStore) Save(msg *protocol.Message, ttl time.Duration) error {
+	r.ttls[msg.IDHex()] = ttl
+	return r.MemoryStore.Save(msg, ttl)
+}
+
+// TestRelayServer_TTLByType verifies that messages without an explicit TTL
+// are stored with the per-type TTL, falling back to DefaultTTL.
+func TestRelayServer_TTLByType(t *testing.T) {
+	store := newTTLRecordingStore()
+	cfg := DefaultConfig()
+	cfg.Storage = store
+	cfg.DefaultTTL = 5 * time.Minute
+	cfg.TTLByType = map[protocol.MessageType]time.Duration{
+		protocol.MessageTypeRequest: 30 * time.Second,
+	}
+	srv := NewRelayServer(cfg)
+
+	request := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:a", "relay-server", nil)
+	request.TTL = 0
+	event := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil)
+	event.TTL = 0
+	explicit := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:a", "relay-server", nil)
+	explicit.TTL = 2000 // milliseconds
+
+	if err := srv.handleRequest("client-1", request); err != nil {
+		t.Fatalf("handleRequest error: %v", err)
+	}
+	if err := srv.handleEvent("client-1", event); err != nil {
+		t.Fatalf("handleEvent error: %v", err)
+	}
+	if err := srv.handleRequest("client-1", explicit); err != nil {
+		t.Fatalf("handleRequest error: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		msg  *protocol.Message
+		want time.Duration
+	}{
+		{"request uses per-type TTL", request, 30 * time.Second},
+		{"event falls back to DefaultTTL", event, 5 * time.Minute},
+		{"explicit message TTL wins", explicit, 2 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := store.ttls[tc.msg.IDHex()]; got != tc.want {
+				t.Errorf("stored TTL = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRelayServer_TTLClamping verifies stored TTLs are bounded by MinTTL and
+// MaxTTL whatever the message requests.
+func TestRelayServer_TTLClamping(t *testing.T) {
+	store := newTTLRecordingStore()
+	cfg := DefaultConfig()
+	cfg.Storage = store
+	cfg.MinTTL = 10 * time.Second
+	cfg.MaxTTL = time.Hour
+	srv := NewRelayServer(cfg)
+
+	tests := []struct {
+		name  string
+		ttlMs uint64
+		want  time.Duration
+	}{
+		{"below min", 500, 10 * time.Second},
+		{"above max", uint64((72 * time.Hour).Milliseconds()), time.Hour},
+		{"in range", 60000, time.Minute},
+		{"default in range", 0, 5 * time.Minute},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil)
+			msg.TTL = tc.ttlMs
+			if err := srv.handleEvent("client-1", msg); err != nil {
+				t.Fatalf("handleEvent error: %v", err)
+			}
+			if got := store.ttls[msg.IDHex()]; got != tc.want {
+				t.Errorf("stored TTL = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestRelayServer_MaxBodyDepth verifies over-deep bodies are rejected with
+// body_too_deep while acceptable ones are processed.
+func TestRelayServer_MaxBodyDepth(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxBodyDepth = 2
+	srv := startTestServer(t, cfg)
+	srv.RegisterRoute("ping", func(msg RelayMessage) (RelayMessage, error) {
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), "pong")), nil
+	})
+	client := dialTestClient(t, srv)
+
+	ok := newActionRequest("ping")
+	ok.Body.(map[string]interface{})["args"] = []interface{}{"x"}
+	sendTestMessage(t, client, ok)
+	if resp := readTestMessage(t, client); resp.Type != protocol.MessageTypeResponse {
+		t.Fatalf("acceptable body: type 0x%02x code %q, want response", resp.Type, errorCode(resp))
+	}
+
+	deep := newActionRequest("ping")
+	deep.Body.(map[string]interface{})["args"] = []interface{}{[]interface{}{"x"}}
+	sendTestMessage(t, client, deep)
+	if resp := readTestMessage(t, client); errorCode(resp) != protocol.ErrCodeBodyTooDeep {
+		t.Errorf("deep body: code %q, want %q", errorCode(resp), protocol.ErrCodeBodyTooDeep)
+	}
+}
+
+// TestRelayServer_MaxResponseSize verifies an oversized handler response is
+// replaced by a response_too_large error.
+func TestRelayServer_MaxResponseSize(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MaxResponseSize = 1024
+	srv := startTestServer(t, cfg)
+	srv.RegisterRoute("dump", func(msg RelayMessage) (RelayMessage, error) {
+		size := 16
+		if body, ok := msg.Body().(map[interface{}]interface{}); ok && body["big"] == true {
+			size = 4096
+		}
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), make([]byte, size))), nil
+	})
+	client := dialTestClient(t, srv)
+
+	sendTestMessage(t, client, newActionRequest("dump"))
+	if resp := readTestMessage(t, client); resp.Type != protocol.MessageTypeResponse {
+		t.Fatalf("small response: got %s %q, want response", resp.Type.Name(), errorCode(resp))
+	}
+
+	big := newActionRequest("dump")
+	big.Body.(map[string]interface{})["big"] = true
+	sendTestMessage(t, client, big)
+	resp := readTestMessage(t, client)
+	if errorCode(resp) != "response_too_large" {
+		t.Fatalf("big response: got %s %q, want response_too_large", resp.Type.Name(), errorCode(resp))
+	}
+	if !bytes.Equal(resp.ReplyTo, big.ID) {
+		t.Errorf("error replies to %x, want the request %x", resp.ReplyTo, big.ID)
+	}
+}
+
+// TestRelayServer_AuthHandlerSignsWithServerKey verifies the configured
+// server identity reaches the RFC-002 handshake.
+func TestRelayServer_AuthHandlerSignsWithServerKey(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.ServerDID = "did:web:relay.example"
+	cfg.ServerKey = priv
+	srv := NewRelayServer(cfg)
+
+	frame, _ := json.Marshal(transport.AuthFrame{
+		Type:      "auth",
+		DID:       "did:web:alice",
+		Timestamp: time.Now().Unix(),
+		Nonce:     "nonce-123",
+	})
+	resp, err := srv.AuthHandler().HandleAuth(nil, frame)
+	if err != nil {
+		t.Fatalf("HandleAuth: %v", err)
+	}
+	if resp.ServerDID != cfg.ServerDID {
+		t.Errorf("ServerDID = %q, want %q", resp.ServerDID, cfg.ServerDID)
+	}
+	if !transport.VerifyServerSignature(pub, resp, "nonce-123", "did:web:alice") {
+		t.Error("auth_ok signature did not verify against the server key")
+	}
+}
+
+// ed25519Verifier checks auth signatures against known client keys
+type ed25519Verifier map[string]ed25519.PublicKey
+
+func (v ed25519Verifier) Verify(did string, signature []byte, nonce string) (bool, error) {
+	key, ok := v[did]
+	return ok && ed25519.Verify(key, []byte(nonce), signature), nil
+}
+
+// TestRelayServer_MutualAuthOnLiveConnection runs the handshake against a
+// running relay: the client's signed nonce is verified, and auth_ok carries
+// the relay's signature over it.
+func TestRelayServer_MutualAuthOnLiveConnection(t *testing.T) {
+	serverPub, serverKey, _ := ed25519.GenerateKey(nil)
+	alicePub, aliceKey, _ := ed25519.GenerateKey(nil)
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.ServerDID = "did:web:relay.example"
+	cfg.ServerKey = serverKey
+	cfg.SignatureVerifier = ed25519Verifier{"did:web:alice": alicePub}
+	srv := startTestServer(t, cfg)
+
+	authenticate := func(key ed25519.PrivateKey) (string, transport.AuthResponse) {
+		conn := dialTestClient(t, srv)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var challenge transport.ChallengeFrame
+		if err := conn.ReadJSON(&challenge); err != nil {
+			t.Fatalf("read challenge: %v", err)
+		}
+		conn.WriteJSON(transport.AuthFrame{
+			Type:      "auth",
+			DID:       "did:web:alice",
+			Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(challenge.Nonce))),
+			Algorithm: "ed25519",
+			Timestamp: time.Now().Unix(),
+			Nonce:     challenge.Nonce,
+		})
+		var resp transport.AuthResponse
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatalf("read auth response: %v", err)
+		}
+		return challenge.Nonce, resp
+	}
+
+	nonce, resp := authenticate(aliceKey)
+	if resp.Type != "auth_ok" {
+		t.Fatalf("auth response = %+v, want auth_ok", resp)
+	}
+	if !transport.VerifyServerSignature(serverPub, &resp, nonce, "did:web:alice") {
+		t.Error("auth_ok signature did not verify against the server key")
+	}
+
+	// A signature by any other key is refused
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	if _, resp := authenticate(otherKey); resp.ErrorCode != "invalid_signature" {
+		t.Errorf("wrong key: auth response = %+v, want invalid_signature", resp)
+	}
+}
+
+// TestRelayServer_HandshakeBindsDID verifies the DID a client authenticates
+// as is bound to its connection, so it is reachable by that DID and cannot
+// send as another.
+func TestRelayServer_HandshakeBindsDID(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, alice, "did:example:alice")
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+
+	if got := srv.clientDID(clientIDForDID(t, srv, "did:example:alice")); got != "did:example:alice" {
+		t.Fatalf("alice's connection is bound to %q", got)
+	}
+
+	// A message without a sender is sent as the bound DID
+	msg := protocol.NewMessage(protocol.MessageTypeRequest, "", "did:example:bob",
+		map[string]interface{}{"action": "unrouted"})
+	sendTestMessage(t, alice, msg)
+	if got := readTestMessage(t, bob); got.From != "did:example:alice" {
+		t.Errorf("forwarded From = %q, want did:example:alice", got.From)
+	}
+
+	// Claiming another DID is refused
+	spoofed := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:carol", "did:example:bob",
+		map[string]interface{}{"action": "unrouted"})
+	sendTestMessage(t, alice, spoofed)
+	if reply := readTestMessage(t, alice); errorCode(reply) != errCodeForbidden {
+		t.Errorf("spoofed sender got %s %q, want forbidden", reply.Type.Name(), errorCode(reply))
+	}
+}
+
+// TestRelayServer_MaxMessageAge verifies a long or unlimited TTL is capped at
+// MaxMessageAge and that messages older than it are purged, leaving the
+// relay's control state alone.
+func TestRelayServer_MaxMessageAge(t *testing.T) {
+	store := newTTLRecordingStore()
+	cfg := DefaultConfig()
+	cfg.Storage = store
+	cfg.DefaultTTL = 0
+	cfg.MaxMessageAge = time.Hour
+	srv := NewRelayServer(cfg)
+
+	long := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil)
+	long.TTL = uint64((72 * time.Hour).Milliseconds())
+	unlimited := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil)
+	unlimited.TTL = 0
+	short := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil)
+	short.TTL = 60000
+	for _, msg := range []*protocol.Message{long, unlimited, short} {
+		if err := srv.handleEvent("client-1", msg); err != nil {
+			t.Fatalf("handleEvent error: %v", err)
+		}
+	}
+	for name, tc := range map[string]struct {
+		msg  *protocol.Message
+		want time.Duration
+	}{
+		"long TTL capped":      {long, time.Hour},
+		"no expiry capped":     {unlimited, time.Hour},
+		"short TTL unaffected": {short, time.Minute},
+	} {
+		if got := store.ttls[tc.msg.IDHex()]; got != tc.want {
+			t.Errorf("%s: stored TTL = %v, want %v", name, got, tc.want)
+		}
+	}
+
+	// A message that slipped past the cap is purged once past the max age
+	stale := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil)
+	stale.Ts = uint64(time.Now().Add(-2 * time.Hour).UnixMilli())
+	store.MemoryStore.Save(stale, storage.NoExpiry)
+	if err := srv.contacts.save("did:example:a", "did:example:b", contactAccepted, "did:example:a"); err != nil {
+		t.Fatalf("save contact: %v", err)
+	}
+	srv.purgeAgedMessages()
+
+	if msg, _ := store.Get(stale.IDHex()); msg != nil {
+		t.Error("message older than MaxMessageAge was not purged")
+	}
+	if msg, _ := store.Get(long.IDHex()); msg == nil {
+		t.Error("fresh message was purged")
+	}
+	if state, _, _ := srv.contacts.load("did:example:a", "did:example:b"); state != contactAccepted {
+		t.Errorf("contact state after purge = %q, want %q", state, contactAccepted)
+	}
+}
+
+// TestRelayServer_StoreCleanup verifies the server starts the store's
+// background sweep at the configured CleanupInterval.
+func TestRelayServer_StoreCleanup(t *testing.T) {
+	store := storage.NewMemoryStore()
+	cfg := DefaultConfig()
+	cfg.Storage = store
+	cfg.CleanupInterval = 10 * time.Millisecond
+	startTestServer(t, cfg)
+
+	store.Save(protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "", nil), time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for store.Count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expired message was not swept")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// slowSaveStore wraps a MemoryStore and delays every Save
+type slowSaveStore struct {
+	*storage.MemoryStore
+	delay time.Duration
+}
+
+func (s *slowSaveStore) Save(msg *protocol.Message, ttl time.Duration) error {
+	time.Sleep(s.delay)
+	return s.MemoryStore.Save(msg, ttl)
+}
+
+// TestRelayServer_StorageTimeout verifies a store that outlives its timeout
+// is reported to the sender as storage_timeout
+func TestRelayServer_StorageTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Storage = storage.NewTimeoutStore(&slowSaveStore{MemoryStore: storage.NewMemoryStore(), delay: 200 * time.Millisecond}, 10*time.Millisecond)
+	srv := startTestServer(t, cfg)
+	alice := dialTestClient(t, srv)
+
+	sendTestMessage(t, alice, protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob",
+		map[string]interface{}{"action": "lookup"}))
+	if reply := readTestMessage(t, alice); errorCode(reply) != "storage_timeout" {
+		t.Errorf("got %s %q, want storage_timeout", reply.Type.Name(), errorCode(reply))
+	}
+}
+
+// TestRelayServer_OutboundEncodingFollowsSubprotocol verifies a client that
+// negotiated JSON sends and receives JSON text frames, while a CBOR client
+// on the same relay keeps receiving CBOR.
+func TestRelayServer_OutboundEncodingFollowsSubprotocol(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	srv.RegisterRoute("echo", echoRelayHandler)
+	cborClient := dialTestClient(t, srv)
+
+	dialer := websocket.Dialer{Subprotocols: []string{transport.SubprotocolAMPJSON}}
+	jsonClient, _, err := dialer.Dial("ws://"+srv.config.ListenAddr+"/amp/v1/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer jsonClient.Close()
+	if got := jsonClient.Subprotocol(); got != transport.SubprotocolAMPJSON {
+		t.Fatalf("negotiated subprotocol %q, want %q", got, transport.SubprotocolAMPJSON)
+	}
+	deadline := time.Now().Add(time.Second)
+	for srv.wsServer.GetClientCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	sendJSON := func(msg *protocol.Message) {
+		t.Helper()
+		data, err := json.Marshal(msg)
+		if err != nil {
+			t.Fatalf("json.Marshal: %v", err)
+		}
+		if err := jsonClient.WriteMessage(websocket.TextMessage, data); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+	}
+	readJSON := func() *protocol.Message {
+		t.Helper()
+		jsonClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+		frameType, data, err := jsonClient.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		if frameType != websocket.TextMessage {
+			t.Errorf("frame type = %d, want text", frameType)
+		}
+		msg := &protocol.Message{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			t.Fatalf("reply %q is not JSON: %v", data, err)
+		}
+		return msg
+	}
+
+	// A routed response comes back as JSON
+	req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:json", "relay-server",
+		map[string]interface{}{"action": "echo", "n": 1})
+	sendJSON(req)
+	if reply := readJSON(); reply.Type != protocol.MessageTypeResponse || !bytes.Equal(reply.ReplyTo, req.ID) {
+		t.Errorf("got %s replying to %x, want response to %x", reply.Type.Name(), reply.ReplyTo, req.ID)
+	}
+
+	// So does an error
+	sendJSON(protocol.NewMessage(protocol.MessageTypeResponse, "did:example:json", "", nil))
+	if reply := readJSON(); reply.Type != protocol.MessageTypeError {
+		t.Errorf("got %s, want error", reply.Type.Name())
+	} else if body, _ := reply.Body.(map[string]interface{}); body["code"] != "missing_destination" {
+		t.Errorf("error body = %v, want code missing_destination", reply.Body)
+	}
+
+	// An event from the CBOR client reaches the JSON client as JSON
+	event := protocol.NewMessage(protocol.MessageTypeEvent, "did:example:cbor", "", map[string]interface{}{"k": "v"})
+	sendTestMessage(t, cborClient, event)
+	if got := readJSON(); got.IDHex() != event.IDHex() {
+		t.Errorf("JSON client got %s, want the event", got.IDHex())
+	}
+
+	// The CBOR client still gets CBOR
+	sendTestMessage(t, cborClient, protocol.NewMessage(protocol.MessageTypeResponse, "did:example:cbor", "", nil))
+	if reply := readTestMessage(t, cborClient); errorCode(reply) != "missing_destination" {
+		t.Errorf("CBOR client got %s %q, want missing_destination", reply.Type.Name(), errorCode(reply))
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// resumeTokenField is the Hello / HelloACK body field carrying a resume token
+const resumeTokenField = "resume_token"
+
+// session is the resumable state of one client connection
+type session struct {
+	clientID      string
+	did           string
+	subscriptions []string  // DIDs whose presence the client watched
+	expires       time.Time // zero while the client is connected
+}
+
+// sessionManager issues resume tokens and keeps a departed client's state
+// for the resume window, so a reconnecting client can pick it up again
+type sessionManager struct {
+	window   time.Duration
+	now      func() time.Time
+	mu       sync.Mutex
+	byToken  map[string]*session
+	byClient map[string]string // client ID -> its current token
+}
+
+// newSessionManager creates a manager keeping state for window after a disconnect
+func newSessionManager(window time.Duration) *sessionManager {
+	return &sessionManager{
+		window:   window,
+		now:      time.Now,
+		byToken:  make(map[string]*session),
+		byClient: make(map[string]string),
+	}
+}
+
+// issue starts a session for clientID and returns its resume token,
+// replacing any token the client already held
+func (m *sessionManager) issue(clientID string) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	token := hex.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.byClient[clientID]; ok {
+		delete(m.byToken, old)
+	}
+	m.byToken[token] = &session{clientID: clientID}
+	m.byClient[clientID] = token
+	return token
+}
+
+// suspend records a departing client's state and starts its resume window.
+// Clients that never started a session are ignored.
+func (m *sessionManager) suspend(clientID, did string, subscriptions []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	token, ok := m.byClient[clientID]
+	if !ok {
+		return
+	}
+	delete(m.byClient, clientID)
+	sess := m.byToken[token]
+	sess.did = did
+	sess.subscriptions = subscriptions
+	sess.expires = m.now().Add(m.window)
+}
+
+// resume consumes token and returns the suspended session it names, if it
+// belonged to did. It reports false for unknown tokens, sessions whose client
+// is still connected or was another DID, and sessions past their resume
+// window; a token presented by another DID is left for its owner.
+func (m *sessionManager) resume(token, did string) (*session, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sess, ok := m.byToken[token]
+	if !ok || sess.expires.IsZero() || sess.did != did {
+		return nil, false
+	}
+	delete(m.byToken, token)
+	if !m.now().Before(sess.expires) {
+		return nil, false
+	}
+	return sess, true
+}
+
+// sweep drops suspended sessions whose resume window has passed
+func (m *sessionManager) sweep() {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for token, sess := range m.byToken {
+		if !sess.expires.IsZero() && !now.Before(sess.expires) {
+			delete(m.byToken, token)
+		}
+	}
+}
+
+// handleHello starts a session for the client, resuming the one named by a
+// resume token in the body if it is still within its window, and answers
+// with a HelloACK carrying the client's next resume token
+func (s *RelayServer) handleHello(clientID string, msg *protocol.Message) error {
+	if s.sessions == nil {
+		return s.sendErrorResponse(clientID, msg, "unsupported_type",
+			"session resumption is not enabled on this relay")
+	}
+
+	resumed := false
+	if token := bodyString(msg.Body, resumeTokenField); token != "" {
+		if sess, ok := s.sessions.resume(token, s.clientDID(clientID)); ok {
+			s.restoreSession(clientID, sess)
+			resumed = true
+		}
+	}
+
+	ack := protocol.NewMessage(protocol.MessageTypeHelloACK, s.serverIdentity(), msg.From, map[string]interface{}{
+		resumeTokenField:   s.sessions.issue(clientID),
+		"resumed":          resumed,
+		"resume_window_ms": s.config.SessionResumeWindow.Milliseconds(),
+	})
+	ack.ReplyTo = msg.ID
+	return s.forwardMessageToClient(clientID, ack)
+}
+
+// restoreSession moves a suspended session's presence subscriptions onto
+// clientID, which has authenticated as the session's DID. Requests pending
+// for the DID follow it, as they are keyed by requester DID rather than by
+// connection.
+func (s *RelayServer) restoreSession(clientID string, sess *session) {
+	for _, did := range sess.subscriptions {
+		s.presence.subscribe(did, clientID)
+	}
+	s.logger.Info("Resumed session", "client", clientID, "previous", sess.clientID,
+		"did", sess.did, "subscriptions", len(sess.subscriptions))
+}
+
+// bodyString returns the string field key of a map body, or ""
+func bodyString(body interface{}, key string) string {
+	switch b := body.(type) {
+	case map[interface{}]interface{}:
+		v, _ := b[key].(string)
+		return v
+	case map[string]interface{}:
+		v, _ := b[key].(string)
+		return v
+	}
+	return ""
+}
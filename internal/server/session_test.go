@@ -0,0 +1,138 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/gorilla/websocket"
+)
+
+// sendHello starts a session on conn, presenting token if non-empty, and
+// returns the next resume token and whether the old session was resumed
+func sendHello(t *testing.T, conn *websocket.Conn, from, token string) (string, bool) {
+	t.Helper()
+	var body map[string]interface{}
+	if token != "" {
+		body = map[string]interface{}{resumeTokenField: token}
+	}
+	sendTestMessage(t, conn, protocol.NewMessage(protocol.MessageTypeHello, from, "", body))
+	ack := readTestMessage(t, conn)
+	if ack.Type != protocol.MessageTypeHelloACK {
+		t.Fatalf("hello reply type = 0x%02x code %q, want HelloACK", ack.Type, errorCode(ack))
+	}
+	next := bodyString(ack.Body, resumeTokenField)
+	if next == "" || next == token {
+		t.Fatalf("HelloACK resume token = %q, want a fresh token", next)
+	}
+	resumed, _ := ack.Body.(map[interface{}]interface{})["resumed"].(bool)
+	return next, resumed
+}
+
+// waitForClients waits until the server tracks n clients
+func waitForClients(t *testing.T, srv *RelayServer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for srv.GetStats().ConnectedClients != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("ConnectedClients = %d, want %d", srv.GetStats().ConnectedClients, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRelayServer_ResumedSessionKeepsSubscriptions(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.SessionResumeWindow = time.Minute
+	srv := startTestServer(t, cfg)
+
+	bob := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, bob, "did:example:bob")
+	watcher := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, watcher, "did:example:watcher")
+	token, resumed := sendHello(t, watcher, "did:example:watcher", "")
+	if resumed {
+		t.Fatal("first hello reported a resumed session")
+	}
+	subscribePresence(t, watcher, "did:example:watcher", "did:example:bob")
+
+	// The watcher's connection drops
+	watcher.Close()
+	waitForClients(t, srv, 1)
+
+	// Another agent that got hold of the token can't take the session over
+	fresh := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, fresh, "did:example:other")
+	if _, resumed := sendHello(t, fresh, "did:example:other", token); resumed {
+		t.Error("another DID resumed the watcher's session")
+	}
+
+	// The watcher reconnects with its token
+	back := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, back, "did:example:watcher")
+	if _, resumed := sendHello(t, back, "did:example:watcher", token); !resumed {
+		t.Fatal("reconnect with a valid token was not resumed")
+	}
+	if _, resumed := sendHello(t, fresh, "did:example:other", token); resumed {
+		t.Error("a used token resumed a second session")
+	}
+	if id := clientIDForDID(t, srv, "did:example:watcher"); len(srv.presence.subscriptionsOf(id)) != 1 {
+		t.Errorf("resumed client subscriptions = %v, want did:example:bob", srv.presence.subscriptionsOf(id))
+	}
+
+	// Only the resumed connection hears that bob went offline
+	bob.Close()
+	expectOffline(t, back, "did:example:bob", disconnectClosed)
+	fresh.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := fresh.ReadMessage(); err == nil {
+		t.Error("fresh connection received a presence event")
+	}
+}
+
+func TestSessionManager_TokenLifecycle(t *testing.T) {
+	now := time.Now()
+	m := newSessionManager(time.Minute)
+	m.now = func() time.Time { return now }
+
+	token := m.issue("client-1")
+	if _, ok := m.resume(token, ""); ok {
+		t.Error("resumed a session whose client is still connected")
+	}
+
+	m.suspend("client-1", "did:example:alice", []string{"did:example:bob"})
+	if _, ok := m.resume(token, "did:example:mallory"); ok {
+		t.Error("resumed alice's session as another DID")
+	}
+	sess, ok := m.resume(token, "did:example:alice")
+	if !ok || sess.did != "did:example:alice" || len(sess.subscriptions) != 1 {
+		t.Fatalf("resume() = %+v, %v; want alice's session", sess, ok)
+	}
+	if _, ok := m.resume(token, "did:example:alice"); ok {
+		t.Error("token resumed twice")
+	}
+
+	stale := m.issue("client-2")
+	m.suspend("client-2", "did:example:carol", nil)
+	now = now.Add(2 * time.Minute)
+	if _, ok := m.resume(stale, "did:example:carol"); ok {
+		t.Error("resumed a session past its window")
+	}
+
+	m.issue("client-3")
+	m.suspend("client-3", "", nil)
+	now = now.Add(2 * time.Minute)
+	m.sweep()
+	if len(m.byToken) != 0 {
+		t.Errorf("sessions after sweep = %d, want 0", len(m.byToken))
+	}
+}
+
+func TestRelayServer_HelloWithoutResumption(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	client := dialTestClient(t, srv)
+	sendTestMessage(t, client, protocol.NewMessage(protocol.MessageTypeHello, "did:example:alice", "", nil))
+	if resp := readTestMessage(t, client); errorCode(resp) != "unsupported_type" {
+		t.Errorf("hello reply code = %q, want unsupported_type", errorCode(resp))
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/hex"
+	"sync"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// streamTracker counts the streams each client has open so a single client
+// cannot hold an unbounded number of them
+type streamTracker struct {
+	max  int // 0 means unlimited
+	mu   sync.Mutex
+	open map[string]map[string]struct{} // clientID -> open stream IDs
+}
+
+// newStreamTracker creates a tracker allowing max open streams per client
+func newStreamTracker(max int) *streamTracker {
+	return &streamTracker{max: max, open: make(map[string]map[string]struct{})}
+}
+
+// start records a new stream for clientID, returning false if the client is
+// already at its limit. Restarting a stream that is already open is allowed.
+func (t *streamTracker) start(clientID, streamID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	streams := t.open[clientID]
+	if _, exists := streams[streamID]; exists {
+		return true
+	}
+	if t.max > 0 && len(streams) >= t.max {
+		return false
+	}
+	if streams == nil {
+		streams = make(map[string]struct{})
+		t.open[clientID] = streams
+	}
+	streams[streamID] = struct{}{}
+	return true
+}
+
+// end forgets a stream once it has finished
+func (t *streamTracker) end(clientID, streamID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if streams, ok := t.open[clientID]; ok {
+		delete(streams, streamID)
+		if len(streams) == 0 {
+			delete(t.open, clientID)
+		}
+	}
+}
+
+// drop forgets every stream held by clientID
+func (t *streamTracker) drop(clientID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.open, clientID)
+}
+
+// count returns the number of streams clientID has open
+func (t *streamTracker) count(clientID string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.open[clientID])
+}
+
+// streamID identifies the stream a stream frame belongs to: its thread ID if
+// set, otherwise the StreamStart message ID, which later frames reference
+// through ReplyTo
+func streamID(msg *protocol.Message) string {
+	switch {
+	case len(msg.ThreadID) > 0:
+		return hex.EncodeToString(msg.ThreadID)
+	case msg.Type == protocol.MessageTypeStreamStart:
+		return msg.IDHex()
+	default:
+		return hex.EncodeToString(msg.ReplyTo)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func TestStreamTracker_Limit(t *testing.T) {
+	tr := newStreamTracker(2)
+
+	if !tr.start("c1", "s1") || !tr.start("c1", "s2") {
+		t.Fatal("streams within the limit should start")
+	}
+	if tr.start("c1", "s3") {
+		t.Error("third stream should be rejected")
+	}
+	if !tr.start("c1", "s1") {
+		t.Error("restarting an open stream should be allowed")
+	}
+	if !tr.start("c2", "s1") {
+		t.Error("limit should apply per client")
+	}
+
+	tr.end("c1", "s1")
+	if !tr.start("c1", "s3") {
+		t.Error("ending a stream should free a slot")
+	}
+
+	tr.drop("c1")
+	if n := tr.count("c1"); n != 0 {
+		t.Errorf("count after drop = %d, want 0", n)
+	}
+}
+
+func TestStreamTracker_Unlimited(t *testing.T) {
+	tr := newStreamTracker(0)
+	for i := 0; i < 100; i++ {
+		if !tr.start("c1", string(rune('a'+i))) {
+			t.Fatalf("stream %d rejected with no limit", i)
+		}
+	}
+}
+
+// TestRelayServer_MaxStreamsPerClient opens more streams than allowed and
+// verifies the excess is rejected while the open streams keep flowing.
+func TestRelayServer_MaxStreamsPerClient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RequireAuthHandshake = true
+	cfg.MaxStreamsPerClient = 2
+	srv := startTestServer(t, cfg)
+
+	receiver := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, receiver, "did:example:b")
+	sender := dialTestClient(t, srv)
+	bindTestClientDID(t, srv, sender, "did:example:a")
+
+	var starts []*protocol.Message
+	for i := 0; i < 2; i++ {
+		start := protocol.NewMessage(protocol.MessageTypeStreamStart, "did:example:a", "did:example:b", nil)
+		sendTestMessage(t, sender, start)
+		if got := readTestMessage(t, receiver); !bytes.Equal(got.ID, start.ID) {
+			t.Fatalf("stream %d was not relayed", i)
+		}
+		starts = append(starts, start)
+	}
+
+	excess := protocol.NewMessage(protocol.MessageTypeStreamStart, "did:example:a", "did:example:b", nil)
+	sendTestMessage(t, sender, excess)
+	resp := readTestMessage(t, sender)
+	if code := errorCode(resp); code != "too_many_streams" || !bytes.Equal(resp.ReplyTo, excess.ID) {
+		t.Fatalf("excess stream: code = %q, want too_many_streams", code)
+	}
+
+	// Existing streams continue
+	data := protocol.NewMessage(protocol.MessageTypeStreamData, "did:example:a", "did:example:b", "chunk")
+	data.ReplyTo = starts[0].ID
+	sendTestMessage(t, sender, data)
+	if got := readTestMessage(t, receiver); !bytes.Equal(got.ID, data.ID) {
+		t.Error("data for an open stream was not relayed")
+	}
+
+	// Ending a stream frees a slot for a new one
+	end := protocol.NewMessage(protocol.MessageTypeStreamEnd, "did:example:a", "did:example:b", nil)
+	end.ReplyTo = starts[0].ID
+	sendTestMessage(t, sender, end)
+	readTestMessage(t, receiver)
+
+	next := protocol.NewMessage(protocol.MessageTypeStreamStart, "did:example:a", "did:example:b", nil)
+	sendTestMessage(t, sender, next)
+	if got := readTestMessage(t, receiver); !bytes.Equal(got.ID, next.ID) {
+		t.Error("new stream after StreamEnd was not relayed")
+	}
+}
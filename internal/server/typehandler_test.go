@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func TestRelayServer_TypeHandlerOverridesPing(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	srv.RegisterTypeHandler(protocol.MessageTypePing, func(msg RelayMessage) (RelayMessage, error) {
+		return WrapMessage(protocol.NewMessage(protocol.MessageTypePong, "relay-server", msg.From(), "custom pong")), nil
+	})
+	client := dialTestClient(t, srv)
+
+	ping := protocol.NewMessage(protocol.MessageTypePing, "did:example:alice", "", nil)
+	sendTestMessage(t, client, ping)
+	resp := readTestMessage(t, client)
+	if resp.Type != protocol.MessageTypePong || resp.Body != "custom pong" {
+		t.Errorf("reply = type 0x%02x body %v, want custom pong", resp.Type, resp.Body)
+	}
+	if !bytes.Equal(resp.ReplyTo, ping.ID) {
+		t.Errorf("reply ReplyTo = %x, want ping ID %x", resp.ReplyTo, ping.ID)
+	}
+
+	// Removing the override restores the built-in pong
+	srv.UnregisterTypeHandler(protocol.MessageTypePing)
+	sendTestMessage(t, client, ping)
+	resp = readTestMessage(t, client)
+	if resp.Type != protocol.MessageTypePong || resp.Body != nil {
+		t.Errorf("default reply = type 0x%02x body %v, want empty pong", resp.Type, resp.Body)
+	}
+}
+
+func TestRelayServer_TypeHandlerErrors(t *testing.T) {
+	srv := startTestServer(t, DefaultConfig())
+	srv.RegisterTypeHandler(protocol.MessageTypePresence, func(msg RelayMessage) (RelayMessage, error) {
+		return nil, errors.New("presence unavailable")
+	})
+	client := dialTestClient(t, srv)
+
+	// Presence has no built-in handling; the override still answers it
+	sendTestMessage(t, client, protocol.NewMessage(protocol.MessageTypePresence, "did:example:alice", "", nil))
+	if resp := readTestMessage(t, client); errorCode(resp) != "handler_error" {
+		t.Errorf("error code = %q, want handler_error", errorCode(resp))
+	}
+}
+
+func TestRelayServer_HandlerPrecedenceOnCollision(t *testing.T) {
+	logs := &syncBuffer{}
+	cfg := DefaultConfig()
+	cfg.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	srv := startTestServer(t, cfg)
+	reply := func(body string) RouteHandler {
+		return func(msg RelayMessage) (RelayMessage, error) {
+			return WrapMessage(protocol.NewMessage(protocol.MessageTypeResponse, "relay-server", msg.From(), body)), nil
+		}
+	}
+	client := dialTestClient(t, srv)
+
+	// A route named after a control type does not intercept that type
+	srv.RegisterRoute("ping", reply("route"))
+	sendTestMessage(t, client, protocol.NewMessage(protocol.MessageTypePing, "did:example:alice", "", nil))
+	if resp := readTestMessage(t, client); resp.Type != protocol.MessageTypePong || resp.Body != nil {
+		t.Errorf("protocol ping reply = type 0x%02x body %v, want built-in pong", resp.Type, resp.Body)
+	}
+	sendTestMessage(t, client, newActionRequest("ping"))
+	if resp := readTestMessage(t, client); resp.Body != "route" {
+		t.Errorf("ping request body = %v, want route", resp.Body)
+	}
+
+	// Registering the action again replaces the earlier route
+	srv.RegisterRoute("ping", reply("second route"))
+	sendTestMessage(t, client, newActionRequest("ping"))
+	if resp := readTestMessage(t, client); resp.Body != "second route" {
+		t.Errorf("ping request body after re-registering = %v, want second route", resp.Body)
+	}
+
+	// A type handler for requests wins over the action routes
+	srv.RegisterTypeHandler(protocol.MessageTypeRequest, TypeHandler(reply("type handler")))
+	sendTestMessage(t, client, newActionRequest("ping"))
+	if resp := readTestMessage(t, client); resp.Body != "type handler" {
+		t.Errorf("ping request body with type handler = %v, want type handler", resp.Body)
+	}
+
+	var replaced, overridden bool
+	for _, rec := range logs.records(t) {
+		switch rec["msg"] {
+		case "Replacing route handler":
+			replaced = rec["action"] == "ping"
+		case "Type handler overrides built-in handling":
+			overridden = rec["type"] == "request"
+		}
+	}
+	if !replaced || !overridden {
+		t.Errorf("logged route replacement %v, built-in override %v; want both", replaced, overridden)
+	}
+}
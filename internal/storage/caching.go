@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// defaultCacheReadTTL bounds how long a message read from the backing store
+// stays cached, since the backing store doesn't report its expiry
+const defaultCacheReadTTL = time.Minute
+
+// CachingStore fronts a slower MessageStore with a bounded in-memory LRU of
+// messages. Gets are served from the cache when possible, Saves write
+// through to the backing store, and Deletes invalidate. Listings always go
+// to the backing store.
+type CachingStore struct {
+	backing  MessageStore
+	capacity int
+	readTTL  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // Value is *cacheEntry
+	lru     *list.List               // most recently used at the front
+
+	// gen changes on every write, so a Get that raced one doesn't cache a
+	// message the write replaced or removed
+	gen uint64
+}
+
+type cacheEntry struct {
+	id      string
+	message *protocol.Message
+	expiry  time.Time // zero = until evicted or invalidated
+}
+
+// NewCachingStore caches up to capacity messages from backing
+func NewCachingStore(backing MessageStore, capacity int) *CachingStore {
+	return &CachingStore{
+		backing:  backing,
+		capacity: capacity,
+		readTTL:  defaultCacheReadTTL,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// SetReadTTL sets how long a message loaded from the backing store on a
+// miss may stay cached. Messages cached by Save follow their Save TTL.
+func (cs *CachingStore) SetReadTTL(d time.Duration) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.readTTL = d
+}
+
+// Save writes the message to the backing store, then caches it until its TTL
+func (cs *CachingStore) Save(message *protocol.Message, ttl time.Duration) error {
+	id := message.IDHex()
+	if err := cs.backing.Save(message, ttl); err != nil {
+		cs.invalidate(id)
+		return err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.gen++
+	if ttl == 0 {
+		// Expired on arrival; nothing to serve later
+		cs.removeLocked(id)
+		return nil
+	}
+	var expiry time.Time
+	if ttl > 0 {
+		expiry = time.Now().Add(ttl)
+	}
+	cs.putLocked(id, message, expiry)
+	return nil
+}
+
+// Get returns a cached message, or loads it from the backing store and
+// caches it
+func (cs *CachingStore) Get(id string) (*protocol.Message, error) {
+	cs.mu.Lock()
+	if elem, ok := cs.entries[id]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.expiry.IsZero() || time.Now().Before(entry.expiry) {
+			cs.lru.MoveToFront(elem)
+			cs.mu.Unlock()
+			return entry.message, nil
+		}
+		cs.removeLocked(id)
+	}
+	gen := cs.gen
+	cs.mu.Unlock()
+
+	msg, err := cs.backing.Get(id)
+	if err != nil || msg == nil {
+		return msg, err
+	}
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.gen == gen && cs.readTTL > 0 {
+		cs.putLocked(id, msg, readExpiry(msg, time.Now().Add(cs.readTTL)))
+	}
+	return msg, nil
+}
+
+// Delete removes the message from the backing store and the cache
+func (cs *CachingStore) Delete(id string) error {
+	err := cs.backing.Delete(id)
+	cs.invalidate(id)
+	return err
+}
+
+// Pop retrieves and deletes the message in the backing store, dropping it
+// from the cache
+func (cs *CachingStore) Pop(id string) (*protocol.Message, error) {
+	msg, err := cs.backing.Pop(id)
+	cs.invalidate(id)
+	return msg, err
+}
+
+// List returns all messages from the backing store
+func (cs *CachingStore) List() ([]*protocol.Message, error) {
+	return cs.backing.List()
+}
+
+// ListFiltered returns the messages accepted by filter from the backing store
+func (cs *CachingStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	return cs.backing.ListFiltered(filter)
+}
+
+// ListByRecipient returns the messages addressed to did from the backing store
+func (cs *CachingStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	return cs.backing.ListByRecipient(did)
+}
+
+// Clear empties the backing store and the cache
+func (cs *CachingStore) Clear() error {
+	err := cs.backing.Clear()
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.gen++
+	cs.entries = make(map[string]*list.Element)
+	cs.lru.Init()
+	return err
+}
+
+// Subscribe implements Notifier using the backing store. If it cannot
+// notify, the returned channel never fires.
+func (cs *CachingStore) Subscribe(filter MessageFilter) (<-chan *protocol.Message, func()) {
+	if n, ok := cs.backing.(Notifier); ok {
+		return n.Subscribe(filter)
+	}
+	return nil, func() {}
+}
+
+// Len returns the number of cached messages, including expired ones not yet dropped
+func (cs *CachingStore) Len() int {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.lru.Len()
+}
+
+// invalidate drops id from the cache after a write
+func (cs *CachingStore) invalidate(id string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.gen++
+	cs.removeLocked(id)
+}
+
+// putLocked caches a message, evicting the least recently used past capacity
+func (cs *CachingStore) putLocked(id string, msg *protocol.Message, expiry time.Time) {
+	if cs.capacity <= 0 {
+		return
+	}
+	if elem, ok := cs.entries[id]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.message, entry.expiry = msg, expiry
+		cs.lru.MoveToFront(elem)
+		return
+	}
+	cs.entries[id] = cs.lru.PushFront(&cacheEntry{id: id, message: msg, expiry: expiry})
+	for cs.lru.Len() > cs.capacity {
+		cs.removeLocked(cs.lru.Back().Value.(*cacheEntry).id)
+	}
+}
+
+func (cs *CachingStore) removeLocked(id string) {
+	if elem, ok := cs.entries[id]; ok {
+		cs.lru.Remove(elem)
+		delete(cs.entries, id)
+	}
+}
+
+// readExpiry caps limit by the message's own expiry, its timestamp plus TTL
+func readExpiry(msg *protocol.Message, limit time.Time) time.Time {
+	if msg.TTL == 0 {
+		return limit
+	}
+	own := time.UnixMilli(int64(msg.Ts)).Add(time.Duration(msg.TTL) * time.Millisecond)
+	if own.Before(limit) {
+		return own
+	}
+	return limit
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+// newCachingTestStore fronts a metered memory store, so tests can see which
+// calls reached the backing store
+func newCachingTestStore(capacity int) (*CachingStore, *MeteredStore) {
+	backing := NewMeteredStore(NewMemoryStore())
+	return NewCachingStore(backing, capacity), backing
+}
+
+func TestCachingStore_GetHitsCache(t *testing.T) {
+	cache, backing := newCachingTestStore(4)
+	msg := newTestMsg("source", "dest")
+	backing.Save(msg, time.Minute)
+
+	// The first Get loads from the backing store, later ones don't
+	for i := 0; i < 3; i++ {
+		if got, err := cache.Get(msg.IDHex()); err != nil || got != msg {
+			t.Fatalf("Get #%d = %v, %v; want the message", i, got, err)
+		}
+	}
+	if gets := backing.Metrics().Op(OpGet).Count; gets != 1 {
+		t.Errorf("backing store saw %d Gets, want 1", gets)
+	}
+
+	// Misses aren't cached
+	cache.Get("missing")
+	cache.Get("missing")
+	if misses := backing.Metrics().Misses; misses != 2 {
+		t.Errorf("backing store saw %d misses, want 2", misses)
+	}
+}
+
+func TestCachingStore_WriteThrough(t *testing.T) {
+	cache, backing := newCachingTestStore(4)
+	msg := newTestMsg("source", "dest")
+
+	if err := cache.Save(msg, time.Minute); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got, _ := backing.Get(msg.IDHex()); got != msg {
+		t.Fatal("Save didn't reach the backing store")
+	}
+	if got, _ := cache.Get(msg.IDHex()); got != msg {
+		t.Fatal("Get lost a saved message")
+	}
+	if gets := backing.Metrics().Op(OpGet).Count; gets != 1 {
+		t.Errorf("Get after Save went to the backing store (%d backing Gets, want only the check above)", gets)
+	}
+
+	// Delete invalidates
+	cache.Delete(msg.IDHex())
+	if got, _ := cache.Get(msg.IDHex()); got != nil {
+		t.Error("Get returned a deleted message")
+	}
+	if got, _ := backing.Get(msg.IDHex()); got != nil {
+		t.Error("Delete didn't reach the backing store")
+	}
+}
+
+func TestCachingStore_RespectsTTL(t *testing.T) {
+	cache, _ := newCachingTestStore(4)
+	short := newTestMsg("source", "dest")
+	cache.Save(short, 20*time.Millisecond)
+	instant := newTestMsg("source", "dest")
+	cache.Save(instant, 0)
+
+	if got, _ := cache.Get(instant.IDHex()); got != nil {
+		t.Error("Get returned a message saved with TTL 0")
+	}
+	if got, _ := cache.Get(short.IDHex()); got == nil {
+		t.Fatal("Get lost a message before its TTL")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if got, _ := cache.Get(short.IDHex()); got != nil {
+		t.Error("Get served a cached message past its TTL")
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("cache holds %d entries after expiry, want 0", n)
+	}
+}
+
+func TestCachingStore_ReadTTL(t *testing.T) {
+	cache, backing := newCachingTestStore(4)
+	cache.SetReadTTL(20 * time.Millisecond)
+	msg := newTestMsg("source", "dest")
+	backing.Save(msg, time.Minute)
+
+	cache.Get(msg.IDHex())
+	cache.Get(msg.IDHex())
+	time.Sleep(30 * time.Millisecond)
+	cache.Get(msg.IDHex())
+	if gets := backing.Metrics().Op(OpGet).Count; gets != 2 {
+		t.Errorf("backing store saw %d Gets, want a reload once the read TTL passed", gets)
+	}
+}
+
+func TestCachingStore_EvictsLeastRecentlyUsed(t *testing.T) {
+	cache, backing := newCachingTestStore(2)
+	a, b, c := newTestMsg("a", "dest"), newTestMsg("b", "dest"), newTestMsg("c", "dest")
+	cache.Save(a, time.Minute)
+	cache.Save(b, time.Minute)
+	cache.Get(a.IDHex()) // b is now least recently used
+	cache.Save(c, time.Minute)
+
+	if n := cache.Len(); n != 2 {
+		t.Fatalf("cache holds %d entries, want 2", n)
+	}
+	cache.Get(a.IDHex())
+	cache.Get(c.IDHex())
+	if gets := backing.Metrics().Op(OpGet).Count; gets != 0 {
+		t.Errorf("recently used messages went to the backing store %d times", gets)
+	}
+	if got, _ := cache.Get(b.IDHex()); got != b {
+		t.Fatal("evicted message wasn't reloaded from the backing store")
+	}
+	if gets := backing.Metrics().Op(OpGet).Count; gets != 1 {
+		t.Errorf("backing store saw %d Gets, want 1 for the evicted message", gets)
+	}
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// Codec serializes messages for stores that hold bytes rather than
+// *protocol.Message values, keeping the storage format independent of the
+// wire format
+type Codec interface {
+	Marshal(msg *protocol.Message) ([]byte, error)
+	Unmarshal(data []byte) (*protocol.Message, error)
+}
+
+// CBORCodec stores messages in their CBOR wire encoding
+type CBORCodec struct{}
+
+// Marshal encodes msg as CBOR
+func (CBORCodec) Marshal(msg *protocol.Message) ([]byte, error) {
+	return msg.CBORMarshal()
+}
+
+// Unmarshal decodes a CBOR message
+func (CBORCodec) Unmarshal(data []byte) (*protocol.Message, error) {
+	msg := &protocol.Message{}
+	if err := msg.CBORUnmarshal(data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// JSONCodec stores messages as JSON, which is easier to inspect at rest.
+// Body values come back as their JSON types, e.g. numbers as float64.
+type JSONCodec struct{}
+
+// Marshal encodes msg as JSON
+func (JSONCodec) Marshal(msg *protocol.Message) ([]byte, error) {
+	return msg.JSONMarshal()
+}
+
+// Unmarshal decodes a JSON message
+func (JSONCodec) Unmarshal(data []byte) (*protocol.Message, error) {
+	msg := &protocol.Message{}
+	if err := msg.JSONUnmarshal(data); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// EncodedStore implements MessageStore by holding each message serialized
+// with a Codec. Every Get and List decodes a fresh copy, so callers never
+// share a stored message. It is the in-memory counterpart of file and
+// Redis backends and has no eviction.
+type EncodedStore struct {
+	codec    Codec
+	messages map[string]encodedMessage
+	mutex    sync.RWMutex
+}
+
+type encodedMessage struct {
+	data   []byte
+	expiry time.Time
+}
+
+// NewEncodedStore creates a store serializing messages with codec
+func NewEncodedStore(codec Codec) *EncodedStore {
+	return &EncodedStore{
+		codec:    codec,
+		messages: make(map[string]encodedMessage),
+	}
+}
+
+// Save encodes and stores a message for ttl; see MessageStore.Save for zero
+// and negative TTLs
+func (es *EncodedStore) Save(message *protocol.Message, ttl time.Duration) error {
+	data, err := es.codec.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	var expiry time.Time
+	if ttl >= 0 {
+		expiry = time.Now().Add(ttl)
+	}
+
+	es.mutex.Lock()
+	es.messages[message.IDHex()] = encodedMessage{data: data, expiry: expiry}
+	es.mutex.Unlock()
+	return nil
+}
+
+// Get decodes a message by ID, or returns nil if it is missing or expired
+func (es *EncodedStore) Get(id string) (*protocol.Message, error) {
+	es.mutex.RLock()
+	stored, exists := es.messages[id]
+	es.mutex.RUnlock()
+
+	if !exists || stored.expired(time.Now()) {
+		return nil, nil
+	}
+	return es.decode(stored.data)
+}
+
+// Delete removes a message by ID
+func (es *EncodedStore) Delete(id string) error {
+	es.mutex.Lock()
+	delete(es.messages, id)
+	es.mutex.Unlock()
+	return nil
+}
+
+// Pop decodes and removes a message by ID in one step, or returns nil if it
+// is missing or expired
+func (es *EncodedStore) Pop(id string) (*protocol.Message, error) {
+	es.mutex.Lock()
+	stored, exists := es.messages[id]
+	delete(es.messages, id)
+	es.mutex.Unlock()
+
+	if !exists || stored.expired(time.Now()) {
+		return nil, nil
+	}
+	return es.decode(stored.data)
+}
+
+// List returns all non-expired messages
+func (es *EncodedStore) List() ([]*protocol.Message, error) {
+	return es.ListFiltered(nil)
+}
+
+// ListFiltered returns all non-expired messages accepted by filter, dropping
+// expired ones as it goes. A nil filter accepts every message.
+func (es *EncodedStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	es.mutex.Lock()
+	defer es.mutex.Unlock()
+
+	var result []*protocol.Message
+	now := time.Now()
+	for id, stored := range es.messages {
+		if stored.expired(now) {
+			delete(es.messages, id)
+			continue
+		}
+		msg, err := es.decode(stored.data)
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil && !filter(msg) {
+			continue
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+// ListByRecipient returns all non-expired messages addressed to did
+func (es *EncodedStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	return es.ListFiltered(func(msg *protocol.Message) bool { return msg.To == did })
+}
+
+// Clear removes all messages
+func (es *EncodedStore) Clear() error {
+	es.mutex.Lock()
+	es.messages = make(map[string]encodedMessage)
+	es.mutex.Unlock()
+	return nil
+}
+
+func (es *EncodedStore) decode(data []byte) (*protocol.Message, error) {
+	msg, err := es.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+	return msg, nil
+}
+
+// expired reports whether the message has passed its expiry
+func (em encodedMessage) expired(now time.Time) bool {
+	return !em.expiry.IsZero() && !now.Before(em.expiry)
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// codecTestMessage fills every field a codec has to carry
+func codecTestMessage() *protocol.Message {
+	msg := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:alice", "did:example:bob", map[string]interface{}{
+		"action": "lookup",
+		"count":  3,
+		"tags":   []interface{}{"a", "b"},
+		"nested": map[interface{}]interface{}{"ok": true},
+	})
+	msg.ReplyTo = bytes.Repeat([]byte{1}, 16)
+	msg.ThreadID = bytes.Repeat([]byte{2}, 16)
+	msg.Sig = bytes.Repeat([]byte{3}, 64)
+	msg.Ext = map[string]interface{}{"amp.trace": "abc"}
+	msg.Headers = map[string]string{"kid": "key-1"}
+	return msg
+}
+
+func TestEncodedStore_RoundTrip(t *testing.T) {
+	codecs := map[string]Codec{"cbor": CBORCodec{}, "json": JSONCodec{}}
+	for name, codec := range codecs {
+		t.Run(name, func(t *testing.T) {
+			store := NewEncodedStore(codec)
+			msg := codecTestMessage()
+			if err := store.Save(msg, time.Minute); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+
+			got, err := store.Get(msg.IDHex())
+			if err != nil || got == nil {
+				t.Fatalf("Get = %v, %v; want the message", got, err)
+			}
+			if got == msg {
+				t.Error("Get returned the saved pointer, want a decoded copy")
+			}
+
+			// Body values come back in the codec's types, so compare the
+			// canonical JSON of both messages
+			want, _ := msg.JSONMarshal()
+			have, err := got.JSONMarshal()
+			if err != nil {
+				t.Fatalf("JSONMarshal of decoded message: %v", err)
+			}
+			if !bytes.Equal(have, want) {
+				t.Errorf("round trip changed the message\n got: %s\nwant: %s", have, want)
+			}
+
+			listed, err := store.ListFiltered(func(m *protocol.Message) bool { return m.From == "did:example:alice" })
+			if err != nil || len(listed) != 1 || listed[0].IDHex() != msg.IDHex() {
+				t.Errorf("ListFiltered = %d messages, %v; want the message", len(listed), err)
+			}
+		})
+	}
+}
+
+func TestEncodedStore_TTL(t *testing.T) {
+	store := NewEncodedStore(CBORCodec{})
+	kept := newTestMsg("source", "dest")
+	expired := newTestMsg("source", "dest")
+	store.Save(kept, NoExpiry)
+	store.Save(expired, 0)
+
+	if got, _ := store.Get(expired.IDHex()); got != nil {
+		t.Error("Get returned a message saved with TTL 0")
+	}
+	if got, _ := store.Get(kept.IDHex()); got == nil {
+		t.Error("Get lost a message saved with NoExpiry")
+	}
+	if list, _ := store.List(); len(list) != 1 {
+		t.Errorf("List = %d messages, want 1", len(list))
+	}
+
+	store.Delete(kept.IDHex())
+	if got, _ := store.Get(kept.IDHex()); got != nil {
+		t.Error("Get returned a deleted message")
+	}
+}
+
+func TestEncodedStore_CorruptData(t *testing.T) {
+	store := NewEncodedStore(JSONCodec{})
+	store.messages["bad"] = encodedMessage{data: []byte("{not json")}
+	if _, err := store.Get("bad"); err == nil {
+		t.Error("Get of corrupt data returned no error")
+	}
+}
@@ -0,0 +1,316 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/fxamacker/cbor/v2"
+)
+
+// File names inside a FileStore directory
+const (
+	fileIndexName  = "index.cbor" // message ID -> expiry
+	fileMessageExt = ".msg"       // suffix of each message blob
+	fileTempPrefix = ".tmp-"      // files being written, ignored when listing
+)
+
+// FileStore implements MessageStore on disk: each message is a blob named
+// by its ID hex, and a sidecar index records every message's expiry. Both
+// are written to a temporary file and renamed into place, so a crash leaves
+// either the old or the new version. The index is rewritten on every change,
+// which suits modest stores; it is kept in memory and read once at open.
+type FileStore struct {
+	dir   string
+	codec Codec
+
+	mutex sync.RWMutex
+	index map[string]int64 // message ID hex -> expiry in Unix nanoseconds (0 = none)
+}
+
+// NewFileStore opens or creates a store in dir, keeping messages as CBOR
+func NewFileStore(dir string) (*FileStore, error) {
+	return NewFileStoreWithCodec(dir, CBORCodec{})
+}
+
+// NewFileStoreWithCodec opens or creates a store in dir, serializing
+// messages with codec. Messages saved by an earlier run are loaded from the
+// index; blobs the index doesn't know, left by an interrupted Save, are removed.
+func NewFileStoreWithCodec(dir string, codec Codec) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	fs := &FileStore{dir: dir, codec: codec, index: make(map[string]int64)}
+
+	data, err := os.ReadFile(filepath.Join(dir, fileIndexName))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read store index: %w", err)
+	default:
+		if err := cbor.Unmarshal(data, &fs.index); err != nil {
+			return nil, fmt.Errorf("failed to decode store index: %w", err)
+		}
+	}
+
+	ids, err := fs.blobIDs()
+	if err != nil {
+		return nil, err
+	}
+	onDisk := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		onDisk[id] = true
+		if _, ok := fs.index[id]; !ok {
+			os.Remove(fs.blobPath(id))
+		}
+	}
+	for id := range fs.index {
+		if !onDisk[id] {
+			delete(fs.index, id)
+		}
+	}
+	return fs, nil
+}
+
+// Save writes a message for ttl; see MessageStore.Save for zero and negative TTLs
+func (fs *FileStore) Save(message *protocol.Message, ttl time.Duration) error {
+	data, err := fs.codec.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	var expiry int64
+	if ttl >= 0 {
+		expiry = time.Now().Add(ttl).UnixNano()
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	id := message.IDHex()
+	if err := fs.writeFile(fs.blobPath(id), data); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	fs.index[id] = expiry
+	return fs.writeIndexLocked()
+}
+
+// Get reads a message by ID, deleting it instead if it has expired
+func (fs *FileStore) Get(id string) (*protocol.Message, error) {
+	fs.mutex.RLock()
+	expiry, ok := fs.index[id]
+	fs.mutex.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	if indexExpired(expiry, time.Now()) {
+		return nil, fs.Delete(id)
+	}
+	return fs.read(id)
+}
+
+// Delete removes a message by ID
+func (fs *FileStore) Delete(id string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if _, ok := fs.index[id]; !ok {
+		return nil
+	}
+	return fs.removeLocked([]string{id})
+}
+
+// Pop reads and removes a message by ID under the store lock, or returns
+// nil if it is missing or expired
+func (fs *FileStore) Pop(id string) (*protocol.Message, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	expiry, ok := fs.index[id]
+	if !ok {
+		return nil, nil
+	}
+	var msg *protocol.Message
+	if !indexExpired(expiry, time.Now()) {
+		var err error
+		if msg, err = fs.read(id); err != nil {
+			return nil, err
+		}
+	}
+	if err := fs.removeLocked([]string{id}); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// List returns all non-expired messages
+func (fs *FileStore) List() ([]*protocol.Message, error) {
+	return fs.ListFiltered(nil)
+}
+
+// ListFiltered walks the directory and returns the non-expired messages
+// accepted by filter, deleting expired ones as it goes. A nil filter
+// accepts every message.
+func (fs *FileStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	ids, err := fs.blobIDs()
+	if err != nil {
+		return nil, err
+	}
+	var result []*protocol.Message
+	var dead []string
+	now := time.Now()
+	for _, id := range ids {
+		expiry, ok := fs.index[id]
+		if !ok {
+			continue // an interrupted Save
+		}
+		if indexExpired(expiry, now) {
+			dead = append(dead, id)
+			continue
+		}
+		msg, err := fs.read(id)
+		if err != nil {
+			return nil, err
+		}
+		if filter != nil && !filter(msg) {
+			continue
+		}
+		result = append(result, msg)
+	}
+	if len(dead) > 0 {
+		if err := fs.removeLocked(dead); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// ListByRecipient returns all non-expired messages addressed to did. The
+// file store keeps no recipient index, so this walks the directory.
+func (fs *FileStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	return fs.ListFiltered(func(msg *protocol.Message) bool { return msg.To == did })
+}
+
+// Clear removes all messages
+func (fs *FileStore) Clear() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	ids := make([]string, 0, len(fs.index))
+	for id := range fs.index {
+		ids = append(ids, id)
+	}
+	return fs.removeLocked(ids)
+}
+
+// Sweep deletes every expired message and returns how many were removed
+func (fs *FileStore) Sweep() int {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	var dead []string
+	now := time.Now()
+	for id, expiry := range fs.index {
+		if indexExpired(expiry, now) {
+			dead = append(dead, id)
+		}
+	}
+	if len(dead) == 0 || fs.removeLocked(dead) != nil {
+		return 0
+	}
+	return len(dead)
+}
+
+// StartCleanup runs Sweep every interval in a background goroutine until
+// ctx is done or the returned stop function is called; see
+// MemoryStore.StartCleanup
+func (fs *FileStore) StartCleanup(ctx context.Context, interval time.Duration) (stop func()) {
+	return startSweeper(ctx, interval, fs.Sweep)
+}
+
+// read decodes a message blob
+func (fs *FileStore) read(id string) (*protocol.Message, error) {
+	data, err := os.ReadFile(fs.blobPath(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message: %w", err)
+	}
+	msg, err := fs.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message %s: %w", id, err)
+	}
+	return msg, nil
+}
+
+// removeLocked deletes the blobs of ids and drops them from the index
+func (fs *FileStore) removeLocked(ids []string) error {
+	for _, id := range ids {
+		if err := os.Remove(fs.blobPath(id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete message: %w", err)
+		}
+		delete(fs.index, id)
+	}
+	return fs.writeIndexLocked()
+}
+
+// writeIndexLocked persists the index
+func (fs *FileStore) writeIndexLocked() error {
+	data, err := cbor.Marshal(fs.index)
+	if err != nil {
+		return fmt.Errorf("failed to encode store index: %w", err)
+	}
+	if err := fs.writeFile(filepath.Join(fs.dir, fileIndexName), data); err != nil {
+		return fmt.Errorf("failed to write store index: %w", err)
+	}
+	return nil
+}
+
+// writeFile replaces path with data by way of a renamed temporary file
+func (fs *FileStore) writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(fs.dir, fileTempPrefix)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// blobIDs lists the IDs of the message blobs in the directory
+func (fs *FileStore) blobIDs() ([]string, error) {
+	entries, err := os.ReadDir(fs.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list store directory: %w", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, fileTempPrefix) || !strings.HasSuffix(name, fileMessageExt) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, fileMessageExt))
+	}
+	return ids, nil
+}
+
+func (fs *FileStore) blobPath(id string) string {
+	return filepath.Join(fs.dir, id+fileMessageExt)
+}
+
+// indexExpired reports whether an index expiry has passed
+func indexExpired(expiry int64, now time.Time) bool {
+	return expiry != 0 && now.UnixNano() >= expiry
+}
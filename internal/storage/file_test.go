@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func newTestFileStore(t *testing.T, dir string) *FileStore {
+	t.Helper()
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	return fs
+}
+
+func TestFileStore_SaveGetDelete(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestFileStore(t, dir)
+	msg := codecTestMessage()
+
+	if err := store.Save(msg, time.Minute); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, msg.IDHex()+fileMessageExt)); err != nil {
+		t.Fatalf("message blob not written: %v", err)
+	}
+
+	got, err := store.Get(msg.IDHex())
+	if err != nil || got == nil {
+		t.Fatalf("Get = %v, %v; want the message", got, err)
+	}
+	want, _ := msg.JSONMarshal()
+	if have, _ := got.JSONMarshal(); string(have) != string(want) {
+		t.Errorf("Get returned\n%s\nwant\n%s", have, want)
+	}
+
+	if err := store.Delete(msg.IDHex()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("Get returned a deleted message")
+	}
+	if _, err := os.Stat(filepath.Join(dir, msg.IDHex()+fileMessageExt)); !os.IsNotExist(err) {
+		t.Errorf("blob of a deleted message still exists (stat error %v)", err)
+	}
+}
+
+func TestFileStore_ListByRecipient(t *testing.T) {
+	store := newTestFileStore(t, t.TempDir())
+	toBob := newTestMsg("alice", "bob")
+	store.Save(toBob, time.Minute)
+	store.Save(newTestMsg("alice", "carol"), time.Minute)
+	store.Save(newTestMsg("alice", "bob"), 0) // expired at once
+
+	got, err := store.ListByRecipient("bob")
+	if err != nil {
+		t.Fatalf("ListByRecipient failed: %v", err)
+	}
+	if len(got) != 1 || got[0].IDHex() != toBob.IDHex() {
+		t.Errorf("ListByRecipient(bob) = %v, want only the live message to bob", got)
+	}
+}
+
+func TestFileStore_Pop(t *testing.T) {
+	store := newTestFileStore(t, t.TempDir())
+	msg := newTestMsg("alice", "bob")
+	store.Save(msg, time.Minute)
+
+	if n := popConcurrently(t, store, msg.IDHex()); n != 1 {
+		t.Fatalf("%d concurrent Pops got the message, want exactly 1", n)
+	}
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("Get found a popped message")
+	}
+}
+
+func TestFileStore_ReloadsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestFileStore(t, dir)
+	kept := newTestMsg("source", "dest")
+	short := newTestMsg("source", "dest")
+	store.Save(kept, NoExpiry)
+	store.Save(short, 20*time.Millisecond)
+
+	// A second store over the same directory sees both, with their expiries
+	reopened := newTestFileStore(t, dir)
+	if list, err := reopened.List(); err != nil || len(list) != 2 {
+		t.Fatalf("List after reopen = %d messages, %v; want 2", len(list), err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if got, _ := reopened.Get(short.IDHex()); got != nil {
+		t.Error("expiry was lost across the restart")
+	}
+	if got, _ := reopened.Get(kept.IDHex()); got == nil || got.IDHex() != kept.IDHex() {
+		t.Error("message saved with NoExpiry was lost across the restart")
+	}
+}
+
+func TestFileStore_ExpiredMessagesAreDeleted(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestFileStore(t, dir)
+	viaGet := newTestMsg("source", "dest")
+	viaList := newTestMsg("source", "dest")
+	viaSweep := newTestMsg("source", "dest")
+	for _, msg := range []*protocol.Message{viaGet, viaList, viaSweep} {
+		store.Save(msg, 0)
+	}
+
+	store.Get(viaGet.IDHex())
+	if list, _ := store.ListFiltered(func(m *protocol.Message) bool { return m.IDHex() == viaList.IDHex() }); len(list) != 0 {
+		t.Errorf("ListFiltered returned %d expired messages", len(list))
+	}
+	// Get and List already removed the other two
+	if n := store.Sweep(); n != 0 {
+		t.Errorf("Sweep removed %d messages, want 0", n)
+	}
+
+	store.Save(viaSweep, 0)
+	if n := store.Sweep(); n != 1 {
+		t.Errorf("Sweep removed %d messages, want 1", n)
+	}
+	if ids, _ := store.blobIDs(); len(ids) != 0 {
+		t.Errorf("expired blobs remain on disk: %v", ids)
+	}
+}
+
+func TestFileStore_DiscardsInterruptedSaves(t *testing.T) {
+	dir := t.TempDir()
+	store := newTestFileStore(t, dir)
+	msg := newTestMsg("source", "dest")
+	store.Save(msg, time.Minute)
+
+	// A blob the index never recorded, and an index entry whose blob is gone
+	orphan := filepath.Join(dir, "00ff"+fileMessageExt)
+	os.WriteFile(orphan, []byte("partial"), 0644)
+	os.Remove(filepath.Join(dir, msg.IDHex()+fileMessageExt))
+
+	reopened := newTestFileStore(t, dir)
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Error("orphaned blob survived reopening")
+	}
+	if list, err := reopened.List(); err != nil || len(list) != 0 {
+		t.Errorf("List = %d messages, %v; want none", len(list), err)
+	}
+}
+
+func TestFileStore_CorruptIndex(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, fileIndexName), []byte{0xff, 0x00}, 0644)
+	if _, err := NewFileStore(dir); err == nil {
+		t.Error("NewFileStore accepted a corrupt index")
+	}
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// StoreOp names a MessageStore operation in MeteredStore metrics
+type StoreOp int
+
+// Metered operations; ListFiltered is counted as OpList
+const (
+	OpSave StoreOp = iota
+	OpGet
+	OpDelete
+	OpList
+	OpClear
+	OpPop
+	numStoreOps
+)
+
+var storeOpNames = [numStoreOps]string{"save", "get", "delete", "list", "clear", "pop"}
+
+// String returns the operation's lowercase name
+func (op StoreOp) String() string {
+	if op < 0 || op >= numStoreOps {
+		return "unknown"
+	}
+	return storeOpNames[op]
+}
+
+// OperationMetrics summarizes the calls of one store operation
+type OperationMetrics struct {
+	Count  uint64        // calls made
+	Errors uint64        // calls that returned an error
+	Total  time.Duration // summed call latency
+	Max    time.Duration // slowest call
+}
+
+// Mean returns the average call latency, or 0 before the first call
+func (m OperationMetrics) Mean() time.Duration {
+	if m.Count == 0 {
+		return 0
+	}
+	return m.Total / time.Duration(m.Count)
+}
+
+// StoreMetrics is a snapshot of a MeteredStore's counters
+type StoreMetrics struct {
+	Hits       uint64 // Gets that found a message
+	Misses     uint64 // Gets that returned nil
+	Operations [numStoreOps]OperationMetrics
+}
+
+// Op returns the metrics of one operation
+func (m StoreMetrics) Op(op StoreOp) OperationMetrics {
+	return m.Operations[op]
+}
+
+// opCounters are the live counters behind OperationMetrics
+type opCounters struct {
+	count  atomic.Uint64
+	errors atomic.Uint64
+	total  atomic.Int64
+	max    atomic.Int64
+}
+
+// MeteredStore wraps a MessageStore, timing each operation and counting Get
+// hits and misses, so any backend can be measured without changes to it
+type MeteredStore struct {
+	store  MessageStore
+	ops    [numStoreOps]opCounters
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewMeteredStore returns store wrapped to record access metrics
+func NewMeteredStore(store MessageStore) *MeteredStore {
+	return &MeteredStore{store: store}
+}
+
+// Save stores a message through the wrapped store
+func (m *MeteredStore) Save(message *protocol.Message, ttl time.Duration) error {
+	start := time.Now()
+	err := m.store.Save(message, ttl)
+	m.record(OpSave, start, err)
+	return err
+}
+
+// Get retrieves a message through the wrapped store; a nil message is a miss
+func (m *MeteredStore) Get(id string) (*protocol.Message, error) {
+	start := time.Now()
+	msg, err := m.store.Get(id)
+	m.record(OpGet, start, err)
+	if err == nil {
+		if msg != nil {
+			m.hits.Add(1)
+		} else {
+			m.misses.Add(1)
+		}
+	}
+	return msg, err
+}
+
+// Delete removes a message through the wrapped store
+func (m *MeteredStore) Delete(id string) error {
+	start := time.Now()
+	err := m.store.Delete(id)
+	m.record(OpDelete, start, err)
+	return err
+}
+
+// Pop retrieves and deletes a message in the wrapped store
+func (m *MeteredStore) Pop(id string) (*protocol.Message, error) {
+	start := time.Now()
+	msg, err := m.store.Pop(id)
+	m.record(OpPop, start, err)
+	return msg, err
+}
+
+// List returns all messages from the wrapped store
+func (m *MeteredStore) List() ([]*protocol.Message, error) {
+	start := time.Now()
+	msgs, err := m.store.List()
+	m.record(OpList, start, err)
+	return msgs, err
+}
+
+// ListFiltered returns the messages accepted by filter from the wrapped store
+func (m *MeteredStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	start := time.Now()
+	msgs, err := m.store.ListFiltered(filter)
+	m.record(OpList, start, err)
+	return msgs, err
+}
+
+// ListByRecipient returns the messages addressed to did from the wrapped store
+func (m *MeteredStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	start := time.Now()
+	msgs, err := m.store.ListByRecipient(did)
+	m.record(OpList, start, err)
+	return msgs, err
+}
+
+// Clear removes all messages from the wrapped store
+func (m *MeteredStore) Clear() error {
+	start := time.Now()
+	err := m.store.Clear()
+	m.record(OpClear, start, err)
+	return err
+}
+
+// Subscribe implements Notifier using the wrapped store. If it cannot
+// notify, the returned channel never fires.
+func (m *MeteredStore) Subscribe(filter MessageFilter) (<-chan *protocol.Message, func()) {
+	if n, ok := m.store.(Notifier); ok {
+		return n.Subscribe(filter)
+	}
+	return nil, func() {}
+}
+
+// Metrics returns a snapshot of the counters. Fields are read one at a
+// time, so a snapshot taken under load may be slightly inconsistent.
+func (m *MeteredStore) Metrics() StoreMetrics {
+	metrics := StoreMetrics{Hits: m.hits.Load(), Misses: m.misses.Load()}
+	for op := range m.ops {
+		c := &m.ops[op]
+		metrics.Operations[op] = OperationMetrics{
+			Count:  c.count.Load(),
+			Errors: c.errors.Load(),
+			Total:  time.Duration(c.total.Load()),
+			Max:    time.Duration(c.max.Load()),
+		}
+	}
+	return metrics
+}
+
+// record adds one call of op that began at start
+func (m *MeteredStore) record(op StoreOp, start time.Time, err error) {
+	elapsed := int64(time.Since(start))
+	c := &m.ops[op]
+	c.count.Add(1)
+	c.total.Add(elapsed)
+	if err != nil {
+		c.errors.Add(1)
+	}
+	for {
+		prev := c.max.Load()
+		if elapsed <= prev || c.max.CompareAndSwap(prev, elapsed) {
+			return
+		}
+	}
+}
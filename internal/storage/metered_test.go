@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// slowGetStore delays every Get so its timing is measurable
+type slowGetStore struct {
+	*MemoryStore
+	delay time.Duration
+}
+
+func (s *slowGetStore) Get(id string) (*protocol.Message, error) {
+	time.Sleep(s.delay)
+	return s.MemoryStore.Get(id)
+}
+
+func TestMeteredStore_HitsAndMisses(t *testing.T) {
+	store := NewMeteredStore(NewMemoryStore())
+	msg := newTestMsg("source", "dest")
+	store.Save(msg, time.Minute)
+
+	if got, _ := store.Get(msg.IDHex()); got == nil {
+		t.Fatal("Get lost the saved message")
+	}
+	if got, _ := store.Get("missing"); got != nil {
+		t.Fatal("Get found a message that was never saved")
+	}
+	store.List()
+	store.ListFiltered(func(*protocol.Message) bool { return true })
+	store.Delete(msg.IDHex())
+
+	m := store.Metrics()
+	if m.Hits != 1 || m.Misses != 1 {
+		t.Errorf("hits/misses = %d/%d, want 1/1", m.Hits, m.Misses)
+	}
+	want := map[StoreOp]uint64{OpSave: 1, OpGet: 2, OpList: 2, OpDelete: 1, OpClear: 0}
+	for op, count := range want {
+		if got := m.Op(op).Count; got != count {
+			t.Errorf("%s count = %d, want %d", op, got, count)
+		}
+	}
+}
+
+func TestMeteredStore_Timings(t *testing.T) {
+	const delay = 5 * time.Millisecond
+	store := NewMeteredStore(&slowGetStore{MemoryStore: NewMemoryStore(), delay: delay})
+	store.Get("a")
+	store.Get("b")
+
+	get := store.Metrics().Op(OpGet)
+	if get.Max < delay || get.Total < 2*delay || get.Mean() < delay {
+		t.Errorf("get timings max=%v total=%v mean=%v, want each call to take at least %v",
+			get.Max, get.Total, get.Mean(), delay)
+	}
+	if save := store.Metrics().Op(OpSave); save.Count != 0 || save.Total != 0 || save.Mean() != 0 {
+		t.Errorf("unused save has metrics %+v", save)
+	}
+}
+
+func TestMeteredStore_Errors(t *testing.T) {
+	store := NewMeteredStore(ReadOnly(NewMemoryStore()))
+	if err := store.Save(newTestMsg("source", "dest"), time.Minute); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Save: got %v, want ErrReadOnly", err)
+	}
+	if save := store.Metrics().Op(OpSave); save.Count != 1 || save.Errors != 1 {
+		t.Errorf("save count/errors = %d/%d, want 1/1", save.Count, save.Errors)
+	}
+}
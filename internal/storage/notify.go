@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// subscriptionBuffer is how many notifications a subscriber may fall behind
+// before further ones are dropped
+const subscriptionBuffer = 16
+
+// Notifier is implemented by stores that can announce newly saved messages
+type Notifier interface {
+	// Subscribe returns a channel that receives each message accepted by
+	// filter as it is saved, and a function that ends the subscription.
+	// Notifications are hints: a subscriber that falls behind misses some
+	// and should re-list the store. filter may run under the store's lock
+	// and must not call back into the store.
+	Subscribe(filter MessageFilter) (<-chan *protocol.Message, func())
+}
+
+// subscription is a registered Subscribe call on a MemoryStore
+type subscription struct {
+	filter MessageFilter
+	ch     chan *protocol.Message
+}
+
+// Subscribe implements Notifier
+func (ms *MemoryStore) Subscribe(filter MessageFilter) (<-chan *protocol.Message, func()) {
+	sub := &subscription{
+		filter: filter,
+		ch:     make(chan *protocol.Message, subscriptionBuffer),
+	}
+
+	ms.mutex.Lock()
+	if ms.subscribers == nil {
+		ms.subscribers = make(map[*subscription]struct{})
+	}
+	ms.subscribers[sub] = struct{}{}
+	ms.mutex.Unlock()
+
+	cancel := func() {
+		ms.mutex.Lock()
+		delete(ms.subscribers, sub)
+		ms.mutex.Unlock()
+	}
+	return sub.ch, cancel
+}
+
+// notifySubscribersLocked announces a saved message without blocking.
+// The caller must hold the lock.
+func (ms *MemoryStore) notifySubscribersLocked(msg *protocol.Message) {
+	for sub := range ms.subscribers {
+		if sub.filter != nil && !sub.filter(msg) {
+			continue
+		}
+		select {
+		case sub.ch <- msg:
+		default:
+			// Subscriber is behind; it will catch up by listing
+		}
+	}
+}
+
+// Subscribe implements Notifier using the primary, which receives all writes.
+// If the primary cannot notify, the returned channel never fires.
+func (m *MultiStore) Subscribe(filter MessageFilter) (<-chan *protocol.Message, func()) {
+	if n, ok := m.primary.(Notifier); ok {
+		return n.Subscribe(filter)
+	}
+	return nil, func() {}
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func TestMemoryStore_Subscribe(t *testing.T) {
+	store := NewMemoryStore()
+	updates, cancel := store.Subscribe(func(m *protocol.Message) bool { return m.To == "dest" })
+
+	other := newTestMsg("source", "elsewhere")
+	wanted := newTestMsg("source", "dest")
+	store.Save(other, time.Minute)
+	store.Save(wanted, time.Minute)
+
+	select {
+	case got := <-updates:
+		if got.IDHex() != wanted.IDHex() {
+			t.Errorf("notified of %s, want %s", got.IDHex(), wanted.IDHex())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no notification for a matching save")
+	}
+
+	select {
+	case got := <-updates:
+		t.Errorf("unexpected notification for %s", got.IDHex())
+	default:
+	}
+
+	cancel()
+	store.Save(newTestMsg("source", "dest"), time.Minute)
+	select {
+	case <-updates:
+		t.Error("notified after the subscription was cancelled")
+	default:
+	}
+}
+
+func TestMemoryStore_SubscribeSlowSubscriberDoesNotBlock(t *testing.T) {
+	store := NewMemoryStore()
+	_, cancel := store.Subscribe(nil)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < subscriptionBuffer*4; i++ {
+			store.Save(newTestMsg("source", "dest"), time.Minute)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Save blocked on a subscriber that is not reading")
+	}
+}
+
+func TestMultiStore_SubscribeUsesPrimary(t *testing.T) {
+	ms := NewMultiStore(NewMemoryStore(), NewMemoryStore())
+	updates, cancel := ms.Subscribe(nil)
+	defer cancel()
+
+	msg := newTestMsg("source", "dest")
+	ms.Save(msg, time.Minute)
+
+	select {
+	case got := <-updates:
+		if got.IDHex() != msg.IDHex() {
+			t.Errorf("notified of %s, want %s", got.IDHex(), msg.IDHex())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no notification through the multi store")
+	}
+}
+
+func TestMemoryStore_ZeroTTLReachesSubscribersOnly(t *testing.T) {
+	store := NewMemoryStore()
+	updates, cancel := store.Subscribe(nil)
+	defer cancel()
+
+	msg := newTestMsg("source", "dest")
+	msg.TTL = 0
+	if err := store.Save(msg, 0); err != nil {
+		t.Fatalf("Save returned error: %v", err)
+	}
+
+	select {
+	case got := <-updates:
+		if got.IDHex() != msg.IDHex() {
+			t.Errorf("notified of %s, want %s", got.IDHex(), msg.IDHex())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("TTL=0 message was not delivered to a live subscriber")
+	}
+
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("TTL=0 message was retained after immediate delivery")
+	}
+}
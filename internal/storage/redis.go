@@ -0,0 +1,323 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis key layout
+const (
+	redisMessagePrefix   = "amp:msg:" // + ID hex: the encoded message
+	redisRecipientPrefix = "amp:to:"  // + DID: set of IDs addressed to it
+	redisStatePrefix     = "amp:st:"  // + key: a StateStore value
+)
+
+// redisScanBatch is how many keys each SCAN and MGET round trip handles
+const redisScanBatch = 256
+
+// RedisStore implements MessageStore in Redis. Each message is a key with a
+// native Redis expiry, and a set per recipient DID indexes their IDs. Set
+// members outlive the messages Redis expires, so lookups through the index
+// skip and prune IDs whose message is gone.
+type RedisStore struct {
+	client *redis.Client
+	codec  Codec
+}
+
+// NewRedisStore connects to the Redis server at addr, keeping messages as CBOR
+func NewRedisStore(addr, password string, db int) (*RedisStore, error) {
+	return NewRedisStoreWithCodec(addr, password, db, CBORCodec{})
+}
+
+// NewRedisStoreWithCodec connects to the Redis server at addr, serializing
+// messages with codec. It fails if the server can't be reached.
+func NewRedisStoreWithCodec(addr, password string, db int, codec Codec) (*RedisStore, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr, Password: password, DB: db})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", addr, err)
+	}
+	return &RedisStore{client: client, codec: codec}, nil
+}
+
+// Save stores a message for ttl, letting Redis expire it; see
+// MessageStore.Save for zero and negative TTLs. A zero TTL message is never
+// visible, so it is not written at all.
+func (rs *RedisStore) Save(message *protocol.Message, ttl time.Duration) error {
+	ctx := context.Background()
+	id := message.IDHex()
+	if ttl == 0 {
+		return rs.Delete(id)
+	}
+
+	data, err := rs.codec.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	if ttl < 0 {
+		ttl = 0 // no expiry, in go-redis terms
+	}
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisMessagePrefix+id, data, ttl)
+		if message.To != "" {
+			pipe.SAdd(ctx, redisRecipientPrefix+message.To, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save message: %w", err)
+	}
+	return nil
+}
+
+// Get retrieves a message by ID, or nil if it is missing or expired
+func (rs *RedisStore) Get(id string) (*protocol.Message, error) {
+	data, err := rs.client.Get(context.Background(), redisMessagePrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load message: %w", err)
+	}
+	return rs.decode(data)
+}
+
+// Delete removes a message by ID
+func (rs *RedisStore) Delete(id string) error {
+	ctx := context.Background()
+	msg, err := rs.Get(id)
+	if err != nil {
+		return err
+	}
+	_, err = rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisMessagePrefix+id)
+		if msg != nil && msg.To != "" {
+			pipe.SRem(ctx, redisRecipientPrefix+msg.To, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+	return nil
+}
+
+// Pop retrieves and deletes a message with GETDEL, so only one caller gets
+// it, or returns nil if it is missing or expired
+func (rs *RedisStore) Pop(id string) (*protocol.Message, error) {
+	ctx := context.Background()
+	data, err := rs.client.GetDel(ctx, redisMessagePrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to pop message: %w", err)
+	}
+	msg, err := rs.decode(data)
+	if err != nil {
+		return nil, err
+	}
+	// A stale index entry is harmless: ListByRecipient prunes it
+	if msg.To != "" {
+		rs.client.SRem(ctx, redisRecipientPrefix+msg.To, id)
+	}
+	return msg, nil
+}
+
+// List returns all messages
+func (rs *RedisStore) List() ([]*protocol.Message, error) {
+	return rs.ListFiltered(nil)
+}
+
+// ListFiltered scans the message keys and returns the messages accepted by
+// filter. A nil filter accepts every message.
+func (rs *RedisStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	ctx := context.Background()
+	var result []*protocol.Message
+	var cursor uint64
+	for {
+		keys, next, err := rs.client.Scan(ctx, cursor, redisMessagePrefix+"*", redisScanBatch).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list messages: %w", err)
+		}
+		msgs, _, err := rs.load(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range msgs {
+			if filter == nil || filter(msg) {
+				result = append(result, msg)
+			}
+		}
+		if cursor = next; cursor == 0 {
+			return result, nil
+		}
+	}
+}
+
+// ListByRecipient returns the messages addressed to did, using the
+// recipient index rather than a scan
+func (rs *RedisStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	ctx := context.Background()
+	setKey := redisRecipientPrefix + did
+	ids, err := rs.client.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list messages for %s: %w", did, err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = redisMessagePrefix + id
+	}
+	msgs, missing, err := rs.load(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop index entries whose message has expired or been readdressed
+	var result []*protocol.Message
+	for _, msg := range msgs {
+		if msg.To == did {
+			result = append(result, msg)
+		} else {
+			missing = append(missing, redisMessagePrefix+msg.IDHex())
+		}
+	}
+	if len(missing) > 0 {
+		stale := make([]interface{}, len(missing))
+		for i, key := range missing {
+			stale[i] = strings.TrimPrefix(key, redisMessagePrefix)
+		}
+		rs.client.SRem(ctx, setKey, stale...)
+	}
+	return result, nil
+}
+
+// Clear removes every message and recipient index, leaving other keys alone
+func (rs *RedisStore) Clear() error {
+	ctx := context.Background()
+	for _, pattern := range []string{redisMessagePrefix + "*", redisRecipientPrefix + "*"} {
+		iter := rs.client.Scan(ctx, 0, pattern, redisScanBatch).Iterator()
+		for iter.Next(ctx) {
+			if err := rs.client.Del(ctx, iter.Val()).Err(); err != nil {
+				return fmt.Errorf("failed to clear messages: %w", err)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			return fmt.Errorf("failed to clear messages: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close releases the connection to Redis
+func (rs *RedisStore) Close() error {
+	return rs.client.Close()
+}
+
+// State returns a StateStore kept in the same Redis database under its own
+// key prefix, so Clear leaves it alone and values expire natively
+func (rs *RedisStore) State() *RedisStateStore {
+	return &RedisStateStore{client: rs.client}
+}
+
+// RedisStateStore implements StateStore in Redis
+type RedisStateStore struct {
+	client *redis.Client
+}
+
+// Get returns the value under key, or nil if it is missing or expired
+func (ss *RedisStateStore) Get(key string) ([]byte, error) {
+	data, err := ss.client.Get(context.Background(), redisStatePrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	return data, nil
+}
+
+// Put stores value under key for ttl, letting Redis expire it
+func (ss *RedisStateStore) Put(key string, value []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		ttl = 0 // no expiry, in go-redis terms
+	} else if ttl == 0 {
+		return ss.Delete(key)
+	}
+	if err := ss.client.Set(context.Background(), redisStatePrefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the value under key
+func (ss *RedisStateStore) Delete(key string) error {
+	if err := ss.client.Del(context.Background(), redisStatePrefix+key).Err(); err != nil {
+		return fmt.Errorf("failed to delete state: %w", err)
+	}
+	return nil
+}
+
+// List scans for the values whose keys start with prefix
+func (ss *RedisStateStore) List(prefix string) (map[string][]byte, error) {
+	ctx := context.Background()
+	result := make(map[string][]byte)
+	iter := ss.client.Scan(ctx, 0, redisStatePrefix+prefix+"*", redisScanBatch).Iterator()
+	for iter.Next(ctx) {
+		data, err := ss.client.Get(ctx, iter.Val()).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list state: %w", err)
+		}
+		result[strings.TrimPrefix(iter.Val(), redisStatePrefix)] = data
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list state: %w", err)
+	}
+	return result, nil
+}
+
+// load fetches and decodes the messages under keys, also returning the keys
+// that no longer exist
+func (rs *RedisStore) load(ctx context.Context, keys []string) (msgs []*protocol.Message, missing []string, err error) {
+	if len(keys) == 0 {
+		return nil, nil, nil
+	}
+	values, err := rs.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load messages: %w", err)
+	}
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			missing = append(missing, keys[i])
+			continue
+		}
+		msg, err := rs.decode([]byte(s))
+		if err != nil {
+			return nil, nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, missing, nil
+}
+
+func (rs *RedisStore) decode(data []byte) (*protocol.Message, error) {
+	msg, err := rs.codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message: %w", err)
+	}
+	return msg, nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedisStore(t *testing.T) (*RedisStore, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	store, err := NewRedisStore(mr.Addr(), "", 0)
+	if err != nil {
+		t.Fatalf("NewRedisStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, mr
+}
+
+func TestRedisStore_SaveGetDelete(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	msg := codecTestMessage()
+
+	if err := store.Save(msg, time.Minute); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if ttl := mr.TTL(redisMessagePrefix + msg.IDHex()); ttl != time.Minute {
+		t.Errorf("key TTL = %v, want 1m", ttl)
+	}
+
+	got, err := store.Get(msg.IDHex())
+	if err != nil || got == nil {
+		t.Fatalf("Get = %v, %v; want the message", got, err)
+	}
+	want, _ := msg.JSONMarshal()
+	if have, _ := got.JSONMarshal(); string(have) != string(want) {
+		t.Errorf("Get returned\n%s\nwant\n%s", have, want)
+	}
+
+	if got, err := store.Get("missing"); got != nil || err != nil {
+		t.Errorf("Get of a missing key = %v, %v; want nil, nil", got, err)
+	}
+
+	if err := store.Delete(msg.IDHex()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("Get returned a deleted message")
+	}
+	if members, _ := mr.Members(redisRecipientPrefix + msg.To); len(members) != 0 {
+		t.Errorf("recipient index still holds %v", members)
+	}
+}
+
+func TestRedisStore_Expiry(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	kept := newTestMsg("source", "dest")
+	short := newTestMsg("source", "dest")
+	instant := newTestMsg("source", "dest")
+	store.Save(kept, NoExpiry)
+	store.Save(short, 10*time.Second)
+	store.Save(instant, 0)
+
+	if mr.TTL(redisMessagePrefix+kept.IDHex()) != 0 {
+		t.Error("NoExpiry message has a Redis TTL")
+	}
+	if mr.Exists(redisMessagePrefix + instant.IDHex()) {
+		t.Error("message saved with TTL 0 was written")
+	}
+
+	mr.FastForward(11 * time.Second)
+	if got, _ := store.Get(short.IDHex()); got != nil {
+		t.Error("Get returned a message Redis should have expired")
+	}
+	if list, _ := store.List(); len(list) != 1 || list[0].IDHex() != kept.IDHex() {
+		t.Errorf("List = %d messages, want only the unexpiring one", len(list))
+	}
+}
+
+func TestRedisStore_ListByRecipient(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	toBob := []*protocol.Message{newTestMsg("alice", "did:example:bob"), newTestMsg("carol", "did:example:bob")}
+	for _, msg := range toBob {
+		store.Save(msg, time.Minute)
+	}
+	store.Save(newTestMsg("bob", "did:example:alice"), time.Minute)
+	expiring := newTestMsg("dave", "did:example:bob")
+	store.Save(expiring, time.Second)
+
+	mr.FastForward(2 * time.Second)
+	got, err := store.ListByRecipient("did:example:bob")
+	if err != nil || len(got) != len(toBob) {
+		t.Fatalf("ListByRecipient = %d messages, %v; want %d", len(got), err, len(toBob))
+	}
+	for _, msg := range got {
+		if msg.To != "did:example:bob" {
+			t.Errorf("listed a message to %s", msg.To)
+		}
+	}
+	if members, _ := mr.Members(redisRecipientPrefix + "did:example:bob"); len(members) != len(toBob) {
+		t.Errorf("index holds %d IDs after listing, want the expired one pruned", len(members))
+	}
+
+	filtered, _ := store.ListFiltered(func(m *protocol.Message) bool { return m.From == "bob" })
+	if len(filtered) != 1 {
+		t.Errorf("ListFiltered = %d messages, want 1", len(filtered))
+	}
+}
+
+func TestRedisStore_Pop(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	msg := newTestMsg("alice", "bob")
+	if err := store.Save(msg, time.Minute); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	if n := popConcurrently(t, store, msg.IDHex()); n != 1 {
+		t.Fatalf("%d concurrent Pops got the message, want exactly 1", n)
+	}
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("Get found a popped message")
+	}
+	if got, _ := store.ListByRecipient("bob"); len(got) != 0 {
+		t.Error("popped message still listed for its recipient")
+	}
+}
+
+func TestRedisStore_ClearLeavesOtherKeys(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	store.Save(newTestMsg("source", "dest"), time.Minute)
+	mr.Set("unrelated", "value")
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if keys := mr.Keys(); len(keys) != 1 || keys[0] != "unrelated" {
+		t.Errorf("keys after Clear = %v, want only the unrelated one", keys)
+	}
+}
+
+func TestNewRedisStore_Unreachable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	addr := mr.Addr()
+	mr.Close()
+	if _, err := NewRedisStore(addr, "", 0); err == nil {
+		t.Error("NewRedisStore succeeded without a server")
+	}
+}
@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// ErrReadOnly is returned by write operations on a read-only store
+var ErrReadOnly = errors.New("store is read-only")
+
+// ReadOnlyStore wraps a MessageStore and rejects all writes
+type ReadOnlyStore struct {
+	store MessageStore
+}
+
+// ReadOnly returns a view of store that allows reads and rejects writes with ErrReadOnly
+func ReadOnly(store MessageStore) *ReadOnlyStore {
+	return &ReadOnlyStore{store: store}
+}
+
+// Save always returns ErrReadOnly
+func (r *ReadOnlyStore) Save(message *protocol.Message, ttl time.Duration) error {
+	return ErrReadOnly
+}
+
+// Get retrieves a message by ID from the underlying store
+func (r *ReadOnlyStore) Get(id string) (*protocol.Message, error) {
+	return r.store.Get(id)
+}
+
+// Delete always returns ErrReadOnly
+func (r *ReadOnlyStore) Delete(id string) error {
+	return ErrReadOnly
+}
+
+// Pop always returns ErrReadOnly
+func (r *ReadOnlyStore) Pop(id string) (*protocol.Message, error) {
+	return nil, ErrReadOnly
+}
+
+// List returns all messages from the underlying store
+func (r *ReadOnlyStore) List() ([]*protocol.Message, error) {
+	return r.store.List()
+}
+
+// ListFiltered returns the filtered messages from the underlying store
+func (r *ReadOnlyStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	return r.store.ListFiltered(filter)
+}
+
+// ListByRecipient returns the messages addressed to did from the underlying store
+func (r *ReadOnlyStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	return r.store.ListByRecipient(did)
+}
+
+// Clear always returns ErrReadOnly
+func (r *ReadOnlyStore) Clear() error {
+	return ErrReadOnly
+}
+
+// MultiStore sends writes to a primary store and spreads reads across replicas.
+// Replication itself is the backends' concern; MultiStore only routes calls.
+type MultiStore struct {
+	primary  MessageStore
+	replicas []MessageStore
+	next     atomic.Uint64
+}
+
+// NewMultiStore creates a store that writes to primary and reads from replicas.
+// With no replicas, reads are served by the primary.
+func NewMultiStore(primary MessageStore, replicas ...MessageStore) *MultiStore {
+	return &MultiStore{
+		primary:  primary,
+		replicas: replicas,
+	}
+}
+
+// Save stores a message in the primary
+func (m *MultiStore) Save(message *protocol.Message, ttl time.Duration) error {
+	return m.primary.Save(message, ttl)
+}
+
+// Get retrieves a message from the next replica, falling back to the primary
+// when the replica does not have it yet (replication lag)
+func (m *MultiStore) Get(id string) (*protocol.Message, error) {
+	reader := m.reader()
+	msg, err := reader.Get(id)
+	if err != nil || msg != nil || reader == m.primary {
+		return msg, err
+	}
+	return m.primary.Get(id)
+}
+
+// Delete removes a message from the primary
+func (m *MultiStore) Delete(id string) error {
+	return m.primary.Delete(id)
+}
+
+// Pop retrieves and deletes a message in the primary
+func (m *MultiStore) Pop(id string) (*protocol.Message, error) {
+	return m.primary.Pop(id)
+}
+
+// List returns all messages from the next replica
+func (m *MultiStore) List() ([]*protocol.Message, error) {
+	return m.reader().List()
+}
+
+// ListFiltered returns the filtered messages from the next replica
+func (m *MultiStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	return m.reader().ListFiltered(filter)
+}
+
+// ListByRecipient returns the messages addressed to did from the next replica
+func (m *MultiStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	return m.reader().ListByRecipient(did)
+}
+
+// Clear removes all messages from the primary
+func (m *MultiStore) Clear() error {
+	return m.primary.Clear()
+}
+
+// reader picks a replica round-robin, or the primary if there are none
+func (m *MultiStore) reader() MessageStore {
+	if len(m.replicas) == 0 {
+		return m.primary
+	}
+	n := m.next.Add(1) - 1
+	return m.replicas[n%uint64(len(m.replicas))]
+}
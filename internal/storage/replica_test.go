@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+func TestReadOnlyStore_RejectsWrites(t *testing.T) {
+	ro := ReadOnly(NewMemoryStore())
+	msg := newTestMsg("source", "dest")
+
+	if err := ro.Save(msg, time.Minute); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Save: got %v, want ErrReadOnly", err)
+	}
+	if err := ro.Delete(msg.IDHex()); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Delete: got %v, want ErrReadOnly", err)
+	}
+	if err := ro.Clear(); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Clear: got %v, want ErrReadOnly", err)
+	}
+}
+
+func TestReadOnlyStore_AllowsReads(t *testing.T) {
+	backing := NewMemoryStore()
+	msg := newTestMsg("source", "dest")
+	other := newTestMsg("source", "elsewhere")
+	backing.Save(msg, time.Minute)
+	backing.Save(other, time.Minute)
+
+	ro := ReadOnly(backing)
+
+	got, err := ro.Get(msg.IDHex())
+	if err != nil || got == nil {
+		t.Fatalf("Get: got (%v, %v), want message", got, err)
+	}
+
+	all, err := ro.List()
+	if err != nil || len(all) != 2 {
+		t.Errorf("List: got %d messages (err %v), want 2", len(all), err)
+	}
+
+	filtered, err := ro.ListFiltered(func(m *protocol.Message) bool { return m.To == "dest" })
+	if err != nil || len(filtered) != 1 {
+		t.Errorf("ListFiltered: got %d messages (err %v), want 1", len(filtered), err)
+	}
+}
+
+func TestMultiStore_WritesToPrimaryReadsFromReplica(t *testing.T) {
+	primary := NewMemoryStore()
+	replica := NewMemoryStore()
+	ms := NewMultiStore(primary, ReadOnly(replica))
+
+	msg := newTestMsg("source", "dest")
+	if err := ms.Save(msg, time.Minute); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got, _ := primary.Get(msg.IDHex()); got == nil {
+		t.Error("message was not written to the primary")
+	}
+	if got, _ := replica.Get(msg.IDHex()); got != nil {
+		t.Error("message should not be written to the replica")
+	}
+
+	// Simulate replication and read back through the multi store
+	replica.Save(msg, time.Minute)
+	all, err := ms.List()
+	if err != nil || len(all) != 1 {
+		t.Errorf("List: got %d messages (err %v), want 1", len(all), err)
+	}
+
+	if err := ms.Delete(msg.IDHex()); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if got, _ := primary.Get(msg.IDHex()); got != nil {
+		t.Error("message should be deleted from the primary")
+	}
+}
+
+func TestMultiStore_GetFallsBackToPrimary(t *testing.T) {
+	primary := NewMemoryStore()
+	ms := NewMultiStore(primary, NewMemoryStore())
+
+	msg := newTestMsg("source", "dest")
+	ms.Save(msg, time.Minute)
+
+	got, err := ms.Get(msg.IDHex())
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got == nil {
+		t.Error("Get should fall back to the primary when the replica misses")
+	}
+}
+
+func TestMultiStore_NoReplicas(t *testing.T) {
+	ms := NewMultiStore(NewMemoryStore())
+	msg := newTestMsg("source", "dest")
+	ms.Save(msg, time.Minute)
+
+	if got, _ := ms.Get(msg.IDHex()); got == nil {
+		t.Error("Get should read from the primary when there are no replicas")
+	}
+}
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	cbor "github.com/fxamacker/cbor/v2"
+)
+
+// messageOverhead approximates the CBOR framing and fixed-width fields
+// (version, type, timestamps, map keys) of an encoded message
+const messageOverhead = 48
+
+// messageSize approximates the serialized size of msg in bytes. It walks
+// the shapes the CBOR decoder produces and only encodes bodies of other types.
+func messageSize(msg *protocol.Message) int64 {
+	n := int64(messageOverhead)
+	n += int64(len(msg.ID) + len(msg.From) + len(msg.To))
+	n += int64(len(msg.ReplyTo) + len(msg.ThreadID) + len(msg.Sig))
+	n += valueSize(msg.Body)
+	for k, v := range msg.Ext {
+		n += int64(len(k)) + valueSize(v) + 2
+	}
+	for k, v := range msg.Headers {
+		n += int64(len(k)+len(v)) + 4
+	}
+	return n
+}
+
+// valueSize approximates the encoded size of a decoded body value
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil, bool:
+		return 1
+	case string:
+		return int64(len(v)) + 2
+	case []byte:
+		return int64(len(v)) + 2
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return 9
+	case []interface{}:
+		n := int64(2)
+		for _, e := range v {
+			n += valueSize(e)
+		}
+		return n
+	case map[interface{}]interface{}:
+		n := int64(2)
+		for k, e := range v {
+			n += valueSize(k) + valueSize(e)
+		}
+		return n
+	case map[string]interface{}:
+		n := int64(2)
+		for k, e := range v {
+			n += int64(len(k)) + 2 + valueSize(e)
+		}
+		return n
+	default:
+		data, err := cbor.Marshal(v)
+		if err != nil {
+			return 0
+		}
+		return int64(len(data))
+	}
+}
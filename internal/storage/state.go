@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// StateStore keeps the relay's own control state, such as quota counters,
+// contact states, delegation grants, pending requests and dead letters, as
+// opaque values under string keys. It is separate from the MessageStore, so
+// control state never appears in message listings or mailboxes, doesn't
+// count against message limits, and isn't evicted or cleared with messages.
+type StateStore interface {
+	// Get returns the value under key, or nil if it is missing or expired
+	Get(key string) ([]byte, error)
+
+	// Put stores value under key for ttl. A negative TTL, e.g. NoExpiry,
+	// keeps it until it is deleted.
+	Put(key string, value []byte, ttl time.Duration) error
+
+	// Delete removes the value under key
+	Delete(key string) error
+
+	// List returns the live values whose keys start with prefix, by key
+	List(prefix string) (map[string][]byte, error)
+}
+
+// stateEntry is a value and its expiry in Unix nanoseconds (0 = none)
+type stateEntry struct {
+	Value  []byte `cbor:"v"`
+	Expiry int64  `cbor:"e,omitempty"`
+}
+
+func (e stateEntry) expired(now time.Time) bool {
+	return indexExpired(e.Expiry, now)
+}
+
+// stateExpiry converts a Put TTL to an entry expiry
+func stateExpiry(ttl time.Duration) int64 {
+	if ttl < 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixNano()
+}
+
+// MemoryStateStore implements StateStore in memory; its state is lost on restart
+type MemoryStateStore struct {
+	mutex   sync.Mutex
+	entries map[string]stateEntry
+}
+
+// NewMemoryStateStore creates an empty in-memory state store
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{entries: make(map[string]stateEntry)}
+}
+
+// Get returns the value under key, or nil if it is missing or expired
+func (ms *MemoryStateStore) Get(key string) ([]byte, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	entry, ok := ms.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	if entry.expired(time.Now()) {
+		delete(ms.entries, key)
+		return nil, nil
+	}
+	return entry.Value, nil
+}
+
+// Put stores value under key for ttl
+func (ms *MemoryStateStore) Put(key string, value []byte, ttl time.Duration) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.entries[key] = stateEntry{Value: value, Expiry: stateExpiry(ttl)}
+	return nil
+}
+
+// Delete removes the value under key
+func (ms *MemoryStateStore) Delete(key string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	delete(ms.entries, key)
+	return nil
+}
+
+// List returns the live values whose keys start with prefix, dropping expired ones
+func (ms *MemoryStateStore) List(prefix string) (map[string][]byte, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	now := time.Now()
+	result := make(map[string][]byte)
+	for key, entry := range ms.entries {
+		if entry.expired(now) {
+			delete(ms.entries, key)
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			result[key] = entry.Value
+		}
+	}
+	return result, nil
+}
+
+// FileStateStore implements StateStore as a single CBOR file, kept in memory
+// and rewritten through a renamed temporary file on every change, so a crash
+// leaves either the old or the new state. It suits the modest amount of
+// control state a relay keeps.
+type FileStateStore struct {
+	path string
+
+	mutex   sync.Mutex
+	entries map[string]stateEntry
+}
+
+// NewFileStateStore opens or creates the state file at path, dropping
+// entries that expired while the relay was down
+func NewFileStateStore(path string) (*FileStateStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	fs := &FileStateStore{path: path, entries: make(map[string]stateEntry)}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	default:
+		if err := cbor.Unmarshal(data, &fs.entries); err != nil {
+			return nil, fmt.Errorf("failed to decode state file: %w", err)
+		}
+	}
+	now := time.Now()
+	for key, entry := range fs.entries {
+		if entry.expired(now) {
+			delete(fs.entries, key)
+		}
+	}
+	return fs, nil
+}
+
+// Get returns the value under key, or nil if it is missing or expired
+func (fs *FileStateStore) Get(key string) ([]byte, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	entry, ok := fs.entries[key]
+	if !ok || entry.expired(time.Now()) {
+		return nil, nil
+	}
+	return entry.Value, nil
+}
+
+// Put stores value under key for ttl and rewrites the file
+func (fs *FileStateStore) Put(key string, value []byte, ttl time.Duration) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	fs.entries[key] = stateEntry{Value: value, Expiry: stateExpiry(ttl)}
+	return fs.writeLocked()
+}
+
+// Delete removes the value under key and rewrites the file
+func (fs *FileStateStore) Delete(key string) error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if _, ok := fs.entries[key]; !ok {
+		return nil
+	}
+	delete(fs.entries, key)
+	return fs.writeLocked()
+}
+
+// List returns the live values whose keys start with prefix
+func (fs *FileStateStore) List(prefix string) (map[string][]byte, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	now := time.Now()
+	result := make(map[string][]byte)
+	for key, entry := range fs.entries {
+		if strings.HasPrefix(key, prefix) && !entry.expired(now) {
+			result[key] = entry.Value
+		}
+	}
+	return result, nil
+}
+
+// writeLocked drops expired entries and replaces the file with the rest
+func (fs *FileStateStore) writeLocked() error {
+	now := time.Now()
+	for key, entry := range fs.entries {
+		if entry.expired(now) {
+			delete(fs.entries, key)
+		}
+	}
+	data, err := cbor.Marshal(fs.entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), fileTempPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), fs.path); err != nil {
+		return fmt.Errorf("failed to write state: %w", err)
+	}
+	return nil
+}
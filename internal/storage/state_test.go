@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// testStateStore exercises Put, Get, List, Delete and expiry on any
+// StateStore, calling wait to let a short TTL run out
+func testStateStore(t *testing.T, ss StateStore, wait func(time.Duration)) {
+	t.Helper()
+
+	if err := ss.Put("quota:alice", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := ss.Put("contact:alice\x00bob", []byte("accepted"), NoExpiry); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := ss.Put("quota:gone", []byte("1"), time.Millisecond); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	wait(10 * time.Millisecond)
+
+	if got, err := ss.Get("quota:alice"); err != nil || string(got) != "1" {
+		t.Errorf("Get = %q, %v; want \"1\"", got, err)
+	}
+	if got, _ := ss.Get("quota:gone"); got != nil {
+		t.Errorf("expired value returned: %q", got)
+	}
+	if got, _ := ss.Get("missing"); got != nil {
+		t.Errorf("missing key returned %q", got)
+	}
+
+	values, err := ss.List("quota:")
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(values) != 1 || string(values["quota:alice"]) != "1" {
+		t.Errorf("List(quota:) = %v, want only quota:alice", values)
+	}
+
+	if err := ss.Delete("quota:alice"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if got, _ := ss.Get("quota:alice"); got != nil {
+		t.Errorf("deleted value returned: %q", got)
+	}
+	if got, _ := ss.Get("contact:alice\x00bob"); string(got) != "accepted" {
+		t.Errorf("unrelated value = %q after Delete, want \"accepted\"", got)
+	}
+}
+
+func TestMemoryStateStore(t *testing.T) {
+	testStateStore(t, NewMemoryStateStore(), time.Sleep)
+}
+
+func TestFileStateStore(t *testing.T) {
+	testStateStore(t, newTestFileStateStore(t, filepath.Join(t.TempDir(), "state.cbor")), time.Sleep)
+}
+
+func TestRedisStateStore(t *testing.T) {
+	store, mr := newTestRedisStore(t)
+	testStateStore(t, store.State(), mr.FastForward)
+}
+
+func newTestFileStateStore(t *testing.T, path string) *FileStateStore {
+	t.Helper()
+	ss, err := NewFileStateStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStateStore: %v", err)
+	}
+	return ss
+}
+
+// TestFileStateStore_Reopen verifies values survive reopening the file and
+// values that expired meanwhile are dropped
+func TestFileStateStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.cbor")
+	before := newTestFileStateStore(t, path)
+	before.Put("delegation:a", []byte("grant"), NoExpiry)
+	before.Put("pending:b", []byte("request"), 20*time.Millisecond)
+	time.Sleep(30 * time.Millisecond)
+
+	after := newTestFileStateStore(t, path)
+	if got, _ := after.Get("delegation:a"); string(got) != "grant" {
+		t.Errorf("reopened value = %q, want \"grant\"", got)
+	}
+	if values, _ := after.List("pending:"); len(values) != 0 {
+		t.Errorf("expired values reloaded: %v", values)
+	}
+}
+
+// TestRedisStateStore_SurvivesClear verifies clearing the message store
+// leaves state in the same database alone
+func TestRedisStateStore_SurvivesClear(t *testing.T) {
+	store, _ := newTestRedisStore(t)
+	state := store.State()
+	state.Put("contact:a\x00b", []byte("accepted"), NoExpiry)
+	store.Save(protocol.NewMessage(protocol.MessageTypeEvent, "did:example:a", "did:example:b", nil), time.Minute)
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Clear failed: %v", err)
+	}
+	if got, _ := state.Get("contact:a\x00b"); string(got) != "accepted" {
+		t.Errorf("state after Clear = %q, want \"accepted\"", got)
+	}
+	if msgs, _ := store.List(); len(msgs) != 0 {
+		t.Errorf("List after Clear = %d messages, want none", len(msgs))
+	}
+}
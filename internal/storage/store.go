@@ -1,15 +1,27 @@
 package storage
 
 import (
+	"container/list"
+	"context"
+	"errors"
 	"sync"
 	"time"
 
 	"github.com/agentries/amp-relay-go/internal/protocol"
 )
 
+// ErrMessageTooLarge is returned when a message alone exceeds the store's byte budget
+var ErrMessageTooLarge = errors.New("message exceeds store byte budget")
+
+// NoExpiry is the Save TTL for a message that is kept until deleted or evicted
+const NoExpiry time.Duration = -1
+
 // MessageStore defines the interface for storing and retrieving AMP messages
 type MessageStore interface {
-	// Save stores a message with optional TTL
+	// Save stores a message for ttl. A zero TTL gives the message no
+	// lifetime: subscribers see it, but it is expired as soon as it is
+	// saved, matching TTL=0 "immediate delivery" (RFC 001 §8.3). A negative
+	// TTL, e.g. NoExpiry, keeps it until it is deleted or evicted.
 	Save(message *protocol.Message, ttl time.Duration) error
 
 	// Get retrieves a message by ID
@@ -18,94 +30,463 @@ type MessageStore interface {
 	// Delete removes a message by ID
 	Delete(id string) error
 
-	// List returns all messages (with optional filtering in the future)
+	// Pop atomically retrieves and deletes a message by ID, so of several
+	// concurrent callers only one gets it. It returns nil if the message is
+	// missing or expired.
+	Pop(id string) (*protocol.Message, error)
+
+	// List returns all messages
 	List() ([]*protocol.Message, error)
+
+	// ListFiltered returns all messages for which filter returns true
+	ListFiltered(filter MessageFilter) ([]*protocol.Message, error)
+
+	// ListByRecipient returns all messages addressed to did, so messages
+	// stored while a client was offline can be delivered when it returns
+	ListByRecipient(did string) ([]*protocol.Message, error)
+
+	// Clear removes all messages
+	Clear() error
+}
+
+// MessageFilter reports whether a message should be included in a listing
+type MessageFilter func(msg *protocol.Message) bool
+
+// Eviction priorities, lowest evicted first
+const (
+	priorityLow    = iota // events / plain messages
+	priorityNormal        // requests, responses and other typed traffic
+	priorityHigh          // control messages
+	numPriorities
+)
+
+// evictionPriority ranks a message type for eviction: control > request > event
+func evictionPriority(t protocol.MessageType) int {
+	switch {
+	case t <= 0x0F:
+		return priorityHigh
+	case t == protocol.MessageTypeMessage:
+		return priorityLow
+	default:
+		return priorityNormal
+	}
 }
 
 // MemoryStore implements MessageStore in memory
 type MemoryStore struct {
 	messages map[string]*storedMessage
 	mutex    sync.RWMutex
+
+	// Insertion order per eviction priority, oldest at the front
+	order       [numPriorities]*list.List
+	maxMessages int
+
+	// IDs of the messages addressed to each recipient DID, oldest first
+	recipients map[string][]string
+
+	// Approximate serialized size of all stored messages, and its cap
+	totalBytes int64
+	maxBytes   int64
+
+	// How long an expired message is kept, invisible to Get and List,
+	// before it is purged
+	expiryGrace time.Duration
+
+	// OnExpire, if set, is called with each expired message as it is pruned.
+	// It runs outside the store lock, so it may safely call back into the store.
+	// Set it before the store is shared between goroutines.
+	OnExpire func(msg *protocol.Message)
+
+	// Active Subscribe calls
+	subscribers map[*subscription]struct{}
 }
 
 type storedMessage struct {
-	message *protocol.Message
-	expiry  time.Time
+	message  *protocol.Message
+	expiry   time.Time
+	priority int
+	size     int64         // approximate serialized size
+	elem     *list.Element // position in order[priority]; Value is the message ID
+	to       string        // recipient it is indexed under, as of Save
 }
 
 // NewMemoryStore creates a new in-memory message store
 func NewMemoryStore() *MemoryStore {
-	return &MemoryStore{
-		messages: make(map[string]*storedMessage),
+	ms := &MemoryStore{
+		messages:   make(map[string]*storedMessage),
+		recipients: make(map[string][]string),
+	}
+	for i := range ms.order {
+		ms.order[i] = list.New()
 	}
+	return ms
 }
 
-// Save stores a message with optional TTL
+// SetMaxMessages caps the number of stored messages (0 = unlimited).
+// When a Save would exceed the cap, the oldest message of the lowest priority
+// present is evicted (events before requests before control messages).
+// Expired messages are left to Sweep, so they keep their grace period and
+// still reach OnExpire.
+func (ms *MemoryStore) SetMaxMessages(n int) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.maxMessages = n
+	ms.evictLocked(0, 0)
+}
+
+// SetMaxBytes caps the approximate serialized size of all stored messages
+// (0 = unlimited), evicting by the same policy as SetMaxMessages. A single
+// message larger than the cap is rejected with ErrMessageTooLarge.
+func (ms *MemoryStore) SetMaxBytes(n int64) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.maxBytes = n
+	ms.evictLocked(0, 0)
+}
+
+// SetExpiryGrace keeps expired messages for d past their expiry before they
+// are purged (0 = purge as soon as they expire). Get and List stop returning
+// a message once it expires; within the grace period it can still be read
+// with Lookup, e.g. to investigate a late retry or clock skew between relays.
+func (ms *MemoryStore) SetExpiryGrace(d time.Duration) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expiryGrace = d
+}
+
+// TotalBytes returns the approximate serialized size of all stored messages
+func (ms *MemoryStore) TotalBytes() int64 {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return ms.totalBytes
+}
+
+// Save stores a message for ttl; see MessageStore.Save for zero and negative TTLs
 func (ms *MemoryStore) Save(message *protocol.Message, ttl time.Duration) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
 	var expiry time.Time
-	if ttl > 0 {
+	if ttl >= 0 {
+		// TTL=0 expires at once: the message is only deliverable right now
 		expiry = time.Now().Add(ttl)
-	} else {
-		// No expiration if TTL is 0 or negative
-		expiry = time.Time{}
 	}
 
-	ms.messages[message.IDHex()] = &storedMessage{
-		message: message,
-		expiry:  expiry,
+	size := messageSize(message)
+	if ms.maxBytes > 0 && size > ms.maxBytes {
+		return ErrMessageTooLarge
 	}
 
+	id := message.IDHex()
+	if old, exists := ms.messages[id]; exists {
+		ms.removeLocked(id, old)
+	}
+	ms.evictLocked(1, size)
+
+	stored := &storedMessage{
+		message:  message,
+		expiry:   expiry,
+		priority: evictionPriority(message.Type),
+		size:     size,
+		to:       message.To,
+	}
+	stored.elem = ms.order[stored.priority].PushBack(id)
+	ms.messages[id] = stored
+	if stored.to != "" {
+		ms.recipients[stored.to] = append(ms.recipients[stored.to], id)
+	}
+	ms.totalBytes += size
+	ms.notifySubscribersLocked(message)
+
 	return nil
 }
 
+// overLimitLocked reports whether adding incoming messages totalling
+// incomingBytes would exceed maxMessages or maxBytes
+func (ms *MemoryStore) overLimitLocked(incoming int, incomingBytes int64) bool {
+	return (ms.maxMessages > 0 && len(ms.messages)+incoming > ms.maxMessages) ||
+		(ms.maxBytes > 0 && ms.totalBytes+incomingBytes > ms.maxBytes)
+}
+
+// evictLocked makes room for incoming new messages totalling incomingBytes
+// under maxMessages and maxBytes, taking each victim from the front of an
+// order list. The caller must hold the write lock.
+func (ms *MemoryStore) evictLocked(incoming int, incomingBytes int64) {
+	for p := 0; p < numPriorities; p++ {
+		for ms.overLimitLocked(incoming, incomingBytes) && ms.order[p].Len() > 0 {
+			id := ms.order[p].Front().Value.(string)
+			ms.removeLocked(id, ms.messages[id])
+		}
+	}
+}
+
+// removeLocked deletes a message from the map, its order list and the
+// recipient index. The caller must hold the write lock.
+func (ms *MemoryStore) removeLocked(id string, stored *storedMessage) {
+	ms.order[stored.priority].Remove(stored.elem)
+	delete(ms.messages, id)
+	ms.totalBytes -= stored.size
+
+	ids := ms.recipients[stored.to]
+	for i, indexed := range ids {
+		if indexed == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(ms.recipients, stored.to)
+	} else {
+		ms.recipients[stored.to] = ids
+	}
+}
+
 // Get retrieves a message by ID
 func (ms *MemoryStore) Get(id string) (*protocol.Message, error) {
 	ms.mutex.RLock()
-	defer ms.mutex.RUnlock()
-
 	stored, exists := ms.messages[id]
+	grace := ms.expiryGrace
+	ms.mutex.RUnlock()
+
 	if !exists {
 		return nil, nil
 	}
 
-	// Check if message has expired; cleanup is handled by List and background cleanup
-	if !stored.expiry.IsZero() && time.Now().After(stored.expiry) {
+	// Expired messages are hidden, and pruned once past the grace period
+	now := time.Now()
+	if stored.expired(now) {
+		if stored.purgeable(now, grace) {
+			ms.pruneExpired(id)
+		}
 		return nil, nil
 	}
 
 	return stored.message, nil
 }
 
+// Lookup returns a message by ID even if it has expired but not yet been
+// purged, reporting whether it has expired
+func (ms *MemoryStore) Lookup(id string) (msg *protocol.Message, expired bool) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	stored, exists := ms.messages[id]
+	if !exists {
+		return nil, false
+	}
+	return stored.message, stored.expired(time.Now())
+}
+
 // Delete removes a message by ID
 func (ms *MemoryStore) Delete(id string) error {
 	ms.mutex.Lock()
 	defer ms.mutex.Unlock()
 
-	delete(ms.messages, id)
+	if stored, exists := ms.messages[id]; exists {
+		ms.removeLocked(id, stored)
+	}
 	return nil
 }
 
+// Pop retrieves and deletes a message under a single write lock, returning
+// nil if it is missing or expired
+func (ms *MemoryStore) Pop(id string) (*protocol.Message, error) {
+	ms.mutex.Lock()
+	stored, exists := ms.messages[id]
+	if !exists {
+		ms.mutex.Unlock()
+		return nil, nil
+	}
+
+	now := time.Now()
+	if stored.expired(now) {
+		// Left for Lookup until the grace period is over, like Get does
+		purged := stored.purgeable(now, ms.expiryGrace)
+		if purged {
+			ms.removeLocked(id, stored)
+		}
+		ms.mutex.Unlock()
+		if purged {
+			ms.notifyExpired([]*protocol.Message{stored.message})
+		}
+		return nil, nil
+	}
+
+	ms.removeLocked(id, stored)
+	ms.mutex.Unlock()
+	return stored.message, nil
+}
+
+// Clear removes all messages. Cleared messages are dropped, not reported to OnExpire.
+func (ms *MemoryStore) Clear() error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.messages = make(map[string]*storedMessage)
+	ms.recipients = make(map[string][]string)
+	for i := range ms.order {
+		ms.order[i].Init()
+	}
+	ms.totalBytes = 0
+	return nil
+}
+
+// Count returns the number of messages held, including expired ones not yet pruned
+func (ms *MemoryStore) Count() int {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+	return len(ms.messages)
+}
+
 // List returns all non-expired messages
 func (ms *MemoryStore) List() ([]*protocol.Message, error) {
+	return ms.ListFiltered(nil)
+}
+
+// ListFiltered returns all non-expired messages accepted by filter.
+// A nil filter accepts every message.
+func (ms *MemoryStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
 	ms.mutex.Lock()
-	defer ms.mutex.Unlock()
 
 	var result []*protocol.Message
+	var expired []*protocol.Message
 	now := time.Now()
 
 	for id, stored := range ms.messages {
-		// Check if message has expired
-		if !stored.expiry.IsZero() && now.After(stored.expiry) {
-			// Remove expired message
-			delete(ms.messages, id)
+		// Skip expired messages, removing those past the grace period
+		if stored.expired(now) {
+			if stored.purgeable(now, ms.expiryGrace) {
+				ms.removeLocked(id, stored)
+				expired = append(expired, stored.message)
+			}
+			continue
+		}
+
+		if filter != nil && !filter(stored.message) {
 			continue
 		}
 
 		result = append(result, stored.message)
 	}
+	ms.mutex.Unlock()
+
+	ms.notifyExpired(expired)
+	return result, nil
+}
+
+// ListByRecipient returns the non-expired messages addressed to did, oldest
+// first, using the recipient index rather than a scan of the whole store
+func (ms *MemoryStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	ms.mutex.Lock()
+	var result []*protocol.Message
+	var expired []*protocol.Message
+	now := time.Now()
+
+	// Copy the IDs, since pruning below edits the index entry
+	ids := append([]string(nil), ms.recipients[did]...)
+	for _, id := range ids {
+		stored := ms.messages[id]
+		if stored.expired(now) {
+			if stored.purgeable(now, ms.expiryGrace) {
+				ms.removeLocked(id, stored)
+				expired = append(expired, stored.message)
+			}
+			continue
+		}
+		result = append(result, stored.message)
+	}
+	ms.mutex.Unlock()
 
+	ms.notifyExpired(expired)
 	return result, nil
 }
+
+// Sweep removes every expired message past the grace period, reporting each
+// to OnExpire, and returns how many were removed. Get and List only prune
+// the messages they touch; Sweep also catches those nobody reads.
+func (ms *MemoryStore) Sweep() int {
+	ms.mutex.Lock()
+	var expired []*protocol.Message
+	now := time.Now()
+	for id, stored := range ms.messages {
+		if stored.purgeable(now, ms.expiryGrace) {
+			ms.removeLocked(id, stored)
+			expired = append(expired, stored.message)
+		}
+	}
+	ms.mutex.Unlock()
+
+	ms.notifyExpired(expired)
+	return len(expired)
+}
+
+// StartCleanup runs Sweep every interval in a background goroutine until
+// ctx is done or the returned stop function is called. Stop waits for the
+// goroutine to exit and may be called more than once. A non-positive
+// interval starts nothing.
+func (ms *MemoryStore) StartCleanup(ctx context.Context, interval time.Duration) (stop func()) {
+	return startSweeper(ctx, interval, ms.Sweep)
+}
+
+// startSweeper calls sweep every interval until ctx is done or stop is called
+func startSweeper(ctx context.Context, interval time.Duration, sweep func() int) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sweep()
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// pruneExpired removes the message with the given ID if it is expired and
+// past the grace period
+func (ms *MemoryStore) pruneExpired(id string) {
+	ms.mutex.Lock()
+	stored, exists := ms.messages[id]
+	if !exists || !stored.purgeable(time.Now(), ms.expiryGrace) {
+		ms.mutex.Unlock()
+		return
+	}
+	ms.removeLocked(id, stored)
+	ms.mutex.Unlock()
+
+	ms.notifyExpired([]*protocol.Message{stored.message})
+}
+
+// notifyExpired invokes OnExpire for each pruned message; must be called without the lock held
+func (ms *MemoryStore) notifyExpired(expired []*protocol.Message) {
+	if ms.OnExpire == nil {
+		return
+	}
+	for _, msg := range expired {
+		ms.OnExpire(msg)
+	}
+}
+
+// expired reports whether the stored message has passed its expiry
+func (sm *storedMessage) expired(now time.Time) bool {
+	return !sm.expiry.IsZero() && !now.Before(sm.expiry)
+}
+
+// purgeable reports whether the stored message has expired and is past the grace period
+func (sm *storedMessage) purgeable(now time.Time, grace time.Duration) bool {
+	return sm.expired(now) && now.Sub(sm.expiry) >= grace
+}
@@ -1,7 +1,10 @@
 package storage
 
 import (
+	"context"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,7 +29,7 @@ func TestMemoryStore_Save(t *testing.T) {
 	store := NewMemoryStore()
 	msg := newTestMsg("source", "dest")
 
-	err := store.Save(msg, 0)
+	err := store.Save(msg, NoExpiry)
 	if err != nil {
 		t.Errorf("Save without TTL failed: %v", err)
 	}
@@ -144,8 +147,8 @@ func TestMemoryStore_List_WithExpired(t *testing.T) {
 	msg1 := newTestMsg("source-non-expiring", "dest1")
 	msg2 := newTestMsg("source-expiring", "dest2")
 
-	store.Save(msg1, 0) // no expiration
-	store.Save(msg2, 1) // 1 nanosecond
+	store.Save(msg1, NoExpiry) // no expiration
+	store.Save(msg2, 1)        // 1 nanosecond
 
 	time.Sleep(15 * time.Millisecond)
 
@@ -282,7 +285,8 @@ func TestMemoryStore_TTLOperations(t *testing.T) {
 		wait    time.Duration
 		expired bool
 	}{
-		{"no_expiration", 0, 10 * time.Millisecond, false},
+		{"no_expiration", NoExpiry, 10 * time.Millisecond, false},
+		{"zero_ttl_immediate_delivery_only", 0, 0, true},
 		{"short_ttl_expires", 1, 10 * time.Millisecond, true},
 		{"long_ttl_no_expire", 1 * time.Hour, 10 * time.Millisecond, false},
 	}
@@ -305,6 +309,43 @@ func TestMemoryStore_TTLOperations(t *testing.T) {
 	}
 }
 
+func TestMemoryStore_ExpiryGrace(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetExpiryGrace(50 * time.Millisecond)
+	var purged []string
+	store.OnExpire = func(msg *protocol.Message) { purged = append(purged, msg.IDHex()) }
+
+	msg := newTestMsg("source", "dest")
+	store.Save(msg, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	// Expired but within the grace period: hidden, yet still held
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("Get returned an expired message")
+	}
+	if list, _ := store.List(); len(list) != 0 {
+		t.Errorf("List returned %d messages, want 0", len(list))
+	}
+	if got, expired := store.Lookup(msg.IDHex()); got == nil || !expired {
+		t.Errorf("Lookup = (%v, %v), want the message reported as expired", got, expired)
+	}
+	if len(purged) != 0 {
+		t.Errorf("message purged within the grace period")
+	}
+
+	// Past the grace period it is purged
+	time.Sleep(60 * time.Millisecond)
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("Get returned an expired message")
+	}
+	if got, _ := store.Lookup(msg.IDHex()); got != nil {
+		t.Error("message still held after the grace period")
+	}
+	if len(purged) != 1 || purged[0] != msg.IDHex() {
+		t.Errorf("OnExpire saw %v, want the message once", purged)
+	}
+}
+
 func BenchmarkMemoryStore_Save(b *testing.B) {
 	store := NewMemoryStore()
 	b.ResetTimer()
@@ -338,3 +379,476 @@ func BenchmarkMemoryStore_List(b *testing.B) {
 		store.List()
 	}
 }
+
+func TestMemoryStore_StartCleanup(t *testing.T) {
+	store := NewMemoryStore()
+	expired := make(chan *protocol.Message, 1)
+	store.OnExpire = func(msg *protocol.Message) { expired <- msg }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store.StartCleanup(ctx, 10*time.Millisecond)
+
+	// Neither message is ever read; only the sweep can remove the expired one
+	stale := newTestMsg("source", "dest")
+	store.Save(stale, time.Millisecond)
+	store.Save(newTestMsg("source", "dest"), time.Hour)
+
+	select {
+	case msg := <-expired:
+		if msg.IDHex() != stale.IDHex() {
+			t.Errorf("sweep removed %s, want %s", msg.IDHex(), stale.IDHex())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expired message was not swept")
+	}
+	if n := store.Count(); n != 1 {
+		t.Errorf("Count() = %d after sweep, want 1", n)
+	}
+}
+
+func TestMemoryStore_StartCleanupStop(t *testing.T) {
+	store := NewMemoryStore()
+	swept := make(chan *protocol.Message, 1)
+	store.OnExpire = func(msg *protocol.Message) { swept <- msg }
+
+	stop := store.StartCleanup(context.Background(), 5*time.Millisecond)
+	stop()
+	stop() // stopping twice is safe
+
+	// Once stopped, nothing sweeps the store
+	store.Save(newTestMsg("source", "dest"), time.Millisecond)
+	select {
+	case <-swept:
+		t.Fatal("sweep ran after stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n := store.Count(); n != 1 {
+		t.Errorf("Count() = %d, want the unswept message", n)
+	}
+
+	// A non-positive interval starts nothing but still returns a stop function
+	store.StartCleanup(context.Background(), 0)()
+}
+
+func TestMemoryStore_ListByRecipient(t *testing.T) {
+	store := NewMemoryStore()
+	first := newTestMsg("alice", "bob")
+	second := newTestMsg("alice", "bob")
+	other := newTestMsg("alice", "carol")
+	for _, msg := range []*protocol.Message{first, second, other, newTestMsg("alice", "")} {
+		store.Save(msg, 5*time.Minute)
+	}
+
+	got, err := store.ListByRecipient("bob")
+	if err != nil {
+		t.Fatalf("ListByRecipient failed: %v", err)
+	}
+	if len(got) != 2 || got[0] != first || got[1] != second {
+		t.Fatalf("ListByRecipient(bob) = %v, want both of bob's messages oldest first", got)
+	}
+	if got, _ := store.ListByRecipient("dave"); len(got) != 0 {
+		t.Errorf("ListByRecipient(dave) = %d messages, want 0", len(got))
+	}
+
+	// Delete drops a message from the index
+	store.Delete(first.IDHex())
+	if got, _ := store.ListByRecipient("bob"); len(got) != 1 || got[0] != second {
+		t.Errorf("after Delete, ListByRecipient(bob) = %v, want only the second message", got)
+	}
+
+	// Saving a message again under a new recipient moves it in the index
+	readdressed := *other
+	readdressed.To = "bob"
+	store.Save(&readdressed, 5*time.Minute)
+	if got, _ := store.ListByRecipient("carol"); len(got) != 0 {
+		t.Errorf("ListByRecipient(carol) = %d messages after readdressing, want 0", len(got))
+	}
+	if got, _ := store.ListByRecipient("bob"); len(got) != 2 {
+		t.Errorf("ListByRecipient(bob) = %d messages after readdressing, want 2", len(got))
+	}
+
+	store.Clear()
+	if got, _ := store.ListByRecipient("bob"); len(got) != 0 || len(store.recipients) != 0 {
+		t.Errorf("after Clear, ListByRecipient(bob) = %d messages and %d index entries, want none", len(got), len(store.recipients))
+	}
+}
+
+func TestMemoryStore_ListByRecipientPrunesExpired(t *testing.T) {
+	store := NewMemoryStore()
+	live := newTestMsg("alice", "bob")
+	store.Save(live, 5*time.Minute)
+	store.Save(newTestMsg("alice", "bob"), time.Millisecond)
+	store.Save(newTestMsg("alice", "carol"), time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+
+	// Listing prunes the recipient's expired messages from the index
+	if got, _ := store.ListByRecipient("bob"); len(got) != 1 || got[0] != live {
+		t.Fatalf("ListByRecipient(bob) = %v, want only the live message", got)
+	}
+	if ids := store.recipients["bob"]; len(ids) != 1 {
+		t.Errorf("index holds %d IDs for bob, want 1", len(ids))
+	}
+
+	// A sweep prunes those nobody listed
+	if _, indexed := store.recipients["carol"]; !indexed {
+		t.Fatal("carol's expired message should still be indexed before the sweep")
+	}
+	store.Sweep()
+	if _, indexed := store.recipients["carol"]; indexed {
+		t.Error("index still holds carol's expired message after Sweep")
+	}
+}
+
+// popConcurrently pops id from store in many goroutines at once and returns
+// how many got the message
+func popConcurrently(t *testing.T, store MessageStore, id string) int {
+	t.Helper()
+	const goroutines = 50
+	var got atomic.Int32
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			msg, err := store.Pop(id)
+			if err != nil {
+				t.Errorf("Pop failed: %v", err)
+			}
+			if msg != nil {
+				got.Add(1)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return int(got.Load())
+}
+
+func TestMemoryStore_Pop(t *testing.T) {
+	store := NewMemoryStore()
+	msg := newTestMsg("alice", "bob")
+	store.Save(msg, 5*time.Minute)
+
+	if got, err := store.Pop(msg.IDHex()); err != nil || got != msg {
+		t.Fatalf("Pop = %v, %v; want the message", got, err)
+	}
+	if got, _ := store.Get(msg.IDHex()); got != nil {
+		t.Error("Get found a popped message")
+	}
+	if got, _ := store.ListByRecipient("bob"); len(got) != 0 {
+		t.Error("popped message still indexed for its recipient")
+	}
+	if got, err := store.Pop(msg.IDHex()); err != nil || got != nil {
+		t.Errorf("second Pop = %v, %v; want nil", got, err)
+	}
+
+	expired := newTestMsg("alice", "bob")
+	store.Save(expired, 0)
+	if got, _ := store.Pop(expired.IDHex()); got != nil {
+		t.Error("Pop returned an expired message")
+	}
+}
+
+func TestMemoryStore_PopConcurrent(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < 20; i++ {
+		msg := newTestMsg("alice", "bob")
+		store.Save(msg, 5*time.Minute)
+		if n := popConcurrently(t, store, msg.IDHex()); n != 1 {
+			t.Fatalf("%d concurrent Pops got the message, want exactly 1", n)
+		}
+	}
+}
+
+func TestMemoryStore_ListFiltered(t *testing.T) {
+	store := NewMemoryStore()
+	store.Save(newTestMsg("alice", "bob"), 5*time.Minute)
+	store.Save(newTestMsg("alice", "carol"), 5*time.Minute)
+	store.Save(newTestMsg("dave", "bob"), 5*time.Minute)
+
+	toBob, err := store.ListFiltered(func(m *protocol.Message) bool { return m.To == "bob" })
+	if err != nil {
+		t.Fatalf("ListFiltered failed: %v", err)
+	}
+	if len(toBob) != 2 {
+		t.Errorf("Expected 2 messages to bob, got %d", len(toBob))
+	}
+
+	all, err := store.ListFiltered(nil)
+	if err != nil {
+		t.Fatalf("ListFiltered(nil) failed: %v", err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Expected 3 messages with nil filter, got %d", len(all))
+	}
+}
+
+func TestMemoryStore_OnExpire(t *testing.T) {
+	store := NewMemoryStore()
+
+	var fired []*protocol.Message
+	store.OnExpire = func(msg *protocol.Message) {
+		fired = append(fired, msg)
+	}
+
+	viaGet := newTestMsg("source", "dest")
+	viaList := newTestMsg("source", "dest")
+	live := newTestMsg("source", "dest")
+	store.Save(viaGet, 1) // 1 nanosecond
+	store.Save(viaList, 1)
+	store.Save(live, 5*time.Minute)
+
+	time.Sleep(10 * time.Millisecond)
+
+	if got, _ := store.Get(viaGet.IDHex()); got != nil {
+		t.Error("Get should return nil for expired message")
+	}
+	if len(fired) != 1 || fired[0] != viaGet {
+		t.Fatalf("OnExpire after Get: got %d calls, want 1 with the expired message", len(fired))
+	}
+
+	store.List()
+	if len(fired) != 2 || fired[1] != viaList {
+		t.Fatalf("OnExpire after List: got %d calls, want 2", len(fired))
+	}
+
+	// Already-pruned messages must not fire again
+	store.Get(viaGet.IDHex())
+	store.List()
+	if len(fired) != 2 {
+		t.Errorf("OnExpire fired again for pruned messages: got %d calls", len(fired))
+	}
+}
+
+func TestMemoryStore_PriorityEviction(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetMaxMessages(4)
+
+	control := protocol.NewMessage(protocol.MessageTypeACK, "a", "b", nil)
+	request := protocol.NewMessage(protocol.MessageTypeRequest, "a", "b", nil)
+	event1 := protocol.NewMessage(protocol.MessageTypeEvent, "a", "b", nil)
+	event2 := protocol.NewMessage(protocol.MessageTypeEvent, "a", "b", nil)
+
+	// Oldest first: control, request, then events
+	for _, m := range []*protocol.Message{control, request, event1, event2} {
+		store.Save(m, 5*time.Minute)
+	}
+
+	// Two more requests push out the two events before anything older
+	newer1 := protocol.NewMessage(protocol.MessageTypeRequest, "a", "b", nil)
+	newer2 := protocol.NewMessage(protocol.MessageTypeRequest, "a", "b", nil)
+	store.Save(newer1, 5*time.Minute)
+	store.Save(newer2, 5*time.Minute)
+
+	for _, m := range []*protocol.Message{event1, event2} {
+		if got, _ := store.Get(m.IDHex()); got != nil {
+			t.Error("low-priority event should have been evicted first")
+		}
+	}
+	for _, m := range []*protocol.Message{control, request, newer1, newer2} {
+		if got, _ := store.Get(m.IDHex()); got == nil {
+			t.Error("higher-priority message should have survived eviction")
+		}
+	}
+
+	// With no events left, the oldest request goes before the control message
+	store.Save(protocol.NewMessage(protocol.MessageTypeRequest, "a", "b", nil), 5*time.Minute)
+	if got, _ := store.Get(request.IDHex()); got != nil {
+		t.Error("oldest request should be evicted once no events remain")
+	}
+	if got, _ := store.Get(control.IDHex()); got == nil {
+		t.Error("control message should outlive requests")
+	}
+
+	all, _ := store.List()
+	if len(all) != 4 {
+		t.Errorf("store holds %d messages, want cap of 4", len(all))
+	}
+}
+
+func TestMemoryStore_MaxMessagesEvictsOldest(t *testing.T) {
+	const max, extra = 5, 3
+	store := NewMemoryStore()
+	store.SetMaxMessages(max)
+
+	var saved []*protocol.Message
+	for i := 0; i < max+extra; i++ {
+		msg := newTestMsg("a", "b")
+		store.Save(msg, 5*time.Minute)
+		saved = append(saved, msg)
+		if all, _ := store.List(); len(all) > max {
+			t.Fatalf("after %d saves the store holds %d messages, want at most %d", i+1, len(all), max)
+		}
+	}
+
+	for i, msg := range saved {
+		got, _ := store.Get(msg.IDHex())
+		if i < extra && got != nil {
+			t.Errorf("message %d should have been evicted", i)
+		}
+		if i >= extra && got == nil {
+			t.Errorf("message %d should have been kept", i)
+		}
+	}
+}
+
+// TestMemoryStore_EvictionLeavesExpiredToSweep verifies a Save at the cap
+// evicts by priority and age only, so an expired message in its grace period
+// is still readable with Lookup and purged through OnExpire by Sweep
+func TestMemoryStore_EvictionLeavesExpiredToSweep(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetMaxMessages(2)
+	store.SetExpiryGrace(time.Minute)
+	var purged []*protocol.Message
+	store.OnExpire = func(msg *protocol.Message) { purged = append(purged, msg) }
+
+	expired := protocol.NewMessage(protocol.MessageTypeACK, "a", "b", nil)
+	event := protocol.NewMessage(protocol.MessageTypeEvent, "a", "b", nil)
+	store.Save(expired, 1) // 1 nanosecond
+	store.Save(event, 5*time.Minute)
+	time.Sleep(10 * time.Millisecond)
+
+	store.Save(protocol.NewMessage(protocol.MessageTypeEvent, "a", "b", nil), 5*time.Minute)
+	if got, _ := store.Get(event.IDHex()); got != nil {
+		t.Error("oldest event should have been evicted")
+	}
+	if got, isExpired := store.Lookup(expired.IDHex()); got == nil || !isExpired {
+		t.Error("expired message should stay for its grace period")
+	}
+	if len(purged) != 0 {
+		t.Errorf("OnExpire fired %d times during eviction, want none", len(purged))
+	}
+
+	store.SetExpiryGrace(0)
+	store.Sweep()
+	if len(purged) != 1 || purged[0] != expired {
+		t.Errorf("Sweep purged %v, want the expired message", purged)
+	}
+}
+
+func TestMemoryStore_UnlimitedByDefault(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < 100; i++ {
+		store.Save(newTestMsg("a", "b"), 5*time.Minute)
+	}
+	all, _ := store.List()
+	if len(all) != 100 {
+		t.Errorf("Expected 100 messages, got %d", len(all))
+	}
+}
+
+func newSizedMsg(t protocol.MessageType, bodyLen int) *protocol.Message {
+	return protocol.NewMessage(t, "a", "b", make([]byte, bodyLen))
+}
+
+func TestMemoryStore_ByteBudgetEviction(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetMaxBytes(10000)
+
+	control := newSizedMsg(protocol.MessageTypeACK, 1000)
+	store.Save(control, 5*time.Minute)
+
+	var saved []*protocol.Message
+	for i := 0; i < 20; i++ {
+		m := newSizedMsg(protocol.MessageTypeEvent, 1000)
+		if err := store.Save(m, 5*time.Minute); err != nil {
+			t.Fatalf("Save #%d: %v", i, err)
+		}
+		saved = append(saved, m)
+		if total := store.TotalBytes(); total > 10000 {
+			t.Fatalf("after save #%d TotalBytes = %d, over the 10000 cap", i, total)
+		}
+	}
+
+	all, _ := store.List()
+	if len(all) < 8 || len(all) >= 21 {
+		t.Errorf("store holds %d messages, want the budget to fit roughly 9", len(all))
+	}
+	if got, _ := store.Get(saved[0].IDHex()); got != nil {
+		t.Error("oldest event should have been evicted")
+	}
+	if got, _ := store.Get(saved[19].IDHex()); got == nil {
+		t.Error("newest event should be stored")
+	}
+	if got, _ := store.Get(control.IDHex()); got == nil {
+		t.Error("control message should outlive events under the byte budget")
+	}
+}
+
+func TestMemoryStore_ByteBudgetRejectsOversized(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetMaxBytes(4096)
+	small := newSizedMsg(protocol.MessageTypeRequest, 100)
+	store.Save(small, 5*time.Minute)
+
+	if err := store.Save(newSizedMsg(protocol.MessageTypeRequest, 8192), 5*time.Minute); err != ErrMessageTooLarge {
+		t.Fatalf("Save oversized error = %v, want ErrMessageTooLarge", err)
+	}
+	if got, _ := store.Get(small.IDHex()); got == nil {
+		t.Error("rejected save should not evict existing messages")
+	}
+}
+
+func TestMemoryStore_ByteAccounting(t *testing.T) {
+	store := NewMemoryStore()
+	m := newSizedMsg(protocol.MessageTypeRequest, 1000)
+	store.Save(m, 5*time.Minute)
+
+	data, _ := m.CBORMarshal()
+	if diff := store.TotalBytes() - int64(len(data)); diff < -64 || diff > 64 {
+		t.Errorf("TotalBytes = %d, want close to encoded size %d", store.TotalBytes(), len(data))
+	}
+
+	// Replacing a message swaps its size rather than adding to it
+	m.Body = make([]byte, 3000)
+	store.Save(m, 5*time.Minute)
+	if total := store.TotalBytes(); total < 3000 || total > 3100 {
+		t.Errorf("TotalBytes after replace = %d, want about 3000", total)
+	}
+
+	store.Delete(m.IDHex())
+	if total := store.TotalBytes(); total != 0 {
+		t.Errorf("TotalBytes after delete = %d, want 0", total)
+	}
+
+	// Lowering the budget evicts what no longer fits
+	for i := 0; i < 5; i++ {
+		store.Save(newSizedMsg(protocol.MessageTypeRequest, 1000), 5*time.Minute)
+	}
+	store.SetMaxBytes(2500)
+	if total := store.TotalBytes(); total > 2500 {
+		t.Errorf("TotalBytes after SetMaxBytes = %d, want at most 2500", total)
+	}
+}
+
+func TestMemoryStore_Clear(t *testing.T) {
+	store := NewMemoryStore()
+	store.SetMaxMessages(10)
+	for i := 0; i < 5; i++ {
+		store.Save(newSizedMsg(protocol.MessageTypeRequest, 100), 5*time.Minute)
+	}
+
+	if err := store.Clear(); err != nil {
+		t.Fatalf("Clear() error: %v", err)
+	}
+	if all, _ := store.List(); len(all) != 0 {
+		t.Errorf("List() after Clear = %d messages, want 0", len(all))
+	}
+	if n := store.Count(); n != 0 {
+		t.Errorf("Count() after Clear = %d, want 0", n)
+	}
+	if total := store.TotalBytes(); total != 0 {
+		t.Errorf("TotalBytes() after Clear = %d, want 0", total)
+	}
+
+	// The store stays usable, with the eviction order reset
+	for i := 0; i < 10; i++ {
+		store.Save(newSizedMsg(protocol.MessageTypeRequest, 100), 5*time.Minute)
+	}
+	if n := store.Count(); n != 10 {
+		t.Errorf("Count() after refill = %d, want 10", n)
+	}
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// ErrTimeout is returned when a TimeoutStore operation outlives its timeout
+var ErrTimeout = errors.New("storage_timeout")
+
+// TimeoutStore wraps a MessageStore and bounds each operation by a timeout,
+// so a hung backend fails calls instead of blocking the message pipeline.
+// MessageStore takes no context, so a timed-out call keeps running in the
+// background until the backend returns; its result is discarded.
+type TimeoutStore struct {
+	store   MessageStore
+	timeout time.Duration
+}
+
+// NewTimeoutStore returns store with each operation limited to timeout
+func NewTimeoutStore(store MessageStore, timeout time.Duration) *TimeoutStore {
+	return &TimeoutStore{store: store, timeout: timeout}
+}
+
+// Save stores a message through the wrapped store
+func (ts *TimeoutStore) Save(message *protocol.Message, ttl time.Duration) error {
+	_, err := withTimeout(ts, OpSave, func() (struct{}, error) {
+		return struct{}{}, ts.store.Save(message, ttl)
+	})
+	return err
+}
+
+// Get retrieves a message through the wrapped store
+func (ts *TimeoutStore) Get(id string) (*protocol.Message, error) {
+	return withTimeout(ts, OpGet, func() (*protocol.Message, error) {
+		return ts.store.Get(id)
+	})
+}
+
+// Delete removes a message through the wrapped store
+func (ts *TimeoutStore) Delete(id string) error {
+	_, err := withTimeout(ts, OpDelete, func() (struct{}, error) {
+		return struct{}{}, ts.store.Delete(id)
+	})
+	return err
+}
+
+// Pop retrieves and deletes a message in the wrapped store
+func (ts *TimeoutStore) Pop(id string) (*protocol.Message, error) {
+	return withTimeout(ts, OpPop, func() (*protocol.Message, error) {
+		return ts.store.Pop(id)
+	})
+}
+
+// List returns all messages from the wrapped store
+func (ts *TimeoutStore) List() ([]*protocol.Message, error) {
+	return withTimeout(ts, OpList, ts.store.List)
+}
+
+// ListFiltered returns the messages accepted by filter from the wrapped store
+func (ts *TimeoutStore) ListFiltered(filter MessageFilter) ([]*protocol.Message, error) {
+	return withTimeout(ts, OpList, func() ([]*protocol.Message, error) {
+		return ts.store.ListFiltered(filter)
+	})
+}
+
+// ListByRecipient returns the messages addressed to did from the wrapped store
+func (ts *TimeoutStore) ListByRecipient(did string) ([]*protocol.Message, error) {
+	return withTimeout(ts, OpList, func() ([]*protocol.Message, error) {
+		return ts.store.ListByRecipient(did)
+	})
+}
+
+// Clear removes all messages from the wrapped store
+func (ts *TimeoutStore) Clear() error {
+	_, err := withTimeout(ts, OpClear, func() (struct{}, error) {
+		return struct{}{}, ts.store.Clear()
+	})
+	return err
+}
+
+// Subscribe implements Notifier using the wrapped store. If it cannot
+// notify, the returned channel never fires.
+func (ts *TimeoutStore) Subscribe(filter MessageFilter) (<-chan *protocol.Message, func()) {
+	if n, ok := ts.store.(Notifier); ok {
+		return n.Subscribe(filter)
+	}
+	return nil, func() {}
+}
+
+// withTimeout runs fn, giving up with ErrTimeout once ts.timeout has passed
+func withTimeout[T any](ts *TimeoutStore, op StoreOp, fn func() (T, error)) (T, error) {
+	type result struct {
+		value T
+		err   error
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), ts.timeout)
+	defer cancel()
+
+	// Buffered so an abandoned call can still finish and exit
+	done := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		done <- result{value, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, fmt.Errorf("%w: %s exceeded %v", ErrTimeout, op, ts.timeout)
+	}
+}
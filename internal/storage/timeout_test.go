@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+)
+
+// hungStore blocks every operation until release is closed
+type hungStore struct {
+	release chan struct{}
+}
+
+func (h *hungStore) Save(*protocol.Message, time.Duration) error { <-h.release; return nil }
+func (h *hungStore) Get(string) (*protocol.Message, error)       { <-h.release; return nil, nil }
+func (h *hungStore) Delete(string) error                         { <-h.release; return nil }
+func (h *hungStore) List() ([]*protocol.Message, error)          { <-h.release; return nil, nil }
+func (h *hungStore) Clear() error                                { <-h.release; return nil }
+func (h *hungStore) ListFiltered(MessageFilter) ([]*protocol.Message, error) {
+	<-h.release
+	return nil, nil
+}
+func (h *hungStore) Pop(string) (*protocol.Message, error) {
+	<-h.release
+	return nil, nil
+}
+func (h *hungStore) ListByRecipient(string) ([]*protocol.Message, error) {
+	<-h.release
+	return nil, nil
+}
+
+func TestTimeoutStore_TimesOut(t *testing.T) {
+	hung := &hungStore{release: make(chan struct{})}
+	defer close(hung.release)
+	store := NewTimeoutStore(hung, 10*time.Millisecond)
+	msg := newTestMsg("source", "dest")
+
+	ops := map[string]func() error{
+		"save":   func() error { return store.Save(msg, time.Minute) },
+		"get":    func() error { _, err := store.Get(msg.IDHex()); return err },
+		"delete": func() error { return store.Delete(msg.IDHex()) },
+		"list":   func() error { _, err := store.List(); return err },
+		"clear":  func() error { return store.Clear() },
+	}
+	for name, op := range ops {
+		start := time.Now()
+		err := op()
+		if !errors.Is(err, ErrTimeout) {
+			t.Errorf("%s: got %v, want ErrTimeout", name, err)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "storage_timeout: "+name) {
+			t.Errorf("%s: error %q doesn't name the operation", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: returned after %v, want about the 10ms timeout", name, elapsed)
+		}
+	}
+}
+
+func TestTimeoutStore_PassesThrough(t *testing.T) {
+	store := NewTimeoutStore(ReadOnly(NewMemoryStore()), time.Second)
+	if err := store.Save(newTestMsg("source", "dest"), time.Minute); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Save: got %v, want the wrapped store's ErrReadOnly", err)
+	}
+
+	store = NewTimeoutStore(NewMemoryStore(), time.Second)
+	msg := newTestMsg("source", "dest")
+	if err := store.Save(msg, time.Minute); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if got, err := store.Get(msg.IDHex()); err != nil || got != msg {
+		t.Errorf("Get = %v, %v; want the message", got, err)
+	}
+}
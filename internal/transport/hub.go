@@ -0,0 +1,126 @@
+package transport
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+// hubShard owns the clients whose IDs hash to it. Each shard runs its own
+// hub goroutine, so registration and broadcast fan-out spread across cores
+// instead of queuing behind a single hub.
+type hubShard struct {
+	// clients maps client ID to *Client. Senders look clients up without
+	// locking; mu serializes the hub's changes and snapshots with Stop.
+	clients sync.Map
+	count   atomic.Int64
+	mu      sync.RWMutex
+
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan []byte
+}
+
+// newHubShards creates n empty shards (at least one), each queuing up to
+// backlog broadcasts
+func newHubShards(n, backlog int) []*hubShard {
+	if n < 1 {
+		n = 1
+	}
+	shards := make([]*hubShard, n)
+	for i := range shards {
+		shards[i] = &hubShard{
+			register:   make(chan *Client, hubQueueSize),
+			unregister: make(chan *Client, hubQueueSize),
+			broadcast:  make(chan []byte, backlog),
+		}
+	}
+	return shards
+}
+
+// shardFor returns the shard that owns clientID
+func (ws *WebSocketServer) shardFor(clientID string) *hubShard {
+	if len(ws.shards) == 1 {
+		return ws.shards[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(clientID))
+	return ws.shards[h.Sum32()%uint32(len(ws.shards))]
+}
+
+// lookupClient returns the connected client with the given ID without
+// taking any lock, so concurrent sends don't contend with each other or the hub
+func (ws *WebSocketServer) lookupClient(clientID string) (*Client, bool) {
+	v, exists := ws.shardFor(clientID).clients.Load(clientID)
+	if !exists {
+		return nil, false
+	}
+	return v.(*Client), true
+}
+
+// snapshot returns the shard's current clients
+func (shard *hubShard) snapshot() []*Client {
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	clients := make([]*Client, 0, shard.count.Load())
+	shard.clients.Range(func(_, v interface{}) bool {
+		clients = append(clients, v.(*Client))
+		return true
+	})
+	return clients
+}
+
+// closeAll closes and forgets every client in the shard
+func (shard *hubShard) closeAll() {
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	shard.clients.Range(func(id, v interface{}) bool {
+		v.(*Client).Close()
+		shard.clients.Delete(id)
+		return true
+	})
+	shard.count.Store(0)
+}
+
+// runHub manages client registration/unregistration and broadcasting for one shard
+func (ws *WebSocketServer) runHub(shard *hubShard) {
+	defer ws.wg.Done()
+
+	for {
+		select {
+		case <-ws.ctx.Done():
+			return
+
+		case client := <-shard.register:
+			shard.mu.Lock()
+			if _, replaced := shard.clients.Swap(client.ID, client); !replaced {
+				shard.count.Add(1)
+			}
+			shard.mu.Unlock()
+
+		case client := <-shard.unregister:
+			shard.mu.Lock()
+			_, exists := shard.clients.LoadAndDelete(client.ID)
+			if exists {
+				shard.count.Add(-1)
+				client.closeSendChan()
+			}
+			shard.mu.Unlock()
+
+			if exists && ws.disconnectHandler != nil {
+				go ws.disconnectHandler(client.ID)
+			}
+
+		case message := <-shard.broadcast:
+			// Send to all clients
+			for _, client := range shard.snapshot() {
+				select {
+				case client.SendChan <- message:
+				default:
+					// Client send buffer full, close connection
+					client.Close()
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,429 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketServer_ShardedHub(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.HubShards = 4
+	ids := make(chan string, 16)
+	server.SetMessageHandler(func(clientID string, data []byte) error {
+		ids <- clientID
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+	if len(server.shards) != 4 {
+		t.Fatalf("shards = %d, want 4", len(server.shards))
+	}
+
+	s := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer s.Close()
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	// Each connection says hello so we learn its client ID
+	conns := make(map[string]*websocket.Conn)
+	for i := 0; i < 16; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte("hello")); err != nil {
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+		select {
+		case id := <-ids:
+			conns[id] = conn
+		case <-time.After(time.Second):
+			t.Fatal("hello not handled")
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for server.GetClientCount() != 16 {
+		if time.Now().After(deadline) {
+			t.Fatalf("GetClientCount() = %d, want 16", server.GetClientCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	used := 0
+	for _, shard := range server.shards {
+		if shard.count.Load() > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Errorf("clients landed on %d shard(s), want them spread", used)
+	}
+
+	read := func(id string, conn *websocket.Conn, want string) {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, data, err := conn.ReadMessage()
+		if err != nil || string(data) != want {
+			t.Fatalf("client %s read %q, %v; want %q", id, data, err, want)
+		}
+	}
+
+	// Direct sends find the client whichever shard holds it
+	for id, conn := range conns {
+		if !server.SendToClient(id, []byte("to "+id)) {
+			t.Fatalf("SendToClient(%s) = false", id)
+		}
+		read(id, conn, "to "+id)
+	}
+
+	// A broadcast reaches every shard's clients
+	server.Broadcast([]byte("everyone"))
+	for id, conn := range conns {
+		read(id, conn, "everyone")
+	}
+
+	// Disconnects are handled by the owning shard
+	for id, conn := range conns {
+		conn.Close()
+		delete(conns, id)
+		if len(conns) == 8 {
+			break
+		}
+	}
+	deadline = time.Now().Add(time.Second)
+	for server.GetClientCount() != 8 {
+		if time.Now().After(deadline) {
+			t.Fatalf("GetClientCount() = %d after disconnects, want 8", server.GetClientCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// addQueueClients registers n connection-less clients whose send queues
+// are drained in the background, waiting until the hub holds them all.
+// removeQueueClients must run before the server stops, which would try to
+// close their (missing) connections.
+func addQueueClients(server *WebSocketServer, n int) []*Client {
+	clients := make([]*Client, n)
+	for i := range clients {
+		client := &Client{ID: fmt.Sprintf("client-%d", i), Server: server, SendChan: make(chan []byte, 4096)}
+		go func() {
+			for range client.SendChan {
+			}
+		}()
+		server.shardFor(client.ID).register <- client
+		clients[i] = client
+	}
+	for server.GetClientCount() < n {
+		time.Sleep(time.Millisecond)
+	}
+	return clients
+}
+
+// removeQueueClients unregisters clients, which closes their queues and
+// ends their drainers, and waits until the hub has let them all go
+func removeQueueClients(server *WebSocketServer, clients []*Client) {
+	for _, client := range clients {
+		server.shardFor(client.ID).unregister <- client
+	}
+	for server.GetClientCount() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// churnClient registers and then unregisters a connection-less client,
+// waiting for the hub to take each step since it serves the two queues in
+// no particular order
+func churnClient(server *WebSocketServer, id string) {
+	client := &Client{ID: id, Server: server, SendChan: make(chan []byte, 1)}
+	shard := server.shardFor(id)
+	shard.register <- client
+	for _, ok := server.lookupClient(id); !ok; _, ok = server.lookupClient(id) {
+		runtime.Gosched()
+	}
+	shard.unregister <- client
+	for _, ok := server.lookupClient(id); ok; _, ok = server.lookupClient(id) {
+		runtime.Gosched()
+	}
+}
+
+func TestWebSocketServer_SendDoesNotWaitForHub(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+	clients := addQueueClients(server, 1)
+	defer removeQueueClients(server, clients)
+
+	// With the hub's lock held, as during a registration or broadcast
+	// snapshot, sends still find their client
+	hub := server.shards[0]
+	hub.mu.Lock()
+	sent := make(chan bool)
+	go func() { sent <- server.SendToClient(clients[0].ID, []byte("hi")) }()
+	select {
+	case ok := <-sent:
+		hub.mu.Unlock()
+		if !ok {
+			t.Error("SendToClient = false for a registered client")
+		}
+	case <-time.After(time.Second):
+		hub.mu.Unlock()
+		t.Fatal("SendToClient blocked on the hub lock")
+	}
+}
+
+func TestWebSocketServer_ConcurrentSendsAndChurn(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.HubShards = 4
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+	clients := addQueueClients(server, 64)
+
+	// Clients come and go while others are sent to from many goroutines
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			churnClient(server, fmt.Sprintf("churn-%d", i))
+		}
+	}()
+
+	var failed atomic.Int64
+	var senders sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		senders.Add(1)
+		go func(g int) {
+			defer senders.Done()
+			for i := 0; i < 500; i++ {
+				client := clients[(g*500+i)%len(clients)]
+				if !server.SendToClient(client.ID, []byte("ping")) {
+					failed.Add(1)
+				}
+			}
+		}(g)
+	}
+	senders.Wait()
+	close(stop)
+	wg.Wait()
+
+	if n := failed.Load(); n != 0 {
+		t.Errorf("%d sends to registered clients failed", n)
+	}
+	for server.GetClientCount() != len(clients) {
+		time.Sleep(time.Millisecond)
+	}
+	removeQueueClients(server, clients)
+}
+
+func TestWebSocketServer_SendRacingUnregister(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	// Senders hammer a client while the hub unregisters it and closes its
+	// queue; sends must fail cleanly rather than panic
+	for round := 0; round < 50; round++ {
+		client := addQueueClients(server, 1)[0]
+		var wg sync.WaitGroup
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					server.SendToClientTimeout(client.ID, []byte("x"), time.Millisecond)
+				}
+			}()
+		}
+		removeQueueClients(server, []*Client{client})
+		wg.Wait()
+
+		if !client.IsClosed() {
+			t.Fatal("unregistered client not marked closed")
+		}
+		if client.send([]byte("late"), time.Millisecond) {
+			t.Fatal("send to an unregistered client succeeded")
+		}
+	}
+}
+
+func TestWebSocketServer_BroadcastBacklogOverflow(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.BroadcastBacklog = 2
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+	hub := server.shards[0]
+	if got := cap(hub.broadcast); got != 2 {
+		t.Fatalf("broadcast backlog = %d, want 2", got)
+	}
+
+	// Stall the hub on its first broadcast, then fill the backlog
+	hub.mu.Lock()
+	server.Broadcast([]byte("first"))
+	for len(hub.broadcast) != 0 {
+		runtime.Gosched()
+	}
+	start := time.Now()
+	server.Broadcast([]byte("queued-1"))
+	server.Broadcast([]byte("queued-2"))
+	if elapsed := time.Since(start); elapsed >= broadcastTimeout {
+		t.Errorf("queuing within the backlog took %v", elapsed)
+	}
+	if n := server.DroppedBroadcasts(); n != 0 {
+		t.Errorf("DroppedBroadcasts() = %d within the backlog, want 0", n)
+	}
+
+	// One more waits out the timeout and is dropped and counted
+	start = time.Now()
+	server.Broadcast([]byte("overflow"))
+	if elapsed := time.Since(start); elapsed < broadcastTimeout {
+		t.Errorf("overflowing broadcast returned after %v, want it to wait %v", elapsed, broadcastTimeout)
+	}
+	if n := server.DroppedBroadcasts(); n != 1 {
+		t.Errorf("DroppedBroadcasts() = %d after overflow, want 1", n)
+	}
+	if n := len(hub.broadcast); n != 2 {
+		t.Errorf("backlog holds %d broadcasts, want 2", n)
+	}
+
+	// Once the hub recovers the backlog drains
+	hub.mu.Unlock()
+	deadline := time.Now().Add(time.Second)
+	for len(hub.broadcast) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("backlog did not drain")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// BenchmarkClientLookup compares parallel client lookups through the old
+// RWMutex-guarded map with the lock-free lookup SendToClient now uses,
+// while the hub keeps registering and unregistering clients
+func BenchmarkClientLookup(b *testing.B) {
+	const numClients = 1000
+	ids := make([]string, numClients)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("client-%d", i)
+	}
+
+	b.Run("rwmutex-map", func(b *testing.B) {
+		var mu sync.RWMutex
+		clients := make(map[string]*Client, numClients)
+		for _, id := range ids {
+			clients[id] = &Client{ID: id}
+		}
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := fmt.Sprintf("churn-%d", i%64)
+				mu.Lock()
+				clients[id] = &Client{ID: id}
+				mu.Unlock()
+				mu.Lock()
+				delete(clients, id)
+				mu.Unlock()
+			}
+		}()
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				mu.RLock()
+				_ = clients[ids[i%numClients]]
+				mu.RUnlock()
+			}
+		})
+	})
+
+	b.Run("lock-free", func(b *testing.B) {
+		server := NewWebSocketServer("127.0.0.1:0", nil)
+		if err := server.Start(); err != nil {
+			b.Fatalf("Start failed: %v", err)
+		}
+		defer server.Stop()
+		clients := addQueueClients(server, numClients)
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				churnClient(server, fmt.Sprintf("churn-%d", i%64))
+			}
+		}()
+
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				server.lookupClient(ids[i%numClients])
+			}
+		})
+		b.StopTimer()
+		close(stop)
+		<-done
+		for server.GetClientCount() != numClients {
+			time.Sleep(time.Millisecond)
+		}
+		removeQueueClients(server, clients)
+	})
+}
+
+// BenchmarkWebSocketServer_ShardedBroadcast fans broadcasts out to 2000
+// clients with the hub split across different shard counts
+func BenchmarkWebSocketServer_ShardedBroadcast(b *testing.B) {
+	for _, shards := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			server := NewWebSocketServer("127.0.0.1:0", nil)
+			server.HubShards = shards
+			if err := server.Start(); err != nil {
+				b.Fatalf("Start failed: %v", err)
+			}
+			defer server.Stop()
+			clients := addQueueClients(server, 2000)
+
+			message := []byte("benchmark broadcast message")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				server.Broadcast(message)
+			}
+			b.StopTimer()
+			removeQueueClients(server, clients)
+		})
+	}
+}
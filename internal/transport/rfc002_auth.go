@@ -2,9 +2,15 @@
 package transport
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -18,7 +24,7 @@ type AuthFrame struct {
 	// Agent's DID
 	DID string `json:"did"`
 
-	// Signature of the connection nonce (or timestamp)
+	// Signature of the connection nonce, base64 (standard encoding)
 	Signature string `json:"signature"`
 
 	// Signature algorithm (e.g., "ed25519")
@@ -34,6 +40,22 @@ type AuthFrame struct {
 	Nonce string `json:"nonce,omitempty"`
 }
 
+// ChallengeFrame is sent by the server on connect and carries the nonce the
+// client must sign in its auth frame
+type ChallengeFrame struct {
+	// Message type: always "challenge"
+	Type string `json:"type"`
+
+	// Server-issued nonce, valid once and only until NonceTTL elapses
+	Nonce string `json:"nonce"`
+
+	// Server's DID (optional)
+	ServerDID string `json:"server_did,omitempty"`
+
+	// Server timestamp
+	Timestamp int64 `json:"timestamp"`
+}
+
 // AuthResponse represents the authentication response (RFC-002 §3.1)
 type AuthResponse struct {
 	// Response type: "auth_ok" or "auth_fail"
@@ -53,6 +75,38 @@ type AuthResponse struct {
 
 	// Server timestamp
 	Timestamp int64 `json:"timestamp"`
+
+	// Server's signature for mutual auth (RFC-002): base64 (standard
+	// encoding) of an Ed25519 signature over ServerAuthPayload(nonce,
+	// client DID, ServerDID, Timestamp), where nonce is the one the client
+	// sent in its AuthFrame. Clients check it with VerifyServerSignature
+	// using the public key from the server DID's document.
+	Signature string `json:"signature,omitempty"`
+
+	// Signature algorithm, "ed25519" when Signature is set
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// ServerAuthPayload builds the bytes the server signs in an auth_ok
+// response. Binding the client DID, server DID and timestamp to the
+// client's nonce keeps a signature from being replayed to another client
+// or for another server identity.
+func ServerAuthPayload(nonce, clientDID, serverDID string, timestamp int64) []byte {
+	return []byte("amp-auth-ok\n" + nonce + "\n" + clientDID + "\n" + serverDID + "\n" + strconv.FormatInt(timestamp, 10))
+}
+
+// VerifyServerSignature checks the mutual-auth signature in an auth_ok
+// response against the server's public key, the nonce the client sent and
+// the client's own DID
+func VerifyServerSignature(publicKey ed25519.PublicKey, resp *AuthResponse, nonce, clientDID string) bool {
+	if resp.Signature == "" || resp.Algorithm != "ed25519" || len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(publicKey, ServerAuthPayload(nonce, clientDID, resp.ServerDID, resp.Timestamp), sig)
 }
 
 // AuthenticatedClient extends Client with auth state
@@ -64,21 +118,63 @@ type AuthenticatedClient struct {
 	AuthTime      time.Time
 }
 
+// UnauthenticatedPolicy is the response to a non-auth message received
+// before a client has authenticated
+type UnauthenticatedPolicy int
+
+const (
+	// CloseUnauthenticated answers auth_required and closes the connection
+	CloseUnauthenticated UnauthenticatedPolicy = iota
+
+	// RetryUnauthenticated answers auth_required and keeps the connection
+	// open so the client can still authenticate within AuthTimeout
+	RetryUnauthenticated
+)
+
+// SignatureVerifier checks the signature in an auth frame over the
+// connection nonce against the keys of the claimed DID
+type SignatureVerifier interface {
+	Verify(did string, signature []byte, nonce string) (bool, error)
+}
+
 // WebSocketAuthHandler handles RFC-002 authentication
 type WebSocketAuthHandler struct {
-	// Authenticator interface
-	Authenticator interface {
-		Verify(did string, signature []byte, nonce string) (bool, error)
-	}
+	// Authenticator verifies auth frame signatures (nil = any DID is accepted)
+	Authenticator SignatureVerifier
 
 	// Server's own DID (for mutual authentication)
 	ServerDID string
 
+	// Server's signing key; when set, auth_ok responses to frames carrying
+	// a nonce are signed so the client can authenticate the server
+	ServerKey ed25519.PrivateKey
+
 	// Default max message size (1 MiB as per RFC-002)
 	DefaultMaxMsgSize int
 
 	// Auth timeout (RFC-002: must auth within reasonable time)
 	AuthTimeout time.Duration
+
+	// RequireChallenge makes HandleAuth reject auth frames whose nonce was
+	// not issued to the connection by SendChallenge, so a signed nonce cannot
+	// be replayed. Authenticate always requires the nonce it issued.
+	RequireChallenge bool
+
+	// NonceTTL bounds how long an issued nonce can be used
+	NonceTTL time.Duration
+
+	// OnUnauthenticated decides what happens to a client that sends a
+	// non-auth message before authenticating
+	OnUnauthenticated UnauthenticatedPolicy
+
+	noncesMu sync.Mutex
+	nonces   map[string]issuedNonce
+}
+
+// issuedNonce records who a challenge nonce was issued to and until when
+type issuedNonce struct {
+	clientID string
+	expiry   time.Time
 }
 
 // NewWebSocketAuthHandler creates a new auth handler
@@ -86,11 +182,150 @@ func NewWebSocketAuthHandler() *WebSocketAuthHandler {
 	return &WebSocketAuthHandler{
 		DefaultMaxMsgSize: 1024 * 1024, // 1 MiB
 		AuthTimeout:       30 * time.Second,
+		NonceTTL:          60 * time.Second,
+		nonces:            make(map[string]issuedNonce),
+	}
+}
+
+// IssueNonce creates a single-use nonce bound to clientID
+func (h *WebSocketAuthHandler) IssueNonce(clientID string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+	nonce := hex.EncodeToString(buf)
+
+	now := time.Now()
+	h.noncesMu.Lock()
+	defer h.noncesMu.Unlock()
+	if h.nonces == nil {
+		h.nonces = make(map[string]issuedNonce)
+	}
+	// Drop nonces that were never used
+	for n, issued := range h.nonces {
+		if now.After(issued.expiry) {
+			delete(h.nonces, n)
+		}
+	}
+	h.nonces[nonce] = issuedNonce{clientID: clientID, expiry: now.Add(h.NonceTTL)}
+	return nonce, nil
+}
+
+// consumeNonce reports whether nonce was issued to clientID and has not
+// expired, removing it so it cannot be used again
+func (h *WebSocketAuthHandler) consumeNonce(clientID, nonce string) bool {
+	h.noncesMu.Lock()
+	defer h.noncesMu.Unlock()
+
+	issued, ok := h.nonces[nonce]
+	if !ok {
+		return false
+	}
+	delete(h.nonces, nonce)
+	return issued.clientID == clientID && !time.Now().After(issued.expiry)
+}
+
+// DropClient discards the unused nonces issued to a departed client
+func (h *WebSocketAuthHandler) DropClient(clientID string) {
+	h.noncesMu.Lock()
+	defer h.noncesMu.Unlock()
+	for n, issued := range h.nonces {
+		if issued.clientID == clientID {
+			delete(h.nonces, n)
+		}
+	}
+}
+
+// SendChallenge issues a nonce for client and sends it in a challenge frame.
+// It must be called before the connection's write pump starts.
+func (h *WebSocketAuthHandler) SendChallenge(client *Client) (string, error) {
+	nonce, err := h.IssueNonce(client.ID)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(ChallengeFrame{
+		Type:      "challenge",
+		Nonce:     nonce,
+		ServerDID: h.ServerDID,
+		Timestamp: time.Now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := client.Conn.WriteMessage(authFrameType(client), data); err != nil {
+		return "", err
+	}
+	return nonce, nil
+}
+
+// maxAuthFrameSize caps frames read during the handshake
+const maxAuthFrameSize = 16 * 1024
+
+// Authenticate runs the RFC-002 handshake on a new connection: it sends a
+// challenge, then reads frames until the client authenticates, answering
+// the auth frame with auth_ok or auth_fail. Other frames are answered per
+// OnUnauthenticated. It returns the authenticated DID, or an error once the
+// connection has been closed. It must run before the connection's pumps start.
+func (h *WebSocketAuthHandler) Authenticate(client *Client) (string, error) {
+	connectedAt := time.Now()
+	did, err := h.authenticate(client, connectedAt)
+	if err != nil {
+		h.DropClient(client.ID)
+		client.Close()
+	}
+	return did, err
+}
+
+// authenticate is Authenticate without the cleanup on failure
+func (h *WebSocketAuthHandler) authenticate(client *Client, connectedAt time.Time) (string, error) {
+	if _, err := h.SendChallenge(client); err != nil {
+		return "", fmt.Errorf("send challenge: %w", err)
+	}
+
+	client.Conn.SetReadLimit(maxAuthFrameSize)
+	if h.AuthTimeout > 0 {
+		client.Conn.SetReadDeadline(connectedAt.Add(h.AuthTimeout))
+	}
+	for {
+		_, frame, err := client.Conn.ReadMessage()
+		if err != nil {
+			return "", fmt.Errorf("read auth frame: %w", err)
+		}
+
+		var probe struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal(frame, &probe) != nil || probe.Type != RFC002Constants.MsgTypeAuth {
+			closed, err := h.HandleUnauthenticated(client, connectedAt)
+			if closed {
+				return "", fmt.Errorf("message before authentication (reply: %v)", err)
+			}
+			continue
+		}
+
+		resp, did, authErr := h.handleAuth(client, frame, true)
+		if err := sendAuthResponse(client, resp); err != nil {
+			return "", fmt.Errorf("send auth response: %w", err)
+		}
+		if authErr != nil {
+			return "", authErr
+		}
+		return did, nil
 	}
 }
 
 // HandleAuth processes the authentication frame
 func (h *WebSocketAuthHandler) HandleAuth(client *Client, frame []byte) (*AuthResponse, error) {
+	resp, _, err := h.handleAuth(client, frame, h.RequireChallenge)
+	return resp, err
+}
+
+// handleAuth is HandleAuth, also returning the DID that authenticated.
+// With requireNonce, the frame must carry a nonce issued to the client.
+func (h *WebSocketAuthHandler) handleAuth(client *Client, frame []byte, requireNonce bool) (*AuthResponse, string, error) {
 	var authFrame AuthFrame
 	if err := json.Unmarshal(frame, &authFrame); err != nil {
 		return &AuthResponse{
@@ -98,7 +333,7 @@ func (h *WebSocketAuthHandler) HandleAuth(client *Client, frame []byte) (*AuthRe
 			Error:     "invalid auth frame format",
 			ErrorCode: "invalid_format",
 			Timestamp: time.Now().Unix(),
-		}, fmt.Errorf("unmarshal auth frame: %w", err)
+		}, "", fmt.Errorf("unmarshal auth frame: %w", err)
 	}
 
 	// Validate frame type
@@ -108,7 +343,7 @@ func (h *WebSocketAuthHandler) HandleAuth(client *Client, frame []byte) (*AuthRe
 			Error:     "expected auth frame",
 			ErrorCode: "invalid_type",
 			Timestamp: time.Now().Unix(),
-		}, fmt.Errorf("expected auth frame, got: %s", authFrame.Type)
+		}, "", fmt.Errorf("expected auth frame, got: %s", authFrame.Type)
 	}
 
 	// Validate DID format (basic check)
@@ -118,7 +353,7 @@ func (h *WebSocketAuthHandler) HandleAuth(client *Client, frame []byte) (*AuthRe
 			Error:     "DID cannot be empty",
 			ErrorCode: "invalid_did",
 			Timestamp: time.Now().Unix(),
-		}, fmt.Errorf("empty DID")
+		}, "", fmt.Errorf("empty DID")
 	}
 
 	// Check timestamp for replay protection (±5 minutes)
@@ -129,11 +364,47 @@ func (h *WebSocketAuthHandler) HandleAuth(client *Client, frame []byte) (*AuthRe
 			Error:     "timestamp out of acceptable range",
 			ErrorCode: "invalid_timestamp",
 			Timestamp: now,
-		}, fmt.Errorf("timestamp out of range")
+		}, "", fmt.Errorf("timestamp out of range")
 	}
 
-	// TODO: Real signature verification
-	// For now, placeholder accepts any DID
+	// The nonce must be one this server issued to this connection
+	if requireNonce {
+		clientID := ""
+		if client != nil {
+			clientID = client.ID
+		}
+		if authFrame.Nonce == "" || !h.consumeNonce(clientID, authFrame.Nonce) {
+			return &AuthResponse{
+				Type:      "auth_fail",
+				Error:     "nonce was not issued to this connection or has expired",
+				ErrorCode: "invalid_nonce",
+				Timestamp: now,
+			}, "", fmt.Errorf("invalid nonce from %s", authFrame.DID)
+		}
+	}
+
+	// Verify the signature over the nonce when a verifier is configured;
+	// without one, any DID is accepted (placeholder mode)
+	if h.Authenticator != nil {
+		sig, err := base64.StdEncoding.DecodeString(authFrame.Signature)
+		if err != nil {
+			return &AuthResponse{
+				Type:      "auth_fail",
+				Error:     "signature is not valid base64",
+				ErrorCode: "invalid_signature",
+				Timestamp: now,
+			}, "", fmt.Errorf("decode signature: %w", err)
+		}
+		ok, err := h.Authenticator.Verify(authFrame.DID, sig, authFrame.Nonce)
+		if err != nil || !ok {
+			return &AuthResponse{
+				Type:      "auth_fail",
+				Error:     "signature verification failed",
+				ErrorCode: "invalid_signature",
+				Timestamp: now,
+			}, "", fmt.Errorf("signature verification failed for %s: %v", authFrame.DID, err)
+		}
+	}
 	log.Printf("[AUTH] Authenticating DID: %s", authFrame.DID)
 
 	// Negotiate max_msg_size
@@ -143,12 +414,37 @@ func (h *WebSocketAuthHandler) HandleAuth(client *Client, frame []byte) (*AuthRe
 	}
 
 	// Success
-	return &AuthResponse{
+	resp := &AuthResponse{
 		Type:       "auth_ok",
 		ServerDID:  h.ServerDID,
 		MaxMsgSize: negotiatedMax,
 		Timestamp:  now,
-	}, nil
+	}
+
+	// Mutual auth: prove the server's identity over the client's nonce
+	if h.ServerKey != nil && authFrame.Nonce != "" {
+		payload := ServerAuthPayload(authFrame.Nonce, authFrame.DID, h.ServerDID, now)
+		resp.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(h.ServerKey, payload))
+		resp.Algorithm = "ed25519"
+	}
+
+	return resp, authFrame.DID, nil
+}
+
+// HandleUnauthenticated answers a message from a client that has not yet
+// authenticated with an auth_required failure, then applies OnUnauthenticated.
+// connectedAt is when the connection was accepted: once AuthTimeout has
+// passed the connection is closed under either policy. It reports whether
+// the connection was closed.
+func (h *WebSocketAuthHandler) HandleUnauthenticated(client *Client, connectedAt time.Time) (bool, error) {
+	err := SendAuthFailure(client, "authentication required before sending messages", "auth_required")
+	if err == nil && h.OnUnauthenticated == RetryUnauthenticated &&
+		(h.AuthTimeout <= 0 || time.Since(connectedAt) < h.AuthTimeout) {
+		return false, nil
+	}
+
+	client.Close()
+	return true, err
 }
 
 // SendAuthFailure sends an auth failure response and closes connection
@@ -166,7 +462,7 @@ func SendAuthFailure(client *Client, error string, errorCode string) error {
 	}
 
 	client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return client.Conn.WriteMessage(websocket.BinaryMessage, data)
+	return client.Conn.WriteMessage(authFrameType(client), data)
 }
 
 // SendAuthSuccess sends an auth success response
@@ -184,7 +480,27 @@ func SendAuthSuccess(client *Client, serverDID string, maxMsgSize int) error {
 	}
 
 	client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-	return client.Conn.WriteMessage(websocket.BinaryMessage, data)
+	return client.Conn.WriteMessage(authFrameType(client), data)
+}
+
+// sendAuthResponse sends a response built by HandleAuth
+func sendAuthResponse(client *Client, resp *AuthResponse) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return client.Conn.WriteMessage(authFrameType(client), data)
+}
+
+// authFrameType returns the WebSocket opcode for auth frames sent to client:
+// the server's AuthFrameType if set, otherwise text since the frames are JSON
+func authFrameType(client *Client) int {
+	if client.Server != nil && client.Server.AuthFrameType != 0 {
+		return client.Server.AuthFrameType
+	}
+	return websocket.TextMessage
 }
 
 // RFC002Constants defines RFC-002 protocol constants
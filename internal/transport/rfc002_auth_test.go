@@ -0,0 +1,479 @@
+package transport
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newAuthFrame(t *testing.T, did, nonce string) []byte {
+	t.Helper()
+	data, err := json.Marshal(AuthFrame{
+		Type:      "auth",
+		DID:       did,
+		Signature: "placeholder",
+		Algorithm: "ed25519",
+		Timestamp: time.Now().Unix(),
+		Nonce:     nonce,
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return data
+}
+
+func TestHandleAuth_MutualAuthSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	h := NewWebSocketAuthHandler()
+	h.ServerDID = "did:web:relay.example"
+	h.ServerKey = priv
+
+	resp, err := h.HandleAuth(nil, newAuthFrame(t, "did:web:alice", "nonce-123"))
+	if err != nil {
+		t.Fatalf("HandleAuth: %v", err)
+	}
+	if resp.Type != "auth_ok" || resp.ServerDID != "did:web:relay.example" {
+		t.Fatalf("response = %+v, want auth_ok from the server DID", resp)
+	}
+	if !VerifyServerSignature(pub, resp, "nonce-123", "did:web:alice") {
+		t.Fatal("server signature did not verify")
+	}
+
+	// The signature is bound to the nonce, the client and the server key
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if VerifyServerSignature(pub, resp, "nonce-456", "did:web:alice") {
+		t.Error("signature verified for a different nonce")
+	}
+	if VerifyServerSignature(pub, resp, "nonce-123", "did:web:mallory") {
+		t.Error("signature verified for a different client")
+	}
+	if VerifyServerSignature(otherPub, resp, "nonce-123", "did:web:alice") {
+		t.Error("signature verified with the wrong public key")
+	}
+
+	tampered := *resp
+	tampered.ServerDID = "did:web:imposter.example"
+	if VerifyServerSignature(pub, &tampered, "nonce-123", "did:web:alice") {
+		t.Error("signature verified after the server DID was changed")
+	}
+}
+
+func TestHandleAuth_NoSignatureWithoutKeyOrNonce(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+
+	unkeyed := NewWebSocketAuthHandler()
+	resp, err := unkeyed.HandleAuth(nil, newAuthFrame(t, "did:web:alice", "nonce-123"))
+	if err != nil {
+		t.Fatalf("HandleAuth: %v", err)
+	}
+	if resp.Signature != "" || resp.Algorithm != "" {
+		t.Errorf("unkeyed handler signed the response: %+v", resp)
+	}
+
+	keyed := NewWebSocketAuthHandler()
+	keyed.ServerKey = priv
+	resp, err = keyed.HandleAuth(nil, newAuthFrame(t, "did:web:alice", ""))
+	if err != nil {
+		t.Fatalf("HandleAuth: %v", err)
+	}
+	if resp.Signature != "" {
+		t.Errorf("response signed without a client nonce: %+v", resp)
+	}
+}
+
+func TestSendAuthFrames_Opcode(t *testing.T) {
+	tests := []struct {
+		name      string
+		frameType int
+		want      int
+	}{
+		{"default is text", 0, websocket.TextMessage},
+		{"configured text", websocket.TextMessage, websocket.TextMessage},
+		{"configured binary", websocket.BinaryMessage, websocket.BinaryMessage},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := NewWebSocketServer(":0", nil)
+			ws.AuthFrameType = tc.frameType
+			serverConn, clientConn := dialTestClient(t, ws, nil)
+			client := &Client{ID: "auth-client", Conn: serverConn, Server: ws}
+
+			if err := SendAuthSuccess(client, "did:web:relay.example", 1024); err != nil {
+				t.Fatalf("SendAuthSuccess: %v", err)
+			}
+			if err := SendAuthFailure(client, "denied", "auth_failed"); err != nil {
+				t.Fatalf("SendAuthFailure: %v", err)
+			}
+
+			for _, wantType := range []string{"auth_ok", "auth_fail"} {
+				clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+				opcode, data, err := clientConn.ReadMessage()
+				if err != nil {
+					t.Fatalf("ReadMessage: %v", err)
+				}
+				if opcode != tc.want {
+					t.Errorf("%s opcode = %d, want %d", wantType, opcode, tc.want)
+				}
+				var resp AuthResponse
+				if err := json.Unmarshal(data, &resp); err != nil || resp.Type != wantType {
+					t.Errorf("frame = %s (err %v), want %s", data, err, wantType)
+				}
+			}
+		})
+	}
+}
+
+// keyVerifier checks Ed25519 nonce signatures against known client keys
+type keyVerifier map[string]ed25519.PublicKey
+
+func (v keyVerifier) Verify(did string, signature []byte, nonce string) (bool, error) {
+	pub, ok := v[did]
+	if !ok {
+		return false, nil
+	}
+	return ed25519.Verify(pub, []byte(nonce), signature), nil
+}
+
+func signedAuthFrame(t *testing.T, did, nonce string, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	data, err := json.Marshal(AuthFrame{
+		Type:      "auth",
+		DID:       did,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(nonce))),
+		Algorithm: "ed25519",
+		Timestamp: time.Now().Unix(),
+		Nonce:     nonce,
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return data
+}
+
+func TestHandleAuth_ChallengeFlow(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	h := NewWebSocketAuthHandler()
+	h.RequireChallenge = true
+	h.Authenticator = keyVerifier{"did:web:alice": pub}
+
+	ws := NewWebSocketServer(":0", nil)
+	serverConn, clientConn := dialTestClient(t, ws, nil)
+	client := &Client{ID: "client-1", Conn: serverConn, Server: ws}
+
+	issued, err := h.SendChallenge(client)
+	if err != nil {
+		t.Fatalf("SendChallenge: %v", err)
+	}
+
+	// The client reads the challenge and signs its nonce
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, data, err := clientConn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	var challenge ChallengeFrame
+	if err := json.Unmarshal(data, &challenge); err != nil || challenge.Type != "challenge" {
+		t.Fatalf("challenge frame = %s (err %v)", data, err)
+	}
+	if challenge.Nonce != issued || challenge.Nonce == "" {
+		t.Fatalf("challenge nonce = %q, want issued nonce %q", challenge.Nonce, issued)
+	}
+
+	frame := signedAuthFrame(t, "did:web:alice", challenge.Nonce, priv)
+	resp, err := h.HandleAuth(client, frame)
+	if err != nil || resp.Type != "auth_ok" {
+		t.Fatalf("HandleAuth = %+v, %v; want auth_ok", resp, err)
+	}
+
+	// Nonces are single-use
+	resp, _ = h.HandleAuth(client, frame)
+	if resp.ErrorCode != "invalid_nonce" {
+		t.Errorf("replayed auth: code = %q, want invalid_nonce", resp.ErrorCode)
+	}
+}
+
+func TestHandleAuth_ChallengeRejections(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	_, otherPriv, _ := ed25519.GenerateKey(nil)
+	newHandler := func() *WebSocketAuthHandler {
+		h := NewWebSocketAuthHandler()
+		h.RequireChallenge = true
+		h.Authenticator = keyVerifier{"did:web:alice": pub}
+		return h
+	}
+	client := &Client{ID: "client-1"}
+
+	t.Run("unsolicited bogus nonce", func(t *testing.T) {
+		h := newHandler()
+		resp, err := h.HandleAuth(client, signedAuthFrame(t, "did:web:alice", "bogus", priv))
+		if err == nil || resp.ErrorCode != "invalid_nonce" {
+			t.Errorf("code = %q (err %v), want invalid_nonce", resp.ErrorCode, err)
+		}
+	})
+
+	t.Run("nonce issued to another connection", func(t *testing.T) {
+		h := newHandler()
+		nonce, _ := h.IssueNonce("client-2")
+		resp, _ := h.HandleAuth(client, signedAuthFrame(t, "did:web:alice", nonce, priv))
+		if resp.ErrorCode != "invalid_nonce" {
+			t.Errorf("code = %q, want invalid_nonce", resp.ErrorCode)
+		}
+	})
+
+	t.Run("expired nonce", func(t *testing.T) {
+		h := newHandler()
+		h.NonceTTL = time.Millisecond
+		nonce, _ := h.IssueNonce(client.ID)
+		time.Sleep(5 * time.Millisecond)
+		resp, _ := h.HandleAuth(client, signedAuthFrame(t, "did:web:alice", nonce, priv))
+		if resp.ErrorCode != "invalid_nonce" {
+			t.Errorf("code = %q, want invalid_nonce", resp.ErrorCode)
+		}
+	})
+
+	t.Run("nonce of a dropped client", func(t *testing.T) {
+		h := newHandler()
+		nonce, _ := h.IssueNonce(client.ID)
+		other, _ := h.IssueNonce("client-2")
+		h.DropClient(client.ID)
+		resp, _ := h.HandleAuth(client, signedAuthFrame(t, "did:web:alice", nonce, priv))
+		if resp.ErrorCode != "invalid_nonce" {
+			t.Errorf("code = %q, want invalid_nonce", resp.ErrorCode)
+		}
+		if !h.consumeNonce("client-2", other) {
+			t.Error("DropClient discarded another client's nonce")
+		}
+	})
+
+	t.Run("signature by the wrong key", func(t *testing.T) {
+		h := newHandler()
+		nonce, _ := h.IssueNonce(client.ID)
+		resp, _ := h.HandleAuth(client, signedAuthFrame(t, "did:web:alice", nonce, otherPriv))
+		if resp.ErrorCode != "invalid_signature" {
+			t.Errorf("code = %q, want invalid_signature", resp.ErrorCode)
+		}
+	})
+}
+
+func TestHandleUnauthenticated_Policies(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      UnauthenticatedPolicy
+		connectedAt time.Duration // before now
+		wantClosed  bool
+	}{
+		{"close immediately", CloseUnauthenticated, 0, true},
+		{"retry within timeout", RetryUnauthenticated, 0, false},
+		{"retry after timeout", RetryUnauthenticated, time.Minute, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ws := NewWebSocketServer(":0", nil)
+			serverConn, clientConn := dialTestClient(t, ws, nil)
+			client := &Client{ID: "unauth-client", Conn: serverConn, Server: ws}
+
+			h := NewWebSocketAuthHandler()
+			h.OnUnauthenticated = tc.policy
+			h.AuthTimeout = 30 * time.Second
+
+			closed, err := h.HandleUnauthenticated(client, time.Now().Add(-tc.connectedAt))
+			if err != nil {
+				t.Fatalf("HandleUnauthenticated: %v", err)
+			}
+			if closed != tc.wantClosed || client.IsClosed() != tc.wantClosed {
+				t.Errorf("closed = %v (client %v), want %v", closed, client.IsClosed(), tc.wantClosed)
+			}
+
+			clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, data, err := clientConn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			var resp AuthResponse
+			if err := json.Unmarshal(data, &resp); err != nil || resp.Type != "auth_fail" || resp.ErrorCode != "auth_required" {
+				t.Errorf("frame = %s (err %v), want auth_fail auth_required", data, err)
+			}
+
+			if tc.wantClosed {
+				if _, _, err := clientConn.ReadMessage(); err == nil {
+					t.Error("connection still open after close policy")
+				}
+				return
+			}
+
+			// The client may still authenticate on the same connection
+			if _, err := h.HandleAuth(client, newAuthFrame(t, "did:example:alice", "")); err != nil {
+				t.Fatalf("HandleAuth after retry: %v", err)
+			}
+			if err := SendAuthSuccess(client, "did:web:relay.example", 1024); err != nil {
+				t.Fatalf("SendAuthSuccess after retry: %v", err)
+			}
+			if _, data, err := clientConn.ReadMessage(); err != nil || !strings.Contains(string(data), "auth_ok") {
+				t.Errorf("after retry got %s (err %v), want auth_ok", data, err)
+			}
+		})
+	}
+}
+
+func TestWebSocketServer_AuthHandshake(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	serverPub, serverKey, _ := ed25519.GenerateKey(nil)
+
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.Auth = NewWebSocketAuthHandler()
+	server.Auth.ServerDID = "did:web:relay.example"
+	server.Auth.ServerKey = serverKey
+	server.Auth.RequireChallenge = true
+	server.Auth.Authenticator = keyVerifier{"did:web:alice": pub}
+
+	connected := make(chan string, 4)
+	server.SetConnectHandler(func(clientID, did string) { connected <- did })
+	received := make(chan []byte, 4)
+	server.SetMessageHandler(func(clientID string, data []byte) error {
+		received <- data
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	s := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer s.Close()
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	dial := func() (*websocket.Conn, string) {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var challenge ChallengeFrame
+		if err := conn.ReadJSON(&challenge); err != nil || challenge.Type != "challenge" || challenge.Nonce == "" {
+			t.Fatalf("challenge = %+v (err %v)", challenge, err)
+		}
+		return conn, challenge.Nonce
+	}
+
+	t.Run("signed nonce authenticates", func(t *testing.T) {
+		conn, nonce := dial()
+		conn.WriteMessage(websocket.TextMessage, signedAuthFrame(t, "did:web:alice", nonce, priv))
+
+		var resp AuthResponse
+		if err := conn.ReadJSON(&resp); err != nil || resp.Type != "auth_ok" {
+			t.Fatalf("response = %+v (err %v), want auth_ok", resp, err)
+		}
+		if !VerifyServerSignature(serverPub, &resp, nonce, "did:web:alice") {
+			t.Error("server signature on a live connection did not verify")
+		}
+		select {
+		case did := <-connected:
+			if did != "did:web:alice" {
+				t.Errorf("connect handler DID = %q, want did:web:alice", did)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("connect handler not called")
+		}
+
+		// Relay traffic flows only after the handshake
+		conn.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+		select {
+		case data := <-received:
+			if string(data) != "hello" {
+				t.Errorf("message handler got %q", data)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message after auth not handled")
+		}
+	})
+
+	t.Run("bogus nonce is refused", func(t *testing.T) {
+		conn, _ := dial()
+		conn.WriteMessage(websocket.TextMessage, signedAuthFrame(t, "did:web:alice", "bogus", priv))
+
+		var resp AuthResponse
+		if err := conn.ReadJSON(&resp); err != nil || resp.ErrorCode != "invalid_nonce" {
+			t.Fatalf("response = %+v (err %v), want invalid_nonce", resp, err)
+		}
+		if _, _, err := conn.ReadMessage(); err == nil {
+			t.Error("connection still open after failed auth")
+		}
+	})
+
+	t.Run("messages before auth are refused", func(t *testing.T) {
+		conn, _ := dial()
+		conn.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+
+		var resp AuthResponse
+		if err := conn.ReadJSON(&resp); err != nil || resp.ErrorCode != "auth_required" {
+			t.Fatalf("response = %+v (err %v), want auth_required", resp, err)
+		}
+		if _, _, err := conn.ReadMessage(); err == nil {
+			t.Error("connection still open after unauthenticated message")
+		}
+	})
+
+	select {
+	case data := <-received:
+		t.Errorf("unauthenticated traffic reached the message handler: %q", data)
+	case did := <-connected:
+		t.Errorf("connect handler called for a failed handshake (DID %q)", did)
+	default:
+	}
+}
+
+// TestWebSocketServer_AuthHandshakeRequiresNonce verifies the live handshake
+// checks the nonce it issued even with no verifier or RequireChallenge set
+func TestWebSocketServer_AuthHandshakeRequiresNonce(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.Auth = NewWebSocketAuthHandler()
+	connected := make(chan string, 4)
+	server.SetConnectHandler(func(clientID, did string) { connected <- did })
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	s := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer s.Close()
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	for _, nonce := range []string{"", "bogus"} {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var challenge ChallengeFrame
+		if err := conn.ReadJSON(&challenge); err != nil {
+			t.Fatalf("read challenge: %v", err)
+		}
+		conn.WriteMessage(websocket.TextMessage, signedAuthFrame(t, "did:web:mallory", nonce, priv))
+
+		var resp AuthResponse
+		if err := conn.ReadJSON(&resp); err != nil || resp.ErrorCode != "invalid_nonce" {
+			t.Errorf("nonce %q: response = %+v (err %v), want invalid_nonce", nonce, resp, err)
+		}
+		conn.Close()
+	}
+
+	select {
+	case did := <-connected:
+		t.Errorf("DID %q bound without the issued nonce", did)
+	default:
+	}
+}
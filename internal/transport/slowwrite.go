@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"log"
+	"time"
+)
+
+// defaultSlowWriteLimit is how many consecutive slow writes close a client
+// when SlowWriteLimit is unset
+const defaultSlowWriteLimit = 3
+
+// recordWrite notes how long a message write to the client took and
+// reports whether the client has now exceeded the server's slow-write
+// threshold too many times in a row. Only the client's writePump calls it.
+func (c *Client) recordWrite(elapsed time.Duration) bool {
+	c.writeLatency.Store(int64(elapsed))
+
+	threshold := c.Server.SlowWriteThreshold
+	if threshold <= 0 {
+		return false
+	}
+	if elapsed <= threshold {
+		c.slowWrites = 0
+		return false
+	}
+
+	c.slowWrites++
+	c.Server.slowWrites.Add(1)
+	limit := c.Server.SlowWriteLimit
+	if limit <= 0 {
+		limit = defaultSlowWriteLimit
+	}
+	if c.slowWrites < limit {
+		return false
+	}
+
+	log.Printf("Closing client %s: %d consecutive writes slower than %v (last %v)", c.ID, c.slowWrites, threshold, elapsed)
+	c.Server.slowClientsClosed.Add(1)
+	return true
+}
+
+// ClientWriteLatency returns how long the last message write to a client
+// took, or 0 if it is not connected or nothing has been written yet
+func (ws *WebSocketServer) ClientWriteLatency(clientID string) time.Duration {
+	if client, exists := ws.lookupClient(clientID); exists {
+		return time.Duration(client.writeLatency.Load())
+	}
+	return 0
+}
+
+// SlowWrites returns how many message writes took longer than SlowWriteThreshold
+func (ws *WebSocketServer) SlowWrites() uint64 {
+	return ws.slowWrites.Load()
+}
+
+// SlowClientsClosed returns how many clients were closed for consistently slow writes
+func (ws *WebSocketServer) SlowClientsClosed() uint64 {
+	return ws.slowClientsClosed.Load()
+}
@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// slowListener hands out connections whose writes stall by delay once slow is set
+type slowListener struct {
+	net.Listener
+	slow  *atomic.Bool
+	delay time.Duration
+}
+
+func (l *slowListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &slowConn{Conn: conn, slow: l.slow, delay: l.delay}, nil
+}
+
+type slowConn struct {
+	net.Conn
+	slow  *atomic.Bool
+	delay time.Duration
+}
+
+func (c *slowConn) Write(b []byte) (int, error) {
+	if c.slow.Load() {
+		time.Sleep(c.delay)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestWebSocketServer_ClosesConsistentlySlowClient(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.SlowWriteThreshold = 20 * time.Millisecond
+	server.SlowWriteLimit = 3
+	ids := make(chan string, 1)
+	server.SetMessageHandler(func(clientID string, data []byte) error {
+		ids <- clientID
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	slow := &atomic.Bool{}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(server.handleWebSocket))
+	s.Listener = &slowListener{Listener: s.Listener, slow: slow, delay: 40 * time.Millisecond}
+	s.Start()
+	defer s.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+	var id string
+	select {
+	case id = <-ids:
+	case <-time.After(time.Second):
+		t.Fatal("hello not handled")
+	}
+
+	// Fast writes are tracked but not held against the client
+	server.SendToClient(id, []byte("fast"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if n := server.SlowWrites(); n != 0 {
+		t.Errorf("SlowWrites() = %d after a fast write, want 0", n)
+	}
+
+	// Once every write stalls, the client is closed after SlowWriteLimit of them
+	slow.Store(true)
+	for i := 0; i < 5; i++ {
+		server.SendToClient(id, []byte("slow"))
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for server.SlowClientsClosed() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("SlowClientsClosed() = %d, want 1 (SlowWrites() = %d)", server.SlowClientsClosed(), server.SlowWrites())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := server.SlowWrites(); n != 3 {
+		t.Errorf("SlowWrites() = %d, want 3", n)
+	}
+
+	// The closed client is unregistered
+	for server.GetClientCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("GetClientCount() = %d after closing the slow client, want 0", server.GetClientCount())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -2,12 +2,17 @@
 package transport
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
+	"net"
 	"net/http"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -15,6 +20,37 @@ import (
 	"github.com/gorilla/websocket"
 )
 
+// WebSocket subprotocols offered by the server
+const (
+	// SubprotocolAMP carries one AMP message per WebSocket frame
+	SubprotocolAMP = "amp.v1"
+
+	// SubprotocolAMPBatch carries one or more length-prefixed AMP messages
+	// per frame; see SplitCoalescedFrame
+	SubprotocolAMPBatch = "amp.v1.batch"
+
+	// SubprotocolAMPJSON carries one JSON-encoded AMP message per text frame,
+	// for clients without a CBOR codec
+	SubprotocolAMPJSON = "amp.v1.json"
+)
+
+// defaultSendTimeout bounds how long SendToClient waits on a full send queue
+const defaultSendTimeout = 100 * time.Millisecond
+
+// maxCoalescedMessages caps how many queued messages are batched into one frame
+const maxCoalescedMessages = 64
+
+// CloseIdle is the close code sent to a client evicted for inactivity,
+// from the private-use range of RFC 6455 §7.4.2
+const CloseIdle = 4000
+
+// DisconnectHandler is called after a client has been removed from the server
+type DisconnectHandler func(clientID string)
+
+// ConnectHandler is the callback function for handling client connections.
+// did is the DID the client authenticated as, or empty without Auth.
+type ConnectHandler func(clientID, did string)
+
 // MessageHandler is the callback function for handling incoming messages
 type MessageHandler func(clientID string, data []byte) error
 
@@ -24,8 +60,26 @@ type Client struct {
 	Conn     *websocket.Conn
 	Server   *WebSocketServer
 	SendChan chan []byte
+	Origin   string // Origin header of the upgrade request, if any
+	DID      string // DID authenticated by the RFC-002 handshake, if any
 	mu       sync.RWMutex
 	closed   bool
+
+	// coalesce batches queued messages into length-prefixed frames
+	// (negotiated via SubprotocolAMPBatch)
+	coalesce bool
+
+	// json marks a client that negotiated SubprotocolAMPJSON; its frames are
+	// sent as text
+	json bool
+
+	// Write latency tracking: the last message write's duration, and how
+	// many writes in a row exceeded SlowWriteThreshold (writePump only)
+	writeLatency atomic.Int64
+	slowWrites   int
+
+	// readBuf is reused for every inbound frame when ReuseReadBuffers is set
+	readBuf bytes.Buffer
 }
 
 // WebSocketServer manages WebSocket connections
@@ -35,12 +89,56 @@ type WebSocketServer struct {
 	AllowedOrigins []string
 	Upgrader       websocket.Upgrader
 
-	// Connection management
-	clients    map[string]*Client
-	clientsMu  sync.RWMutex
-	register   chan *Client
-	unregister chan *Client
-	broadcast  chan []byte
+	// DisableWebSocket runs the server HTTP-only: the WebSocket endpoint is
+	// not registered and only the health endpoint is served
+	DisableWebSocket bool
+
+	// AuthFrameType is the WebSocket opcode for RFC-002 auth frames
+	// (websocket.TextMessage or websocket.BinaryMessage); 0 means text,
+	// matching their JSON encoding
+	AuthFrameType int
+
+	// ReuseReadBuffers reads each inbound frame into a per-connection buffer
+	// instead of a fresh slice. The data passed to the MessageHandler is then
+	// only valid until the handler returns and must be copied to be kept.
+	ReuseReadBuffers bool
+
+	// MaxMessageSize caps a whole inbound message, summed over all of its
+	// frames (0 = defaultMaxMessageSize). MaxFrameSize caps each frame on its
+	// own (0 = only the message limit applies). A connection exceeding either
+	// is closed with CloseMessageTooBig.
+	MaxMessageSize int64
+	MaxFrameSize   int64
+
+	// HubShards spreads clients across this many hub goroutines by client
+	// ID hash (0 or 1 = a single hub). It must be set before Start.
+	HubShards int
+
+	// BroadcastBacklog is how many broadcasts each hub queues while it is
+	// busy (0 = defaultBroadcastBacklog). When a hub's backlog is full,
+	// Broadcast waits up to broadcastTimeout, then drops the broadcast for
+	// that hub, logs it and counts it in DroppedBroadcasts. It must be set
+	// before Start.
+	BroadcastBacklog int
+
+	// SlowWriteThreshold marks a message write that takes longer as slow
+	// (0 = writes are not judged). A client with SlowWriteLimit slow writes
+	// in a row (0 = defaultSlowWriteLimit) is treated as a stuck consumer
+	// and closed.
+	SlowWriteThreshold time.Duration
+	SlowWriteLimit     int
+
+	// Auth, if set, runs the RFC-002 handshake on each new connection before
+	// it is registered; connections that fail it are closed
+	Auth *WebSocketAuthHandler
+
+	// Connection management, sharded by client ID
+	shards            []*hubShard
+	droppedBroadcasts atomic.Uint64
+
+	// Slow-write metrics
+	slowWrites        atomic.Uint64
+	slowClientsClosed atomic.Uint64
 
 	// Lifecycle management
 	ctx     context.Context
@@ -51,10 +149,32 @@ type WebSocketServer struct {
 	// Message handler callback
 	messageHandler MessageHandler
 
+	// Called once per client before its pumps start
+	connectHandler ConnectHandler
+
+	// Called once per client after it is unregistered
+	disconnectHandler DisconnectHandler
+
+	// Additional HTTP routes registered with HandleFunc
+	routes map[string]http.HandlerFunc
+
 	// HTTP server
 	server *http.Server
 }
 
+// defaultMaxMessageSize is the inbound message limit when MaxMessageSize is unset
+const defaultMaxMessageSize = 512 * 1024
+
+// hubQueueSize is how many connects and disconnects may wait for the hub,
+// so they don't stall while it is busy, e.g. fanning out a broadcast
+const hubQueueSize = 256
+
+// defaultBroadcastBacklog is the per-hub broadcast queue when BroadcastBacklog is unset
+const defaultBroadcastBacklog = 64
+
+// broadcastTimeout bounds how long Broadcast waits on a full backlog
+const broadcastTimeout = 100 * time.Millisecond
+
 // NewWebSocketServer creates a new WebSocket server instance
 func NewWebSocketServer(addr string, allowedOrigins []string) *WebSocketServer {
 	ctx, cancel := context.WithCancel(context.Background())
@@ -62,10 +182,7 @@ func NewWebSocketServer(addr string, allowedOrigins []string) *WebSocketServer {
 	ws := &WebSocketServer{
 		Addr:           addr,
 		AllowedOrigins: allowedOrigins,
-		clients:        make(map[string]*Client),
-		register:       make(chan *Client),
-		unregister:     make(chan *Client),
-		broadcast:      make(chan []byte),
+		shards:         newHubShards(1, defaultBroadcastBacklog),
 		ctx:            ctx,
 		cancel:         cancel,
 	}
@@ -83,7 +200,7 @@ func NewWebSocketServer(addr string, allowedOrigins []string) *WebSocketServer {
 			}
 			return false
 		},
-		Subprotocols:    []string{"amp.v1"},
+		Subprotocols:    []string{SubprotocolAMP, SubprotocolAMPBatch, SubprotocolAMPJSON},
 		ReadBufferSize:  1024,
 		WriteBufferSize: 1024,
 	}
@@ -96,22 +213,83 @@ func (ws *WebSocketServer) SetMessageHandler(handler MessageHandler) {
 	ws.messageHandler = handler
 }
 
+// SetConnectHandler sets the callback run when a client has connected and,
+// with Auth set, authenticated. It runs before the client's first message
+// is read.
+func (ws *WebSocketServer) SetConnectHandler(handler ConnectHandler) {
+	ws.connectHandler = handler
+}
+
+// SetDisconnectHandler sets the callback run when a client disconnects.
+// It runs on its own goroutine so it cannot stall the hub.
+func (ws *WebSocketServer) SetDisconnectHandler(handler DisconnectHandler) {
+	ws.disconnectHandler = handler
+}
+
+// CloseClient sends a close frame with code and reason to a client and then
+// closes its connection. It returns false if the client is not connected.
+func (ws *WebSocketServer) CloseClient(clientID string, code int, reason string) bool {
+	client, exists := ws.lookupClient(clientID)
+	if !exists {
+		return false
+	}
+
+	// WriteControl may run concurrently with the client's writePump
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := client.Conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+		log.Printf("Failed to send close frame to client %s: %v", clientID, err)
+	}
+	client.Close()
+	return true
+}
+
+// HandleFunc registers an additional HTTP handler served alongside the
+// WebSocket endpoint. It must be called before Start.
+func (ws *WebSocketServer) HandleFunc(pattern string, handler http.HandlerFunc) {
+	if ws.routes == nil {
+		ws.routes = make(map[string]http.HandlerFunc)
+	}
+	ws.routes[pattern] = handler
+}
+
 // Start starts the WebSocket server
 func (ws *WebSocketServer) Start() error {
-	if ws.running.Load() {
+	if !ws.running.CompareAndSwap(false, true) {
 		return nil
 	}
 
-	ws.running.Store(true)
+	// Bind before starting any goroutines so a busy address is reported to
+	// the caller and a failed Start leaves nothing for Stop to clean up
+	addr := ws.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		ws.running.Store(false)
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	ln = ws.limitFrames(ln)
 
-	// Start the hub goroutine for managing connections
-	ws.wg.Add(1)
-	go ws.runHub()
+	// Start a hub goroutine per shard for managing connections
+	if shards, backlog := ws.HubShards, ws.broadcastBacklog(); len(ws.shards) != max(shards, 1) ||
+		cap(ws.shards[0].broadcast) != backlog {
+		ws.shards = newHubShards(shards, backlog)
+	}
+	for _, shard := range ws.shards {
+		ws.wg.Add(1)
+		go ws.runHub(shard)
+	}
 
 	// Setup HTTP handlers on a local mux
 	mux := http.NewServeMux()
-	mux.HandleFunc("/amp/v1/ws", ws.handleWebSocket)
+	if !ws.DisableWebSocket {
+		mux.HandleFunc("/amp/v1/ws", ws.handleWebSocket)
+	}
 	mux.HandleFunc("/amp/v1/health", ws.handleHealth)
+	for pattern, handler := range ws.routes {
+		mux.HandleFunc(pattern, handler)
+	}
 
 	// Create HTTP server
 	ws.server = &http.Server{
@@ -119,13 +297,17 @@ func (ws *WebSocketServer) Start() error {
 		Handler: mux,
 	}
 
-	log.Printf("WebSocket server starting on %s", ws.Addr)
+	if ws.DisableWebSocket {
+		log.Printf("HTTP server starting on %s (WebSocket disabled)", ws.Addr)
+	} else {
+		log.Printf("WebSocket server starting on %s", ws.Addr)
+	}
 
 	// Start listening in a goroutine
 	ws.wg.Add(1)
 	go func() {
 		defer ws.wg.Done()
-		if err := ws.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := ws.server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Printf("WebSocket server error: %v", err)
 		}
 	}()
@@ -133,9 +315,10 @@ func (ws *WebSocketServer) Start() error {
 	return nil
 }
 
-// Stop gracefully stops the WebSocket server
+// Stop gracefully stops the WebSocket server. It is a no-op returning nil
+// if the server is not running, including when Start was never called or failed.
 func (ws *WebSocketServer) Stop() error {
-	if !ws.running.Load() {
+	if !ws.running.CompareAndSwap(true, false) {
 		return nil
 	}
 
@@ -145,61 +328,117 @@ func (ws *WebSocketServer) Stop() error {
 	ws.cancel()
 
 	// Close all client connections
-	ws.clientsMu.Lock()
-	for _, client := range ws.clients {
-		client.Close()
+	for _, shard := range ws.shards {
+		shard.closeAll()
 	}
-	ws.clients = make(map[string]*Client)
-	ws.clientsMu.Unlock()
 
 	// Shutdown HTTP server with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := ws.server.Shutdown(ctx); err != nil {
-		log.Printf("Server shutdown error: %v", err)
+	if ws.server != nil {
+		if err := ws.server.Shutdown(ctx); err != nil {
+			log.Printf("Server shutdown error: %v", err)
+		}
 	}
 
 	// Wait for all goroutines to finish
 	ws.wg.Wait()
 
-	ws.running.Store(false)
 	log.Println("WebSocket server stopped")
 	return nil
 }
 
-// Broadcast sends a message to all connected clients
+// Broadcast queues a message for all connected clients. A hub whose
+// backlog stays full for broadcastTimeout drops it; see BroadcastBacklog.
 func (ws *WebSocketServer) Broadcast(data []byte) {
-	select {
-	case ws.broadcast <- data:
-	case <-time.After(100 * time.Millisecond):
-		log.Println("Broadcast timeout: channel full")
+	var timer *time.Timer
+	timedOut := false
+	dropped := 0
+	for _, shard := range ws.shards {
+		select {
+		case shard.broadcast <- data:
+			continue
+		default:
+		}
+		if timedOut {
+			dropped++
+			continue
+		}
+
+		// All hubs share one wait
+		if timer == nil {
+			timer = time.NewTimer(broadcastTimeout)
+			defer timer.Stop()
+		}
+		select {
+		case shard.broadcast <- data:
+		case <-timer.C:
+			timedOut = true
+			dropped++
+		}
+	}
+
+	if dropped > 0 {
+		ws.droppedBroadcasts.Add(uint64(dropped))
+		log.Printf("Broadcast dropped: backlog full on %d of %d hub(s)", dropped, len(ws.shards))
+	}
+}
+
+// DroppedBroadcasts returns how many per-hub broadcasts were dropped
+// because the hub's backlog was full
+func (ws *WebSocketServer) DroppedBroadcasts() uint64 {
+	return ws.droppedBroadcasts.Load()
+}
+
+// broadcastBacklog returns the configured per-hub broadcast queue size
+func (ws *WebSocketServer) broadcastBacklog() int {
+	if ws.BroadcastBacklog > 0 {
+		return ws.BroadcastBacklog
 	}
+	return defaultBroadcastBacklog
 }
 
 // SendToClient sends a message to a specific client
 func (ws *WebSocketServer) SendToClient(clientID string, data []byte) bool {
-	ws.clientsMu.RLock()
-	client, exists := ws.clients[clientID]
-	ws.clientsMu.RUnlock()
+	return ws.SendToClientTimeout(clientID, data, defaultSendTimeout)
+}
 
+// SendToClientTimeout queues data for a client, giving up if its send queue
+// stays full for longer than timeout
+func (ws *WebSocketServer) SendToClientTimeout(clientID string, data []byte, timeout time.Duration) bool {
+	client, exists := ws.lookupClient(clientID)
 	if !exists {
 		return false
 	}
+	return client.send(data, timeout)
+}
 
-	select {
-	case client.SendChan <- data:
-		return true
-	case <-time.After(100 * time.Millisecond):
-		return false
+// ClientOrigin returns the Origin header a client connected with, or "" if
+// it sent none or is not connected
+func (ws *WebSocketServer) ClientOrigin(clientID string) string {
+	if client, exists := ws.lookupClient(clientID); exists {
+		return client.Origin
 	}
+	return ""
+}
+
+// ClientUsesJSON reports whether a connected client negotiated
+// SubprotocolAMPJSON and so sends and expects JSON-encoded messages
+func (ws *WebSocketServer) ClientUsesJSON(clientID string) bool {
+	if client, exists := ws.lookupClient(clientID); exists {
+		return client.json
+	}
+	return false
 }
 
 // GetClientCount returns the number of connected clients
 func (ws *WebSocketServer) GetClientCount() int {
-	ws.clientsMu.RLock()
-	defer ws.clientsMu.RUnlock()
-	return len(ws.clients)
+	count := 0
+	for _, shard := range ws.shards {
+		count += int(shard.count.Load())
+	}
+	return count
 }
 
 // handleWebSocket handles WebSocket upgrade requests
@@ -211,6 +450,10 @@ func (ws *WebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	if fc, ok := conn.UnderlyingConn().(*frameLimitConn); ok {
+		fc.activate()
+	}
+
 	// Generate client ID
 	clientID := generateClientID()
 
@@ -220,10 +463,32 @@ func (ws *WebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Reques
 		Conn:     conn,
 		Server:   ws,
 		SendChan: make(chan []byte, 256),
+		Origin:   r.Header.Get("Origin"),
+		coalesce: conn.Subprotocol() == SubprotocolAMPBatch,
+		json:     conn.Subprotocol() == SubprotocolAMPJSON,
 	}
 
-	// Register client
-	ws.register <- client
+	// Authenticate before the client can send or receive relay traffic
+	if ws.Auth != nil {
+		did, err := ws.Auth.Authenticate(client)
+		if err != nil {
+			log.Printf("Client %s from %s failed authentication: %v", clientID, r.RemoteAddr, err)
+			return
+		}
+		client.DID = did
+	}
+
+	// Register client, unless the hub has already shut down
+	select {
+	case ws.shardFor(clientID).register <- client:
+	case <-ws.ctx.Done():
+		conn.Close()
+		return
+	}
+
+	if ws.connectHandler != nil {
+		ws.connectHandler(clientID, client.DID)
+	}
 
 	// Start client goroutines
 	go client.writePump()
@@ -232,65 +497,57 @@ func (ws *WebSocketServer) handleWebSocket(w http.ResponseWriter, r *http.Reques
 	log.Printf("Client %s connected from %s", clientID, r.RemoteAddr)
 }
 
-// handleHealth provides health check endpoint
-func (ws *WebSocketServer) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(fmt.Sprintf(`{"status":"ok","clients":%d}`, ws.GetClientCount())))
+// limitFrames wraps ln to enforce MaxFrameSize on upgraded connections
+func (ws *WebSocketServer) limitFrames(ln net.Listener) net.Listener {
+	if ws.MaxFrameSize <= 0 {
+		return ln
+	}
+	return &frameLimitListener{Listener: ln, limit: ws.MaxFrameSize}
 }
 
-// runHub manages client registration/unregistration and broadcasting
-func (ws *WebSocketServer) runHub() {
-	defer ws.wg.Done()
+// healthContentType is shared by every health response; it is never modified
+var healthContentType = []string{"application/json"}
 
-	for {
-		select {
-		case <-ws.ctx.Done():
-			return
+// healthBufPool recycles health response buffers so frequent liveness
+// probes don't allocate
+var healthBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 64)
+		return &b
+	},
+}
 
-		case client := <-ws.register:
-			ws.clientsMu.Lock()
-			ws.clients[client.ID] = client
-			ws.clientsMu.Unlock()
+// handleHealth provides health check endpoint
+func (ws *WebSocketServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	buf := healthBufPool.Get().(*[]byte)
+	b := append((*buf)[:0], `{"status":"ok","clients":`...)
+	b = strconv.AppendInt(b, int64(ws.GetClientCount()), 10)
+	b = append(b, '}')
 
-		case client := <-ws.unregister:
-			ws.clientsMu.Lock()
-			if _, exists := ws.clients[client.ID]; exists {
-				delete(ws.clients, client.ID)
-				close(client.SendChan)
-			}
-			ws.clientsMu.Unlock()
+	w.Header()["Content-Type"] = healthContentType
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
 
-		case message := <-ws.broadcast:
-			ws.clientsMu.RLock()
-			clients := make([]*Client, 0, len(ws.clients))
-			for _, client := range ws.clients {
-				clients = append(clients, client)
-			}
-			ws.clientsMu.RUnlock()
-
-			// Send to all clients
-			for _, client := range clients {
-				select {
-				case client.SendChan <- message:
-				default:
-					// Client send buffer full, close connection
-					client.Close()
-				}
-			}
-		}
-	}
+	*buf = b
+	healthBufPool.Put(buf)
 }
 
 // readPump handles incoming messages from client
 func (c *Client) readPump() {
 	defer func() {
-		c.Server.unregister <- c
+		select {
+		case c.Server.shardFor(c.ID).unregister <- c:
+		case <-c.Server.ctx.Done():
+		}
 		c.Conn.Close()
 	}()
 
 	// Configure connection
-	c.Conn.SetReadLimit(512 * 1024) // 512KB max message size
+	limit := c.Server.MaxMessageSize
+	if limit <= 0 {
+		limit = defaultMaxMessageSize
+	}
+	c.Conn.SetReadLimit(limit)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
@@ -298,8 +555,15 @@ func (c *Client) readPump() {
 	})
 
 	for {
-		_, message, err := c.Conn.ReadMessage()
+		message, err := c.nextMessage()
 		if err != nil {
+			// gorilla closes over-limit messages itself; oversized frames
+			// are caught beneath it, so send the close frame here
+			if errors.Is(err, ErrFrameTooLarge) {
+				c.Conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseMessageTooBig, "frame too large"),
+					time.Now().Add(time.Second))
+			}
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error for client %s: %v", c.ID, err)
 			}
@@ -318,6 +582,25 @@ func (c *Client) readPump() {
 	}
 }
 
+// nextMessage reads the next inbound frame, into the client's reusable
+// buffer if the server has ReuseReadBuffers set
+func (c *Client) nextMessage() ([]byte, error) {
+	if !c.Server.ReuseReadBuffers {
+		_, message, err := c.Conn.ReadMessage()
+		return message, err
+	}
+
+	_, r, err := c.Conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	c.readBuf.Reset()
+	if _, err := c.readBuf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return c.readBuf.Bytes(), nil
+}
+
 // writePump handles outgoing messages to client
 func (c *Client) writePump() {
 	ticker := time.NewTicker(30 * time.Second)
@@ -335,11 +618,30 @@ func (c *Client) writePump() {
 				return
 			}
 
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if err := c.Conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+			chanClosed := false
+			if c.coalesce {
+				message, chanClosed = c.coalesceQueued(message)
+			}
+
+			frameType := websocket.BinaryMessage
+			if c.json {
+				frameType = websocket.TextMessage
+			}
+			start := time.Now()
+			c.Conn.SetWriteDeadline(start.Add(10 * time.Second))
+			if err := c.Conn.WriteMessage(frameType, message); err != nil {
 				log.Printf("Write error for client %s: %v", c.ID, err)
 				return
 			}
+			if c.recordWrite(time.Since(start)) {
+				c.Close()
+				return
+			}
+
+			if chanClosed {
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
 
 		case <-ticker.C:
 			// Send ping
@@ -354,6 +656,58 @@ func (c *Client) writePump() {
 	}
 }
 
+// coalesceQueued drains messages already buffered in SendChan (without blocking)
+// and frames them together with first. It reports whether SendChan was closed.
+func (c *Client) coalesceQueued(first []byte) ([]byte, bool) {
+	batch := [][]byte{first}
+	for len(batch) < maxCoalescedMessages {
+		select {
+		case message, ok := <-c.SendChan:
+			if !ok {
+				return coalesceFrames(batch), true
+			}
+			batch = append(batch, message)
+		default:
+			return coalesceFrames(batch), false
+		}
+	}
+	return coalesceFrames(batch), false
+}
+
+// coalesceFrames encodes messages as a sequence of 4-byte big-endian
+// length prefixes each followed by the message bytes
+func coalesceFrames(messages [][]byte) []byte {
+	size := 0
+	for _, m := range messages {
+		size += 4 + len(m)
+	}
+	frame := make([]byte, 0, size)
+	for _, m := range messages {
+		frame = binary.BigEndian.AppendUint32(frame, uint32(len(m)))
+		frame = append(frame, m...)
+	}
+	return frame
+}
+
+// SplitCoalescedFrame splits a frame received on a SubprotocolAMPBatch
+// connection back into the individual messages, in order
+func SplitCoalescedFrame(frame []byte) ([][]byte, error) {
+	var messages [][]byte
+	for len(frame) > 0 {
+		if len(frame) < 4 {
+			return nil, errors.New("truncated length prefix in coalesced frame")
+		}
+		n := binary.BigEndian.Uint32(frame)
+		frame = frame[4:]
+		if uint64(n) > uint64(len(frame)) {
+			return nil, fmt.Errorf("coalesced message length %d exceeds remaining %d bytes", n, len(frame))
+		}
+		messages = append(messages, frame[:n])
+		frame = frame[n:]
+	}
+	return messages, nil
+}
+
 // Close closes the client connection
 func (c *Client) Close() {
 	c.mu.Lock()
@@ -367,6 +721,36 @@ func (c *Client) Close() {
 	c.Conn.Close()
 }
 
+// send queues data for the client, giving up if its send queue stays full
+// for longer than timeout. It returns false once the client is closed: the
+// read lock is held throughout, so the hub can't close SendChan mid-send.
+func (c *Client) send(data []byte, timeout time.Duration) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case c.SendChan <- data:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// closeSendChan marks the client closed and closes its send queue, so
+// later sends fail instead of panicking
+func (c *Client) closeSendChan() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	close(c.SendChan)
+}
+
 // IsClosed checks if client connection is closed
 func (c *Client) IsClosed() bool {
 	c.mu.RLock()
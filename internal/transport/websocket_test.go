@@ -1,9 +1,15 @@
 package transport
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -15,16 +21,16 @@ func TestNewWebSocketServer(t *testing.T) {
 	if server == nil {
 		t.Fatal("NewWebSocketServer returned nil")
 	}
-	if server.clients == nil {
-		t.Error("clients map should be initialized")
+	if len(server.shards) != 1 {
+		t.Fatalf("shards = %d, want a single hub by default", len(server.shards))
 	}
-	if server.register == nil {
+	if server.shards[0].register == nil {
 		t.Error("register channel should be initialized")
 	}
-	if server.unregister == nil {
+	if server.shards[0].unregister == nil {
 		t.Error("unregister channel should be initialized")
 	}
-	if server.broadcast == nil {
+	if server.shards[0].broadcast == nil {
 		t.Error("broadcast channel should be initialized")
 	}
 	if server.ctx == nil {
@@ -89,6 +95,28 @@ func TestWebSocketServer_Stop_NotRunning(t *testing.T) {
 	}
 }
 
+func TestWebSocketServer_StartFailsOnBusyAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	server := NewWebSocketServer(ln.Addr().String(), nil)
+	if err := server.Start(); err == nil {
+		server.Stop()
+		t.Fatal("Start on a busy address should fail")
+	}
+	if server.running.Load() {
+		t.Error("Server should not be running after a failed Start")
+	}
+
+	// Stop after a failed Start must not touch the never-created HTTP server
+	if err := server.Stop(); err != nil {
+		t.Errorf("Stop after failed Start should not error: %v", err)
+	}
+}
+
 func TestWebSocketServer_HealthEndpoint(t *testing.T) {
 	server := NewWebSocketServer(":0", nil)
 	err := server.Start()
@@ -115,6 +143,28 @@ func TestWebSocketServer_HealthEndpoint(t *testing.T) {
 	}
 }
 
+func TestWebSocketServer_HealthJSON(t *testing.T) {
+	server := NewWebSocketServer(":0", nil)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		server.handleHealth(w, httptest.NewRequest("GET", "/amp/v1/health", nil))
+
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		var body struct {
+			Status  string `json:"status"`
+			Clients *int   `json:"clients"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("health response %q is not valid JSON: %v", w.Body.String(), err)
+		}
+		if body.Status != "ok" || body.Clients == nil || *body.Clients != 0 {
+			t.Errorf("health response = %s, want status ok and 0 clients", w.Body.String())
+		}
+	}
+}
+
 func TestWebSocketServer_WebSocketConnection(t *testing.T) {
 	// Create test server
 	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -361,8 +411,463 @@ func BenchmarkWebSocketServer_Broadcast(b *testing.B) {
 	}
 }
 
+// discardResponseWriter is a reusable ResponseWriter that allocates nothing
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
+
+// BenchmarkHandleHealth compares the health handler with the fmt.Sprintf
+// response it used to build
+func BenchmarkHandleHealth(b *testing.B) {
+	server := NewWebSocketServer(":0", nil)
+	req := httptest.NewRequest("GET", "/amp/v1/health", nil)
+	w := &discardResponseWriter{header: make(http.Header)}
+
+	b.Run("sprintf", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(fmt.Sprintf(`{"status":"ok","clients":%d}`, server.GetClientCount())))
+		}
+	})
+	b.Run("handler", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			server.handleHealth(w, req)
+		}
+	})
+}
+
 func BenchmarkGenerateClientID(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		generateClientID()
 	}
 }
+
+// dialTestClient starts an httptest server that upgrades with the given
+// WebSocketServer's upgrader and returns the server-side connection along
+// with the dialed client connection.
+func dialTestClient(t testing.TB, ws *WebSocketServer, subprotocols []string) (*websocket.Conn, *websocket.Conn) {
+	t.Helper()
+	serverConns := make(chan *websocket.Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := ws.Upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("Upgrade failed: %v", err)
+			return
+		}
+		serverConns <- conn
+	}))
+	t.Cleanup(s.Close)
+
+	dialer := websocket.Dialer{Subprotocols: subprotocols}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("WebSocket dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return <-serverConns, conn
+}
+
+func TestClient_WriteCoalescing(t *testing.T) {
+	ws := NewWebSocketServer(":0", nil)
+	serverConn, clientConn := dialTestClient(t, ws, []string{SubprotocolAMPBatch})
+	if clientConn.Subprotocol() != SubprotocolAMPBatch {
+		t.Fatalf("Negotiated subprotocol = %q, want %q", clientConn.Subprotocol(), SubprotocolAMPBatch)
+	}
+
+	client := &Client{
+		ID:       "client-batch",
+		Conn:     serverConn,
+		Server:   ws,
+		SendChan: make(chan []byte, 8),
+		coalesce: serverConn.Subprotocol() == SubprotocolAMPBatch,
+	}
+
+	// Queue several messages before the write pump starts so they are coalesced
+	want := []string{"first", "second", "", "fourth"}
+	for _, m := range want {
+		client.SendChan <- []byte(m)
+	}
+	go client.writePump()
+	defer ws.cancel()
+
+	clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, frame, err := clientConn.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage failed: %v", err)
+	}
+
+	got, err := SplitCoalescedFrame(frame)
+	if err != nil {
+		t.Fatalf("SplitCoalescedFrame failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d messages in frame, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Errorf("Message %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestClient_NoCoalescingByDefault(t *testing.T) {
+	ws := NewWebSocketServer(":0", nil)
+	serverConn, clientConn := dialTestClient(t, ws, []string{SubprotocolAMP})
+
+	client := &Client{
+		ID:       "client-plain",
+		Conn:     serverConn,
+		Server:   ws,
+		SendChan: make(chan []byte, 8),
+		coalesce: serverConn.Subprotocol() == SubprotocolAMPBatch,
+	}
+	client.SendChan <- []byte("one")
+	client.SendChan <- []byte("two")
+	go client.writePump()
+	defer ws.cancel()
+
+	for _, want := range []string{"one", "two"} {
+		clientConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, got, err := clientConn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage failed: %v", err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestSplitCoalescedFrame_Malformed(t *testing.T) {
+	if _, err := SplitCoalescedFrame([]byte{0, 0}); err == nil {
+		t.Error("Expected error for truncated length prefix")
+	}
+	if _, err := SplitCoalescedFrame([]byte{0, 0, 0, 9, 'a'}); err == nil {
+		t.Error("Expected error for length exceeding frame")
+	}
+}
+
+// TestClient_ReuseReadBuffers reads from several connections at once with
+// buffer reuse enabled and checks each frame arrives intact.
+func TestClient_ReuseReadBuffers(t *testing.T) {
+	ws := NewWebSocketServer(":0", nil)
+	ws.ReuseReadBuffers = true
+
+	const conns, frames = 4, 50
+	var wg sync.WaitGroup
+	for i := 0; i < conns; i++ {
+		i := i
+		serverConn, clientConn := dialTestClient(t, ws, nil)
+		client := &Client{ID: fmt.Sprintf("client-%d", i), Conn: serverConn, Server: ws}
+
+		// Each frame is filled with one byte unique to its connection and
+		// sequence, and grows so the buffer is resized along the way
+		fill := func(seq int) byte { return byte(i*frames + seq) }
+		go func() {
+			for seq := 0; seq < frames; seq++ {
+				frame := bytes.Repeat([]byte{fill(seq)}, 64+seq*37)
+				if err := clientConn.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+					t.Errorf("WriteMessage failed: %v", err)
+					return
+				}
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seq := 0; seq < frames; seq++ {
+				serverConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+				data, err := client.nextMessage()
+				if err != nil {
+					t.Errorf("%s: nextMessage failed: %v", client.ID, err)
+					return
+				}
+				want := bytes.Repeat([]byte{fill(seq)}, 64+seq*37)
+				if !bytes.Equal(data, want) {
+					t.Errorf("%s: frame %d corrupted", client.ID, seq)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkClient_NextMessage(b *testing.B) {
+	for _, reuse := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reuse=%v", reuse), func(b *testing.B) {
+			ws := NewWebSocketServer(":0", nil)
+			ws.ReuseReadBuffers = reuse
+			serverConn, clientConn := dialTestClient(b, ws, nil)
+			client := &Client{ID: "bench", Conn: serverConn, Server: ws}
+
+			// Pre-encode the frame so the writer side allocates as little as possible
+			frame := bytes.Repeat([]byte{0xa5}, 4096)
+			pm, err := websocket.NewPreparedMessage(websocket.BinaryMessage, frame)
+			if err != nil {
+				b.Fatal(err)
+			}
+			go func() {
+				for i := 0; i < b.N; i++ {
+					if clientConn.WritePreparedMessage(pm) != nil {
+						return
+					}
+				}
+			}()
+
+			b.ReportAllocs()
+			b.SetBytes(int64(len(frame)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := client.nextMessage(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestWebSocketServer_DisableWebSocket(t *testing.T) {
+	server := NewWebSocketServer(":0", nil)
+	server.DisableWebSocket = true
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	req := httptest.NewRequest("GET", "/amp/v1/ws", nil)
+	w := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("/amp/v1/ws status = %d, want 404", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/amp/v1/health", nil)
+	w = httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("/amp/v1/health status = %d, want 200", w.Code)
+	}
+}
+
+func TestWebSocketServer_ConnectDoesNotWaitForBusyHub(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	handled := make(chan string, 2)
+	server.SetMessageHandler(func(clientID string, data []byte) error {
+		handled <- string(data)
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	s := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer s.Close()
+	url := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	// Wedge the hub: the first registration it takes blocks on the clients
+	// lock, as it would behind a long broadcast
+	hub := server.shards[0]
+	hub.mu.RLock()
+	for _, name := range []string{"first", "second"} {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			hub.mu.RUnlock()
+			t.Fatalf("Dial failed: %v", err)
+		}
+		defer conn.Close()
+		if err := conn.WriteMessage(websocket.BinaryMessage, []byte(name)); err != nil {
+			hub.mu.RUnlock()
+			t.Fatalf("WriteMessage failed: %v", err)
+		}
+	}
+
+	// Both connections are serviced even though the hub is stuck
+	got := map[string]bool{}
+	for len(got) < 2 {
+		select {
+		case msg := <-handled:
+			got[msg] = true
+		case <-time.After(time.Second):
+			hub.mu.RUnlock()
+			t.Fatalf("messages handled while hub busy = %v, want first and second", got)
+		}
+	}
+	hub.mu.RUnlock()
+
+	// Once the hub frees up both registrations land
+	deadline := time.Now().Add(time.Second)
+	for server.GetClientCount() != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("GetClientCount() = %d, want 2", server.GetClientCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWebSocketServer_CloseClientFiresDisconnect(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	registered := make(chan string, 1)
+	server.SetMessageHandler(func(clientID string, data []byte) error {
+		registered <- clientID
+		return nil
+	})
+	disconnected := make(chan string, 1)
+	server.SetDisconnectHandler(func(clientID string) { disconnected <- clientID })
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	s := httptest.NewServer(http.HandlerFunc(server.handleWebSocket))
+	defer s.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.BinaryMessage, []byte("hello"))
+	clientID := <-registered
+
+	if server.CloseClient("no-such-client", CloseIdle, "idle timeout") {
+		t.Error("CloseClient reported success for an unknown client")
+	}
+	if !server.CloseClient(clientID, CloseIdle, "idle timeout") {
+		t.Fatal("CloseClient reported the client as not connected")
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, CloseIdle) {
+		t.Errorf("read error = %v, want close code %d", err, CloseIdle)
+	}
+
+	select {
+	case id := <-disconnected:
+		if id != clientID {
+			t.Errorf("disconnect handler got %s, want %s", id, clientID)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("disconnect handler was not called")
+	}
+}
+
+// dialLimitedServer serves server's WebSocket handler behind its frame-limit
+// listener and returns a client whose writer flushes a frame every writeBuf bytes
+func dialLimitedServer(t *testing.T, server *WebSocketServer, writeBuf int) *websocket.Conn {
+	t.Helper()
+	s := httptest.NewUnstartedServer(http.HandlerFunc(server.handleWebSocket))
+	s.Listener = server.limitFrames(s.Listener)
+	s.Start()
+	t.Cleanup(s.Close)
+
+	dialer := websocket.Dialer{WriteBufferSize: writeBuf}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	// The server hangs up with our oversized frame unread, so don't fail on
+	// echoing its close frame back
+	conn.SetCloseHandler(func(int, string) error { return nil })
+	return conn
+}
+
+// expectTooBig waits for the server to close conn with CloseMessageTooBig
+func expectTooBig(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Errorf("read error = %v, want close code %d", err, websocket.CloseMessageTooBig)
+	}
+}
+
+func TestWebSocketServer_FragmentedMessageOverLimit(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.MaxMessageSize = 4096
+	server.MaxFrameSize = 1024
+	handled := make(chan int, 2)
+	server.SetMessageHandler(func(clientID string, data []byte) error {
+		handled <- len(data)
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	// Each frame is within MaxFrameSize; only the total is checked
+	conn := dialLimitedServer(t, server, 1024)
+	if err := conn.WriteMessage(websocket.BinaryMessage, make([]byte, 3000)); err != nil {
+		t.Fatalf("WriteMessage failed: %v", err)
+	}
+	select {
+	case n := <-handled:
+		if n != 3000 {
+			t.Fatalf("handled %d bytes, want 3000", n)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fragmented message within limits was not handled")
+	}
+
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 8000))
+	expectTooBig(t, conn)
+	select {
+	case n := <-handled:
+		t.Errorf("oversized fragmented message of %d bytes reached the handler", n)
+	default:
+	}
+}
+
+func TestWebSocketServer_SingleFrameOverLimit(t *testing.T) {
+	server := NewWebSocketServer("127.0.0.1:0", nil)
+	server.MaxMessageSize = 64 * 1024
+	server.MaxFrameSize = 1024
+	handled := make(chan int, 1)
+	server.SetMessageHandler(func(clientID string, data []byte) error {
+		handled <- len(data)
+		return nil
+	})
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+
+	// Well under the message limit, but sent as one frame
+	conn := dialLimitedServer(t, server, 8192)
+	conn.WriteMessage(websocket.BinaryMessage, make([]byte, 3000))
+	expectTooBig(t, conn)
+	select {
+	case n := <-handled:
+		t.Errorf("oversized frame of %d bytes reached the handler", n)
+	default:
+	}
+}
+
+func TestFrameLimitConn_ScanAcrossReads(t *testing.T) {
+	c := &frameLimitConn{limit: 200}
+	// Masked frame with a 16-bit length of 150, split mid-header, then an
+	// unmasked frame with a 64-bit length of 300
+	frame := append([]byte{0x82, 0xfe, 0x00, 150, 1, 2, 3, 4}, make([]byte, 150)...)
+	for _, chunk := range [][]byte{frame[:3], frame[3:40], frame[40:]} {
+		if err := c.scan(chunk); err != nil {
+			t.Fatalf("scan of in-limit frame: %v", err)
+		}
+	}
+	if c.remaining != 0 || len(c.header) != 0 {
+		t.Fatalf("parser not at a frame boundary: remaining %d, header %v", c.remaining, c.header)
+	}
+	if err := c.scan([]byte{0x82, 0x7f, 0, 0, 0, 0, 0, 0, 0x01, 0x2c}); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("scan of oversized frame = %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -1,33 +1,57 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
 
+	"github.com/agentries/amp-relay-go/internal/auth"
+	appconfig "github.com/agentries/amp-relay-go/internal/config"
 	"github.com/agentries/amp-relay-go/internal/protocol"
 	"github.com/agentries/amp-relay-go/internal/server"
+	"github.com/agentries/amp-relay-go/internal/storage"
 )
 
 func main() {
+	opts, err := parseFlags(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// -check validates the configuration and exits without starting anything
+	if opts.check {
+		os.Exit(checkConfig(os.Stdout, os.Stderr, opts))
+	}
+
 	fmt.Println("╔════════════════════════════════════════╗")
 	fmt.Println("║     AMP Relay Server v5.0 (Go)         ║")
 	fmt.Println("║     Jason 🍎 Labs Reference Impl       ║")
 	fmt.Println("╚════════════════════════════════════════╝")
 	fmt.Println()
 
-	// Create default configuration
-	config := server.DefaultConfig()
-	config.ListenAddr = ":8080"
+	// Load configuration: flags > environment > file > defaults
+	cfg, err := opts.load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	config, err := serverConfig(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure server: %v", err)
+	}
 
 	// Create and configure server
-	srv := server.NewRelayServer(config)
-
-	// Register example routes
-	srv.RegisterRoute("ping", handlePing)
-	srv.RegisterRoute("echo", handleEcho)
+	srv := newRelayServer(config)
 
 	// Start server
 	if err := srv.Start(); err != nil {
@@ -53,26 +77,189 @@ func main() {
 	fmt.Println("Server stopped gracefully")
 }
 
+// cliOptions holds the parsed command-line flags
+type cliOptions struct {
+	configPath string
+	overrides  appconfig.Overrides
+	check      bool
+}
+
+// parseFlags parses the command-line flags. -addr and -log-level override the
+// file and environment; -config defaults to $AMP_CONFIG_PATH.
+func parseFlags(args []string) (*cliOptions, error) {
+	fs := flag.NewFlagSet("amp-relay", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("AMP_CONFIG_PATH"), "YAML or JSON config file, \"-\" for stdin, or an http(s) URL")
+	addr := fs.String("addr", "", "address to listen on, e.g. :8080")
+	logLevel := fs.String("log-level", "", "log level: debug, info, warn or error")
+	check := fs.Bool("check", false, "validate the configuration and exit without starting the server")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return &cliOptions{
+		configPath: *configPath,
+		overrides: appconfig.Overrides{
+			Address:  *addr,
+			LogLevel: *logLevel,
+		},
+		check: *check,
+	}, nil
+}
+
+// load loads the configuration the options point to
+func (o *cliOptions) load() (*appconfig.Config, error) {
+	return appconfig.LoadWithOverrides(o.configPath, o.overrides)
+}
+
+// loadConfig parses the command-line flags and loads the configuration they
+// point to
+func loadConfig(args []string) (*appconfig.Config, error) {
+	opts, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	return opts.load()
+}
+
+// checkConfig loads and validates the configuration without binding any
+// ports or opening storage, and returns the process exit code
+func checkConfig(stdout, stderr io.Writer, opts *cliOptions) int {
+	source := opts.configPath
+	if source == "" {
+		source = "defaults"
+	}
+	if _, err := opts.load(); err != nil {
+		fmt.Fprintf(stderr, "Configuration invalid (%s): %v\n", source, err)
+		return 1
+	}
+	fmt.Fprintf(stdout, "Configuration OK (%s)\n", source)
+	return 0
+}
+
+// serverConfig maps the loaded configuration onto the relay's settings
+func serverConfig(cfg *appconfig.Config) (*server.Config, error) {
+	logger, err := newLogger(cfg.Logging)
+	if err != nil {
+		return nil, err
+	}
+	store, state, err := newStore(cfg.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	config := server.DefaultConfig()
+	config.ListenAddr = cfg.Server.Address
+	config.DisableWebSocket = !cfg.Server.EnableWebSocket
+	config.MaxPayloadSize = cfg.Server.MaxPayloadSize
+	config.MaxResponseSize = cfg.Server.MaxResponseSize
+	config.Storage = store
+	config.StateStore = state
+	config.DefaultTTL = cfg.Storage.DefaultTTL
+	config.MaxMessageAge = cfg.Storage.MaxMessageAge
+	config.CleanupInterval = cfg.Storage.CleanupInterval
+	config.AllowedOrigins = cfg.Security.AllowedOrigins
+	if cfg.Security.EnableAuth {
+		// Clients prove their DID by signing the handshake nonce
+		config.RequireAuthHandshake = true
+		config.SignatureVerifier = auth.DIDKeyVerifier{}
+	}
+	config.RateLimitPerMinute = cfg.Security.RateLimitPerMinute
+	config.RateLimitByOrigin = cfg.Security.RateLimitByOrigin
+	config.Logger = logger
+	config.LogSampleEvery = cfg.Logging.SampleEvery
+	config.LogMaxPerSecond = cfg.Logging.MaxPerSecond
+	return config, nil
+}
+
+// stateFileName is the file holding the relay's control state in a file storage directory
+const stateFileName = "state.cbor"
+
+// newStore opens the message store backend the storage settings select,
+// along with the state store keeping the relay's control state beside it
+func newStore(cfg appconfig.StorageConfig) (storage.MessageStore, storage.StateStore, error) {
+	switch cfg.Type {
+	case "", "memory":
+		store := storage.NewMemoryStore()
+		store.SetMaxMessages(cfg.MaxMessages)
+		return store, storage.NewMemoryStateStore(), nil
+	case "file":
+		store, err := storage.NewFileStore(cfg.Path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file storage: %w", err)
+		}
+		state, err := storage.NewFileStateStore(filepath.Join(cfg.Path, stateFileName))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open file storage: %w", err)
+		}
+		return store, state, nil
+	case "redis":
+		store, err := storage.NewRedisStore(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open redis storage: %w", err)
+		}
+		return store, store.State(), nil
+	default:
+		return nil, nil, fmt.Errorf("storage type %q is not supported", cfg.Type)
+	}
+}
+
+// newLogger builds the structured logger described by the logging settings
+func newLogger(cfg appconfig.LoggingConfig) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	var out io.Writer
+	switch cfg.Output {
+	case "", "stdout":
+		out = os.Stdout
+	case "stderr":
+		out = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log output: %w", err)
+		}
+		out = f
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	if strings.EqualFold(cfg.Format, "json") {
+		return slog.New(slog.NewJSONHandler(out, opts)), nil
+	}
+	return slog.New(slog.NewTextHandler(out, opts)), nil
+}
+
+// newRelayServer creates the relay server with the example routes registered
+func newRelayServer(config *server.Config) *server.RelayServer {
+	srv := server.NewRelayServer(config)
+	srv.RegisterRoute("ping", handlePing)
+	srv.RegisterRoute("echo", handleEcho)
+	return srv
+}
+
 // handlePing responds to ping requests
-func handlePing(msg *protocol.Message) (*protocol.Message, error) {
+func handlePing(msg server.RelayMessage) (server.RelayMessage, error) {
 	response := protocol.NewMessage(
 		protocol.MessageTypeResponse,
 		"relay-server",
-		msg.Source,
-		"ping",
-		[]byte(`{"status":"ok","message":"pong"}`),
+		msg.From(),
+		map[string]interface{}{
+			"status":  "ok",
+			"message": "pong",
+		},
 	)
-	return response, nil
+	return server.WrapMessage(response), nil
 }
 
 // handleEcho echoes back the received payload
-func handleEcho(msg *protocol.Message) (*protocol.Message, error) {
+func handleEcho(msg server.RelayMessage) (server.RelayMessage, error) {
 	response := protocol.NewMessage(
 		protocol.MessageTypeResponse,
 		"relay-server",
-		msg.Source,
-		"echo",
-		msg.Payload,
+		msg.From(),
+		msg.Body(),
 	)
-	return response, nil
+	return server.WrapMessage(response), nil
 }
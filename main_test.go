@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/internal/protocol"
+	"github.com/agentries/amp-relay-go/internal/server"
+	"github.com/agentries/amp-relay-go/internal/storage"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+)
+
+// TestMain_PingEchoRoundTrip boots the server the way main does and drives
+// it over a real WebSocket, so wiring regressions fail here rather than in
+// production
+func TestMain_PingEchoRoundTrip(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to get free port: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	config := server.DefaultConfig()
+	config.ListenAddr = addr
+	srv := newRelayServer(config)
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer srv.Stop()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/amp/v1/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+
+	roundTrip := func(body interface{}) *protocol.Message {
+		t.Helper()
+		req := protocol.NewMessage(protocol.MessageTypeRequest, "did:example:client", "relay-server", body)
+		data, err := req.CBORMarshal()
+		if err != nil {
+			t.Fatalf("CBORMarshal: %v", err)
+		}
+		if err := conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			t.Fatalf("WriteMessage: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, data, err = conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage: %v", err)
+		}
+		resp := &protocol.Message{}
+		if err := resp.CBORUnmarshal(data); err != nil {
+			t.Fatalf("CBORUnmarshal: %v", err)
+		}
+		if resp.Type != protocol.MessageTypeResponse || !reflect.DeepEqual(resp.ReplyTo, req.ID) {
+			t.Fatalf("got type 0x%02x reply_to %x, want response to %x", resp.Type, resp.ReplyTo, req.ID)
+		}
+		return resp
+	}
+
+	pong := roundTrip(map[string]interface{}{"action": "ping"})
+	body, _ := pong.Body.(map[interface{}]interface{})
+	if body["message"] != "pong" {
+		t.Errorf("ping body = %v, want message pong", pong.Body)
+	}
+
+	echo := roundTrip(map[string]interface{}{"action": "echo", "text": "hello"})
+	body, _ = echo.Body.(map[interface{}]interface{})
+	if body["action"] != "echo" || body["text"] != "hello" {
+		t.Errorf("echo body = %v, want the request body back", echo.Body)
+	}
+}
+
+func TestLoadConfig_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.yaml")
+	file := "server:\n  address: \":9001\"\nlogging:\n  level: warn\n  format: json\n"
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		env       map[string]string
+		args      []string
+		wantAddr  string
+		wantLevel string
+	}{
+		{"defaults", nil, nil, ":8080", "info"},
+		{"file via flag", nil, []string{"-config", path}, ":9001", "warn"},
+		{"file via env", map[string]string{"AMP_CONFIG_PATH": path}, nil, ":9001", "warn"},
+		{"env over file", map[string]string{"AMP_SERVER_ADDRESS": ":9002"}, []string{"-config", path}, ":9002", "warn"},
+		{"flags over env", map[string]string{"AMP_SERVER_ADDRESS": ":9002", "AMP_LOG_LEVEL": "error"},
+			[]string{"-config", path, "-addr", ":9003", "-log-level", "debug"}, ":9003", "debug"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"AMP_CONFIG_PATH", "AMP_SERVER_ADDRESS", "AMP_LOG_LEVEL"} {
+				t.Setenv(key, tt.env[key])
+			}
+			cfg, err := loadConfig(tt.args)
+			if err != nil {
+				t.Fatalf("loadConfig() error: %v", err)
+			}
+			if cfg.Server.Address != tt.wantAddr {
+				t.Errorf("Address = %q, want %q", cfg.Server.Address, tt.wantAddr)
+			}
+			if cfg.Logging.Level != tt.wantLevel {
+				t.Errorf("Level = %q, want %q", cfg.Logging.Level, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestLoadConfig_RejectsInvalidFlags(t *testing.T) {
+	t.Setenv("AMP_CONFIG_PATH", "")
+	if _, err := loadConfig([]string{"-log-level", "loud"}); err == nil {
+		t.Error("loadConfig accepted an invalid -log-level")
+	}
+	if _, err := loadConfig([]string{"-no-such-flag"}); err == nil {
+		t.Error("loadConfig accepted an unknown flag")
+	}
+}
+
+func TestCheckConfig_ExitCodes(t *testing.T) {
+	t.Setenv("AMP_CONFIG_PATH", "")
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("storage:\n  type: floppy\n"), 0644); err != nil {
+		t.Fatalf("WriteFile returned error: %v", err)
+	}
+
+	opts, err := parseFlags([]string{"-check", "-config", path})
+	if err != nil {
+		t.Fatalf("parseFlags() error: %v", err)
+	}
+	if !opts.check {
+		t.Fatal("-check was not recorded")
+	}
+	var stdout, stderr bytes.Buffer
+	if code := checkConfig(&stdout, &stderr, opts); code != 1 {
+		t.Errorf("checkConfig() on an invalid file = %d, want 1", code)
+	}
+	if stderr.Len() == 0 {
+		t.Error("checkConfig() printed nothing for an invalid file")
+	}
+
+	opts, err = parseFlags([]string{"-check", "-addr", "127.0.0.1:9100"})
+	if err != nil {
+		t.Fatalf("parseFlags() error: %v", err)
+	}
+	stdout.Reset()
+	if code := checkConfig(&stdout, &stderr, opts); code != 0 {
+		t.Errorf("checkConfig() on valid settings = %d, want 0", code)
+	}
+	if stdout.Len() == 0 {
+		t.Error("checkConfig() printed nothing on success")
+	}
+}
+
+func TestServerConfig_MapsSettings(t *testing.T) {
+	t.Setenv("AMP_CONFIG_PATH", "")
+	cfg, err := loadConfig([]string{"-addr", "127.0.0.1:9100", "-log-level", "debug"})
+	if err != nil {
+		t.Fatalf("loadConfig() error: %v", err)
+	}
+	config, err := serverConfig(cfg)
+	if err != nil {
+		t.Fatalf("serverConfig() error: %v", err)
+	}
+	if config.ListenAddr != "127.0.0.1:9100" {
+		t.Errorf("ListenAddr = %q, want 127.0.0.1:9100", config.ListenAddr)
+	}
+	if !config.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("logger does not emit debug records at -log-level debug")
+	}
+}
+
+func TestServerConfig_EnableAuthVerifiesSignatures(t *testing.T) {
+	t.Setenv("AMP_CONFIG_PATH", "")
+	t.Setenv("AMP_SECURITY_ENABLE_AUTH", "true")
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("loadConfig() error: %v", err)
+	}
+	config, err := serverConfig(cfg)
+	if err != nil {
+		t.Fatalf("serverConfig() error: %v", err)
+	}
+	if !config.RequireAuthHandshake {
+		t.Error("RequireAuthHandshake = false with enable_auth set")
+	}
+	if config.SignatureVerifier == nil {
+		t.Error("enable_auth set without a SignatureVerifier")
+	}
+}
+
+func TestServerConfig_SelectsStorageBackend(t *testing.T) {
+	t.Setenv("AMP_CONFIG_PATH", "")
+	t.Setenv("AMP_STORAGE_TYPE", "file")
+	t.Setenv("AMP_STORAGE_PATH", t.TempDir())
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("loadConfig() error: %v", err)
+	}
+	config, err := serverConfig(cfg)
+	if err != nil {
+		t.Fatalf("serverConfig() error: %v", err)
+	}
+	if _, ok := config.Storage.(*storage.FileStore); !ok {
+		t.Errorf("Storage = %T, want *storage.FileStore", config.Storage)
+	}
+
+	cfg.Storage.Type = "memory"
+	if config, err = serverConfig(cfg); err != nil {
+		t.Fatalf("serverConfig() error: %v", err)
+	}
+	if _, ok := config.Storage.(*storage.MemoryStore); !ok {
+		t.Errorf("Storage = %T, want *storage.MemoryStore", config.Storage)
+	}
+
+	cfg.Storage.Type = "redis"
+	cfg.Storage.RedisAddr = miniredis.RunT(t).Addr()
+	if config, err = serverConfig(cfg); err != nil {
+		t.Fatalf("serverConfig() error: %v", err)
+	}
+	if _, ok := config.Storage.(*storage.RedisStore); !ok {
+		t.Errorf("Storage = %T, want *storage.RedisStore", config.Storage)
+	}
+}
+
+func TestServerConfig_CapsMemoryStore(t *testing.T) {
+	t.Setenv("AMP_CONFIG_PATH", "")
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("loadConfig() error: %v", err)
+	}
+	cfg.Storage.MaxMessages = 3
+	config, err := serverConfig(cfg)
+	if err != nil {
+		t.Fatalf("serverConfig() error: %v", err)
+	}
+
+	// Control state lives beside the store and is never evicted by the cap
+	config.StateStore.Put("contact:did:example:a", []byte("accepted"), storage.NoExpiry)
+	for i := 0; i < 5; i++ {
+		config.Storage.Save(protocol.NewMessage(protocol.MessageTypeRequest, "did:example:a", "did:example:b", nil), time.Minute)
+	}
+	if all, _ := config.Storage.List(); len(all) != 3 {
+		t.Errorf("memory store holds %d messages, want storage.max_messages = 3", len(all))
+	}
+	if state, _ := config.StateStore.Get("contact:did:example:a"); string(state) != "accepted" {
+		t.Errorf("control state = %q after eviction, want it kept", state)
+	}
+}
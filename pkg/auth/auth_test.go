@@ -215,6 +215,51 @@ func TestCapabilityValidator(t *testing.T) {
 	}
 }
 
+func TestCapabilityValidator_Constraints(t *testing.T) {
+	manifest := &protocol.CapabilityManifest{
+		AgentDID: "did:web:agentries.xyz:agent:constraints",
+		Present: []protocol.Capability{
+			{Domain: "messaging", Type: "email", Version: "v1.0",
+				Constraints: map[string]string{"region": "eu", "max_size": "..1048576", "priority": "1..5"}},
+			{Domain: "storage", Type: "ipfs", Version: "v1.0"},
+		},
+	}
+	validator := NewCapabilityValidator(manifest)
+
+	email := func(constraints map[string]string) protocol.Capability {
+		return protocol.Capability{Domain: "messaging", Type: "email", Version: "v1.0", Constraints: constraints}
+	}
+
+	tests := []struct {
+		name       string
+		capability protocol.Capability
+		expected   bool
+	}{
+		{"no constraints", email(nil), true},
+		{"exact value satisfied", email(map[string]string{"region": "eu"}), true},
+		{"exact value violated", email(map[string]string{"region": "us"}), false},
+		{"number within open range", email(map[string]string{"max_size": "65536"}), true},
+		{"number above range", email(map[string]string{"max_size": "2097152"}), false},
+		{"range within range", email(map[string]string{"priority": "2..4"}), true},
+		{"range exceeding range", email(map[string]string{"priority": "0..3"}), false},
+		{"non-numeric against range", email(map[string]string{"priority": "high"}), false},
+		{"several constraints satisfied", email(map[string]string{"region": "eu", "priority": "5"}), true},
+		{"one of several violated", email(map[string]string{"region": "eu", "priority": "9"}), false},
+		{"key not offered", email(map[string]string{"encrypted": "true"}), false},
+		{
+			name:       "constraints against unconstrained capability",
+			capability: protocol.Capability{Domain: "storage", Type: "ipfs", Version: "v1.0", Constraints: map[string]string{"pinning": "true"}},
+			expected:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, validator.Validate(tt.capability))
+		})
+	}
+}
+
 func TestMessageAuthenticator(t *testing.T) {
 	resolver := NewMockDIDResolver()
 	didAuth := NewDIDAuthenticator(resolver)
@@ -310,3 +355,36 @@ func TestDIDCache(t *testing.T) {
 		assert.Nil(t, retrieved)
 	})
 }
+
+func TestDIDCache_GC(t *testing.T) {
+	cache := NewDIDCache(20 * time.Millisecond)
+	doc := &DIDDocument{ID: "did:web:gc"}
+
+	cache.Set("did:web:gc", doc)
+	stop := cache.StartGC(10 * time.Millisecond)
+	defer stop()
+
+	// 不调用 Get，等待后台协程清理
+	assert.Eventually(t, func() bool {
+		cache.mu.Lock()
+		defer cache.mu.Unlock()
+		return len(cache.data) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	// 重复调用 stop 应当安全
+	stop()
+	stop()
+}
+
+func TestDIDCache_GCNonPositiveInterval(t *testing.T) {
+	cache := NewDIDCache(time.Minute)
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		var stop func()
+		require.NotPanics(t, func() { stop = cache.StartGC(interval) })
+		require.NotNil(t, stop)
+		// 未启动协程时 stop 同样可重复调用
+		stop()
+		stop()
+	}
+}
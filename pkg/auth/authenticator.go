@@ -5,6 +5,9 @@ import (
 	"context"
 	"crypto/ed25519"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/agentries/amp-relay-go/pkg/protocol"
@@ -234,9 +237,10 @@ func (cv *CapabilityValidator) Validate(capability protocol.Capability) bool {
 		}
 	}
 	
-	// 检查是否具备该能力
+	// 检查是否具备该能力，且请求的约束条件可被满足
 	for _, present := range cv.manifest.Present {
-		if present.String() == capability.String() {
+		if present.String() == capability.String() &&
+			constraintsSatisfied(capability.Constraints, present.Constraints) {
 			return true
 		}
 	}
@@ -244,6 +248,71 @@ func (cv *CapabilityValidator) Validate(capability protocol.Capability) bool {
 	return false
 }
 
+// constraintRangeSeparator 分隔数值范围约束的上下界，如 "1..10"、"..1024"、"5.."
+const constraintRangeSeparator = ".."
+
+// constraintsSatisfied 检查请求的约束条件是否被已具备能力的约束条件满足
+// 请求中的每个约束键都必须在已具备能力中声明，且取值相容（见 constraintValueSatisfied）
+// 请求不带约束时总是满足
+func constraintsSatisfied(requested, present map[string]string) bool {
+	for key, want := range requested {
+		have, ok := present[key]
+		if !ok || !constraintValueSatisfied(want, have) {
+			return false
+		}
+	}
+	return true
+}
+
+// constraintValueSatisfied 检查单个约束取值是否相容：
+//   - 字符串完全相等即满足
+//   - 否则双方按数值解析，已具备能力的取值可为数值范围 "lo..hi"（任一端可省略），
+//     请求的数值或数值范围须落在该范围内
+func constraintValueSatisfied(want, have string) bool {
+	if want == have {
+		return true
+	}
+
+	haveLo, haveHi, ok := parseConstraintRange(have)
+	if !ok {
+		return false
+	}
+	wantLo, wantHi, ok := parseConstraintRange(want)
+	if !ok {
+		return false
+	}
+	return wantLo >= haveLo && wantHi <= haveHi
+}
+
+// parseConstraintRange 解析数值范围 "lo..hi"，省略的一端为无穷；单个数值视为 [v, v]
+func parseConstraintRange(s string) (lo, hi float64, ok bool) {
+	loStr, hiStr, isRange := strings.Cut(s, constraintRangeSeparator)
+	if !isRange {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		return v, v, err == nil
+	}
+
+	lo, hi = math.Inf(-1), math.Inf(1)
+	if loStr = strings.TrimSpace(loStr); loStr != "" {
+		v, err := strconv.ParseFloat(loStr, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		lo = v
+	}
+	if hiStr = strings.TrimSpace(hiStr); hiStr != "" {
+		v, err := strconv.ParseFloat(hiStr, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		hi = v
+	}
+	if loStr == "" && hiStr == "" {
+		return 0, 0, false
+	}
+	return lo, hi, lo <= hi
+}
+
 // ValidateBatch 批量验证能力
 func (cv *CapabilityValidator) ValidateBatch(capabilities []protocol.Capability) []bool {
 	results := make([]bool, len(capabilities))
@@ -6,11 +6,13 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 )
 
 // DIDCache DID文档缓存
 type DIDCache struct {
+	mu    sync.Mutex
 	data  map[string]*cacheEntry
 	ttl   time.Duration
 }
@@ -30,6 +32,9 @@ func NewDIDCache(ttl time.Duration) *DIDCache {
 
 // Get 获取缓存的DID文档
 func (c *DIDCache) Get(did string) *DIDDocument {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	entry, exists := c.data[did]
 	if !exists || time.Now().After(entry.expiry) {
 		delete(c.data, did)
@@ -40,12 +45,67 @@ func (c *DIDCache) Get(did string) *DIDDocument {
 
 // Set 设置DID文档缓存
 func (c *DIDCache) Set(did string, doc *DIDDocument) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	c.data[did] = &cacheEntry{
 		document: doc,
 		expiry:   time.Now().Add(c.ttl),
 	}
 }
 
+// Purge 清除所有已过期的缓存项，返回清除数量
+func (c *DIDCache) Purge() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	removed := 0
+	for did, entry := range c.data {
+		if now.After(entry.expiry) {
+			delete(c.data, did)
+			removed++
+		}
+	}
+	return removed
+}
+
+// StartGC 启动后台清理协程，按 interval 周期清除过期项
+// 返回的 stop 函数用于停止协程，可重复调用；interval <= 0 时不启动协程
+func (c *DIDCache) StartGC(interval time.Duration) (stop func()) {
+	return startGC(interval, func() { c.Purge() })
+}
+
+// startGC 按固定间隔执行 purge，直到 stop 被调用
+// 供各类缓存共享同一套后台清理逻辑
+// interval <= 0 时 time.NewTicker 会 panic，此时返回空的 stop 函数
+func startGC(interval time.Duration, purge func()) (stop func()) {
+	if interval <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var once sync.Once
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				purge()
+			}
+		}
+	}()
+
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
 // parseMultibasePublicKey 解析multibase编码的公钥
 func parseMultibasePublicKey(multibase string) ([]byte, error) {
 	// 简单的multibase解析，支持base58btc编码
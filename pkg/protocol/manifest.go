@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultManifestValidity 未调用 ValidFor 时能力清单的有效期
+const DefaultManifestValidity = 24 * time.Hour
+
+// ErrManifestConflict 同一能力同时出现在 Present 和 Absent 中
+var ErrManifestConflict = errors.New("capability both present and absent")
+
+// ManifestBuilder 构建并校验 CapabilityManifest
+type ManifestBuilder struct {
+	agentDID string
+	present  []Capability
+	absent   []Capability
+	validFor time.Duration
+}
+
+// NewManifestBuilder 创建能力清单构建器
+func NewManifestBuilder() *ManifestBuilder {
+	return &ManifestBuilder{validFor: DefaultManifestValidity}
+}
+
+// AgentDID 设置清单所属的 Agent DID
+func (b *ManifestBuilder) AgentDID(did string) *ManifestBuilder {
+	b.agentDID = did
+	return b
+}
+
+// AddPresent 添加具备的能力
+func (b *ManifestBuilder) AddPresent(caps ...Capability) *ManifestBuilder {
+	b.present = append(b.present, caps...)
+	return b
+}
+
+// AddAbsent 添加明确缺失的能力
+func (b *ManifestBuilder) AddAbsent(caps ...Capability) *ManifestBuilder {
+	b.absent = append(b.absent, caps...)
+	return b
+}
+
+// ValidFor 设置清单自签发起的有效期
+func (b *ManifestBuilder) ValidFor(d time.Duration) *ManifestBuilder {
+	b.validFor = d
+	return b
+}
+
+// Build 校验并生成能力清单，填入当前协议版本、签发时间和过期时间
+func (b *ManifestBuilder) Build() (*CapabilityManifest, error) {
+	if b.agentDID == "" {
+		return nil, errors.New("manifest agent DID is empty")
+	}
+	if b.validFor <= 0 {
+		return nil, fmt.Errorf("manifest validity must be positive, got %v", b.validFor)
+	}
+
+	present := make(map[string]bool, len(b.present))
+	for _, c := range b.present {
+		if err := c.Valid(); err != nil {
+			return nil, fmt.Errorf("present capability %q: %w", c.String(), err)
+		}
+		present[c.String()] = true
+	}
+	for _, c := range b.absent {
+		if err := c.Valid(); err != nil {
+			return nil, fmt.Errorf("absent capability %q: %w", c.String(), err)
+		}
+		if present[c.String()] {
+			return nil, fmt.Errorf("%w: %s", ErrManifestConflict, c.String())
+		}
+	}
+
+	issuedAt := time.Now().UTC()
+	return &CapabilityManifest{
+		AgentDID:  b.agentDID,
+		Version:   CurrentVersion,
+		IssuedAt:  issuedAt,
+		ExpiresAt: issuedAt.Add(b.validFor),
+		Present:   append([]Capability(nil), b.present...),
+		Absent:    append([]Capability(nil), b.absent...),
+	}, nil
+}
@@ -0,0 +1,61 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestBuilder_Build(t *testing.T) {
+	email := Capability{Domain: "messaging", Type: "email", Version: "v1.0"}
+	sign := Capability{Domain: "crypto", Type: "sign", Version: "v2.0"}
+	exec := Capability{Domain: "execution", Type: "arbitrary-code", Version: "v1.0"}
+
+	before := time.Now()
+	manifest, err := NewManifestBuilder().
+		AgentDID("did:web:agentries.xyz:agent:builder").
+		AddPresent(email, sign).
+		AddAbsent(exec).
+		ValidFor(time.Hour).
+		Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, "did:web:agentries.xyz:agent:builder", manifest.AgentDID)
+	assert.Equal(t, CurrentVersion, manifest.Version)
+	assert.Equal(t, []Capability{email, sign}, manifest.Present)
+	assert.Equal(t, []Capability{exec}, manifest.Absent)
+	assert.False(t, manifest.IssuedAt.Before(before))
+	assert.Equal(t, time.Hour, manifest.ExpiresAt.Sub(manifest.IssuedAt))
+}
+
+func TestManifestBuilder_DefaultValidity(t *testing.T) {
+	manifest, err := NewManifestBuilder().AgentDID("did:web:example.com").Build()
+	require.NoError(t, err)
+	assert.Equal(t, DefaultManifestValidity, manifest.ExpiresAt.Sub(manifest.IssuedAt))
+}
+
+func TestManifestBuilder_PresentAbsentConflict(t *testing.T) {
+	email := Capability{Domain: "messaging", Type: "email", Version: "v1.0"}
+
+	_, err := NewManifestBuilder().
+		AgentDID("did:web:example.com").
+		AddPresent(email).
+		AddAbsent(Capability{Domain: "messaging", Type: "email", Version: "v1.0",
+			Constraints: map[string]string{"region": "eu"}}).
+		Build()
+	assert.ErrorIs(t, err, ErrManifestConflict)
+}
+
+func TestManifestBuilder_Invalid(t *testing.T) {
+	_, err := NewManifestBuilder().Build()
+	assert.Error(t, err, "missing agent DID")
+
+	_, err = NewManifestBuilder().AgentDID("did:web:example.com").ValidFor(0).Build()
+	assert.Error(t, err, "non-positive validity")
+
+	_, err = NewManifestBuilder().AgentDID("did:web:example.com").
+		AddPresent(Capability{Domain: "messaging", Type: "email"}).Build()
+	assert.ErrorIs(t, err, ErrInvalidCapability)
+}
@@ -4,6 +4,9 @@ package protocol
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -41,6 +44,43 @@ func (c Capability) String() string {
 	return c.Domain + ":" + c.Type + ":" + c.Version
 }
 
+// capabilitySeparator 分隔能力字符串中的 domain、type 和 version
+const capabilitySeparator = ":"
+
+// ErrInvalidCapability 能力字符串或字段不合法
+var ErrInvalidCapability = errors.New("invalid capability")
+
+// Valid 检查能力的三个组成部分均非空且不含分隔符，即 String() 的结果可被 ParseCapability 还原
+func (c Capability) Valid() error {
+	for _, part := range []struct{ name, value string }{
+		{"domain", c.Domain},
+		{"type", c.Type},
+		{"version", c.Version},
+	} {
+		if part.value == "" {
+			return fmt.Errorf("%w: %s is empty", ErrInvalidCapability, part.name)
+		}
+		if strings.Contains(part.value, capabilitySeparator) {
+			return fmt.Errorf("%w: %s %q contains %q", ErrInvalidCapability, part.name, part.value, capabilitySeparator)
+		}
+	}
+	return nil
+}
+
+// ParseCapability 解析 String() 生成的 "domain:type:version" 形式的能力字符串
+// 约束条件不在字符串表示中，解析结果的 Constraints 为空
+func ParseCapability(s string) (Capability, error) {
+	parts := strings.Split(s, capabilitySeparator)
+	if len(parts) != 3 {
+		return Capability{}, fmt.Errorf("%w: %q is not of the form domain:type:version", ErrInvalidCapability, s)
+	}
+	c := Capability{Domain: parts[0], Type: parts[1], Version: parts[2]}
+	if err := c.Valid(); err != nil {
+		return Capability{}, err
+	}
+	return c, nil
+}
+
 // CapabilityManifest Agent能力清单
 type CapabilityManifest struct {
 	AgentDID    string       `json:"agent_did"`           // Agent DID
@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCapability_RoundTrip(t *testing.T) {
+	caps := []Capability{
+		{Domain: "messaging", Type: "email", Version: "v5.13"},
+		{Domain: "storage", Type: "ipfs", Version: "1"},
+		{Domain: "crypto", Type: "eth", Version: "v2.0-beta"},
+	}
+
+	for _, c := range caps {
+		t.Run(c.String(), func(t *testing.T) {
+			require.NoError(t, c.Valid())
+
+			parsed, err := ParseCapability(c.String())
+			require.NoError(t, err)
+			assert.Equal(t, c, parsed)
+			assert.Equal(t, c.String(), parsed.String())
+		})
+	}
+}
+
+func TestParseCapability_Malformed(t *testing.T) {
+	malformed := []string{
+		"",
+		"messaging",
+		"messaging:email",
+		"messaging:email:v5.13:extra",
+		":email:v5.13",
+		"messaging::v5.13",
+		"messaging:email:",
+		"::",
+	}
+
+	for _, s := range malformed {
+		t.Run(s, func(t *testing.T) {
+			_, err := ParseCapability(s)
+			assert.ErrorIs(t, err, ErrInvalidCapability)
+		})
+	}
+}
+
+func TestCapability_Valid(t *testing.T) {
+	assert.ErrorIs(t, Capability{Type: "email", Version: "v1"}.Valid(), ErrInvalidCapability)
+	assert.ErrorIs(t, Capability{Domain: "a:b", Type: "email", Version: "v1"}.Valid(), ErrInvalidCapability)
+	assert.NoError(t, Capability{Domain: "messaging", Type: "email", Version: "v1",
+		Constraints: map[string]string{"region": "eu"}}.Valid())
+}
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultDedupWindow 默认消息ID去重时间窗口
+const DefaultDedupWindow = 5 * time.Minute
+
+// DefaultDedupCapacity 默认去重集合最多记录的消息ID数
+const DefaultDedupCapacity = 100000
+
+// seenSet 有界、带时间窗口的已见消息ID集合
+// 超过窗口或容量时，最早记录的ID被淘汰
+type seenSet struct {
+	mu       sync.Mutex
+	window   time.Duration
+	capacity int
+	seen     map[string]time.Time
+	order    []seenEntry // 按插入时间排序，用于淘汰
+}
+
+type seenEntry struct {
+	id string
+	at time.Time
+}
+
+// newSeenSet 创建去重集合，window<=0 表示禁用去重
+func newSeenSet(window time.Duration, capacity int) *seenSet {
+	return &seenSet{
+		window:   window,
+		capacity: capacity,
+		seen:     make(map[string]time.Time),
+	}
+}
+
+// CheckAndAdd 若ID在窗口内已出现过返回true，否则记录该ID并返回false
+func (s *seenSet) CheckAndAdd(id string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.window <= 0 {
+		return false
+	}
+
+	s.evict(now)
+
+	if _, exists := s.seen[id]; exists {
+		return true
+	}
+
+	s.seen[id] = now
+	s.order = append(s.order, seenEntry{id: id, at: now})
+	return false
+}
+
+// Forget 移除ID的记录，使其可再次通过检查（如转发失败后允许重试）
+func (s *seenSet) Forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.seen, id)
+}
+
+// evict 淘汰过期和超出容量的ID，调用方需持有锁
+// 被Forget后又重新记录的ID，只在其最新记录过期时才删除
+func (s *seenSet) evict(now time.Time) {
+	cutoff := now.Add(-s.window)
+	n := 0
+	for n < len(s.order) && (s.order[n].at.Before(cutoff) || (s.capacity > 0 && len(s.order)-n >= s.capacity)) {
+		entry := s.order[n]
+		if at, ok := s.seen[entry.id]; ok && at.Equal(entry.at) {
+			delete(s.seen, entry.id)
+		}
+		n++
+	}
+	s.order = s.order[n:]
+}
+
+// setWindow 修改去重时间窗口
+func (s *seenSet) setWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = window
+}
+
+// Len 返回当前记录的ID数
+func (s *seenSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.seen)
+}
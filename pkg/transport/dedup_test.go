@@ -0,0 +1,97 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/agentries/amp-relay-go/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestMessageRelay_Dedup(t *testing.T) {
+	relay := NewMessageRelay(zap.NewNop())
+	target := &mockTransport{did: "did:web:bob"}
+	relay.Register(target.did, target)
+
+	msg := &protocol.Message{ID: "dup-1", From: "did:web:alice", To: "did:web:bob"}
+
+	require.NoError(t, relay.Forward(context.Background(), msg))
+	err := relay.Forward(context.Background(), msg)
+	assert.ErrorIs(t, err, ErrDuplicateMessage)
+	assert.Len(t, target.Sent(), 1)
+
+	// 不同ID正常转发
+	other := &protocol.Message{ID: "dup-2", From: "did:web:alice", To: "did:web:bob"}
+	require.NoError(t, relay.Forward(context.Background(), other))
+	assert.Len(t, target.Sent(), 2)
+}
+
+func TestMessageRelay_DedupRetryAfterSendFailure(t *testing.T) {
+	relay := NewMessageRelay(zap.NewNop())
+	target := &mockTransport{did: "did:web:bob", failures: 1}
+	relay.Register(target.did, target)
+
+	msg := &protocol.Message{ID: "retry-1", From: "did:web:alice", To: "did:web:bob"}
+
+	// 发送失败的消息不计入去重，重试可以成功
+	require.Error(t, relay.Forward(context.Background(), msg))
+	require.NoError(t, relay.Forward(context.Background(), msg))
+	assert.Len(t, target.Sent(), 1)
+
+	// 成功转发后再次到达的同一消息仍被抑制
+	assert.ErrorIs(t, relay.Forward(context.Background(), msg), ErrDuplicateMessage)
+	assert.Len(t, target.Sent(), 1)
+}
+
+func TestSeenSet_ForgetThenReadd(t *testing.T) {
+	s := newSeenSet(time.Minute, 0)
+	now := time.Now()
+
+	assert.False(t, s.CheckAndAdd("a", now))
+	s.Forget("a")
+	assert.False(t, s.CheckAndAdd("a", now.Add(50*time.Second)))
+
+	// 旧记录过期不影响重新记录的ID
+	assert.True(t, s.CheckAndAdd("a", now.Add(90*time.Second)))
+}
+
+func TestMessageRelay_DedupDisabled(t *testing.T) {
+	relay := NewMessageRelay(zap.NewNop())
+	relay.SetDedupWindow(0)
+	target := &mockTransport{did: "did:web:bob"}
+	relay.Register(target.did, target)
+
+	msg := &protocol.Message{ID: "dup-1", To: "did:web:bob"}
+	require.NoError(t, relay.Forward(context.Background(), msg))
+	require.NoError(t, relay.Forward(context.Background(), msg))
+	assert.Len(t, target.Sent(), 2)
+}
+
+func TestSeenSet_Window(t *testing.T) {
+	s := newSeenSet(time.Minute, 0)
+	now := time.Now()
+
+	assert.False(t, s.CheckAndAdd("a", now))
+	assert.True(t, s.CheckAndAdd("a", now.Add(30*time.Second)))
+
+	// 窗口过后同一ID被视为新消息
+	assert.False(t, s.CheckAndAdd("a", now.Add(2*time.Minute)))
+}
+
+func TestSeenSet_Capacity(t *testing.T) {
+	s := newSeenSet(time.Hour, 3)
+	now := time.Now()
+
+	for i := 0; i < 10; i++ {
+		s.CheckAndAdd(fmt.Sprintf("id-%d", i), now)
+	}
+	assert.LessOrEqual(t, s.Len(), 3)
+
+	// 最早的ID已被淘汰
+	assert.False(t, s.CheckAndAdd("id-0", now))
+	assert.True(t, s.CheckAndAdd("id-9", now))
+}
@@ -3,8 +3,11 @@ package transport
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/agentries/amp-relay-go/pkg/protocol"
 	"github.com/gorilla/websocket"
@@ -79,20 +82,48 @@ func (t *WSTransport) Close() error {
 func (t *WSTransport) LocalDID() string  { return t.localDID }
 func (t *WSTransport) RemoteDID() string { return t.remoteDID }
 
+// HopCountHeader 记录消息已被中继转发次数的消息头
+const HopCountHeader = "x-amp-hops"
+
+// DefaultMaxHops 默认最大转发跳数
+const DefaultMaxHops = 8
+
+// ErrMaxHopsExceeded 消息转发跳数超过上限（疑似路由环路）
+var ErrMaxHopsExceeded = errors.New("max_hops_exceeded")
+
+// ErrDuplicateMessage 消息ID在去重窗口内已被转发过
+var ErrDuplicateMessage = errors.New("duplicate_message")
+
 // MessageRelay 消息中继器
 type MessageRelay struct {
 	transports map[string]protocol.Transport
 	mu         sync.RWMutex
 	logger     *zap.Logger
+	maxHops    int
+	seen       *seenSet
 }
 
 func NewMessageRelay(logger *zap.Logger) *MessageRelay {
 	return &MessageRelay{
 		transports: make(map[string]protocol.Transport),
 		logger:     logger,
+		maxHops:    DefaultMaxHops,
+		seen:       newSeenSet(DefaultDedupWindow, DefaultDedupCapacity),
 	}
 }
 
+// SetDedupWindow 设置消息ID去重时间窗口，<=0 表示禁用去重
+func (r *MessageRelay) SetDedupWindow(window time.Duration) {
+	r.seen.setWindow(window)
+}
+
+// SetMaxHops 设置最大转发跳数，<=0 表示不限制
+func (r *MessageRelay) SetMaxHops(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxHops = n
+}
+
 // Register 注册Agent传输
 func (r *MessageRelay) Register(did string, t protocol.Transport) {
 	r.mu.Lock()
@@ -108,16 +139,57 @@ func (r *MessageRelay) Unregister(did string) {
 }
 
 // Forward 转发消息
+// 每次转发都会递增跳数消息头，超过上限的消息被丢弃以防止路由环路
 func (r *MessageRelay) Forward(ctx context.Context, msg *protocol.Message) error {
 	r.mu.RLock()
 	target, exists := r.transports[msg.To]
+	maxHops := r.maxHops
 	r.mu.RUnlock()
 	
 	if !exists {
 		return fmt.Errorf("target agent %s not found", msg.To)
 	}
 	
-	return target.Send(ctx, msg)
+	hops := HopCount(msg)
+	if maxHops > 0 && hops >= maxHops {
+		r.logger.Error("dropping message", zap.Error(ErrMaxHopsExceeded), zap.String("id", msg.ID),
+			zap.String("from", msg.From), zap.String("to", msg.To), zap.Int("hops", hops))
+		return fmt.Errorf("%w: message %s reached %d hops", ErrMaxHopsExceeded, msg.ID, hops)
+	}
+	
+	// 同一消息经多条路径到达时只转发一次，防止放大
+	if msg.ID != "" && r.seen.CheckAndAdd(msg.ID, time.Now()) {
+		r.logger.Debug("suppressing duplicate message", zap.String("id", msg.ID),
+			zap.String("from", msg.From), zap.String("to", msg.To))
+		return fmt.Errorf("%w: %s", ErrDuplicateMessage, msg.ID)
+	}
+	
+	// 复制消息头，避免修改调用方持有的消息
+	headers := make(map[string]string, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[HopCountHeader] = strconv.Itoa(hops + 1)
+	forwarded := *msg
+	forwarded.Headers = headers
+	
+	// 发送失败时撤销去重记录，使重试不会被当作重复消息丢弃
+	if err := target.Send(ctx, &forwarded); err != nil {
+		if msg.ID != "" {
+			r.seen.Forget(msg.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// HopCount 返回消息已被转发的跳数，缺失或无法解析时为0
+func HopCount(msg *protocol.Message) int {
+	n, err := strconv.Atoi(msg.Headers[HopCountHeader])
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
 }
 
 // Start 开始运行中继服务
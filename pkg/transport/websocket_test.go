@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/agentries/amp-relay-go/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// mockTransport 记录发送消息的模拟传输
+type mockTransport struct {
+	did      string
+	mu       sync.Mutex
+	sent     []*protocol.Message
+	failures int // 接下来多少次Send返回错误
+}
+
+func (m *mockTransport) Send(ctx context.Context, msg *protocol.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("send failed")
+	}
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *mockTransport) Receive(ctx context.Context) (*protocol.Message, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (m *mockTransport) Close() error      { return nil }
+func (m *mockTransport) LocalDID() string  { return m.did }
+func (m *mockTransport) RemoteDID() string { return "" }
+
+func (m *mockTransport) Sent() []*protocol.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*protocol.Message(nil), m.sent...)
+}
+
+func TestMessageRelay_HopCount(t *testing.T) {
+	relay := NewMessageRelay(zap.NewNop())
+	relay.SetMaxHops(2)
+
+	target := &mockTransport{did: "did:web:bob"}
+	relay.Register(target.did, target)
+
+	t.Run("forward increments hop count", func(t *testing.T) {
+		msg := &protocol.Message{ID: "m1", From: "did:web:alice", To: "did:web:bob"}
+		require.NoError(t, relay.Forward(context.Background(), msg))
+
+		sent := target.Sent()
+		require.Len(t, sent, 1)
+		assert.Equal(t, 1, HopCount(sent[0]))
+		assert.Equal(t, 0, HopCount(msg), "original message should not be modified")
+	})
+
+	t.Run("message at hop limit is dropped", func(t *testing.T) {
+		before := len(target.Sent())
+		msg := &protocol.Message{
+			ID:      "m2",
+			From:    "did:web:alice",
+			To:      "did:web:bob",
+			Headers: map[string]string{HopCountHeader: "1"},
+		}
+		require.NoError(t, relay.Forward(context.Background(), msg))
+
+		looped := target.Sent()[before]
+		err := relay.Forward(context.Background(), looped)
+		assert.ErrorIs(t, err, ErrMaxHopsExceeded)
+		assert.Len(t, target.Sent(), before+1)
+	})
+
+	t.Run("zero max hops disables the check", func(t *testing.T) {
+		relay.SetMaxHops(0)
+		defer relay.SetMaxHops(2)
+
+		msg := &protocol.Message{
+			ID:      "m3",
+			To:      "did:web:bob",
+			Headers: map[string]string{HopCountHeader: "100"},
+		}
+		assert.NoError(t, relay.Forward(context.Background(), msg))
+	})
+}
+
+func TestHopCount_Invalid(t *testing.T) {
+	assert.Equal(t, 0, HopCount(&protocol.Message{}))
+	assert.Equal(t, 0, HopCount(&protocol.Message{Headers: map[string]string{HopCountHeader: "abc"}}))
+	assert.Equal(t, 0, HopCount(&protocol.Message{Headers: map[string]string{HopCountHeader: "-3"}}))
+}